@@ -2,58 +2,27 @@ package main
 
 // 导入必要的包
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"ovaphlow.com/crate/data/middleware"
-	"ovaphlow.com/crate/data/repository"
 	"ovaphlow.com/crate/data/router"
 	"ovaphlow.com/crate/data/service"
 	"ovaphlow.com/crate/data/utility"
 
-	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
-func init() {
-	// 加载环境变量文件
-	err := godotenv.Load()
-	if err != nil {
-		panic("Error loading .env file")
-	}
-
-	// 初始化 Zap 日志器
-	utility.InitZapLogger()
-
-	// 初始化 PostgreSQL 数据库
-	postgres_enabled := os.Getenv("POSTGRES_ENABLED")
-	if postgres_enabled == "true" || postgres_enabled == "1" {
-		user := os.Getenv("POSTGRES_USER")
-		password := os.Getenv("POSTGRES_PASSWORD")
-		host := os.Getenv("POSTGRES_HOST")
-		port := os.Getenv("POSTGRES_PORT")
-		database := os.Getenv("POSTGRES_DATABASE")
-		utility.InitPostgres(user, password, host, port, database)
-	}
-
-	// 初始化 MySQL 数据库
-	mysql_enabled := os.Getenv("MYSQL_ENABLED")
-	if mysql_enabled == "true" || mysql_enabled == "1" {
-		user := os.Getenv("MYSQL_USER")
-		password := os.Getenv("MYSQL_PASSWORD")
-		host := os.Getenv("MYSQL_HOST")
-		port := os.Getenv("MYSQL_PORT")
-		database := os.Getenv("MYSQL_DATABASE")
-		utility.InitMySQL(user, password, host, port, database)
-	}
-
-	// 初始化 SQLite 数据库
-	sqlite_enabled := os.Getenv("SQLITE_ENABLED")
-	if sqlite_enabled == "true" || sqlite_enabled == "1" {
-		utility.InitSQLite()
-	}
-}
-
 type Middleware func(http.Handler) http.Handler
 
 // applyMiddlewares 应用给定的中间件到 HTTP 处理器。
@@ -70,55 +39,187 @@ func applyMiddlewares(h http.Handler, middlewares ...Middleware) http.Handler {
 	return h
 }
 
+// loadConfig 读取配置文件和环境变量，并解析数据表选项。
+//
+// 参数:
+//   - path: 配置文件路径，为空时使用环境变量 CONFIG_FILE，都为空时只读取环境变量。
+//
+// 返回值:
+//   - *utility.Config: 配置，校验失败时仍返回已读取的配置。
+//   - service.TableRegistries: 按数据源的数据表选项。
+//   - error: 读取或校验失败时返回错误。
+func loadConfig(path string) (*utility.Config, service.TableRegistries, error) {
+	cfg, err := utility.LoadConfig(path)
+	if err != nil {
+		return cfg, nil, err
+	}
+	engines := map[string]string{}
+	for name, ds := range cfg.DataSources {
+		engines[name] = ds.Engine
+	}
+	var tables service.TableRegistries
+	if cfg.Tables != nil {
+		b, err := json.Marshal(cfg.Tables)
+		if err == nil {
+			tables, err = service.ParseTableRegistries(b, engines)
+		}
+		if err != nil {
+			return cfg, nil, &utility.ConfigError{Problems: []string{"tables: " + err.Error()}}
+		}
+		return cfg, tables, nil
+	}
+	tables, err = service.LoadTableRegistries(cfg.TableConfig, engines)
+	if err != nil {
+		return cfg, nil, &utility.ConfigError{Problems: []string{"table_config (TABLE_CONFIG): " + err.Error()}}
+	}
+	return cfg, tables, nil
+}
+
 func main() {
+	configPath := flag.String("config", "", "配置文件路径（YAML），为空时使用环境变量 "+utility.ConfigFileEnv)
+	printConfig := flag.Bool("print-config", false, "输出生效的配置（隐去密码）并退出，配置无效时退出码为 1")
+	flag.Parse()
+
+	// .env 文件可选，其中的变量不覆盖已经设置的环境变量
+	if err := loadDotEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, "读取 .env 文件失败:", err)
+		os.Exit(1)
+	}
+
+	cfg, tables, err := loadConfig(*configPath)
+	if *printConfig {
+		if cfg != nil {
+			if err := cfg.Redacted().WriteYAML(os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// 初始化 Zap 日志器
+	utility.InitZapLogger(cfg.Log)
+
+	// 设置服务时区
+	if err := utility.InitTimezone(cfg.Server.Timezone); err != nil {
+		utility.ZapLogger.Fatal("无效的服务时区", zap.Error(err))
+	}
+	utility.SetSnowflakeNode(cfg.SnowflakeNode)
+
+	// 初始化链路追踪
+	if err := utility.InitTracing(cfg.Tracing); err != nil {
+		utility.ZapLogger.Fatal("初始化链路追踪失败", zap.Error(err))
+	}
+
 	// 创建一个新的 ServeMux
 	mux := http.NewServeMux()
 
 	// 应用多个中间件到 mux
 	handler := applyMiddlewares(mux,
-		middleware.LogRequest, // 添加请求日志中间件
+		middleware.RouteMiddleware, // 最内层，记录匹配的路由
+		middleware.LogRequest,      // 添加请求日志中间件
+		middleware.RateLimitMiddleware,
 		middleware.APIVersionMiddleware,
 		middleware.CORSMiddleware,
 		middleware.SecurityHeadersMiddleware,
+		middleware.RequestIDMiddleware,
+		middleware.TracingMiddleware,
+		middleware.MetricsMiddleware, // 最外层，限流拒绝的请求同样计入指标
 	)
 	utility.ZapLogger.Info("中间件已加载")
 
-	// 加载 PostgreSQL 路由
-	postgres_enabled := os.Getenv("POSTGRES_ENABLED")
-	if postgres_enabled == "true" || postgres_enabled == "1" {
-		postgresRepo := repository.NewPostgresRepo(utility.Postgres)
-		postgresService := service.NewApplicationService(postgresRepo)
-		router.LoadPostgresRouter(mux, "/crate-api-data", postgresService)
+	// 数据源、数据表选项与中间件设置可以重新加载，其余设置以启动时的配置为准
+	srv := newServer(mux, *configPath, cfg)
+	srv.start(cfg, tables)
+	router.LoadDataSourceRouter(mux, apiPrefix, srv.dataSources)
+	router.LoadConfigRouter(mux, apiPrefix, srv)
+
+	// 收到 SIGHUP 时重新加载配置
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			srv.Reload("signal")
+		}
+	}()
+
+	// 加载数据表元数据管理路由
+	router.LoadSchemaRouter(mux, apiPrefix, srv.schemaCaches)
+	router.LoadStatementRouter(mux, apiPrefix, srv.statementCaches)
+
+	// 加载工具路由
+	router.LoadUtilityRouter(mux, apiPrefix)
+
+	// 加载 Prometheus 指标接口
+	router.LoadMetricsRouter(mux, srv.dataSources, srv.statementCaches, srv.responses)
+
+	// 加载存活检查与就绪检查端点
+	router.LoadHealthRouter(mux, srv.healthTargets, utility.Webhook)
+
+	// 启动 HTTP 服务器
+	router.StreamWriteTimeout = time.Duration(cfg.Server.WriteTimeout)
+	if cfg.ChangeStream.AllowAll {
+		router.AuthorizeChanges = router.AllowAllChanges
 	}
-
-	// 加载 MySQL 路由
-	mysql_enabled := os.Getenv("MYSQL_ENABLED")
-	if mysql_enabled == "true" || mysql_enabled == "1" {
-		mysqlRepo := repository.NewMySQLRepo(utility.MySQL)
-		mysqlService := service.NewApplicationService(mysqlRepo)
-		router.LoadMySQLRouter(mux, "/crate-api-data", mysqlService)
+	httpServer := &http.Server{
+		Addr:              net.JoinHostPort(cfg.Server.Address, strconv.Itoa(cfg.Server.Port)),
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+		ErrorLog:          zap.NewStdLog(utility.ZapLogger),
 	}
-
-	// 加载 SQLite 路由
-	sqlite_enabled := os.Getenv("SQLITE_ENABLED")
-	if sqlite_enabled == "true" || sqlite_enabled == "1" {
-		sqliteRepo := repository.NewSQLiteRepo(utility.SQLite)
-		sqliteService := service.NewApplicationService(sqliteRepo)
-		router.LoadSQLiteRouter(mux, "/crate-api-data", sqliteService)
+	// 停止时结束变更流的连接，否则它们会一直占用到超时
+	httpServer.RegisterOnShutdown(srv.closeStreams)
+
+	// 收到 SIGTERM 或 Ctrl+C 时停止服务
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	serveErr := make(chan error, 1)
+	go func() {
+		utility.ZapLogger.Info("服务器启动", zap.String("address", httpServer.Addr))
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		utility.ZapLogger.Fatal("服务器启动失败", zap.Error(err))
+	case sig := <-stop:
+		utility.ZapLogger.Info("正在停止服务", zap.String("signal", sig.String()))
 	}
+	shutdown(httpServer, srv, time.Duration(cfg.Server.ShutdownTimeout))
+}
 
-	// 添加健康检查端点
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok","service":"crate-service"}`))
-	})
-
-	// 获取端口号并启动 HTTP 服务器
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8421"
+// shutdown 停止服务。
+//
+// 不再接受新的连接，等待处理中的请求完成，投递 Webhook 和发件箱队列中剩余的记录，关闭连接池，最后导出 span 并写出日志。
+// 所有步骤共用 timeout，超时后关闭仍未完成的连接，剩余的记录留在队列中，下次启动后继续投递。
+//
+// 参数:
+//   - httpServer: HTTP 服务器。
+//   - srv: 服务运行时的状态。
+//   - timeout: 停止服务的最长时间。
+func shutdown(httpServer *http.Server, srv *server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		utility.ZapLogger.Warn("等待处理中的请求超时，关闭剩余连接", zap.Error(err))
+		httpServer.Close()
+	}
+	srv.shutdown(ctx)
+	utility.CloseTracing(ctx)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		utility.ZapLogger.Warn("停止服务超时，未投递的记录在下次启动后继续投递")
 	}
-	utility.ZapLogger.Info("服务器启动", zap.String("address", "0.0.0.0:"+port))
-	panic(http.ListenAndServe("0.0.0.0:"+port, handler))
+	utility.ZapLogger.Info("服务已停止")
+	utility.CloseZapLogger()
 }
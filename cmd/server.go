@@ -0,0 +1,656 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/middleware"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/router"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+)
+
+// apiPrefix 数据接口与管理接口的路径前缀。
+const apiPrefix = "/crate-api-data"
+
+// dataSourceCloseDelay 数据源被移除或重新连接后，旧连接池延迟关闭的时长，让已开始的请求完成。
+const dataSourceCloseDelay = 30 * time.Second
+
+// dotEnvKeys 由 .env 文件设置的环境变量。
+//
+// 重新加载配置时以 .env 文件的新内容为准，进程启动时已经存在的环境变量始终优先，不被覆盖。
+var dotEnvKeys = map[string]bool{}
+
+// loadDotEnv 读取可选的 .env 文件并写入环境变量，文件中删除的变量同时从环境变量中删除。
+func loadDotEnv() error {
+	values, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for key := range dotEnvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(dotEnvKeys, key)
+		}
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !dotEnvKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		dotEnvKeys[key] = true
+	}
+	return nil
+}
+
+// dataSourceRuntime 一个数据源运行中的对象。
+//
+// 连接设置、只读副本、变更捕获或发件箱表变化时整体重新创建；连接池大小、是否可选和数据表选项就地修改。
+type dataSourceRuntime struct {
+	ds         *utility.DataSource
+	cfg        *utility.DataSourceConfig
+	tables     *service.TableRegistry
+	handler    http.Handler
+	schema     *repository.SchemaCache
+	statements map[string]*repository.StatementCache
+	replicas   *repository.ReplicaSet
+	events     *event.Bus
+	cache      *service.BackendCache
+	relays     []*service.OutboxRelay
+	cancel     context.CancelFunc
+	// optional 数据源不可用时就绪检查仍然通过
+	optional atomic.Bool
+	// wg 等待发件箱中继退出
+	wg sync.WaitGroup
+}
+
+// dbs 返回数据源主库和只读副本的连接池。
+func (rt *dataSourceRuntime) dbs() []*sql.DB {
+	result := []*sql.DB{rt.ds.DB}
+	for _, replica := range rt.ds.Replicas {
+		result = append(result, replica.DB)
+	}
+	return slices.DeleteFunc(result, func(db *sql.DB) bool { return db == nil })
+}
+
+// requiredTables 返回服务依赖、须预先创建的数据表：发件箱表及其租约表，以及变更捕获的变更日志表。
+func (rt *dataSourceRuntime) requiredTables() []string {
+	var result []string
+	outboxTables := rt.tables.OutboxTables()
+	slices.Sort(outboxTables)
+	for _, table := range outboxTables {
+		result = append(result, table, table+"_lease")
+	}
+	if rt.ds.Engine == "postgres" && rt.ds.Config.Capture.Enabled {
+		result = append(result, rt.ds.Config.Capture.Log)
+	}
+	return result
+}
+
+// resize 按新的配置修改连接池大小和是否可选，只读副本与主库相同。
+func (rt *dataSourceRuntime) resize(c *utility.DataSourceConfig) {
+	rt.optional.Store(c.Optional)
+	for _, db := range rt.dbs() {
+		db.SetMaxOpenConns(*c.MaxOpenConns)
+		db.SetMaxIdleConns(*c.MaxIdleConns)
+		db.SetConnMaxLifetime(time.Duration(*c.ConnMaxLifetime))
+		db.SetConnMaxIdleTime(time.Duration(*c.ConnMaxIdleTime))
+	}
+	rt.cfg = c
+}
+
+// close 停止数据源的后台任务，关闭变更订阅并清空缓存，delay 之后关闭连接池。
+func (rt *dataSourceRuntime) close(delay time.Duration) {
+	rt.cancel()
+	rt.events.Close()
+	rt.cache.InvalidateAll()
+	time.AfterFunc(delay, func() {
+		for _, db := range rt.dbs() {
+			db.Close()
+		}
+	})
+}
+
+// shutdown 停止数据源的后台任务，投递发件箱中剩余的事件，然后关闭变更订阅和连接池。
+func (rt *dataSourceRuntime) shutdown(ctx context.Context) {
+	rt.cancel()
+	rt.wg.Wait()
+	for _, relay := range rt.relays {
+		n, err := relay.Flush(ctx)
+		if err != nil {
+			utility.ZapLogger.Warn("投递发件箱剩余事件失败", zap.String("backend", rt.ds.Name), zap.Int("events", n), zap.Error(err))
+		} else if n > 0 {
+			utility.ZapLogger.Info("发件箱剩余事件已投递", zap.String("backend", rt.ds.Name), zap.Int("events", n))
+		}
+	}
+	rt.events.Close()
+	for _, db := range rt.dbs() {
+		db.Close()
+	}
+}
+
+// needsRestart 判断数据源是否需要重新创建。
+//
+// 除连接池大小和是否可选外的数据源配置有变化，或者需要后台任务的数据表选项（发件箱表、变更捕获的数据表）有变化时返回 true。
+func (rt *dataSourceRuntime) needsRestart(c *utility.DataSourceConfig, tables *service.TableRegistry) bool {
+	a, b := *rt.cfg, *c
+	a.MaxOpenConns, a.MaxIdleConns, a.ConnMaxLifetime, a.ConnMaxIdleTime = nil, nil, nil, nil
+	b.MaxOpenConns, b.MaxIdleConns, b.ConnMaxLifetime, b.ConnMaxIdleTime = nil, nil, nil, nil
+	a.Optional, b.Optional = false, false
+	if !reflect.DeepEqual(a, b) {
+		return true
+	}
+	before, after := rt.tables.OutboxTables(), tables.OutboxTables()
+	slices.Sort(before)
+	slices.Sort(after)
+	return !slices.Equal(before, after) || !maps.Equal(rt.tables.CaptureTables(), tables.CaptureTables())
+}
+
+// generation 一份生效的配置及按它运行的数据源，重新加载配置时整体替换。
+type generation struct {
+	cfg     *utility.Config
+	sources map[string]*dataSourceRuntime
+	status  router.ReloadStatus
+}
+
+// server 服务运行时的状态。
+//
+// 监听地址、时区、Webhook、变更流、各类缓存的大小、发件箱 Sink 和链路追踪以启动时的配置为准，修改后需要重启；
+// 其余设置（日志级别、CORS、限流、语句超时、数据源、连接池、数据表选项）在重新加载配置时生效。
+type server struct {
+	configPath string
+	startup    *utility.Config
+	webhooks   *service.WebhookService
+	// stopWebhooks 停止 Webhook 投递，webhooksDone 在投递停止后关闭
+	stopWebhooks context.CancelFunc
+	webhooksDone chan struct{}
+	responses    *service.ResponseCache
+	data         *router.DataRouters
+
+	// mu 串行化配置的加载，outboxSinks 和 stopped 也由它保护
+	mu          sync.Mutex
+	outboxSinks []event.Sink
+	stopped     bool
+	current     atomic.Pointer[generation]
+	status      atomic.Pointer[router.ReloadStatus]
+}
+
+// newServer 按启动时的配置创建服务的运行时状态，Webhook 与列表查询缓存的接口注册到 mux。
+func newServer(mux *http.ServeMux, configPath string, cfg *utility.Config) *server {
+	s := &server{configPath: configPath, startup: cfg, data: router.NewDataRouters(mux, apiPrefix)}
+
+	// Webhook 订阅与投递队列保存在独立的 SQLite 数据库中
+	if cfg.Webhook.Enabled {
+		utility.InitWebhook(cfg.Webhook.Database)
+		webhookRepo, err := repository.NewWebhookRepo(utility.Webhook)
+		if err != nil {
+			utility.ZapLogger.Fatal("初始化 Webhook 数据表失败", zap.Error(err))
+		}
+		s.webhooks, err = service.NewWebhookService(webhookRepo, cfg.Webhook.MaxAttempts, cfg.Webhook.AllowLocal, s.outboxed)
+		if err != nil {
+			utility.ZapLogger.Fatal("加载 Webhook 订阅失败", zap.Error(err))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopWebhooks, s.webhooksDone = cancel, make(chan struct{})
+		go func() {
+			defer close(s.webhooksDone)
+			s.webhooks.Run(ctx)
+		}()
+		router.LoadWebhookRouter(mux, apiPrefix, s.webhooks)
+	}
+
+	// 列表查询缓存：所有后端共用内存上限，数据表配置 cache_ttl 后启用
+	s.responses = service.NewResponseCache(cfg.ResponseCache.MaxBytes)
+	router.LoadCacheRouter(mux, apiPrefix, s.responses)
+
+	s.current.Store(&generation{sources: map[string]*dataSourceRuntime{}})
+	return s
+}
+
+// changeStream 创建数据源的变更总线，保留最近的变更用于断线续传。
+func (s *server) changeStream(backend string, required bool) *event.Bus {
+	if !s.startup.ChangeStream.Enabled && !required {
+		return nil
+	}
+	return event.NewBus(s.startup.ChangeStream.Buffer)
+}
+
+// outboxed 判断正在使用的数据源的数据表是否启用发件箱，这些数据表的 Webhook 由发件箱中继投递。
+func (s *server) outboxed(backend string, table string) bool {
+	rt, ok := s.current.Load().sources[backend]
+	return ok && rt.tables.Lookup(table).Outbox
+}
+
+// backendCache 获取数据源的列表查询缓存，变更总线上的变更（包括变更捕获到的外部写入）同样使缓存失效。
+func (s *server) backendCache(backend string, bus *event.Bus) *service.BackendCache {
+	cache := s.responses.For(backend)
+	if bus != nil {
+		bus.Listen(func(c event.Change) {
+			cache.Invalidate(c.Table)
+		})
+	}
+	return cache
+}
+
+// startSchemaCache 预加载数据表元数据并定时刷新，直到 ctx 结束。
+func (s *server) startSchemaCache(ctx context.Context, backend string, cache *repository.SchemaCache) {
+	if s.startup.SchemaCache.Preload {
+		n, err := cache.Preload()
+		if err != nil {
+			utility.ZapLogger.Warn("预加载数据表元数据失败", zap.String("backend", backend), zap.Error(err))
+		}
+		utility.ZapLogger.Info("数据表元数据已预加载", zap.String("backend", backend), zap.Int("tables", n))
+	}
+	refresh := time.Duration(s.startup.SchemaCache.Refresh)
+	if refresh == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := cache.Refresh(); err != nil {
+					utility.ZapLogger.Warn("刷新数据表元数据失败", zap.String("backend", backend), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// startOutbox 启动发件箱中继：每个发件箱表一个中继，多个实例之间通过租约只保留一个持有者。
+func (s *server) startOutbox(ctx context.Context, rt *dataSourceRuntime, repo repository.RDBRepo) error {
+	backend := rt.ds.Name
+	outboxTables := rt.tables.OutboxTables()
+	if len(outboxTables) == 0 {
+		return nil
+	}
+	if s.outboxSinks == nil {
+		sinks, err := event.ParseSinks(strings.Join(s.startup.Outbox.Sinks, ","), utility.ZapLogger)
+		if err != nil {
+			return err
+		}
+		// Webhook 投递队列同样从发件箱接收变更
+		if s.webhooks != nil {
+			sinks = append(sinks, s.webhooks)
+		}
+		s.outboxSinks = sinks
+	}
+	for _, table := range outboxTables {
+		relay := service.NewOutboxRelay(repo, backend, table, s.outboxSinks)
+		rt.relays = append(rt.relays, relay)
+		rt.wg.Add(1)
+		go func() {
+			defer rt.wg.Done()
+			relay.Run(ctx)
+		}()
+		utility.ZapLogger.Info("发件箱中继已启动", zap.String("backend", backend), zap.String("table", table))
+	}
+	return nil
+}
+
+// startReplicas 连接只读副本：读取分发到健康的副本，连接失败或复制延迟过大的副本暂停使用，直到检查恢复。
+func (s *server) startReplicas(ctx context.Context, rt *dataSourceRuntime, repo *repository.SQLRepoImpl) *service.ReadRouting {
+	ds := rt.ds
+	if len(ds.Replicas) == 0 {
+		return nil
+	}
+	replicas := repository.NewReplicaSet(repo, ds.ReplicaMaxLag)
+	for _, replica := range ds.Replicas {
+		if err := replica.Open(); err != nil {
+			utility.ZapLogger.Error("连接只读副本失败", zap.String("datasource", ds.Name), zap.String("replica", replica.Name), zap.Error(err))
+		}
+		rt.statements[ds.Name+"@"+replica.Name] = replicas.Add(replica.Name, replica.DB, s.startup.StatementCache.Size)
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, ds.ReplicaCheckInterval)
+	replicas.Check(checkCtx)
+	cancel()
+	for _, status := range replicas.Status() {
+		utility.ZapLogger.Info("只读副本状态", zap.String("datasource", ds.Name), zap.String("replica", status.Name), zap.String("status", status.Status), zap.String("error", status.Error))
+	}
+	go replicas.Run(ctx, ds.ReplicaCheckInterval, ds.ReplicaCheckInterval)
+	rt.replicas = replicas
+	return service.NewReadRouting(replicas, ds.ReadYourWrites)
+}
+
+// open 打开数据源并启动它的数据接口和后台任务。
+//
+// 连接失败的数据源在恢复后自动重新连接，不视为错误。
+//
+// 参数:
+//   - ds: 数据源，尚未连接。
+//   - tables: 数据源的数据表选项。
+//
+// 返回值:
+//   - *dataSourceRuntime: 运行中的数据源。
+//   - error: 发件箱 Sink 无效或安装变更捕获触发器失败时返回错误，已打开的连接随之关闭。
+func (s *server) open(ds *utility.DataSource, tables *service.TableRegistry) (*dataSourceRuntime, error) {
+	if err := ds.Open(); err != nil {
+		utility.ZapLogger.Error("连接数据源失败", zap.String("datasource", ds.Name), zap.String("engine", ds.Engine), zap.Error(err))
+	} else {
+		utility.ZapLogger.Info("连接数据源成功", zap.String("datasource", ds.Name), zap.String("engine", ds.Engine))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := &dataSourceRuntime{ds: ds, cfg: ds.Config, tables: tables, statements: map[string]*repository.StatementCache{}, cancel: cancel}
+	rt.optional.Store(ds.Config.Optional)
+	fail := func(err error) (*dataSourceRuntime, error) {
+		cancel()
+		for _, db := range rt.dbs() {
+			db.Close()
+		}
+		return nil, err
+	}
+
+	// 时间存储方式：native 使用 TIMESTAMPTZ / DATETIME，text 使用 RFC 3339 UTC 文本，SQLite 默认为 text
+	timeStorage, err := repository.ParseTimeStorage(ds.Config.TimeStorage, repository.TimeStorageNative)
+	if err != nil {
+		return fail(err)
+	}
+	dialect, _ := repository.DialectFor(ds.Engine)
+	repo := repository.NewSQLRepo(ds.DB, dialect, timeStorage, s.startup.StatementCache.Size)
+	repo.SetName(ds.Name)
+	capture := ds.Engine == "postgres" && ds.Config.Capture.Enabled
+	// Webhook 从变更总线接收未启用发件箱的数据表的写入
+	rt.events = s.changeStream(ds.Name, capture || s.webhooks != nil)
+	rt.cache = s.backendCache(ds.Name, rt.events)
+	if s.webhooks != nil {
+		s.webhooks.Watch(ds.Name, rt.events)
+	}
+	svc := service.NewApplicationService(repo, tables, rt.events, rt.cache, s.startReplicas(ctx, rt, repo))
+
+	// 每个数据源一组接口，路径为 /crate-api-data/{datasource}/{st}
+	mux := http.NewServeMux()
+	router.LoadDataRouter(mux, apiPrefix, ds.Name, svc)
+	rt.handler = mux
+	rt.schema = repo.Schema()
+	rt.statements[ds.Name] = repo.Statements()
+	s.startSchemaCache(ctx, ds.Name, rt.schema)
+	if err := s.startOutbox(ctx, rt, repo); err != nil {
+		return fail(err)
+	}
+
+	// 变更捕获：由触发器记录绕过服务的写入，经 LISTEN 发布到同一个变更总线
+	if capture {
+		captureRepo, err := repository.NewPostgresCaptureRepo(ds.DB, ds.Config.Capture.Log)
+		if err != nil {
+			return fail(err)
+		}
+		if err := captureRepo.Install(tables.CaptureTables()); err != nil {
+			return fail(err)
+		}
+		postgresCapture := service.NewPostgresCapture(captureRepo, ds.DSN, ds.ApplicationName, svc.Events(), time.Duration(ds.Config.Capture.Retention))
+		go postgresCapture.Run(ctx)
+	}
+	return rt, nil
+}
+
+// apply 应用一份已校验的配置。
+//
+// 先打开新增和需要重新创建的数据源，全部成功后才替换正在使用的数据源、数据表选项和中间件设置，
+// 再关闭被移除或被替换的数据源；任何一步失败都关闭已打开的数据源，之前的配置继续生效。
+//
+// 参数:
+//   - cfg: 新的配置。
+//   - tables: 新的数据表选项。
+//   - status: 本次加载的结果，成功时补全数据源的变化。
+//
+// 返回值:
+//   - router.ReloadStatus: 本次加载的结果。
+//   - error: 打开数据源失败时返回错误。
+func (s *server) apply(cfg *utility.Config, tables service.TableRegistries, status router.ReloadStatus) (router.ReloadStatus, error) {
+	current := s.current.Load()
+	dataSources, err := utility.LoadDataSources(cfg)
+	if err != nil {
+		return status, err
+	}
+
+	sources := map[string]*dataSourceRuntime{}
+	var opened, replaced []*dataSourceRuntime
+	for _, ds := range dataSources {
+		registry := tables.For(ds.Name)
+		prev, ok := current.sources[ds.Name]
+		if ok && !prev.needsRestart(ds.Config, registry) {
+			sources[ds.Name] = prev
+			continue
+		}
+		rt, err := s.open(ds, registry)
+		if err != nil {
+			for _, rt := range opened {
+				rt.close(0)
+			}
+			return status, &utility.ConfigError{Problems: []string{"datasources." + ds.Name + ": " + err.Error()}}
+		}
+		opened = append(opened, rt)
+		sources[ds.Name] = rt
+		if ok {
+			status.Restarted = append(status.Restarted, ds.Name)
+			replaced = append(replaced, prev)
+		} else {
+			status.Added = append(status.Added, ds.Name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(current.sources)) {
+		if _, ok := sources[name]; !ok {
+			status.Removed = append(status.Removed, name)
+			replaced = append(replaced, current.sources[name])
+		}
+	}
+
+	// 全部就绪，替换正在使用的配置
+	for _, ds := range dataSources {
+		if rt := sources[ds.Name]; rt.ds != ds {
+			rt.tables.Replace(tables.For(ds.Name))
+			rt.resize(ds.Config)
+		}
+	}
+	handlers := map[string]http.Handler{}
+	for name, rt := range sources {
+		handlers[name] = rt.handler
+	}
+	status.Status = "ok"
+	status.Version = current.status.Version + 1
+	status.RestartRequired = restartRequired(s.startup, cfg)
+	s.current.Store(&generation{cfg: cfg, sources: sources, status: status})
+	s.data.Set(handlers)
+	utility.SetLogLevel(cfg.Log)
+	middleware.SetCORSOrigins(cfg.CORS.AllowedOrigins)
+	middleware.SetRateLimit(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+	service.SetStatementTimeout(time.Duration(cfg.StatementTimeout))
+	router.SetHealthConfig(cfg.Health)
+
+	for _, rt := range replaced {
+		rt.close(dataSourceCloseDelay)
+	}
+	return status, nil
+}
+
+// restartRequired 返回与启动时的配置不同、需要重启服务才能生效的配置项。
+func restartRequired(running *utility.Config, next *utility.Config) []string {
+	var result []string
+	check := func(key string, a any, b any) {
+		if !reflect.DeepEqual(a, b) {
+			result = append(result, key)
+		}
+	}
+	check("server", running.Server, next.Server)
+	check("log.dir", running.Log.Dir, next.Log.Dir)
+	check("snowflake_node", running.SnowflakeNode, next.SnowflakeNode)
+	check("change_stream", running.ChangeStream, next.ChangeStream)
+	check("webhook", running.Webhook, next.Webhook)
+	check("statement_cache", running.StatementCache, next.StatementCache)
+	check("schema_cache", running.SchemaCache, next.SchemaCache)
+	check("response_cache", running.ResponseCache, next.ResponseCache)
+	check("outbox", running.Outbox, next.Outbox)
+	check("tracing", running.Tracing, next.Tracing)
+	return result
+}
+
+// start 应用启动时的配置，失败时退出。
+func (s *server) start(cfg *utility.Config, tables service.TableRegistries) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, err := s.apply(cfg, tables, router.ReloadStatus{Trigger: "startup", At: time.Now().UTC()})
+	if err != nil {
+		utility.ZapLogger.Fatal("启动数据源失败", zap.Error(err))
+	}
+	s.status.Store(&status)
+}
+
+// Reload 重新读取 .env 文件、配置文件和环境变量并应用。
+//
+// 新的配置先完整校验，通过后才替换正在使用的配置；失败时之前的配置继续生效，
+// 失败原因记录在日志中，并由 Status 返回。
+//
+// 参数:
+//   - trigger: 触发方式，signal 或 api。
+//
+// 返回值:
+//   - router.ReloadStatus: 本次加载的结果。
+func (s *server) Reload(trigger string) router.ReloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := router.ReloadStatus{Trigger: trigger, At: time.Now().UTC(), Version: s.current.Load().status.Version}
+	err := errors.New("服务正在停止")
+	if !s.stopped {
+		err = loadDotEnv()
+	}
+	var cfg *utility.Config
+	var tables service.TableRegistries
+	if err == nil {
+		cfg, tables, err = loadConfig(s.configPath)
+	}
+	if err == nil {
+		status, err = s.apply(cfg, tables, status)
+	}
+	if err != nil {
+		status.Status = "failed"
+		status.Error = err.Error()
+		if configErr, ok := err.(*utility.ConfigError); ok {
+			status.Problems = configErr.Problems
+		}
+		utility.ZapLogger.Error("重新加载配置失败，继续使用之前的配置", zap.String("trigger", trigger), zap.Int("version", status.Version), zap.Error(err))
+		s.status.Store(&status)
+		return status
+	}
+
+	utility.ZapLogger.Info("配置已重新加载", zap.String("trigger", trigger), zap.Int("version", status.Version),
+		zap.Strings("added", status.Added), zap.Strings("removed", status.Removed), zap.Strings("restarted", status.Restarted))
+	if len(status.RestartRequired) > 0 {
+		utility.ZapLogger.Warn("部分配置需要重启服务才能生效", zap.Strings("keys", status.RestartRequired))
+	}
+	s.status.Store(&status)
+	return status
+}
+
+// Status 返回最近一次加载配置的结果。
+func (s *server) Status() router.ReloadStatus {
+	return *s.status.Load()
+}
+
+// closeStreams 关闭所有数据源的变更订阅，使变更流的连接结束，停止服务时调用。
+func (s *server) closeStreams() {
+	for _, rt := range s.current.Load().sources {
+		rt.events.Close()
+	}
+}
+
+// shutdown 停止服务的后台任务：停止发件箱中继和 Webhook 投递，在 ctx 结束前投递剩余的事件，然后关闭连接池。
+//
+// 正在进行的配置加载完成后才开始，之后不再加载配置。
+//
+// 参数:
+//   - ctx: 限制投递剩余事件的时长。
+func (s *server) shutdown(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+
+	// 先停止数据源，之后不再有新的 Webhook 投递记录
+	var wg sync.WaitGroup
+	for _, rt := range s.current.Load().sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rt.shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if s.webhooks != nil {
+		s.stopWebhooks()
+		<-s.webhooksDone
+		s.webhooks.Flush(ctx)
+		utility.Webhook.Close()
+	}
+}
+
+// dataSources 返回正在使用的数据源（按名称排序）及其只读副本。
+func (s *server) dataSources() ([]*utility.DataSource, map[string]*repository.ReplicaSet) {
+	current := s.current.Load()
+	var sources []*utility.DataSource
+	replicas := map[string]*repository.ReplicaSet{}
+	for _, name := range slices.Sorted(maps.Keys(current.sources)) {
+		rt := current.sources[name]
+		sources = append(sources, rt.ds)
+		if rt.replicas != nil {
+			replicas[name] = rt.replicas
+		}
+	}
+	return sources, replicas
+}
+
+// healthTargets 返回正在使用的数据源的就绪检查对象，按名称排序。
+func (s *server) healthTargets() []router.HealthTarget {
+	current := s.current.Load()
+	var result []router.HealthTarget
+	for _, name := range slices.Sorted(maps.Keys(current.sources)) {
+		rt := current.sources[name]
+		result = append(result, router.HealthTarget{
+			DataSource: rt.ds,
+			Optional:   rt.optional.Load(),
+			Schema:     rt.schema,
+			Replicas:   rt.replicas,
+			Tables:     rt.requiredTables(),
+		})
+	}
+	return result
+}
+
+// schemaCaches 返回正在使用的数据源的元数据缓存。
+func (s *server) schemaCaches() map[string]*repository.SchemaCache {
+	result := map[string]*repository.SchemaCache{}
+	for name, rt := range s.current.Load().sources {
+		result[name] = rt.schema
+	}
+	return result
+}
+
+// statementCaches 返回正在使用的数据源及其只读副本的预编译语句缓存。
+func (s *server) statementCaches() map[string]*repository.StatementCache {
+	result := map[string]*repository.StatementCache{}
+	for _, rt := range s.current.Load().sources {
+		maps.Copy(result, rt.statements)
+	}
+	return result
+}
@@ -0,0 +1,212 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// 变更类型
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// subscriberBuffer 每个订阅者未读变更的上限，超过时视为消费过慢并断开订阅。
+const subscriberBuffer = 256
+
+// Change 一次经过应用服务的数据变更。
+type Change struct {
+	// ID 变更序号，在一个 Bus 内单调递增，用作 SSE 的事件 ID。
+	ID uint64 `json:"id"`
+	// Op 变更类型：create、update 或 delete。
+	Op string `json:"op"`
+	// Table schema and table。
+	Table string `json:"table"`
+	// Key 主键。
+	Key string `json:"key"`
+	// Record 变更后的记录，delete 为删除前的记录。订阅者之间共享，不得修改。
+	Record map[string]any `json:"record"`
+	// At 变更时间。
+	At time.Time `json:"at"`
+	// Captured 是否为变更捕获到的、未经本实例应用服务的写入。
+	Captured bool `json:"-"`
+}
+
+// Bus 一个数据库后端的变更总线，保留最近的变更用于断线续传。
+type Bus struct {
+	mu          sync.Mutex
+	seq         uint64
+	ring        []Change
+	next        int
+	full        bool
+	subscribers map[*Subscription]struct{}
+	listeners   []func(Change)
+}
+
+// NewBus 创建变更总线。
+//
+// 序号从创建时的微秒时间戳开始，服务重启后客户端携带的旧序号总是早于缓冲区，
+// 因而会被识别为无法续传，而不是误认为已经是最新。
+//
+// 参数:
+//   - size: 保留的变更数量。
+//
+// 返回值:
+//   - *Bus: 变更总线。
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = 1
+	}
+	return &Bus{
+		seq:         uint64(time.Now().UnixMicro()),
+		ring:        make([]Change, size),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Listen 注册监听函数。
+//
+// 与订阅不同，监听函数在 Publish 中同步调用，不会因消费过慢丢失变更，
+// 适用于需要可靠接收的消费者，因此监听函数应尽快返回。
+func (b *Bus) Listen(fn func(Change)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// Publish 发布变更，为其分配序号并分发给订阅了该数据表的订阅者和监听函数。
+//
+// 向订阅者分发不会阻塞写入：订阅者的未读变更已满时，该订阅被关闭。
+func (b *Bus) Publish(c Change) {
+	if b == nil {
+		return
+	}
+	c, listeners := b.publish(c)
+	for _, fn := range listeners {
+		fn(c)
+	}
+}
+
+func (b *Bus) publish(c Change) (Change, []func(Change)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	c.ID = b.seq
+	if c.At.IsZero() {
+		c.At = time.Now()
+	}
+	b.ring[b.next] = c
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for sub := range b.subscribers {
+		if sub.table != "" && sub.table != c.Table {
+			continue
+		}
+		select {
+		case sub.ch <- c:
+		default:
+			sub.dropped = true
+			b.unsubscribe(sub)
+		}
+	}
+	return c, b.listeners
+}
+
+// Subscribe 订阅数据表的变更。
+//
+// 参数:
+//   - table: schema and table，为空时订阅所有数据表。
+//   - lastID: 客户端已收到的最后一个变更序号，为 0 时不回放。
+//
+// 返回值:
+//   - *Subscription: 订阅，使用完毕后必须 Close。
+//   - []Change: lastID 之后、订阅之前的变更。
+//   - bool: lastID 之后的变更是否完整，为 false 时部分变更已移出缓冲区，客户端应重新加载。
+func (b *Bus) Subscribe(table string, lastID uint64) (*Subscription, []Change, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{bus: b, table: table, ch: make(chan Change, subscriberBuffer)}
+	b.subscribers[sub] = struct{}{}
+	if lastID == 0 {
+		return sub, nil, true
+	}
+
+	buffered := b.buffered()
+	complete := lastID <= b.seq
+	if len(buffered) > 0 {
+		complete = complete && lastID+1 >= buffered[0].ID
+	} else {
+		complete = complete && lastID == b.seq
+	}
+	var replay []Change
+	for _, c := range buffered {
+		if c.ID > lastID && (table == "" || c.Table == table) {
+			replay = append(replay, c)
+		}
+	}
+	return sub, replay, complete
+}
+
+// Close 关闭所有订阅，订阅者的变更通道随之关闭，客户端重连后订阅新的变更总线。
+//
+// 用于数据源被移除或重新连接；之后的 Publish 仍然可以调用，只是没有订阅者。
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		b.unsubscribe(sub)
+	}
+}
+
+// buffered 按序号顺序返回缓冲区中的变更，调用方须持有锁。
+func (b *Bus) buffered() []Change {
+	if !b.full {
+		return append([]Change(nil), b.ring[:b.next]...)
+	}
+	return append(append([]Change(nil), b.ring[b.next:]...), b.ring[:b.next]...)
+}
+
+// unsubscribe 移除订阅并关闭其通道，调用方须持有锁。
+func (b *Bus) unsubscribe(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.ch)
+}
+
+// Subscription 变更订阅。
+type Subscription struct {
+	bus     *Bus
+	table   string
+	ch      chan Change
+	dropped bool
+}
+
+// Changes 返回变更通道，订阅关闭后通道关闭。
+func (s *Subscription) Changes() <-chan Change {
+	return s.ch
+}
+
+// Dropped 订阅是否因消费过慢被关闭，在通道关闭后调用。
+func (s *Subscription) Dropped() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.dropped
+}
+
+// Close 取消订阅。
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.unsubscribe(s)
+}
@@ -0,0 +1,95 @@
+package event
+
+import (
+	"slices"
+	"testing"
+)
+
+func ids(changes []Change) []uint64 {
+	result := make([]uint64, len(changes))
+	for i, c := range changes {
+		result[i] = c.ID
+	}
+	return result
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	b := NewBus(4)
+	first := b.seq
+	for _, table := range []string{"a", "b", "a", "a", "b", "a"} {
+		b.Publish(Change{Op: OpCreate, Table: table})
+	}
+	// 缓冲区保留 first+3 至 first+6
+
+	tests := []struct {
+		name     string
+		table    string
+		lastID   uint64
+		replay   []uint64
+		complete bool
+	}{
+		{"no resume", "a", 0, nil, true},
+		{"up to date", "a", first + 6, nil, true},
+		{"oldest buffered is next", "a", first + 2, []uint64{first + 3, first + 4, first + 6}, true},
+		{"other table", "b", first + 2, []uint64{first + 5}, true},
+		{"all tables", "", first + 4, []uint64{first + 5, first + 6}, true},
+		{"fallen out of the buffer", "a", first + 1, []uint64{first + 3, first + 4, first + 6}, false},
+		{"before restart", "a", 1, []uint64{first + 3, first + 4, first + 6}, false},
+		{"from the future", "a", first + 100, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, complete := b.Subscribe(tt.table, tt.lastID)
+			defer sub.Close()
+			if got := ids(replay); !slices.Equal(got, tt.replay) {
+				t.Errorf("got replay %v, want %v", got, tt.replay)
+			}
+			if complete != tt.complete {
+				t.Errorf("got complete %v, want %v", complete, tt.complete)
+			}
+		})
+	}
+}
+
+func TestSubscribeEmptyBus(t *testing.T) {
+	b := NewBus(4)
+	sub, replay, complete := b.Subscribe("a", b.seq)
+	defer sub.Close()
+	if len(replay) != 0 || !complete {
+		t.Errorf("got %v %v, want nothing to replay", replay, complete)
+	}
+	sub2, _, complete := b.Subscribe("a", b.seq-1)
+	defer sub2.Close()
+	if complete {
+		t.Error("an ID before the bus was created cannot be resumed")
+	}
+}
+
+func TestSubscriptionReceivesAfterReplay(t *testing.T) {
+	b := NewBus(4)
+	b.Publish(Change{Table: "a"})
+	sub, replay, _ := b.Subscribe("a", b.seq-1)
+	defer sub.Close()
+	b.Publish(Change{Table: "b"})
+	b.Publish(Change{Table: "a"})
+	c := <-sub.Changes()
+	if len(replay) != 1 || c.ID != replay[0].ID+2 {
+		t.Errorf("got replay %v then %d", ids(replay), c.ID)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBus(1)
+	sub, _, _ := b.Subscribe("", 0)
+	for range subscriberBuffer + 1 {
+		b.Publish(Change{Table: "a"})
+	}
+	n := 0
+	for range sub.Changes() {
+		n++
+	}
+	if n != subscriberBuffer || !sub.Dropped() {
+		t.Errorf("got %d changes, dropped %v", n, sub.Dropped())
+	}
+	sub.Close()
+}
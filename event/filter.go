@@ -0,0 +1,196 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Permission 调用方对一个数据表的行、列权限。
+type Permission struct {
+	// Filter 调用方可见的行，与列表接口的过滤条件格式相同，为空时所有行可见。
+	Filter [][]string
+	// Columns 调用方可见的列，为空时所有列可见。
+	Columns []string
+}
+
+// Allows 判断记录对调用方是否可见。
+func (p Permission) Allows(record map[string]any) bool {
+	return Match(record, p.Filter)
+}
+
+// Project 返回只包含可见列的记录副本。
+func (p Permission) Project(record map[string]any) map[string]any {
+	result := make(map[string]any, len(record))
+	for k, v := range record {
+		if len(p.Columns) == 0 || slices.Contains(p.Columns, k) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Match 在内存中判断记录是否满足过滤条件，条件之间为 AND 关系。
+//
+// 支持 utility.ConvertQueryStringToDefaultFilter 生成的全部条件，以及 json-field-in。
+// 两侧都能解析为数字时按数字比较，都能解析为时间时按时间比较，否则按文本比较。
+//
+// 参数:
+//   - record: 记录。
+//   - f: 过滤条件。
+//
+// 返回值:
+//   - bool: 满足所有条件时返回 true，未知的条件视为不满足。
+func Match(record map[string]any, f [][]string) bool {
+	for _, condition := range f {
+		if len(condition) < 3 {
+			continue
+		}
+		if !matchCondition(record, condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchCondition(record map[string]any, condition []string) bool {
+	v, ok := record[condition[1]]
+	if !ok {
+		return false
+	}
+	switch condition[0] {
+	case "equal":
+		return compare(v, condition[2]) == 0
+	case "not-equal":
+		return compare(v, condition[2]) != 0
+	case "in":
+		return slices.ContainsFunc(condition[2:], func(s string) bool { return compare(v, s) == 0 })
+	case "not-in":
+		return !slices.ContainsFunc(condition[2:], func(s string) bool { return compare(v, s) == 0 })
+	case "like":
+		return likePattern(condition[2]).MatchString(text(v))
+	case "greater":
+		return v != nil && compare(v, condition[2]) > 0
+	case "greater-equal":
+		return v != nil && compare(v, condition[2]) >= 0
+	case "less":
+		return v != nil && compare(v, condition[2]) < 0
+	case "less-equal":
+		return v != nil && compare(v, condition[2]) <= 0
+	case "json-array-contains", "jsonb-array-contains":
+		arr, ok := decodeJSON(v).([]any)
+		if !ok {
+			return false
+		}
+		return slices.ContainsFunc(arr, func(item any) bool { return compare(item, condition[2]) == 0 })
+	case "json-object-contains", "jsonb-object-contains":
+		if len(condition) < 4 {
+			return false
+		}
+		obj, ok := decodeJSON(v).(map[string]any)
+		if !ok {
+			return false
+		}
+		field, ok := obj[condition[2]]
+		return ok && compare(field, condition[3]) == 0
+	case "json-field-in":
+		if len(condition) < 4 {
+			return false
+		}
+		obj, ok := decodeJSON(v).(map[string]any)
+		if !ok {
+			return false
+		}
+		field, ok := obj[condition[2]]
+		return ok && slices.ContainsFunc(condition[3:], func(s string) bool { return compare(field, s) == 0 })
+	}
+	return false
+}
+
+// compare 比较记录中的值与过滤条件中的文本。
+func compare(v any, s string) int {
+	str := text(v)
+	if a, err := strconv.ParseFloat(str, 64); err == nil {
+		if b, err := strconv.ParseFloat(s, 64); err == nil {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	if a, ok := parseTime(str); ok {
+		if b, ok := parseTime(s); ok {
+			return a.Compare(b)
+		}
+	}
+	return strings.Compare(str, s)
+}
+
+func text(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	return fmt.Sprint(v)
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// decodeJSON 解码以文本保存的 JSON 列，已解码的值原样返回。
+func decodeJSON(v any) any {
+	s, ok := v.(string)
+	if b, isBytes := v.([]byte); isBytes {
+		s, ok = string(b), true
+	}
+	if !ok {
+		return v
+	}
+	var result any
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		return nil
+	}
+	return result
+}
+
+// likePattern 将 SQL LIKE 模式转换为正则表达式。
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,91 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	record := map[string]any{
+		"id":         int64(42),
+		"title":      "Hello World",
+		"price":      "9.50",
+		"status":     "active",
+		"deleted_at": nil,
+		"flag":       true,
+		"created_at": time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		"day":        "2025-03-01",
+		"tags":       `["a","b"]`,
+		"meta":       []byte(`{"kind":"post","level":3}`),
+		"decoded":    map[string]any{"kind": "page"},
+	}
+	tests := []struct {
+		name string
+		f    [][]string
+		want bool
+	}{
+		{"no conditions", nil, true},
+		{"short condition is ignored", [][]string{{"equal", "id"}}, true},
+		{"equal number", [][]string{{"equal", "id", "42"}}, true},
+		{"equal number as decimal", [][]string{{"equal", "id", "42.0"}}, true},
+		{"equal text", [][]string{{"equal", "status", "active"}}, true},
+		{"equal text mismatch", [][]string{{"equal", "status", "Active"}}, false},
+		{"equal bool", [][]string{{"equal", "flag", "true"}}, true},
+		{"missing column", [][]string{{"equal", "nope", "1"}}, false},
+		{"not-equal", [][]string{{"not-equal", "status", "archived"}}, true},
+		{"in", [][]string{{"in", "status", "draft", "active"}}, true},
+		{"in mismatch", [][]string{{"in", "status", "draft", "archived"}}, false},
+		{"not-in", [][]string{{"not-in", "status", "draft", "archived"}}, true},
+		{"like is case-insensitive", [][]string{{"like", "title", "hello%"}}, true},
+		{"like single character", [][]string{{"like", "title", "Hello_World"}}, true},
+		{"like escapes regexp", [][]string{{"like", "title", "Hello.World"}}, false},
+		{"greater compares numbers", [][]string{{"greater", "price", "10"}}, false},
+		{"less compares numbers", [][]string{{"less", "price", "10"}}, true},
+		{"greater-equal", [][]string{{"greater-equal", "id", "42"}}, true},
+		{"less-equal", [][]string{{"less-equal", "id", "41"}}, false},
+		{"greater on null", [][]string{{"greater", "deleted_at", ""}}, false},
+		{"time against date", [][]string{{"greater", "created_at", "2025-03-01"}}, true},
+		{"time against timestamp", [][]string{{"less", "created_at", "2025-03-01 12:00:01"}}, true},
+		{"date text", [][]string{{"less-equal", "day", "2025-03-01T00:00:00Z"}}, true},
+		{"json array contains", [][]string{{"json-array-contains", "tags", "b"}}, true},
+		{"json array missing item", [][]string{{"jsonb-array-contains", "tags", "c"}}, false},
+		{"json array on object", [][]string{{"json-array-contains", "meta", "post"}}, false},
+		{"json object contains", [][]string{{"json-object-contains", "meta", "kind", "post"}}, true},
+		{"json object number", [][]string{{"jsonb-object-contains", "meta", "level", "3"}}, true},
+		{"json object decoded", [][]string{{"json-object-contains", "decoded", "kind", "page"}}, true},
+		{"json object without value", [][]string{{"json-object-contains", "meta", "kind"}}, false},
+		{"json field in", [][]string{{"json-field-in", "meta", "kind", "page", "post"}}, true},
+		{"json field not in", [][]string{{"json-field-in", "meta", "kind", "page"}}, false},
+		{"invalid json", [][]string{{"json-object-contains", "title", "kind", "post"}}, false},
+		{"unknown operator", [][]string{{"between", "id", "1"}}, false},
+		{"all conditions must hold", [][]string{{"equal", "status", "active"}, {"greater", "id", "100"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(record, tt.f); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermission(t *testing.T) {
+	p := Permission{Filter: [][]string{{"equal", "owner", "7"}}, Columns: []string{"id", "owner"}}
+	record := map[string]any{"id": 1, "owner": 7, "secret": "x"}
+	if !p.Allows(record) {
+		t.Error("owner 7 should be allowed")
+	}
+	if p.Allows(map[string]any{"id": 2, "owner": 8}) {
+		t.Error("owner 8 should not be allowed")
+	}
+	projected := p.Project(record)
+	if _, ok := projected["secret"]; ok || len(projected) != 2 {
+		t.Errorf("got %v", projected)
+	}
+	if len(record) != 3 {
+		t.Error("Project modified the record")
+	}
+	if got := (Permission{}).Project(record); len(got) != 3 {
+		t.Errorf("empty permission got %v", got)
+	}
+}
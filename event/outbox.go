@@ -0,0 +1,161 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OutboxEvent 发件箱中的一个事件，由中继按写入顺序交给各个 Sink。
+type OutboxEvent struct {
+	// ID 事件ID，同一事件重复投递时不变，接收方据此去重。
+	ID string `json:"id"`
+	// Sequence 发件箱中的顺序号。
+	Sequence string `json:"sequence"`
+	Backend  string `json:"backend"`
+	Table    string `json:"table"`
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	// Record 写入事务中的记录，delete 为删除前的记录。
+	Record json.RawMessage `json:"record"`
+	At     string          `json:"at"`
+}
+
+// Sink 发件箱事件的接收方。
+//
+// Send 返回错误时中继停止本批次并稍后重试，因此同一事件可能被投递多次。
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, e OutboxEvent) error
+}
+
+// LogSink 将事件写入日志。
+type LogSink struct {
+	Logger *zap.Logger
+}
+
+func (s LogSink) Name() string {
+	return "log"
+}
+
+func (s LogSink) Send(ctx context.Context, e OutboxEvent) error {
+	s.Logger.Info("发件箱事件",
+		zap.String("id", e.ID),
+		zap.String("backend", e.Backend),
+		zap.String("table", e.Table),
+		zap.String("type", e.Type),
+		zap.String("key", e.Key),
+	)
+	return nil
+}
+
+// FileSink 以 NDJSON 追加写入文件，每个事件写入后同步到磁盘。
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileSink 打开或创建文件。
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, file: f}, nil
+}
+
+func (s *FileSink) Name() string {
+	return "file:" + s.path
+}
+
+func (s *FileSink) Send(ctx context.Context, e OutboxEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// HTTPSink 以 JSON POST 事件，响应状态码不是 2xx 时视为失败。
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s HTTPSink) Name() string {
+	return s.URL
+}
+
+func (s HTTPSink) Send(ctx context.Context, e OutboxEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", e.ID)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("响应状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ParseSinks 根据配置创建 Sink。
+//
+// 参数:
+//   - spec: 以逗号分隔的 Sink，log 写入日志，file:<path> 追加写入文件，http:// 或 https:// 开头的地址 POST 事件。
+//   - logger: 日志器。
+//
+// 返回值:
+//   - []Sink: Sink 列表。
+//   - error: 无法识别或文件无法打开时返回错误。
+func ParseSinks(spec string, logger *zap.Logger) ([]Sink, error) {
+	var sinks []Sink
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "log":
+			sinks = append(sinks, LogSink{Logger: logger})
+		case strings.HasPrefix(item, "file:"):
+			sink, err := NewFileSink(strings.TrimPrefix(item, "file:"))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case strings.HasPrefix(item, "http://"), strings.HasPrefix(item, "https://"):
+			sinks = append(sinks, HTTPSink{URL: item, Client: &http.Client{Timeout: 10 * time.Second}})
+		default:
+			return nil, fmt.Errorf("无法识别的发件箱 Sink %q", item)
+		}
+	}
+	if len(sinks) == 0 {
+		sinks = append(sinks, LogSink{Logger: logger})
+	}
+	return sinks, nil
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// 标签取值的兜底，保证客户端任意的路径不会产生新的时间序列。
+const (
+	// UnmatchedRoute 没有匹配任何路由的请求。
+	UnmatchedRoute = "unmatched"
+	// OtherTable 不在元数据缓存中的数据表，包括不存在的数据表。
+	OtherTable = "_other"
+)
+
+// Registry 服务的指标，由 /metrics 接口输出。
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests 按路由、数据源、数据表和状态码的请求数。
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crate_http_requests_total",
+		Help: "HTTP requests by route, backend, table and status.",
+	}, []string{"route", "backend", "table", "status"})
+
+	// HTTPDuration 按路由、数据源、数据表和状态码的请求耗时。
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crate_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, backend, table and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "backend", "table", "status"})
+
+	// QueryDuration 按数据源和操作的数据库语句耗时，读取包括逐行读取结果的时间。
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crate_db_query_duration_seconds",
+		Help:    "Database statement latency by datasource and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"datasource", "operation"})
+
+	// Problems 按问题类型的错误响应数。
+	Problems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crate_problems_total",
+		Help: "Problem responses by problem type.",
+	}, []string{"type"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		QueryDuration,
+		Problems,
+	)
+}
+
+// RequestLabels 一个请求的指标标签，由中间件创建，数据接口补全数据源和数据表。
+type RequestLabels struct {
+	Route   string
+	Backend string
+	Table   string
+}
+
+type labelsKey struct{}
+
+// WithLabels 在 ctx 中保存请求的指标标签。
+//
+// 参数:
+//   - ctx: 请求的上下文。
+//
+// 返回值:
+//   - context.Context: 带有标签的上下文。
+//   - *RequestLabels: 标签，处理请求的过程中可以修改。
+func WithLabels(ctx context.Context) (context.Context, *RequestLabels) {
+	labels := &RequestLabels{}
+	return context.WithValue(ctx, labelsKey{}, labels), labels
+}
+
+// LabelsFrom 返回 ctx 中请求的指标标签，没有时返回 nil。
+func LabelsFrom(ctx context.Context) *RequestLabels {
+	labels, _ := ctx.Value(labelsKey{}).(*RequestLabels)
+	return labels
+}
+
+// LabelRoute 在数据接口没有设置路由时，以 r 匹配的路由模式为路由标签。
+//
+// 只有直接交给 ServeMux 的请求才带有匹配的模式，因此应在最内层的中间件中调用。
+func LabelRoute(r *http.Request) {
+	if labels := LabelsFrom(r.Context()); labels != nil && labels.Route == "" {
+		labels.Route = r.Pattern
+	}
+}
+
+// Label 设置请求的数据源和数据表标签，请求不是经 WithLabels 创建时不做任何事。
+//
+// 参数:
+//   - r: 请求，r.Pattern 中的数据源名称替换为 {backend}。
+//   - backend: 数据源名称。
+//   - table: 数据表，调用方应保证取值有限，例如用 OtherTable 代替未知的数据表。
+func Label(r *http.Request, backend string, table string) {
+	labels := LabelsFrom(r.Context())
+	if labels == nil {
+		return
+	}
+	labels.Route = strings.Replace(r.Pattern, "/"+backend+"/", "/{backend}/", 1)
+	labels.Backend = backend
+	labels.Table = table
+}
@@ -1,6 +1,11 @@
 package middleware
 
-import "net/http"
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"sync/atomic"
+)
 
 // API 版本
 func APIVersionMiddleware(next http.Handler) http.Handler {
@@ -10,13 +15,59 @@ func APIVersionMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// corsOrigins 允许跨域访问的来源，为 nil 或包含 "*" 时允许任意来源。
+var corsOrigins atomic.Pointer[[]string]
+
+// SetCORSOrigins 设置允许跨域访问的来源，立即生效。
+//
+// 参数:
+//   - origins: 来源，例如 https://app.example.com，"*" 表示任意来源。
+func SetCORSOrigins(origins []string) {
+	origins = slices.Clone(origins)
+	corsOrigins.Store(&origins)
+}
+
+// CORSOriginPatterns 返回 WebSocket 握手允许的来源主机，与 CORS 设置一致。
+func CORSOriginPatterns() []string {
+	origins := corsOrigins.Load()
+	if origins == nil || slices.Contains(*origins, "*") {
+		return []string{"*"}
+	}
+	var result []string
+	for _, origin := range *origins {
+		if u, err := url.Parse(origin); err == nil {
+			result = append(result, u.Host)
+		}
+	}
+	return result
+}
+
+// allowOrigin 返回 Access-Control-Allow-Origin 的值，来源不允许时返回空字符串。
+func allowOrigin(origin string) string {
+	origins := corsOrigins.Load()
+	if origins == nil || slices.Contains(*origins, "*") {
+		return "*"
+	}
+	if origin != "" && slices.Contains(*origins, origin) {
+		return origin
+	}
+	return ""
+}
+
 // CORS
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		allowed := allowOrigin(r.Header.Get("Origin"))
+		if allowed != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+		}
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "accept, content-type, content-length, accept-encoding, x-csrf-token, authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "accept, content-type, content-length, accept-encoding, x-csrf-token, authorization, x-request-id")
+		w.Header().Set("Access-Control-Expose-Headers", "x-request-id")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"ovaphlow.com/crate/data/metrics"
+)
+
+// statusRecorder 记录响应的状态码，Unwrap 使 http.ResponseController 仍能刷新、劫持连接和设置期限。
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// 指标：按路由、数据源、数据表和状态码记录请求数和耗时。
+// 路由取注册时的模式而不是请求路径（见 RouteMiddleware），数据源和数据表由数据接口设置，客户端的任意路径不会产生新的时间序列
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, labels := metrics.WithLabels(r.Context())
+		r = r.WithContext(ctx)
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			route := labels.Route
+			if route == "" {
+				route = metrics.UnmatchedRoute
+			}
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			values := []string{route, labels.Backend, labels.Table, strconv.Itoa(status)}
+			metrics.HTTPRequests.WithLabelValues(values...).Inc()
+			metrics.HTTPDuration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// 路由：记录请求匹配的路由模式，供指标和链路追踪使用。应为最内层的中间件，
+// 外层中间件复制请求（例如设置上下文）后，ServeMux 只在它收到的请求上记录匹配的模式
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		metrics.LabelRoute(r)
+	})
+}
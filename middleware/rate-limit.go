@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+	"ovaphlow.com/crate/data/schema"
+)
+
+// rateLimitIdle 客户端空闲超过该时长后移除其令牌桶。
+const rateLimitIdle = time.Minute
+
+// rateLimiter 按客户端地址的令牌桶。
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+	swept   time.Time
+}
+
+type clientLimiter struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// limiter 当前的限流设置，为 nil 时不限流。
+var limiter atomic.Pointer[rateLimiter]
+
+// SetRateLimit 设置每个客户端地址的请求速率，立即生效，已有的令牌桶被重置。
+//
+// 参数:
+//   - perSecond: 每秒请求数，0 表示不限制。
+//   - burst: 允许的突发请求数，0 表示与每秒请求数相同（至少为 1）。
+func SetRateLimit(perSecond float64, burst int) {
+	if perSecond <= 0 {
+		limiter.Store(nil)
+		return
+	}
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(perSecond)))
+	}
+	limiter.Store(&rateLimiter{limit: rate.Limit(perSecond), burst: burst, clients: map[string]*clientLimiter{}})
+}
+
+// client 获取客户端的令牌桶，并清理空闲的令牌桶。
+func (l *rateLimiter) client(addr string) *rate.Limiter {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[addr]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[addr] = c
+	}
+	c.seen = now
+	if now.Sub(l.swept) >= rateLimitIdle {
+		for a, c := range l.clients {
+			if now.Sub(c.seen) >= rateLimitIdle {
+				delete(l.clients, a)
+			}
+		}
+		l.swept = now
+	}
+	return c.limiter
+}
+
+// 限流：按客户端地址限制请求速率，超过时返回 429 和 Retry-After
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := limiter.Load()
+		if l == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			addr = r.RemoteAddr
+		}
+		reservation := l.client(addr).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			schema.WriteProblem(w, r, "请求过于频繁", schema.NewError(schema.KindTooManyRequests, "请求过于频繁，请稍后重试"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
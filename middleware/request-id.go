@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// 请求标识：沿用调用方传入的 X-Request-ID，缺失或格式不合法时重新生成
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(schema.RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+			r.Header.Set(schema.RequestIDHeader, id)
+		}
+		w.Header().Set(schema.RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,40 +3,67 @@ package middleware
 import (
 	"bytes"
 	"io"
+	"mime"
 	"net/http"
+	"strings"
 
+	"ovaphlow.com/crate/data/schema"
 	"ovaphlow.com/crate/data/utility"
 
 	"go.uber.org/zap"
 )
 
+// logBodyLimit 请求日志中记录的请求体最大字节数。
+const logBodyLimit = 4 << 10
+
 func LogRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		method := r.Method
 		url := r.URL.String()
 
 		// Log basic request info
-		if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
-			// Read body
-			var bodyBytes []byte
-			if r.Body != nil {
-				bodyBytes, _ = io.ReadAll(r.Body)
-				// Restore body
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		if logBody(r) {
+			// Read at most logBodyLimit bytes, the rest is still read from the connection
+			bodyBytes, _ := io.ReadAll(io.LimitReader(r.Body, logBodyLimit+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(bodyBytes), r.Body), r.Body}
+			body := string(bodyBytes)
+			if len(bodyBytes) > logBodyLimit {
+				body = string(bodyBytes[:logBodyLimit]) + "...(truncated)"
 			}
 
 			utility.ZapLogger.Info("HTTP Request",
 				zap.String("method", method),
 				zap.String("url", url),
-				zap.String("body", string(bodyBytes)),
+				zap.String("body", body),
+				zap.String("request_id", r.Header.Get(schema.RequestIDHeader)),
 			)
 		} else {
 			utility.ZapLogger.Info("HTTP Request",
 				zap.String("method", method),
 				zap.String("url", url),
+				zap.String("request_id", r.Header.Get(schema.RequestIDHeader)),
 			)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// logBody 判断是否在日志中记录请求体：导入的文件和 multipart 上传不记录，
+// 既避免把整个文件读入内存，也避免文件内容写入日志。
+func logBody(r *http.Request) bool {
+	if r.Body == nil || (r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch) {
+		return false
+	}
+	if strings.HasSuffix(r.URL.Path, "/_import") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return !strings.HasPrefix(mediaType, "multipart/")
+}
+
+// readCloser 读取 Reader，关闭时关闭原请求体。
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"ovaphlow.com/crate/data/utility"
+)
+
+func TestLogRequestBody(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := utility.ZapLogger
+	utility.ZapLogger = zap.New(core)
+	t.Cleanup(func() { utility.ZapLogger = logger })
+
+	large := strings.Repeat("a", logBodyLimit+100)
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		logged      string // 日志中的请求体，"-" 表示不记录
+	}{
+		{"json", "/api/sqlite/items", "application/json", `{"title":"a"}`, `{"title":"a"}`},
+		{"truncated", "/api/sqlite/items", "application/json", large, large[:logBodyLimit] + "...(truncated)"},
+		{"import", "/api/sqlite/items/_import", "text/csv", "id,secret\n1,x\n", "-"},
+		{"multipart", "/api/upload", "multipart/form-data; boundary=x", "--x\r\n", "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.TakeAll()
+			var read string
+			handler := LogRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				read = string(b)
+			}))
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			// 处理器总能读到完整的请求体
+			if read != tt.body {
+				t.Errorf("handler read %d bytes, want %d", len(read), len(tt.body))
+			}
+			entries := logs.TakeAll()
+			if len(entries) != 1 {
+				t.Fatalf("got %d log entries, want 1", len(entries))
+			}
+			body, ok := entries[0].ContextMap()["body"]
+			if tt.logged == "-" && ok {
+				t.Errorf("got body %q in the log, want none", body)
+			}
+			if tt.logged != "-" && body != tt.logged {
+				t.Errorf("got body %.40q in the log, want %.40q", body, tt.logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"ovaphlow.com/crate/data/metrics"
+	"ovaphlow.com/crate/data/schema"
+)
+
+var tracer = otel.Tracer("ovaphlow.com/crate/data/middleware")
+
+// 链路追踪：按请求头中的 traceparent 延续上游的追踪，为每个请求创建服务端 span，
+// 其中的中间件、应用服务和数据库调用都是它的子 span。span 名称为匹配的路由模式，请求结束后确定
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(status),
+			attribute.String("crate.request_id", r.Header.Get(schema.RequestIDHeader)),
+		)
+		if labels := metrics.LabelsFrom(ctx); labels != nil && labels.Route != "" {
+			// 路由模式可能带有请求方法，例如 "GET /crate-api-data/{backend}/{st}"
+			_, route, ok := strings.Cut(labels.Route, " ")
+			if !ok {
+				route = labels.Route
+			}
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+			if labels.Backend != "" {
+				span.SetAttributes(attribute.String("crate.datasource", labels.Backend), semconv.DBCollectionName(labels.Table))
+			}
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+// Querier runs catalog queries; it is implemented by *sql.DB and *sql.Tx.
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Dialect is what SQLRepoImpl needs to know about one database engine. A new backend
+// is added by implementing Dialect and passing it to NewSQLRepo.
+type Dialect interface {
+	// Name returns the engine name, which is also the database/sql driver name, e.g., "postgres".
+	Name() string
+
+	// Placeholder returns the statement placeholder of the n-th parameter, counting from 1.
+	Placeholder(n int) string
+
+	// Quote quotes a single identifier, e.g., a column name.
+	Quote(identifier string) string
+
+	// LoadTable reads the columns, primary key and indexes of a table.
+	//
+	// Parameters:
+	// - db: connection or transaction
+	// - st: schema and table as given in the request
+	//
+	// Returns:
+	// - *TableMeta: table metadata, without columns when the table does not exist
+	// - error: error information
+	LoadTable(db Querier, st string) (*TableMeta, error)
+
+	// ListTables lists the tables visible to the connection, in the form accepted by LoadTable.
+	ListTables(db Querier) ([]string, error)
+
+	// Value returns the SQL expression that writes placeholder to a column of the given
+	// catalog type, e.g., "CAST(? AS JSON)" for a MySQL JSON column.
+	Value(placeholder string, columnType string) string
+
+	// Returning reports whether inserts report a generated key with RETURNING instead of
+	// LastInsertId.
+	Returning() bool
+
+	// DefaultValues returns the clause that follows "INSERT INTO table" for a row with no
+	// values, e.g., "DEFAULT VALUES".
+	DefaultValues() string
+
+	// Condition compiles a filter operator whose SQL differs between engines, i.e. the JSON
+	// operators. Placeholders for values are obtained from bind.
+	//
+	// Parameters:
+	// - op: operator, e.g., "json-field-in"
+	// - field: column, already validated and quoted
+	// - args: arguments of the condition after the column
+	// - bind: adds a statement parameter and returns its placeholder
+	//
+	// Returns:
+	// - string: SQL condition
+	// - bool: false when the operator is not supported
+	Condition(op string, field string, args []string, bind func(v interface{}) string) (string, bool)
+}
+
+// dialects maps engine names to their dialects.
+var dialects = map[string]Dialect{
+	"postgres": PostgresDialect{},
+	"mysql":    MySQLDialect{},
+	"sqlite":   SQLiteDialect{},
+}
+
+// DialectFor returns the dialect of an engine.
+// Parameters:
+// - engine: engine name, e.g., "postgres"
+// Returns:
+// - Dialect: dialect of the engine
+// - bool: false when the engine is not supported
+func DialectFor(engine string) (Dialect, bool) {
+	d, ok := dialects[engine]
+	return d, ok
+}
+
+// quote_table quotes each part of a "schema.table" name.
+func quote_table(d Dialect, st string) string {
+	parts := strings.Split(st, ".")
+	for i, part := range parts {
+		parts[i] = d.Quote(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quote_with quotes an identifier with q, doubling q inside the name.
+func quote_with(q string, identifier string) string {
+	return q + strings.ReplaceAll(identifier, q, q+q) + q
+}
+
+// json_path returns the JSON path of a top level key, e.g., `$."status"`.
+func json_path(key string) string {
+	b, _ := json.Marshal(key)
+	return "$." + string(b)
+}
+
+// statementArgs collects statement parameters and numbers their placeholders.
+type statementArgs struct {
+	dialect Dialect
+	values  []interface{}
+}
+
+// bind adds v as a statement parameter and returns its placeholder.
+func (a *statementArgs) bind(v interface{}) string {
+	a.values = append(a.values, v)
+	return a.dialect.Placeholder(len(a.values))
+}
+
+// quote_column validates a column named by a request against the table metadata and
+// quotes it. Columns are not validated when the metadata does not list them: a table
+// that does not exist is reported by the database, and a PostgreSQL table given without
+// a schema is described as "*".
+// Parameters:
+// - d: dialect
+// - meta: table metadata
+// - column: column as given in the request
+// Returns:
+// - string: quoted column
+// - error: a bad request error when the table has no such column
+func quote_column(d Dialect, meta *TableMeta, column string) (string, error) {
+	if len(meta.Columns) > 0 && !slices.Equal(meta.Columns, []string{"*"}) && !slices.Contains(meta.Columns, column) {
+		return "", &schema.Error{Kind: schema.KindBadRequest, Detail: "数据表没有列 " + column, Field: column}
+	}
+	return d.Quote(column), nil
+}
+
+// aggregates are the functions a request may apply to a selected column.
+var aggregates = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+
+// select_column compiles one selected column of a request: a column, or an aggregate
+// of a column such as "SUM(amount)" or "COUNT(*)", optionally renamed with "as alias".
+// Columns and aliases are quoted; any other expression is rejected.
+// Parameters:
+// - d: dialect
+// - meta: table metadata
+// - c: selected column as given in the request, e.g., "COUNT(*) as qty"
+// Returns:
+// - string: select expression
+// - error: a bad request error when c is not a column or an aggregate of one
+func select_column(d Dialect, meta *TableMeta, c string) (string, error) {
+	expr, alias := strings.TrimSpace(c), ""
+	if fields := strings.Fields(expr); len(fields) >= 3 && strings.EqualFold(fields[len(fields)-2], "as") {
+		alias = strings.Trim(fields[len(fields)-1], "\"`")
+		expr = strings.Join(fields[:len(fields)-2], " ")
+	}
+
+	var compiled string
+	if fn, arg, ok := strings.Cut(expr, "("); ok && strings.HasSuffix(arg, ")") {
+		fn = strings.ToUpper(strings.TrimSpace(fn))
+		arg = strings.TrimSpace(strings.TrimSuffix(arg, ")"))
+		if !slices.Contains(aggregates, fn) {
+			return "", &schema.Error{Kind: schema.KindBadRequest, Detail: "不支持的列表达式 " + c, Field: "c"}
+		}
+		if arg != "*" || fn != "COUNT" {
+			column, err := quote_column(d, meta, arg)
+			if err != nil {
+				return "", err
+			}
+			arg = column
+		}
+		compiled = fn + "(" + arg + ")"
+	} else {
+		column, err := quote_column(d, meta, expr)
+		if err != nil {
+			return "", err
+		}
+		compiled = column
+	}
+	if alias != "" {
+		compiled += " AS " + d.Quote(alias)
+	}
+	return compiled, nil
+}
+
+// build_where compiles filter conditions into the body of a WHERE clause. Comparison
+// operators are the same on every engine; the JSON operators are compiled by the dialect.
+// Conditions with too few arguments or an unknown operator are skipped.
+// Parameters:
+// - d: dialect
+// - meta: table metadata, the columns of the conditions must belong to it
+// - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
+// - args: statement parameters, the placeholders continue after the ones already bound
+// Returns:
+// - string: conditions joined by AND, empty when f has none
+// - error: a bad request error when a condition names an unknown column
+func build_where(d Dialect, meta *TableMeta, f [][]string, args *statementArgs) (string, error) {
+	var clauses []string
+	for _, condition := range f {
+		if len(condition) < 3 {
+			continue
+		}
+		operator, values := condition[0], condition[2:]
+		field, err := quote_column(d, meta, condition[1])
+		if err != nil {
+			return "", err
+		}
+		switch operator {
+		case "equal":
+			clauses = append(clauses, field+" = "+args.bind(values[0]))
+		case "not-equal":
+			clauses = append(clauses, field+" != "+args.bind(values[0]))
+		case "greater":
+			clauses = append(clauses, field+" > "+args.bind(values[0]))
+		case "greater-equal":
+			clauses = append(clauses, field+" >= "+args.bind(values[0]))
+		case "less":
+			clauses = append(clauses, field+" < "+args.bind(values[0]))
+		case "less-equal":
+			clauses = append(clauses, field+" <= "+args.bind(values[0]))
+		case "like":
+			clauses = append(clauses, field+" LIKE "+args.bind(values[0]))
+		case "in", "not-in":
+			placeholders := make([]string, len(values))
+			for i, v := range values {
+				placeholders[i] = args.bind(v)
+			}
+			keyword := " IN ("
+			if operator == "not-in" {
+				keyword = " NOT IN ("
+			}
+			clauses = append(clauses, field+keyword+strings.Join(placeholders, ", ")+")")
+		default:
+			if clause, ok := d.Condition(operator, field, values, args.bind); ok {
+				clauses = append(clauses, clause)
+			}
+		}
+	}
+	return strings.Join(clauses, " AND "), nil
+}
+
+// bind_value converts a value for a statement parameter: JSON objects and arrays are
+// marshalled, and time.Time values follow the time storage mode.
+func bind_value(v interface{}, ts TimeStorage) (interface{}, error) {
+	switch val := v.(type) {
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	return bindValue(v, ts), nil
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+func TestBuildWhere(t *testing.T) {
+	meta := &TableMeta{Columns: []string{"id", "tags", `we"ird`, "na`me"}}
+	tests := []struct {
+		name    string
+		dialect Dialect
+		f       [][]string
+		want    string
+		args    int
+	}{
+		{"postgres comparison", PostgresDialect{}, [][]string{{"equal", "id", "1"}, {"in", "id", "2", "3"}}, `"id" = $1 AND "id" IN ($2, $3)`, 3},
+		{"postgres json", PostgresDialect{}, [][]string{{"json-array-contains", "tags", "a"}}, `"tags"::jsonb @> jsonb_build_array($1::text)`, 1},
+		{"postgres quote in name", PostgresDialect{}, [][]string{{"equal", `we"ird`, "1"}}, `"we""ird" = $1`, 1},
+		{"mysql json", MySQLDialect{}, [][]string{{"json-field-in", "tags", "k", "a"}}, "JSON_UNQUOTE(JSON_EXTRACT(`tags`, ?)) IN (?)", 2},
+		{"mysql quote in name", MySQLDialect{}, [][]string{{"like", "na`me", "a%"}}, "`na``me` LIKE ?", 1},
+		{"sqlite json", SQLiteDialect{}, [][]string{{"json-object-contains", "tags", "k", "v"}}, `json_extract("tags", ?) = ?`, 2},
+		{"short condition skipped", SQLiteDialect{}, [][]string{{"equal", "id"}}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := &statementArgs{dialect: tt.dialect}
+			got, err := build_where(tt.dialect, meta, tt.f, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || len(args.values) != tt.args {
+				t.Errorf("got %q with %d args, want %q with %d", got, len(args.values), tt.want, tt.args)
+			}
+		})
+	}
+}
+
+func TestBuildWhereUnknownColumn(t *testing.T) {
+	meta := &TableMeta{Columns: []string{"id"}}
+	for _, field := range []string{"id = id OR 1=1 --", "ID", `"id"`, "missing"} {
+		for _, op := range []string{"equal", "json-field-in"} {
+			_, err := build_where(SQLiteDialect{}, meta, [][]string{{op, field, "k", "v"}}, &statementArgs{dialect: SQLiteDialect{}})
+			if e := schema.AsError(err); e.Kind != schema.KindBadRequest || e.Field != field || is_unknown_column(err) {
+				t.Errorf("%s %q: got %v", op, field, err)
+			}
+		}
+	}
+
+	// Tables that are not described are left to the database.
+	for _, meta := range []*TableMeta{{}, {Columns: []string{"*"}}} {
+		got, err := build_where(PostgresDialect{}, meta, [][]string{{"equal", "x", "1"}}, &statementArgs{dialect: PostgresDialect{}})
+		if err != nil || got != `"x" = $1` {
+			t.Errorf("columns %v: got %q, %v", meta.Columns, got, err)
+		}
+	}
+}
+
+func TestSelectColumn(t *testing.T) {
+	meta := &TableMeta{Columns: []string{"id", "city", "amount"}}
+	tests := []struct {
+		c    string
+		want string
+		ok   bool
+	}{
+		{"city", "`city`", true},
+		{" city ", "`city`", true},
+		{"city as base", "`city` AS `base`", true},
+		{"COUNT(*) as qty", "COUNT(*) AS `qty`", true},
+		{"count( * )", "COUNT(*)", true},
+		{"sum(amount) AS `total`", "SUM(`amount`) AS `total`", true},
+		{"MAX(id) as a`b", "MAX(`id`) AS `a``b`", true},
+		{"SUM(*)", "", false},
+		{"SUM(missing)", "", false},
+		{"COUNT(DISTINCT city)", "", false},
+		{"SLEEP(5)", "", false},
+		{"city, (SELECT 1)", "", false},
+		{"data_state->>'status' as status", "", false},
+	}
+	for _, tt := range tests {
+		got, err := select_column(MySQLDialect{}, meta, tt.c)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("%q: got %q, %v, want %q", tt.c, got, err, tt.want)
+		}
+		if !tt.ok && schema.AsError(err).Kind != schema.KindBadRequest {
+			t.Errorf("%q: got %q, %v, want a bad request", tt.c, got, err)
+		}
+	}
+}
+
+func TestSQLiteColumnValidation(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	for _, q := range []string{
+		`CREATE TABLE items (id TEXT PRIMARY KEY, "order" INTEGER)`,
+		`INSERT INTO items (id, "order") VALUES ('1', 1), ('2', 2)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := NewSQLiteRepo(db, TimeStorageText, 0)
+	ctx := context.Background()
+
+	// Reserved words are usable as columns once quoted.
+	rows, err := repo.Get(ctx, "items", []string{"id", "order"}, [][]string{{"greater", "order", "1"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["id"] != "2" {
+		t.Errorf("got %v, want record 2", rows)
+	}
+
+	rows, err = repo.Get(ctx, "items", []string{"COUNT(*) as qty", "SUM(order) as total"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["qty"] != "2" || rows[0]["total"] != "3" {
+		t.Errorf("got %v, want qty 2 and total 3", rows)
+	}
+
+	badRequest := func(name string, err error) {
+		t.Helper()
+		if kind := schema.AsError(err).Kind; kind != schema.KindBadRequest {
+			t.Errorf("%s: got %v, want %s", name, err, schema.KindBadRequest)
+		}
+	}
+	_, err = repo.Get(ctx, "items", []string{"id", "(SELECT 1)"}, nil, "")
+	badRequest("select expression", err)
+	_, err = repo.Get(ctx, "items", nil, [][]string{{"equal", "1=1 OR id", "x"}}, "")
+	badRequest("filter expression", err)
+	_, err = repo.Update(ctx, "items", map[string]any{"order": 3}, [][]string{{"equal", "missing", "1"}})
+	badRequest("update", err)
+	_, err = repo.Remove(ctx, "items", [][]string{{"not-equal", "missing", "1"}})
+	badRequest("remove", err)
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM items").Scan(&n); err != nil || n != 2 {
+		t.Errorf("got %d rows, %v after rejected statements, want 2", n, err)
+	}
+
+	// A column added after the metadata was cached is rejected until the metadata is
+	// reloaded; rejected requests do not reload it.
+	if _, err := db.Exec("ALTER TABLE items ADD COLUMN label TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	loadedAt := repo.Schema().Tables()["items"].LoadedAt
+	_, err = repo.Get(ctx, "items", []string{"label"}, nil, "")
+	badRequest("new column", err)
+	if got := repo.Schema().Tables()["items"].LoadedAt; !got.Equal(loadedAt) {
+		t.Errorf("metadata loaded at %v after a rejected request, want %v", got, loadedAt)
+	}
+	repo.Schema().Invalidate("items")
+	rows, err = repo.Get(ctx, "items", []string{"label"}, [][]string{{"equal", "id", "1"}}, "")
+	if err != nil {
+		t.Fatalf("new column: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("got %v, want one record", rows)
+	}
+
+	// A column dropped after the metadata was cached is reported by the database, which
+	// reloads the metadata.
+	if _, err := db.Exec("ALTER TABLE items DROP COLUMN label"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Update(ctx, "items", map[string]any{"label": "x"}, [][]string{{"equal", "id", "1"}})
+	badRequest("dropped column", err)
+	if slices.Contains(repo.Schema().Tables()["items"].Columns, "label") {
+		t.Error("got label in the cached columns, want the metadata reloaded")
+	}
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+// Operations passed to translateError. A foreign key violation on delete means the
+// row is still referenced (409); on insert or update it means the referenced row is
+// missing (422).
+const (
+	opInsert = "insert"
+	opSelect = "select"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// errMissingFilter guards Update and Remove against touching every row of a table.
+var errMissingFilter = schema.NewError(schema.KindBadRequest, "缺少过滤条件")
+
+var (
+	pqKeyPattern        = regexp.MustCompile(`Key \(([^)]+)\)=`)
+	mysqlKeyPattern     = regexp.MustCompile(`for key '([^']+)'`)
+	mysqlColumnPattern  = regexp.MustCompile(`[Cc]olumn '([^']+)'`)
+	mysqlFKPattern      = regexp.MustCompile("CONSTRAINT `([^`]+)`")
+	sqliteColumnPattern = regexp.MustCompile(`(?:UNIQUE|NOT NULL|PRIMARY KEY) constraint failed: ([\w.]+)`)
+)
+
+// translateError maps driver errors to *schema.Error so that routers can choose the
+// HTTP status without inspecting driver types. Raw driver messages are kept only in
+// the wrapped error and never copied into Detail.
+// Parameters:
+// - op: one of opInsert, opSelect, opUpdate, opDelete
+// - err: error returned by database/sql
+// Returns:
+// - error: nil when err is nil, otherwise a *schema.Error
+func translateError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var appErr *schema.Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	var mysqlErr *mysql.MySQLError
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.As(err, &pqErr):
+		return translatePostgresError(op, pqErr)
+	case errors.As(err, &mysqlErr):
+		return translateMySQLError(op, mysqlErr)
+	case errors.As(err, &sqliteErr):
+		return translateSQLiteError(op, sqliteErr)
+	case errors.Is(err, context.DeadlineExceeded):
+		return schema.WrapError(schema.KindTimeout, err, "")
+	case errors.Is(err, context.Canceled):
+		// A client that went away is neither a database timeout nor a server error.
+		return schema.WrapError(schema.KindClientClosed, err, "")
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET):
+		return schema.WrapError(schema.KindUnavailable, err, "")
+	}
+	return schema.AsError(err)
+}
+
+func referenceKind(op string) schema.ErrorKind {
+	if op == opDelete {
+		return schema.KindReferenced
+	}
+	return schema.KindInvalidReference
+}
+
+func translatePostgresError(op string, err *pq.Error) error {
+	e := &schema.Error{Err: err, Constraint: err.Constraint, Field: err.Column}
+	switch {
+	case err.Code == "23505":
+		e.Kind, e.Code = schema.KindConflict, "CRATE-409-UNIQUE"
+		if m := pqKeyPattern.FindStringSubmatch(err.Detail); m != nil {
+			e.Field = m[1]
+		}
+	case err.Code == "23503":
+		e.Kind = referenceKind(op)
+		if m := pqKeyPattern.FindStringSubmatch(err.Detail); m != nil {
+			e.Field = m[1]
+		}
+	case err.Code == "23502":
+		e.Kind, e.Code = schema.KindValidation, "CRATE-422-NOT-NULL"
+	case err.Code == "23514":
+		e.Kind, e.Code = schema.KindValidation, "CRATE-422-CHECK"
+	case err.Code == "42P01":
+		e.Kind, e.Code, e.Detail = schema.KindNotFound, "CRATE-404-TABLE", "数据表不存在"
+	case err.Code == "42703":
+		e.Kind, e.Code, e.Detail = schema.KindBadRequest, "CRATE-400-COLUMN", "字段不存在"
+	case err.Code == "57014":
+		e.Kind = schema.KindTimeout
+	case err.Code.Class() == "22":
+		e.Kind, e.Code = schema.KindBadRequest, "CRATE-400-VALUE"
+	case err.Code.Class() == "42":
+		e.Kind, e.Code = schema.KindBadRequest, "CRATE-400-SYNTAX"
+	case err.Code.Class() == "08", err.Code == "53300", err.Code == "57P01",
+		err.Code == "57P02", err.Code == "57P03":
+		e.Kind = schema.KindUnavailable
+	default:
+		e.Kind = schema.KindInternal
+	}
+	return e
+}
+
+func translateMySQLError(op string, err *mysql.MySQLError) error {
+	e := &schema.Error{Err: err}
+	switch err.Number {
+	case 1062:
+		e.Kind, e.Code = schema.KindConflict, "CRATE-409-UNIQUE"
+		if m := mysqlKeyPattern.FindStringSubmatch(err.Message); m != nil {
+			e.Constraint = m[1]
+		}
+	case 1451, 1452:
+		if err.Number == 1451 {
+			e.Kind = schema.KindReferenced
+		} else {
+			e.Kind = schema.KindInvalidReference
+		}
+		if m := mysqlFKPattern.FindStringSubmatch(err.Message); m != nil {
+			e.Constraint = m[1]
+		}
+	case 1048:
+		e.Kind, e.Code = schema.KindValidation, "CRATE-422-NOT-NULL"
+		if m := mysqlColumnPattern.FindStringSubmatch(err.Message); m != nil {
+			e.Field = m[1]
+		}
+	case 3819:
+		e.Kind, e.Code = schema.KindValidation, "CRATE-422-CHECK"
+	case 1146:
+		e.Kind, e.Code, e.Detail = schema.KindNotFound, "CRATE-404-TABLE", "数据表不存在"
+	case 1054:
+		e.Kind, e.Code, e.Detail = schema.KindBadRequest, "CRATE-400-COLUMN", "字段不存在"
+		if m := mysqlColumnPattern.FindStringSubmatch(err.Message); m != nil {
+			e.Field = m[1]
+		}
+	case 1064:
+		e.Kind, e.Code = schema.KindBadRequest, "CRATE-400-SYNTAX"
+	case 1292, 1366, 1406, 3140:
+		e.Kind, e.Code = schema.KindBadRequest, "CRATE-400-VALUE"
+		if m := mysqlColumnPattern.FindStringSubmatch(err.Message); m != nil {
+			e.Field = m[1]
+		}
+	case 1205, 3024:
+		e.Kind = schema.KindTimeout
+	case 1040, 1203, 2002, 2003, 2006, 2013:
+		e.Kind = schema.KindUnavailable
+	default:
+		e.Kind = schema.KindInternal
+	}
+	return e
+}
+
+func translateSQLiteError(op string, err *sqlite.Error) error {
+	e := &schema.Error{Err: err}
+	msg := err.Error()
+	field := func() {
+		if m := sqliteColumnPattern.FindStringSubmatch(msg); m != nil {
+			parts := strings.Split(m[1], ".")
+			e.Field = parts[len(parts)-1]
+		}
+	}
+	switch err.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		e.Kind, e.Code = schema.KindConflict, "CRATE-409-UNIQUE"
+		field()
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		e.Kind = referenceKind(op)
+	case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+		e.Kind, e.Code = schema.KindValidation, "CRATE-422-NOT-NULL"
+		field()
+	case sqlite3.SQLITE_CONSTRAINT_CHECK:
+		e.Kind, e.Code = schema.KindValidation, "CRATE-422-CHECK"
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_CANTOPEN:
+		e.Kind = schema.KindUnavailable
+	case sqlite3.SQLITE_INTERRUPT:
+		e.Kind = schema.KindTimeout
+	default:
+		switch {
+		case strings.Contains(msg, "no such table"):
+			e.Kind, e.Code, e.Detail = schema.KindNotFound, "CRATE-404-TABLE", "数据表不存在"
+		case strings.Contains(msg, "no such column"), strings.Contains(msg, "has no column named"):
+			e.Kind, e.Code, e.Detail = schema.KindBadRequest, "CRATE-400-COLUMN", "字段不存在"
+		case strings.Contains(msg, "syntax error"):
+			e.Kind, e.Code = schema.KindBadRequest, "CRATE-400-SYNTAX"
+		default:
+			e.Kind = schema.KindInternal
+		}
+	}
+	return e
+}
+
+// IsBusy reports whether err is SQLite lock contention: the database answered, but
+// another connection holds the lock.
+func IsBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+func TestTranslatePostgresError(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         string
+		err        *pq.Error
+		kind       schema.ErrorKind
+		code       string
+		field      string
+		constraint string
+	}{
+		{"unique", opInsert, &pq.Error{Code: "23505", Detail: "Key (email)=(a@b.c) already exists.", Constraint: "users_email_key"}, schema.KindConflict, "CRATE-409-UNIQUE", "email", "users_email_key"},
+		{"foreign key on insert", opInsert, &pq.Error{Code: "23503", Detail: "Key (owner_id)=(7) is not present in table \"users\".", Constraint: "orders_owner_fkey"}, schema.KindInvalidReference, "", "owner_id", "orders_owner_fkey"},
+		{"foreign key on delete", opDelete, &pq.Error{Code: "23503", Constraint: "orders_owner_fkey"}, schema.KindReferenced, "", "", "orders_owner_fkey"},
+		{"not null", opInsert, &pq.Error{Code: "23502", Column: "title"}, schema.KindValidation, "CRATE-422-NOT-NULL", "title", ""},
+		{"check", opUpdate, &pq.Error{Code: "23514", Constraint: "price_positive"}, schema.KindValidation, "CRATE-422-CHECK", "", "price_positive"},
+		{"undefined table", opSelect, &pq.Error{Code: "42P01"}, schema.KindNotFound, "CRATE-404-TABLE", "", ""},
+		{"undefined column", opSelect, &pq.Error{Code: "42703"}, schema.KindBadRequest, "CRATE-400-COLUMN", "", ""},
+		{"query canceled", opSelect, &pq.Error{Code: "57014"}, schema.KindTimeout, "", "", ""},
+		{"invalid text representation", opInsert, &pq.Error{Code: "22P02"}, schema.KindBadRequest, "CRATE-400-VALUE", "", ""},
+		{"syntax error", opSelect, &pq.Error{Code: "42601"}, schema.KindBadRequest, "CRATE-400-SYNTAX", "", ""},
+		{"connection failure", opSelect, &pq.Error{Code: "08006"}, schema.KindUnavailable, "", "", ""},
+		{"too many connections", opSelect, &pq.Error{Code: "53300"}, schema.KindUnavailable, "", "", ""},
+		{"admin shutdown", opSelect, &pq.Error{Code: "57P01"}, schema.KindUnavailable, "", "", ""},
+		{"other", opSelect, &pq.Error{Code: "XX000"}, schema.KindInternal, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := schema.AsError(translateError(tt.op, tt.err))
+			if e.Kind != tt.kind || e.Code != tt.code || e.Field != tt.field || e.Constraint != tt.constraint {
+				t.Errorf("got kind=%s code=%q field=%q constraint=%q, want kind=%s code=%q field=%q constraint=%q",
+					e.Kind, e.Code, e.Field, e.Constraint, tt.kind, tt.code, tt.field, tt.constraint)
+			}
+			if !errors.Is(e, tt.err) {
+				t.Error("driver error is not wrapped")
+			}
+		})
+	}
+}
+
+func TestTranslateMySQLError(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         string
+		err        *mysql.MySQLError
+		kind       schema.ErrorKind
+		code       string
+		field      string
+		constraint string
+	}{
+		{"duplicate entry", opInsert, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.email'"}, schema.KindConflict, "CRATE-409-UNIQUE", "", "users.email"},
+		{"row is referenced", opDelete, &mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row: a foreign key constraint fails (`shop`.`orders`, CONSTRAINT `orders_owner_fk` FOREIGN KEY (`owner_id`) REFERENCES `users` (`id`))"}, schema.KindReferenced, "", "", "orders_owner_fk"},
+		{"referenced row missing", opInsert, &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`shop`.`orders`, CONSTRAINT `orders_owner_fk` FOREIGN KEY (`owner_id`) REFERENCES `users` (`id`))"}, schema.KindInvalidReference, "", "", "orders_owner_fk"},
+		{"not null", opInsert, &mysql.MySQLError{Number: 1048, Message: "Column 'title' cannot be null"}, schema.KindValidation, "CRATE-422-NOT-NULL", "title", ""},
+		{"check", opInsert, &mysql.MySQLError{Number: 3819, Message: "Check constraint 'price_positive' is violated."}, schema.KindValidation, "CRATE-422-CHECK", "", ""},
+		{"unknown table", opSelect, &mysql.MySQLError{Number: 1146, Message: "Table 'shop.nope' doesn't exist"}, schema.KindNotFound, "CRATE-404-TABLE", "", ""},
+		{"unknown column", opSelect, &mysql.MySQLError{Number: 1054, Message: "Unknown column 'nope' in 'field list'"}, schema.KindBadRequest, "CRATE-400-COLUMN", "nope", ""},
+		{"syntax error", opSelect, &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}, schema.KindBadRequest, "CRATE-400-SYNTAX", "", ""},
+		{"incorrect value", opInsert, &mysql.MySQLError{Number: 1366, Message: "Incorrect integer value: 'x' for column 'price' at row 1"}, schema.KindBadRequest, "CRATE-400-VALUE", "price", ""},
+		{"lock wait timeout", opUpdate, &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, schema.KindTimeout, "", "", ""},
+		{"too many connections", opSelect, &mysql.MySQLError{Number: 1040, Message: "Too many connections"}, schema.KindUnavailable, "", "", ""},
+		{"other", opSelect, &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, schema.KindInternal, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := schema.AsError(translateError(tt.op, tt.err))
+			if e.Kind != tt.kind || e.Code != tt.code || e.Field != tt.field || e.Constraint != tt.constraint {
+				t.Errorf("got kind=%s code=%q field=%q constraint=%q, want kind=%s code=%q field=%q constraint=%q",
+					e.Kind, e.Code, e.Field, e.Constraint, tt.kind, tt.code, tt.field, tt.constraint)
+			}
+		})
+	}
+}
+
+// TestTranslateSQLiteError runs real statements, since sqlite.Error cannot be built outside the driver.
+func TestTranslateSQLiteError(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:errors?mode=memory&_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, owner_id INTEGER REFERENCES users (id), title TEXT NOT NULL, price INTEGER CHECK (price > 0))",
+		"INSERT INTO users (id, email) VALUES (1, 'a@b.c')",
+		"INSERT INTO orders (id, owner_id, title, price) VALUES (1, 1, 'x', 1)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		op    string
+		q     string
+		kind  schema.ErrorKind
+		code  string
+		field string
+	}{
+		{"unique", opInsert, "INSERT INTO users (id, email) VALUES (2, 'a@b.c')", schema.KindConflict, "CRATE-409-UNIQUE", "email"},
+		{"primary key", opInsert, "INSERT INTO users (id, email) VALUES (1, 'x@y.z')", schema.KindConflict, "CRATE-409-UNIQUE", "id"},
+		{"foreign key on insert", opInsert, "INSERT INTO orders (owner_id, title, price) VALUES (9, 'x', 1)", schema.KindInvalidReference, "", ""},
+		{"foreign key on delete", opDelete, "DELETE FROM users WHERE id = 1", schema.KindReferenced, "", ""},
+		{"not null", opInsert, "INSERT INTO orders (owner_id, price) VALUES (1, 1)", schema.KindValidation, "CRATE-422-NOT-NULL", "title"},
+		{"check", opInsert, "INSERT INTO orders (owner_id, title, price) VALUES (1, 'x', 0)", schema.KindValidation, "CRATE-422-CHECK", ""},
+		{"no such table", opSelect, "SELECT * FROM nope", schema.KindNotFound, "CRATE-404-TABLE", ""},
+		{"no such column", opSelect, "SELECT nope FROM users", schema.KindBadRequest, "CRATE-400-COLUMN", ""},
+		{"syntax error", opSelect, "SELEC 1", schema.KindBadRequest, "CRATE-400-SYNTAX", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.Exec(tt.q)
+			if err == nil {
+				t.Fatal("statement succeeded")
+			}
+			e := schema.AsError(translateError(tt.op, err))
+			if e.Kind != tt.kind || e.Code != tt.code || e.Field != tt.field {
+				t.Errorf("%v: got kind=%s code=%q field=%q, want kind=%s code=%q field=%q", err, e.Kind, e.Code, e.Field, tt.kind, tt.code, tt.field)
+			}
+		})
+	}
+}
+
+func TestTranslateGenericError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind schema.ErrorKind
+	}{
+		{"nil", nil, ""},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), schema.KindTimeout},
+		{"client closed", fmt.Errorf("query: %w", context.Canceled), schema.KindClientClosed},
+		{"bad connection", driver.ErrBadConn, schema.KindUnavailable},
+		{"connection done", sql.ErrConnDone, schema.KindUnavailable},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), schema.KindUnavailable},
+		{"already classified", schema.NewError(schema.KindNotFound, "记录不存在"), schema.KindNotFound},
+		{"unknown", errors.New("boom"), schema.KindInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := translateError(opSelect, tt.err)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+				return
+			}
+			if kind := schema.AsError(err).Kind; kind != tt.kind {
+				t.Errorf("got %s, want %s", kind, tt.kind)
+			}
+		})
+	}
+}
+
+func TestClientClosedIsNotServerError(t *testing.T) {
+	e := schema.AsError(translateError(opSelect, context.Canceled))
+	if status := e.Status(); status != schema.StatusClientClosedRequest {
+		t.Errorf("got status %d, want %d", status, schema.StatusClientClosedRequest)
+	}
+}
@@ -1,21 +1,18 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"reflect"
-	"strconv"
 	"strings"
 	"time"
-
-	"ovaphlow.com/crate/data/utility"
 )
 
-func get_columns_mysql(db *sql.DB, st string) ([]string, map[string]string, error) {
+func load_table_mysql(db Querier, st string) (*TableMeta, error) {
 	slice := strings.Split(st, ".")
 	if len(slice) != 2 {
-		return nil, nil, fmt.Errorf("参数错误 schema table")
+		return nil, fmt.Errorf("参数错误 schema table")
 	}
 	query := `
 	select column_name, data_type
@@ -25,288 +22,180 @@ func get_columns_mysql(db *sql.DB, st string) ([]string, map[string]string, erro
 	`
 	rows, err := db.Query(query, slice[0], slice[1])
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	defer rows.Close()
-	columns := []string{}
-	columnTypes := make(map[string]string)
+	meta := &TableMeta{Types: make(map[string]string)}
 	for rows.Next() {
 		var name, dataType string
 		if err := rows.Scan(&name, &dataType); err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		columns = append(columns, name)
-		columnTypes[name] = dataType
-	}
-	return columns, columnTypes, nil
-}
-
-type MySQLRepoImpl struct {
-	db *sql.DB
-}
-
-// NewMySQLRepo creates a new MySQLRepoImpl instance.
-//
-// Parameters:
-//   - db: database connection
-//
-// Returns:
-//   - *MySQLRepoImpl: MySQLRepoImpl instance
-func NewMySQLRepo(db *sql.DB) *MySQLRepoImpl {
-	return &MySQLRepoImpl{db: db}
-}
-
-// Create inserts a new record into the specified table (MySQL).
-//
-// Parameters:
-//   - st: schema and table, format like "schema.table"
-//   - d: data to be inserted
-//
-// Returns:
-//   - error: error information
-func (r *MySQLRepoImpl) Create(st string, d map[string]any) error {
-	utility.ZapLogger.Info(fmt.Sprintf("Data: %v\n", d))
-	columns, columnTypes, err := get_columns_mysql(r.db, st)
-	if err != nil {
-		return err
+		meta.Columns = append(meta.Columns, name)
+		meta.Types[name] = dataType
 	}
-
-	var placeholders []string
-	var values []any
-	for _, column := range columns {
-		if val, ok := d[column]; ok {
-			if str, isStr := val.(string); isStr && column == "event_time" {
-				if strings.Contains(str, "+") && strings.Contains(str, "-") && strings.Contains(str, ":") {
-					t, err := time.Parse("2006-01-02 15:04:05", str)
-					if err == nil {
-						val = t.Format("2006-01-02 15:04:05")
-					}
-				}
-			}
-
-			if columnTypes[column] == "json" {
-				switch v := val.(type) {
-				case map[string]any, []any:
-					jsonStr, err := json.Marshal(v)
-					if err != nil {
-						return err
-					}
-					placeholders = append(placeholders, "CAST(? AS JSON)")
-					values = append(values, string(jsonStr))
-				default:
-					placeholders = append(placeholders, "CAST(? AS JSON)")
-					values = append(values, val)
-				}
-			} else {
-				placeholders = append(placeholders, "?")
-				values = append(values, val)
-			}
-		}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	q := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", st, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
-	utility.ZapLogger.Info(fmt.Sprintf("Query: %s\n", q))
-	utility.ZapLogger.Info(fmt.Sprintf("Values: %v\n", values))
-	stmt, err := r.db.Prepare(q)
+	indexes, err := db.Query(`
+	select index_name, non_unique, column_name
+	from information_schema.statistics
+	where table_schema = ? and table_name = ?
+	order by index_name, seq_in_index;
+	`, slice[0], slice[1])
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(values...)
-	return err
-}
-
-// Get retrieves records from the specified table based on conditions.
-//
-// Parameters:
-//   - st: schema and table, format like "schema.table"
-//   - c: columns to retrieve, e.g., ["id", "name"]
-//   - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
-//   - l: additional clauses, e.g., "order by id desc limit 20 offset 0"
-//
-// Returns:
-//   - []map[string]interface{}: retrieved records
-//   - error: error information
-func (r *MySQLRepoImpl) Get(st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
-	if len(c) == 0 {
-		var err error
-		c, _, err = get_columns_mysql(r.db, st)
-		if err != nil {
-			utility.ZapLogger.Error(fmt.Sprintf("Error getting columns for %s: %s", st, err.Error()))
+	defer indexes.Close()
+	for indexes.Next() {
+		var name string
+		var nonUnique int
+		var column sql.NullString
+		if err := indexes.Scan(&name, &nonUnique, &column); err != nil {
 			return nil, err
 		}
-	}
-	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(c, ", "), st)
-
-	var whereClauses []string
-	var params []interface{}
-
-	for _, condition := range f {
-		if len(condition) < 3 {
+		// 函数索引没有列名
+		if !column.Valid {
 			continue
 		}
-		field := condition[1]
-		operator := condition[0]
-		switch operator {
-		case "equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", field))
-			params = append(params, condition[2])
-		case "not-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s != ?", field))
-			params = append(params, condition[2])
-		case "in":
-			placeholders := strings.Repeat("?,", len(condition)-2)
-			placeholders = placeholders[:len(placeholders)-1]
-			whereClauses = append(whereClauses, fmt.Sprintf("%s IN (%s)", field, placeholders))
-			for _, v := range condition[2:] {
-				params = append(params, v)
-			}
-		case "not-in":
-			placeholders := strings.Repeat("?,", len(condition)-2)
-			placeholders = placeholders[:len(placeholders)-1]
-			whereClauses = append(whereClauses, fmt.Sprintf("%s NOT IN (%s)", field, placeholders))
-			for _, v := range condition[2:] {
-				params = append(params, v)
-			}
-		case "like":
-			whereClauses = append(whereClauses, fmt.Sprintf("POSITION(? IN %s) > 0", field))
-			params = append(params, condition[2])
-		case "greater":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s > ?", field))
-			params = append(params, condition[2])
-		case "greater-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s >= ?", field))
-			params = append(params, condition[2])
-		case "less":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s < ?", field))
-			params = append(params, condition[2])
-		case "less-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s <= ?", field))
-			params = append(params, condition[2])
-		case "json-array-contains":
-			whereClauses = append(whereClauses, fmt.Sprintf("JSON_CONTAINS(%s, JSON_ARRAY('%s'))", field, condition[2]))
-		case "json-object-contains":
-			whereClauses = append(whereClauses, fmt.Sprintf("JSON_CONTAINS(%s, ?, '$')", field))
-			params = append(params, fmt.Sprintf(`{"%s": "%s"}`, condition[2], condition[3]))
+		if name == "PRIMARY" {
+			meta.PrimaryKey = append(meta.PrimaryKey, column.String)
+		}
+		if n := len(meta.Indexes); n > 0 && meta.Indexes[n-1].Name == name {
+			meta.Indexes[n-1].Columns = append(meta.Indexes[n-1].Columns, column.String)
+		} else {
+			meta.Indexes = append(meta.Indexes, IndexMeta{Name: name, Columns: []string{column.String}, Unique: nonUnique == 0})
 		}
 	}
+	return meta, indexes.Err()
+}
 
-	if len(whereClauses) > 0 {
-		q += " WHERE " + strings.Join(whereClauses, " AND ")
-	}
-
-	if l != "" {
-		q += " " + l
-	}
-
-	utility.ZapLogger.Info(q)
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-
-	utility.ZapLogger.Info(fmt.Sprintf("Params: %v\n", params))
-	rows, err := stmt.Query(params...)
+func list_tables_mysql(db Querier) ([]string, error) {
+	rows, err := db.Query("select concat(table_schema, '.', table_name) from information_schema.tables where table_schema = database();")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, err
-	}
-	var result []map[string]interface{}
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
+	var tables []string
 	for rows.Next() {
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
+		var st string
+		if err := rows.Scan(&st); err != nil {
 			return nil, err
 		}
-		m := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if val == nil {
-				m[col] = nil
-			} else {
-				switch v := val.(type) {
-				case []byte:
-					m[col] = string(v)
-				case int, int8, int16, int32, int64:
-					m[col] = strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
-				case uint, uint8, uint16, uint32, uint64:
-					m[col] = strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)
-				default:
-					m[col] = v
-				}
-			}
-		}
-		result = append(result, m)
+		tables = append(tables, st)
 	}
-
-	return result, nil
+	return tables, rows.Err()
 }
 
-// Update modifies records in the specified table based on conditions (MySQL).
+// MySQLDialect is the Dialect of MySQL.
+type MySQLDialect struct{}
+
+// NewMySQLRepo creates a repository for MySQL.
 //
 // Parameters:
-//   - st: schema and table, format like "schema.table"
-//   - d: data to be updated
-//   - w: WHERE condition, e.g., "id='1a'"
+//   - db: database connection
+//   - ts: how time.Time values are stored
+//   - statements: number of prepared statements to cache, 0 disables caching
 //
 // Returns:
-//   - error: error information
-func (r *MySQLRepoImpl) Update(st string, d map[string]interface{}, w string) error {
-	columns, _, err := get_columns_mysql(r.db, st)
-	if err != nil {
-		return err
+//   - *SQLRepoImpl: repository
+func NewMySQLRepo(db *sql.DB, ts TimeStorage, statements int) *SQLRepoImpl {
+	return NewSQLRepo(db, MySQLDialect{}, ts, statements)
+}
+
+func (MySQLDialect) Name() string { return "mysql" }
+
+func (MySQLDialect) Placeholder(n int) string { return "?" }
+
+func (MySQLDialect) Quote(identifier string) string { return quote_with("`", identifier) }
+
+func (MySQLDialect) LoadTable(db Querier, st string) (*TableMeta, error) {
+	return load_table_mysql(db, st)
+}
+
+func (MySQLDialect) ListTables(db Querier) ([]string, error) { return list_tables_mysql(db) }
+
+// Value casts values written to JSON columns, which MySQL does not convert from text.
+func (MySQLDialect) Value(placeholder string, columnType string) string {
+	if columnType == "json" {
+		return "CAST(" + placeholder + " AS JSON)"
 	}
+	return placeholder
+}
 
-	q := fmt.Sprintf("UPDATE %s SET ", st)
-	var assignments []string
-	var values []interface{}
-	for _, column := range columns {
-		if val, ok := d[column]; ok {
-			assignments = append(assignments, fmt.Sprintf("%s = ?", column))
-			values = append(values, val)
+func (MySQLDialect) Returning() bool { return false }
+
+func (MySQLDialect) DefaultValues() string { return "() VALUES ()" }
+
+// Condition compiles the JSON operators with JSON_CONTAINS and JSON_EXTRACT.
+// Values are compared as JSON strings.
+func (MySQLDialect) Condition(op string, field string, args []string, bind func(v interface{}) string) (string, bool) {
+	switch op {
+	case "json-array-contains":
+		// ["json-array-contains", column, value]
+		return "JSON_CONTAINS(" + field + ", JSON_ARRAY(" + bind(args[0]) + "))", true
+	case "json-object-contains":
+		// ["json-object-contains", column, key, value]
+		if len(args) < 2 {
+			return "", false
+		}
+		return "JSON_CONTAINS(" + field + ", JSON_OBJECT(" + bind(args[0]) + ", " + bind(args[1]) + "))", true
+	case "json-field-in":
+		// ["json-field-in", column, key, value...]
+		if len(args) < 2 {
+			return "", false
+		}
+		path := bind(json_path(args[0]))
+		placeholders := make([]string, len(args)-1)
+		for i, v := range args[1:] {
+			placeholders[i] = bind(v)
 		}
+		return "JSON_UNQUOTE(JSON_EXTRACT(" + field + ", " + path + ")) IN (" + strings.Join(placeholders, ", ") + ")", true
 	}
-	q += strings.Join(assignments, ", ")
-	q += " WHERE " + w
+	return "", false
+}
 
-	stmt, err := r.db.Prepare(q)
+// ReplicationLag returns Seconds_Behind_Source of a replica, read with SHOW REPLICA STATUS
+// or, before MySQL 8.0.22, SHOW SLAVE STATUS. A server without replication status reports
+// no lag; a replica whose SQL thread is stopped reports an error.
+func (MySQLDialect) ReplicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil && ctx.Err() == nil {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(values...)
-	return err
-}
+	defer rows.Close()
 
-// Remove deletes records from the specified table based on conditions (MySQL).
-//
-// Parameters:
-//   - st: schema and table, format like "schema.table"
-//   - w: WHERE condition, e.g., "id='1a'"
-//
-// Returns:
-//   - error: error information
-func (r *MySQLRepoImpl) Remove(st string, w string) error {
-	q := fmt.Sprintf("DELETE FROM %s WHERE %s", st, w)
-	stmt, err := r.db.Prepare(q)
+	columns, err := rows.Columns()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec()
-	if err != nil {
-		return err
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[i].Valid {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := time.ParseDuration(values[i].String + "s")
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", column, values[i].String)
+		}
+		return seconds, nil
 	}
-	return nil
+	return 0, nil
 }
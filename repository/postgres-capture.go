@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CaptureTrigger is the name of the trigger installed on captured tables.
+const CaptureTrigger = "crate_capture"
+
+var (
+	captureNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\.[A-Za-z_][A-Za-z0-9_]*$`)
+	captureColumnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ChangeLogEntry is one row of the change log written by the capture trigger.
+type ChangeLogEntry struct {
+	ID    int64
+	Table string
+	Op    string
+	Key   string
+	// Record is the new row, or the old row for a delete.
+	Record map[string]any
+	// Application is the application_name of the session that made the change.
+	Application string
+	CreatedAt   time.Time
+}
+
+// PostgresCaptureRepo installs the capture triggers and reads the change log they write.
+//
+// Every captured insert, update and delete appends a row to the change log and calls
+// pg_notify on Channel with the id of that row, so that listeners can both react to
+// notifications and catch up from the log after losing their connection.
+type PostgresCaptureRepo struct {
+	db       *sql.DB
+	logTable string
+	function string
+	// Channel is the notification channel, the table name of the change log.
+	Channel string
+}
+
+// NewPostgresCaptureRepo creates a capture repository.
+// Parameters:
+// - db: database connection
+// - logTable: change log table in "schema.table" format, the trigger function is created next to it
+// Returns:
+// - *PostgresCaptureRepo: repository
+// - error: the table name is invalid
+func NewPostgresCaptureRepo(db *sql.DB, logTable string) (*PostgresCaptureRepo, error) {
+	if !captureNamePattern.MatchString(logTable) {
+		return nil, fmt.Errorf("invalid change log table %q", logTable)
+	}
+	return &PostgresCaptureRepo{
+		db:       db,
+		logTable: logTable,
+		function: logTable + "_capture",
+		Channel:  strings.SplitN(logTable, ".", 2)[1],
+	}, nil
+}
+
+// Install creates the change log table and trigger function, and (re)creates the trigger
+// on every table. It runs in one transaction under an advisory lock so that instances
+// starting at the same time do not race each other.
+// Parameters:
+// - tables: "schema.table" mapped to its primary key column
+// Returns:
+// - error: error information
+func (r *PostgresCaptureRepo) Install(tables map[string]string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		fmt.Sprintf("SELECT pg_advisory_xact_lock(hashtext('%s'))", r.logTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	table_name TEXT NOT NULL,
+	op TEXT NOT NULL,
+	record_key TEXT NOT NULL,
+	record JSONB,
+	application_name TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, r.logTable),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_created_at ON %s (created_at)", r.Channel, r.logTable),
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger LANGUAGE plpgsql AS $$
+	DECLARE
+		r JSONB;
+		log_id BIGINT;
+	BEGIN
+		IF TG_OP = 'DELETE' THEN r := to_jsonb(OLD); ELSE r := to_jsonb(NEW); END IF;
+		INSERT INTO %s (table_name, op, record_key, record, application_name)
+		VALUES (
+			TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME,
+			CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+			coalesce(r ->> TG_ARGV[0], ''),
+			r,
+			coalesce(current_setting('application_name', true), '')
+		)
+		RETURNING id INTO log_id;
+		PERFORM pg_notify('%s', log_id::text);
+		RETURN NULL;
+	END
+	$$`, r.function, r.logTable, r.Channel),
+	}
+	for st, pk := range tables {
+		if !captureNamePattern.MatchString(st) || !captureColumnPattern.MatchString(pk) {
+			return fmt.Errorf("invalid captured table %q or primary key %q", st, pk)
+		}
+		statements = append(statements,
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", CaptureTrigger, st),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s('%s')",
+				CaptureTrigger, st, r.function, pk),
+		)
+	}
+	for _, q := range statements {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastID returns the id of the newest change log row, 0 when the log is empty.
+func (r *PostgresCaptureRepo) LastID() (int64, error) {
+	var id int64
+	err := r.db.QueryRow(fmt.Sprintf("SELECT coalesce(max(id), 0) FROM %s", r.logTable)).Scan(&id)
+	return id, err
+}
+
+// After returns change log rows with an id greater than id, oldest first.
+// Parameters:
+// - id: last id already processed
+// - limit: maximum number of rows
+// Returns:
+// - []ChangeLogEntry: rows
+// - error: error information
+func (r *PostgresCaptureRepo) After(id int64, limit int) ([]ChangeLogEntry, error) {
+	return r.query(fmt.Sprintf("WHERE id > $1 ORDER BY id LIMIT %d", limit), id)
+}
+
+// ByIDs returns the change log rows with the given ids, oldest first. Listeners use it to
+// pick up rows whose transaction committed after a row with a greater id.
+func (r *PostgresCaptureRepo) ByIDs(ids []int64) ([]ChangeLogEntry, error) {
+	return r.query("WHERE id = ANY($1) ORDER BY id", pq.Array(ids))
+}
+
+// Purge deletes change log rows created before the given time.
+func (r *PostgresCaptureRepo) Purge(before time.Time) (int64, error) {
+	result, err := r.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE created_at < $1", r.logTable), before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *PostgresCaptureRepo) query(where string, args ...any) ([]ChangeLogEntry, error) {
+	rows, err := r.db.Query(fmt.Sprintf(
+		"SELECT id, table_name, op, record_key, record, application_name, created_at FROM %s %s", r.logTable, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ChangeLogEntry
+	for rows.Next() {
+		var e ChangeLogEntry
+		var record []byte
+		if err := rows.Scan(&e.ID, &e.Table, &e.Op, &e.Key, &record, &e.Application, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if record != nil {
+			d := json.NewDecoder(strings.NewReader(string(record)))
+			// Keep large integers exact.
+			d.UseNumber()
+			if err := d.Decode(&e.Record); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
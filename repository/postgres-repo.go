@@ -1,309 +1,186 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"reflect"
 	"strconv"
 	"strings"
-
-	"ovaphlow.com/crate/data/utility"
+	"time"
 )
 
-// get_columns_postgres retrieves column names for a given schema and table.
+// load_table_postgres retrieves the columns, primary key and indexes of a given schema and table.
 // Parameters:
 // - db: database connection
 // - sat: schema and table in "schema.table" format
 // Returns:
-// - []string: list of column names
+// - *TableMeta: table metadata, without columns when the table does not exist
 // - error: error information
-func get_columns_postgres(db *sql.DB, sat string) ([]string, error) {
+func load_table_postgres(db Querier, sat string) (*TableMeta, error) {
 	st := strings.Split(sat, ".")
 	if len(st) != 2 {
-		return []string{"*"}, nil
+		return &TableMeta{Columns: []string{"*"}}, nil
 	}
-	columns := []string{}
-	stmt, err := db.Prepare(`
-	SELECT column_name FROM information_schema.columns
+	meta := &TableMeta{Types: map[string]string{}}
+	rows, err := db.Query(`
+	SELECT column_name, data_type FROM information_schema.columns
 	WHERE table_schema = $1 AND table_name = $2
 	ORDER BY ordinal_position ASC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-
-	rows, err := stmt.Query(st[0], st[1])
+	`, st[0], st[1])
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var column string
-		err := rows.Scan(&column)
+		var column, dataType string
+		err := rows.Scan(&column, &dataType)
 		if err != nil {
 			return nil, err
 		}
-		columns = append(columns, column)
+		meta.Columns = append(meta.Columns, column)
+		meta.Types[column] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return columns, nil
-}
-
-type PostgresRepoImpl struct {
-	db *sql.DB
-}
-
-// NewPostgresRepo creates a new PostgresRepoImpl instance.
-// Parameters:
-// - db: database connection
-// Returns:
-// - *PostgresRepoImpl: PostgresRepoImpl instance
-func NewPostgresRepo(db *sql.DB) *PostgresRepoImpl {
-	return &PostgresRepoImpl{db: db}
-}
 
-// Create inserts a new record into the specified table.
-// Parameters:
-// - st: schema and table in "schema.table" format
-// - d: data to insert
-// Returns:
-// - error: error information
-func (r *PostgresRepoImpl) Create(st string, d map[string]interface{}) error {
-	columns, err := get_columns_postgres(r.db, st)
+	// Index columns in key order; expression columns (attnum 0) have no attribute and are skipped.
+	indexes, err := db.Query(`
+	SELECT i.relname, ix.indisunique, ix.indisprimary, a.attname
+	FROM pg_class t
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+	JOIN pg_index ix ON ix.indrelid = t.oid
+	JOIN pg_class i ON i.oid = ix.indexrelid
+	JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord) ON true
+	JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+	WHERE n.nspname = $1 AND t.relname = $2
+	ORDER BY i.relname, k.ord
+	`, st[0], st[1])
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer indexes.Close()
 
-	var values []string
-	for _, column := range columns {
-		if _, ok := d[column]; ok {
-			values = append(values, fmt.Sprintf("%v", d[column]))
+	for indexes.Next() {
+		var name, column string
+		var unique, primary bool
+		if err := indexes.Scan(&name, &unique, &primary, &column); err != nil {
+			return nil, err
 		}
-	}
-
-	q := fmt.Sprintf("insert into %s (%s) values (", st, strings.Join(columns, ", "))
-	if len(values) == 0 {
-		return nil
-	}
-	for i := 0; i < len(values); i++ {
-		q += "$" + strconv.Itoa(i+1)
-		if i < len(values)-1 {
-			q += ","
+		if primary {
+			meta.PrimaryKey = append(meta.PrimaryKey, column)
+		}
+		if n := len(meta.Indexes); n > 0 && meta.Indexes[n-1].Name == name {
+			meta.Indexes[n-1].Columns = append(meta.Indexes[n-1].Columns, column)
+		} else {
+			meta.Indexes = append(meta.Indexes, IndexMeta{Name: name, Columns: []string{column}, Unique: unique})
 		}
 	}
-	q += ")"
-	p := make([]interface{}, len(values))
-	for i, v := range values {
-		p[i] = v
-	}
-
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(p...)
-	return err
+	return meta, indexes.Err()
 }
 
-// Get retrieves records from the specified table based on conditions.
+// list_tables_postgres lists the tables and views outside the system schemas.
 // Parameters:
-// - st: schema and table in "schema.table" format
-// - c: columns to retrieve, e.g., ["id", "name"]
-// - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
-// - l: additional clauses, e.g., "order by id desc limit 20 offset 0"
+// - db: database connection
 // Returns:
-// - []map[string]interface{}: retrieved records
+// - []string: tables in "schema.table" format
 // - error: error information
-func (r *PostgresRepoImpl) Get(st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
-	if len(c) == 0 {
-		var err error
-		c, err = get_columns_postgres(r.db, st)
-		if err != nil {
-			return nil, err
-		}
-	}
-	q := fmt.Sprintf("select %s from %s", strings.Join(c, ", "), st)
-
-	var whereClauses []string
-	var params []interface{}
-	paramIndex := 1
-
-	for _, condition := range f {
-		if len(condition) < 3 {
-			continue
-		}
-		field := condition[1]
-		operator := condition[0]
-		switch operator {
-		case "equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", field, paramIndex))
-			params = append(params, condition[2])
-			paramIndex++
-		case "not-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s != $%d", field, paramIndex))
-			params = append(params, condition[2])
-			paramIndex++
-		case "in":
-			placeholders := make([]string, len(condition)-2)
-			for i := range placeholders {
-				placeholders[i] = fmt.Sprintf("$%d", paramIndex)
-				params = append(params, condition[i+2])
-				paramIndex++
-			}
-			whereClauses = append(whereClauses, fmt.Sprintf("%s in (%s)", field, strings.Join(placeholders, ", ")))
-		case "not-in":
-			placeholders := make([]string, len(condition)-2)
-			for i := range placeholders {
-				placeholders[i] = fmt.Sprintf("$%d", paramIndex)
-				params = append(params, condition[i+2])
-				paramIndex++
-			}
-			whereClauses = append(whereClauses, fmt.Sprintf("%s not in (%s)", field, strings.Join(placeholders, ", ")))
-		case "greater":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s > $%d", field, paramIndex))
-			params = append(params, condition[2])
-			paramIndex++
-		case "greater-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s >= $%d", field, paramIndex))
-			params = append(params, condition[2])
-			paramIndex++
-		case "less":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s < $%d", field, paramIndex))
-			params = append(params, condition[2])
-			paramIndex++
-		case "less-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s <= $%d", field, paramIndex))
-			params = append(params, condition[2])
-			paramIndex++
-		case "jsonb-array-contains":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s @> '["+"%s"+"]'::jsonb", field, condition[2]))
-			params = append(params, condition[2])
-			paramIndex++
-		case "jsonb-object-contains":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s @> $%d::jsonb", field, paramIndex))
-			params = append(params, fmt.Sprintf(`{"%s": "%s"}`, condition[1], condition[2]))
-			paramIndex++
-		}
-	}
-
-	if len(whereClauses) > 0 {
-		q += " where " + strings.Join(whereClauses, " AND ")
-	}
-
-	if l != "" {
-		q += " " + l
-	}
-
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-
-	rows, err := stmt.Query(params...)
+func list_tables_postgres(db Querier) ([]string, error) {
+	rows, err := db.Query(`
+	SELECT table_schema || '.' || table_name FROM information_schema.tables
+	WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, err
-	}
-	var result []map[string]interface{}
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
+	var tables []string
 	for rows.Next() {
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
+		var st string
+		if err := rows.Scan(&st); err != nil {
 			return nil, err
 		}
-		m := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if val == nil {
-				m[col] = nil
-			} else {
-				switch v := val.(type) {
-				case []byte:
-					m[col] = string(v)
-				case int, int8, int16, int32, int64:
-					m[col] = strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
-				case uint, uint8, uint16, uint32, uint64:
-					m[col] = strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)
-				default:
-					m[col] = v
-				}
-			}
-		}
-		result = append(result, m)
+		tables = append(tables, st)
 	}
-
-	return result, nil
+	return tables, rows.Err()
 }
 
-// Update modifies records in the specified table based on conditions.
+// PostgresDialect is the Dialect of PostgreSQL.
+type PostgresDialect struct{}
+
+// NewPostgresRepo creates a repository for PostgreSQL.
 // Parameters:
-// - st: schema and table in "schema.table" format
-// - d: data to update
-// - w: WHERE condition, e.g., "id='1a'"
+// - db: database connection
+// - ts: how time.Time values are stored
+// - statements: number of prepared statements to cache, 0 disables caching
 // Returns:
-// - error: error information
-func (r *PostgresRepoImpl) Update(st string, d map[string]interface{}, w string) error {
-	columns, err := get_columns_postgres(r.db, st)
-	if err != nil {
-		return err
-	}
+// - *SQLRepoImpl: repository
+func NewPostgresRepo(db *sql.DB, ts TimeStorage, statements int) *SQLRepoImpl {
+	return NewSQLRepo(db, PostgresDialect{}, ts, statements)
+}
 
-	q := fmt.Sprintf("update %s set ", st)
-	var values []string
-	var p []interface{}
-	for _, v := range columns {
-		if _, ok := d[v]; ok {
-			values = append(values, fmt.Sprintf("%s = $%d", v, len(values)+1))
-			p = append(p, d[v])
-		}
-	}
-	q += strings.Join(values, ", ")
-	q += " where " + w
+func (PostgresDialect) Name() string { return "postgres" }
 
-	utility.ZapLogger.Info(q)
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+func (PostgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
 
-	utility.ZapLogger.Info(fmt.Sprintf("Params: %v\n", p))
-	_, err = stmt.Exec(p...)
-	if err != nil {
-		return err
-	}
+func (PostgresDialect) Quote(identifier string) string { return quote_with(`"`, identifier) }
 
-	return nil
+func (PostgresDialect) LoadTable(db Querier, st string) (*TableMeta, error) {
+	return load_table_postgres(db, st)
 }
 
-// Remove deletes records from the specified table based on conditions.
-// Parameters:
-// - st: schema and table in "schema.table" format
-// - w: WHERE condition, e.g., "id='1a'"
-// Returns:
-// - error: error information
-func (r *PostgresRepoImpl) Remove(st string, w string) error {
-	q := fmt.Sprintf("delete from %s where %s", st, w)
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return err
+func (PostgresDialect) ListTables(db Querier) ([]string, error) { return list_tables_postgres(db) }
+
+// Value leaves the placeholder as is; JSON and JSONB columns accept JSON text.
+func (PostgresDialect) Value(placeholder string, columnType string) string { return placeholder }
+
+func (PostgresDialect) Returning() bool { return true }
+
+func (PostgresDialect) DefaultValues() string { return "DEFAULT VALUES" }
+
+// Condition compiles the JSON operators with the jsonb containment and ->> operators.
+// Values are compared as JSON strings.
+func (PostgresDialect) Condition(op string, field string, args []string, bind func(v interface{}) string) (string, bool) {
+	switch op {
+	case "json-array-contains":
+		// ["json-array-contains", column, value]
+		return field + "::jsonb @> jsonb_build_array(" + bind(args[0]) + "::text)", true
+	case "json-object-contains":
+		// ["json-object-contains", column, key, value]
+		if len(args) < 2 {
+			return "", false
+		}
+		return field + "::jsonb @> jsonb_build_object(" + bind(args[0]) + "::text, " + bind(args[1]) + "::text)", true
+	case "json-field-in":
+		// ["json-field-in", column, key, value...]
+		if len(args) < 2 {
+			return "", false
+		}
+		key := bind(args[0])
+		placeholders := make([]string, len(args)-1)
+		for i, v := range args[1:] {
+			placeholders[i] = bind(v)
+		}
+		return field + "::jsonb ->> " + key + "::text IN (" + strings.Join(placeholders, ", ") + ")", true
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec()
+	return "", false
+}
+
+// ReplicationLag returns how far a standby is behind its primary. A standby that has
+// replayed everything it received reports no lag, so an idle primary does not make it
+// look stale; a server that is not in recovery reports no lag either.
+func (PostgresDialect) ReplicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	var seconds float64
+	err := db.QueryRowContext(ctx, `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END
+	`).Scan(&seconds)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return time.Duration(seconds * float64(time.Second)), nil
 }
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaLagger is implemented by dialects that can tell how far a read replica is behind
+// its primary.
+type ReplicaLagger interface {
+	// ReplicationLag returns the replication lag of the server db is connected to.
+	//
+	// Parameters:
+	// - ctx: bounds the query, the health check passes its own
+	// - db: connection pool of the replica
+	//
+	// Returns:
+	// - time.Duration: replication lag
+	// - error: error information
+	ReplicationLag(ctx context.Context, db *sql.DB) (time.Duration, error)
+}
+
+// ReplicaStatus is the result of the last health check of a replica.
+type ReplicaStatus struct {
+	Name string `json:"name"`
+	// Status is "up", "lagging" or "down"; only replicas that are up serve reads.
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LagMS     int64     `json:"lag_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// replica is one read replica of a ReplicaSet.
+type replica struct {
+	name    string
+	db      *sql.DB
+	repo    *SQLRepoImpl
+	healthy atomic.Bool
+
+	mu     sync.Mutex
+	status ReplicaStatus
+}
+
+// ReplicaSet spreads reads of a repository over its read replicas. Replicas start out of
+// rotation and join it once Check finds them reachable and within the allowed lag.
+type ReplicaSet struct {
+	primary  *SQLRepoImpl
+	maxLag   time.Duration
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+// NewReplicaSet creates an empty replica set for a repository.
+// Parameters:
+// - primary: repository of the primary, whose dialect and table metadata the replicas share
+// - maxLag: replicas further behind than this are taken out of rotation
+// Returns:
+// - *ReplicaSet: replica set
+func NewReplicaSet(primary *SQLRepoImpl, maxLag time.Duration) *ReplicaSet {
+	return &ReplicaSet{primary: primary, maxLag: maxLag}
+}
+
+// Add adds a replica. It serves reads after the next Check.
+// Parameters:
+// - name: name shown in the status, e.g., the replica address
+// - db: connection pool of the replica
+// - statements: number of prepared statements to cache for the replica, 0 disables caching
+// Returns:
+// - *StatementCache: prepared statement cache of the replica
+func (s *ReplicaSet) Add(name string, db *sql.DB, statements int) *StatementCache {
+	p := s.primary
+	repo := &SQLRepoImpl{name: p.name, db: db, dialect: p.dialect, timeStorage: p.timeStorage, schema: p.schema, statements: NewStatementCache(db, statements)}
+	s.replicas = append(s.replicas, &replica{name: name, db: db, repo: repo, status: ReplicaStatus{Name: name, Status: "down", Error: "not checked yet"}})
+	return repo.statements
+}
+
+// Reader returns the repository of the next healthy replica in round-robin order.
+// Returns:
+// - RDBRepo: repository bound to the replica
+// - string: name of the replica
+// - bool: false when no replica is healthy, reads should then use the primary
+func (s *ReplicaSet) Reader() (RDBRepo, string, bool) {
+	n := len(s.replicas)
+	start := int(s.next.Add(1) % uint64(max(n, 1)))
+	for i := 0; i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.repo, r.name, true
+		}
+	}
+	return nil, "", false
+}
+
+// Check pings every replica in parallel and reads its replication lag, taking replicas
+// that fail or lag behind by more than maxLag out of rotation until a later check passes.
+// Parameters:
+// - ctx: bounds the ping and the lag query of each replica
+func (s *ReplicaSet) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.check(ctx, r)
+		}()
+	}
+	wg.Wait()
+}
+
+// check runs the health check of one replica.
+func (s *ReplicaSet) check(ctx context.Context, r *replica) {
+	status := ReplicaStatus{Name: r.name, Status: "up", CheckedAt: time.Now().UTC()}
+	err := r.db.PingContext(ctx)
+	if err == nil {
+		if lagger, ok := s.primary.dialect.(ReplicaLagger); ok {
+			var lag time.Duration
+			lag, err = lagger.ReplicationLag(ctx, r.db)
+			status.LagMS = lag.Milliseconds()
+			if err == nil && s.maxLag > 0 && lag > s.maxLag {
+				status.Status = "lagging"
+				status.Error = fmt.Sprintf("replication lag %s exceeds %s", lag.Round(time.Millisecond), s.maxLag)
+			}
+		}
+	}
+	if err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+	r.healthy.Store(status.Status == "up")
+}
+
+// Run checks the replicas every interval until ctx is done.
+// Parameters:
+// - ctx: stops the checks
+// - interval: time between checks
+// - timeout: bound of each check
+func (s *ReplicaSet) Run(ctx context.Context, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			s.Check(checkCtx)
+			cancel()
+		}
+	}
+}
+
+// Status returns the result of the last check of each replica.
+func (s *ReplicaSet) Status() []ReplicaStatus {
+	result := make([]ReplicaStatus, len(s.replicas))
+	for i, r := range s.replicas {
+		r.mu.Lock()
+		result[i] = r.status
+		r.mu.Unlock()
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// lagDialect is SQLite reporting a fixed replication lag per replica; a replica without
+// one blocks until the context of the check is done.
+type lagDialect struct {
+	SQLiteDialect
+	lags map[*sql.DB]time.Duration
+}
+
+func (d lagDialect) ReplicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	if lag, ok := d.lags[db]; ok {
+		return lag, nil
+	}
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestReplicaCheck(t *testing.T) {
+	open := func(name string) *sql.DB {
+		db, err := sql.Open("sqlite", "file:"+t.Name()+name+"?mode=memory&cache=shared")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	primary, current, behind, stuck := open("primary"), open("current"), open("behind"), open("stuck")
+	dialect := lagDialect{lags: map[*sql.DB]time.Duration{current: 100 * time.Millisecond, behind: time.Minute}}
+	set := NewReplicaSet(NewSQLRepo(primary, dialect, TimeStorageText, 0), 10*time.Second)
+	set.Add("current", current, 0)
+	set.Add("behind", behind, 0)
+	set.Add("stuck", stuck, 0)
+
+	if _, _, ok := set.Reader(); ok {
+		t.Fatal("replica serves reads before the first check")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	set.Check(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("check took %s, the lag query does not follow its context", elapsed)
+	}
+
+	want := map[string]string{"current": "up", "behind": "lagging", "stuck": "down"}
+	for _, status := range set.Status() {
+		if status.Status != want[status.Name] {
+			t.Errorf("%s: got %s (%s), want %s", status.Name, status.Status, status.Error, want[status.Name])
+		}
+	}
+	for _, status := range set.Status() {
+		if status.Name == "stuck" && !strings.Contains(status.Error, context.DeadlineExceeded.Error()) {
+			t.Errorf("stuck: got error %q, want the deadline of the check", status.Error)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, name, ok := set.Reader(); !ok || name != "current" {
+			t.Errorf("got reader %q, %v, want current", name, ok)
+		}
+	}
+
+	// A replica back within the allowed lag rejoins the rotation on the next check.
+	dialect.lags[behind] = 0
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	set.Check(ctx)
+	names := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		_, name, _ := set.Reader()
+		names[name] = true
+	}
+	if !names["current"] || !names["behind"] || names["stuck"] {
+		t.Errorf("got readers %v, want current and behind", names)
+	}
+}
@@ -1,19 +1,135 @@
 package repository
 
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strconv"
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so that a repository can run its
+// statements inside a transaction.
+type dbtx interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// contextQuerier runs the catalog queries of a Dialect on db under ctx.
+type contextQuerier struct {
+	ctx context.Context
+	db  dbtx
+}
+
+func (q contextQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return q.db.QueryContext(q.ctx, query, args...)
+}
+
+func (q contextQuerier) QueryRow(query string, args ...interface{}) *sql.Row {
+	return q.db.QueryRowContext(q.ctx, query, args...)
+}
+
+// transaction begins a transaction on db and runs fn with the repository returned by
+// bind. When db is already a transaction, fn joins it. Cancelling ctx rolls the
+// transaction back.
+func transaction(ctx context.Context, db dbtx, fn func(repo RDBRepo) error, bind func(tx dbtx) RDBRepo) error {
+	conn, ok := db.(*sql.DB)
+	if !ok {
+		return fn(bind(db))
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(opUpdate, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(bind(tx)); err != nil {
+		return err
+	}
+	return translateError(opUpdate, tx.Commit())
+}
+
+// scan_rows converts each row of rows to a map and passes it to fn. Byte slices become
+// strings and integers become decimal strings, so large keys survive JSON encoding.
+// Parameters:
+// - rows: query result, closed by the caller
+// - fn: called once per row with the result columns in select order and a map it may keep;
+// its error stops the scan and is returned as is
+// Returns:
+// - error: scan error translated for opSelect, or the error of fn
+func scan_rows(rows *sql.Rows, fn func(columns []string, row map[string]interface{}) error) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return translateError(opSelect, err)
+	}
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for rows.Next() {
+		for i := range columns {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return translateError(opSelect, err)
+		}
+		m := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			val := values[i]
+			if val == nil {
+				m[col] = nil
+			} else {
+				switch v := val.(type) {
+				case []byte:
+					m[col] = string(v)
+				case int, int8, int16, int32, int64:
+					m[col] = strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
+				case uint, uint8, uint16, uint32, uint64:
+					m[col] = strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)
+				default:
+					m[col] = v
+				}
+			}
+		}
+		if err := fn(columns, m); err != nil {
+			return err
+		}
+	}
+	return translateError(opSelect, rows.Err())
+}
+
+// RDBRepo runs statements against one database. Every statement runs under the ctx
+// passed to it: cancelling ctx, e.g., when the client disconnects or the statement
+// timeout expires, aborts the statement and returns a KindTimeout error.
 type RDBRepo interface {
 	// Create inserts a new record into the specified table.
 	//
 	// Parameters:
+	// - ctx: bounds the statement
 	// - st: schema and table, formatted as "schema.table"
 	// - d: data to be inserted
 	//
 	// Returns:
 	// - error: error information
-	Create(st string, d map[string]interface{}) error
+	Create(ctx context.Context, st string, d map[string]interface{}) error
+
+	// CreateReturning inserts a new record whose key is generated by the database
+	// (SERIAL, AUTO_INCREMENT or ROWID) and returns that key.
+	//
+	// Parameters:
+	// - ctx: bounds the statement
+	// - st: schema and table, formatted as "schema.table"
+	// - d: data to be inserted, without the generated key
+	// - key: generated key column, e.g., "id"
+	//
+	// Returns:
+	// - string: generated key
+	// - error: error information
+	CreateReturning(ctx context.Context, st string, d map[string]interface{}, key string) (string, error)
 
 	// Get retrieves records from the specified table based on conditions.
 	//
 	// Parameters:
+	// - ctx: bounds the query
 	// - st: schema and table, formatted as "schema.table"
 	// - c: columns to retrieve, e.g., ["id", "name"]
 	// - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
@@ -22,26 +138,62 @@ type RDBRepo interface {
 	// Returns:
 	// - []map[string]interface{}: retrieved records
 	// - error: error information
-	Get(st string, c []string, f [][]string, l string) ([]map[string]interface{}, error)
+	Get(ctx context.Context, st string, c []string, f [][]string, l string) ([]map[string]interface{}, error)
+
+	// Stream retrieves records like Get, passing them to fn one at a time instead of
+	// collecting them, so memory does not grow with the number of rows.
+	//
+	// Parameters:
+	// - ctx: cancels the query, e.g., when the client disconnects
+	// - st: schema and table, formatted as "schema.table"
+	// - c: columns to retrieve, e.g., ["id", "name"]
+	// - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
+	// - l: additional clauses, e.g., "order by id desc limit 20 offset 0"
+	// - fn: called once per row with the result columns in select order; an error stops
+	//   the query and is returned
+	//
+	// Returns:
+	// - error: error information
+	Stream(ctx context.Context, st string, c []string, f [][]string, l string, fn func(columns []string, row map[string]interface{}) error) error
 
 	// Update modifies records in the specified table based on conditions.
 	//
 	// Parameters:
+	// - ctx: bounds the statement
 	// - st: schema and table, formatted as "schema.table"
 	// - d: data to be updated
-	// - w: WHERE condition, e.g., "id='1a'"
+	// - f: filter conditions, e.g., [["equal", "id", "1a"]], must not be empty
 	//
 	// Returns:
+	// - int64: number of rows matched by f, including rows whose values did not change
 	// - error: error information
-	Update(st string, d map[string]interface{}, w string) error
+	Update(ctx context.Context, st string, d map[string]interface{}, f [][]string) (int64, error)
 
 	// Remove deletes records from the specified table based on conditions.
 	//
 	// Parameters:
+	// - ctx: bounds the statement
 	// - st: schema and table, formatted as "schema.table"
-	// - w: WHERE condition, e.g., "id='1a'"
+	// - f: filter conditions, e.g., [["equal", "id", "1a"]], must not be empty
 	//
 	// Returns:
+	// - int64: number of rows deleted
 	// - error: error information
-	Remove(st string, w string) error
+	Remove(ctx context.Context, st string, f [][]string) (int64, error)
+
+	// Transaction runs fn with a repository bound to one transaction.
+	//
+	// Parameters:
+	// - ctx: bounds the transaction, which rolls back when ctx is done before the commit
+	// - fn: statements to run, the transaction commits when fn returns nil
+	//
+	// Returns:
+	// - error: error returned by fn, or the commit error
+	Transaction(ctx context.Context, fn func(repo RDBRepo) error) error
+
+	// Schema returns the table metadata cache of the repository.
+	//
+	// Returns:
+	// - *SchemaCache: metadata cache, shared with the repositories bound to a transaction
+	Schema() *SchemaCache
 }
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+// TableMeta is the cached catalog information of one table.
+type TableMeta struct {
+	// Columns in ordinal order.
+	Columns []string `json:"columns"`
+	// Types maps a column to its data type as reported by the catalog.
+	Types      map[string]string `json:"types"`
+	PrimaryKey []string          `json:"primary_key"`
+	Indexes    []IndexMeta       `json:"indexes"`
+	LoadedAt   time.Time         `json:"loaded_at"`
+}
+
+// IndexMeta describes one index of a table.
+type IndexMeta struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// SchemaCache keeps the metadata of the tables a repository has touched, so that
+// statements do not query information_schema or PRAGMA table_info on every request.
+// Entries are loaded lazily, reloaded by Refresh, and dropped by Invalidate or when a
+// statement fails with an unknown column error.
+type SchemaCache struct {
+	mu     sync.RWMutex
+	db     dbtx
+	tables map[string]*TableMeta
+	load   func(db Querier, st string) (*TableMeta, error)
+	list   func(db Querier) ([]string, error)
+	// refreshedAt and refreshErr are the time and the error of the last Preload or Refresh.
+	refreshedAt time.Time
+	refreshErr  error
+}
+
+// SchemaCacheState is the state of a schema cache reported by health checks.
+type SchemaCacheState struct {
+	Tables int `json:"tables"`
+	// RefreshedAt is the time of the last preload or refresh, nil before the first.
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"`
+	// Error is the error of the last preload or refresh.
+	Error string `json:"error,omitempty"`
+}
+
+// newSchemaCache creates a schema cache.
+// Parameters:
+// - db: database connection used by Preload and Refresh
+// - load: reads the metadata of one table, returning no columns when it does not exist
+// - list: lists the tables visible to the connection
+// Returns:
+// - *SchemaCache: schema cache
+func newSchemaCache(db dbtx, load func(db Querier, st string) (*TableMeta, error), list func(db Querier) ([]string, error)) *SchemaCache {
+	return &SchemaCache{db: db, tables: map[string]*TableMeta{}, load: load, list: list}
+}
+
+// Table returns the metadata of st, loading it through db on a miss. Tables without
+// columns are not cached, so a table created later is picked up on the next call.
+// Parameters:
+// - ctx: bounds the catalog queries
+// - db: connection or transaction used to load the metadata
+// - st: schema and table
+// Returns:
+// - *TableMeta: metadata, shared and must not be modified
+// - error: error information
+func (c *SchemaCache) Table(ctx context.Context, db dbtx, st string) (*TableMeta, error) {
+	c.mu.RLock()
+	meta, ok := c.tables[st]
+	c.mu.RUnlock()
+	if ok {
+		return meta, nil
+	}
+
+	meta, err := c.load(contextQuerier{ctx: ctx, db: db}, st)
+	if err != nil {
+		return nil, err
+	}
+	meta.LoadedAt = time.Now()
+	if len(meta.Columns) > 0 {
+		c.mu.Lock()
+		c.tables[st] = meta
+		c.mu.Unlock()
+	}
+	return meta, nil
+}
+
+// Lookup returns the metadata of st, loading it through the connection of the cache on a miss.
+func (c *SchemaCache) Lookup(st string) (*TableMeta, error) {
+	return c.Table(context.Background(), c.db, st)
+}
+
+// Has reports whether the metadata of st is cached, without loading it. Only tables
+// that exist are cached, so the answer never grows with the names clients send.
+func (c *SchemaCache) Has(st string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.tables[st]
+	return ok
+}
+
+// Exists reports whether st exists, loading its metadata on a miss.
+// Parameters:
+// - ctx: bounds the catalog queries
+// - st: schema and table
+// Returns:
+// - bool: true when the table has columns
+// - error: error information
+func (c *SchemaCache) Exists(ctx context.Context, st string) (bool, error) {
+	meta, err := c.Table(ctx, c.db, st)
+	if err != nil {
+		return false, err
+	}
+	return len(meta.Columns) > 0, nil
+}
+
+// State returns the number of cached tables and the result of the last preload or refresh.
+func (c *SchemaCache) State() SchemaCacheState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	state := SchemaCacheState{Tables: len(c.tables)}
+	if !c.refreshedAt.IsZero() {
+		at := c.refreshedAt
+		state.RefreshedAt = &at
+	}
+	if c.refreshErr != nil {
+		state.Error = c.refreshErr.Error()
+	}
+	return state
+}
+
+// record_refresh stores the result of a preload or refresh.
+func (c *SchemaCache) record_refresh(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshedAt, c.refreshErr = time.Now().UTC(), err
+}
+
+// Tables returns a snapshot of the cached metadata.
+func (c *SchemaCache) Tables() map[string]*TableMeta {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Clone(c.tables)
+}
+
+// Invalidate drops the metadata of st.
+func (c *SchemaCache) Invalidate(st string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tables, st)
+}
+
+// InvalidateAll drops the metadata of every table.
+func (c *SchemaCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables = map[string]*TableMeta{}
+}
+
+// Preload loads the metadata of every table visible to the connection.
+// Returns:
+// - int: number of tables loaded
+// - error: error information, tables loaded before the error stay cached
+func (c *SchemaCache) Preload() (n int, err error) {
+	defer func() { c.record_refresh(err) }()
+	ctx := context.Background()
+	tables, err := c.list(contextQuerier{ctx: ctx, db: c.db})
+	if err != nil {
+		return 0, err
+	}
+	for _, st := range tables {
+		if _, err := c.Table(ctx, c.db, st); err != nil {
+			return len(c.Tables()), err
+		}
+	}
+	return len(c.Tables()), nil
+}
+
+// Refresh reloads the metadata of every cached table. Tables that no longer exist are
+// dropped.
+// Returns:
+// - error: the first error, the remaining tables are still reloaded
+func (c *SchemaCache) Refresh() error {
+	c.mu.RLock()
+	tables := slices.Collect(maps.Keys(c.tables))
+	c.mu.RUnlock()
+
+	var first error
+	for _, st := range tables {
+		meta, err := c.load(contextQuerier{ctx: context.Background(), db: c.db}, st)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		meta.LoadedAt = time.Now()
+		c.mu.Lock()
+		if len(meta.Columns) > 0 {
+			c.tables[st] = meta
+		} else {
+			delete(c.tables, st)
+		}
+		c.mu.Unlock()
+	}
+	c.record_refresh(first)
+	return first
+}
+
+// with_schema_retry runs fn, and when it fails because a column does not exist drops
+// the cached metadata of st and runs fn once more with fresh metadata. Inside a
+// transaction the statement is not retried, since PostgreSQL aborts the transaction
+// on the first error.
+// Parameters:
+// - c: schema cache
+// - db: connection or transaction of the repository
+// - st: schema and table
+// - fn: the statement
+// Returns:
+// - T: result of fn
+// - error: error of the last attempt
+func with_schema_retry[T any](c *SchemaCache, db dbtx, st string, fn func() (T, error)) (T, error) {
+	v, err := fn()
+	if err == nil || !is_unknown_column(err) {
+		return v, err
+	}
+	c.Invalidate(st)
+	if _, ok := db.(*sql.DB); !ok {
+		return v, err
+	}
+	return fn()
+}
+
+// is_unknown_column reports whether the database says a referenced column does not exist.
+// A request naming a column missing from the cached metadata is rejected before reaching
+// the database and does not match, so bad requests never reload the catalog.
+func is_unknown_column(err error) bool {
+	var pqErr *pq.Error
+	var mysqlErr *mysql.MySQLError
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.As(err, &pqErr):
+		return pqErr.Code == "42703"
+	case errors.As(err, &mysqlErr):
+		return mysqlErr.Number == 1054
+	case errors.As(err, &sqliteErr):
+		msg := sqliteErr.Error()
+		return strings.Contains(msg, "no such column") || strings.Contains(msg, "has no column named")
+	}
+	return false
+}
@@ -0,0 +1,422 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SQLRepoImpl implements RDBRepo for any engine described by a Dialect.
+type SQLRepoImpl struct {
+	name        string
+	db          dbtx
+	dialect     Dialect
+	timeStorage TimeStorage
+	schema      *SchemaCache
+	statements  *StatementCache
+}
+
+// NewSQLRepo creates a repository for the engine described by dialect.
+// Parameters:
+// - db: database connection
+// - dialect: SQL dialect of the engine
+// - ts: how time.Time values are stored
+// - statements: number of prepared statements to cache, 0 disables caching
+// Returns:
+// - *SQLRepoImpl: repository
+func NewSQLRepo(db *sql.DB, dialect Dialect, ts TimeStorage, statements int) *SQLRepoImpl {
+	return &SQLRepoImpl{
+		name:        dialect.Name(),
+		db:          db,
+		dialect:     dialect,
+		timeStorage: ts,
+		schema:      newSchemaCache(db, dialect.LoadTable, dialect.ListTables),
+		statements:  NewStatementCache(db, statements),
+	}
+}
+
+// SetName sets the datasource name that labels the query metrics and spans of the repository,
+// its transactions and its read replicas. It defaults to the dialect name.
+func (r *SQLRepoImpl) SetName(name string) {
+	r.name = name
+}
+
+// Dialect returns the SQL dialect of the repository.
+func (r *SQLRepoImpl) Dialect() Dialect {
+	return r.dialect
+}
+
+// Statements returns the prepared statement cache of the repository.
+func (r *SQLRepoImpl) Statements() *StatementCache {
+	return r.statements
+}
+
+// Schema returns the table metadata cache of the repository.
+func (r *SQLRepoImpl) Schema() *SchemaCache {
+	return r.schema
+}
+
+// Transaction runs fn with a repository bound to one transaction, committing when fn
+// returns nil and rolling back otherwise. Nested calls reuse the outer transaction.
+// Parameters:
+// - ctx: bounds the transaction
+// - fn: the statements to run in the transaction
+// Returns:
+// - error: the error returned by fn, or the commit error
+func (r *SQLRepoImpl) Transaction(ctx context.Context, fn func(repo RDBRepo) error) (err error) {
+	ctx, end := r.begin(ctx, opTransaction, "")
+	defer func() { err = end(err) }()
+	return transaction(ctx, r.db, fn, func(tx dbtx) RDBRepo {
+		return &SQLRepoImpl{name: r.name, db: tx, dialect: r.dialect, timeStorage: r.timeStorage, schema: r.schema, statements: r.statements}
+	})
+}
+
+// build_insert builds an insert statement for the columns of st present in d.
+// Parameters:
+// - d: dialect
+// - meta: table metadata
+// - st: schema and table
+// - data: data to insert
+// - ts: how time.Time values are stored
+// Returns:
+// - string: insert statement, inserting default values when data has no known column
+// - []interface{}: statement parameters
+// - error: error information
+func build_insert(d Dialect, meta *TableMeta, st string, data map[string]interface{}, ts TimeStorage) (string, []interface{}, error) {
+	args := &statementArgs{dialect: d}
+	var names []string
+	var values []string
+	for _, column := range meta.Columns {
+		val, ok := data[column]
+		if !ok {
+			continue
+		}
+		v, err := bind_value(val, ts)
+		if err != nil {
+			return "", nil, err
+		}
+		names = append(names, d.Quote(column))
+		values = append(values, d.Value(args.bind(v), meta.Types[column]))
+	}
+	q := "INSERT INTO " + quote_table(d, st)
+	if len(names) == 0 {
+		return q + " " + d.DefaultValues(), nil, nil
+	}
+	q += " (" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(values, ", ") + ")"
+	return q, args.values, nil
+}
+
+// Create inserts a new record into the specified table.
+// Parameters:
+// - ctx: bounds the statement
+// - st: schema and table
+// - d: data to insert
+// Returns:
+// - error: error information
+func (r *SQLRepoImpl) Create(ctx context.Context, st string, d map[string]interface{}) (err error) {
+	ctx, end := r.begin(ctx, opInsert, st)
+	defer func() { err = end(err) }()
+	_, err = with_schema_retry(r.schema, r.db, st, func() (struct{}, error) {
+		return struct{}{}, r.create(ctx, st, d)
+	})
+	return err
+}
+
+// create runs one attempt of Create.
+func (r *SQLRepoImpl) create(ctx context.Context, st string, d map[string]interface{}) error {
+	meta, err := r.schema.Table(ctx, r.db, st)
+	if err != nil {
+		return translateError(opInsert, err)
+	}
+	q, p, err := build_insert(r.dialect, meta, st, d, r.timeStorage)
+	if err != nil {
+		return translateError(opInsert, err)
+	}
+
+	trace_query(ctx, q)
+	stmt, err := r.statements.prepare(ctx, r.db, q)
+	if err != nil {
+		return translateError(opInsert, err)
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, p...)
+	if err != nil {
+		return translateError(opInsert, err)
+	}
+	trace_affected(ctx, result)
+	return nil
+}
+
+// CreateReturning inserts a new record and returns the key generated by the database,
+// read with RETURNING or LastInsertId depending on the dialect.
+// Parameters:
+// - ctx: bounds the statement
+// - st: schema and table
+// - d: data to insert, without the generated key
+// - key: generated key column, e.g., "id"
+// Returns:
+// - string: generated key
+// - error: error information
+func (r *SQLRepoImpl) CreateReturning(ctx context.Context, st string, d map[string]interface{}, key string) (id string, err error) {
+	ctx, end := r.begin(ctx, opInsert, st)
+	defer func() { err = end(err) }()
+	return with_schema_retry(r.schema, r.db, st, func() (string, error) {
+		return r.createReturning(ctx, st, d, key)
+	})
+}
+
+// createReturning runs one attempt of CreateReturning.
+func (r *SQLRepoImpl) createReturning(ctx context.Context, st string, d map[string]interface{}, key string) (string, error) {
+	meta, err := r.schema.Table(ctx, r.db, st)
+	if err != nil {
+		return "", translateError(opInsert, err)
+	}
+	q, p, err := build_insert(r.dialect, meta, st, d, r.timeStorage)
+	if err != nil {
+		return "", translateError(opInsert, err)
+	}
+	if r.dialect.Returning() {
+		q += " RETURNING " + r.dialect.Quote(key)
+	}
+
+	trace_query(ctx, q)
+	stmt, err := r.statements.prepare(ctx, r.db, q)
+	if err != nil {
+		return "", translateError(opInsert, err)
+	}
+	defer stmt.Close()
+
+	if r.dialect.Returning() {
+		var id interface{}
+		if err := stmt.QueryRowContext(ctx, p...).Scan(&id); err != nil {
+			return "", translateError(opInsert, err)
+		}
+		trace.SpanFromContext(ctx).SetAttributes(affectedRowsKey.Int(1))
+		if b, ok := id.([]byte); ok {
+			return string(b), nil
+		}
+		return fmt.Sprintf("%v", id), nil
+	}
+
+	result, err := stmt.ExecContext(ctx, p...)
+	if err != nil {
+		return "", translateError(opInsert, err)
+	}
+	trace_affected(ctx, result)
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", translateError(opInsert, err)
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// Get retrieves records from the specified table based on conditions.
+// Parameters:
+// - ctx: bounds the query
+// - st: schema and table
+// - c: columns to retrieve, e.g., ["id", "name"]
+// - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
+// - l: additional clauses, e.g., "order by id desc limit 20 offset 0"
+// Returns:
+// - []map[string]interface{}: retrieved records
+// - error: error information
+func (r *SQLRepoImpl) Get(ctx context.Context, st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
+	var result []map[string]interface{}
+	err := r.Stream(ctx, st, c, f, l, func(columns []string, row map[string]interface{}) error {
+		result = append(result, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Stream retrieves records like Get, passing each one to fn as it is read.
+// Parameters:
+// - ctx: cancels the query when done
+// - st: schema and table
+// - c: columns to retrieve, e.g., ["id", "name"]
+// - f: filter conditions, e.g., [["equal", "name", "John Doe"], ["in", "id", "1a", "1b"]]
+// - l: additional clauses, e.g., "order by id desc limit 20 offset 0"
+// - fn: called once per record, an error stops the query
+// Returns:
+// - error: error information, or the error of fn
+func (r *SQLRepoImpl) Stream(ctx context.Context, st string, c []string, f [][]string, l string, fn func(columns []string, row map[string]interface{}) error) (err error) {
+	ctx, end := r.begin(ctx, opSelect, st)
+	defer func() { err = end(err) }()
+	rows := 0
+	_, err = with_schema_retry(r.schema, r.db, st, func() (struct{}, error) {
+		rows = 0
+		return struct{}{}, r.stream(ctx, st, c, f, l, func(columns []string, row map[string]interface{}) error {
+			rows++
+			return fn(columns, row)
+		})
+	})
+	trace.SpanFromContext(ctx).SetAttributes(semconv.DBResponseReturnedRows(rows))
+	return err
+}
+
+// stream runs one attempt of Stream.
+func (r *SQLRepoImpl) stream(ctx context.Context, st string, c []string, f [][]string, l string, fn func(columns []string, row map[string]interface{}) error) error {
+	meta, err := r.schema.Table(ctx, r.db, st)
+	if err != nil {
+		return translateError(opSelect, err)
+	}
+	var columns []string
+	if len(c) == 0 {
+		for _, column := range meta.Columns {
+			if column != "*" {
+				column = r.dialect.Quote(column)
+			}
+			columns = append(columns, column)
+		}
+	}
+	for _, column := range c {
+		compiled, err := select_column(r.dialect, meta, column)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, compiled)
+	}
+	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), quote_table(r.dialect, st))
+
+	args := &statementArgs{dialect: r.dialect}
+	where, err := build_where(r.dialect, meta, f, args)
+	if err != nil {
+		return err
+	}
+	if where != "" {
+		q += " WHERE " + where
+	}
+
+	if l != "" {
+		q += " " + l
+	}
+
+	trace_query(ctx, q)
+	stmt, err := r.statements.prepare(ctx, r.db, q)
+	if err != nil {
+		return translateError(opSelect, err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, args.values...)
+	if err != nil {
+		return translateError(opSelect, err)
+	}
+	defer rows.Close()
+
+	return scan_rows(rows, fn)
+}
+
+// Update modifies records in the specified table based on conditions.
+// Parameters:
+// - ctx: bounds the statement
+// - st: schema and table
+// - d: data to update
+// - f: filter conditions, e.g., [["equal", "id", "1a"]]
+// Returns:
+// - int64: number of rows matched by f
+// - error: error information
+func (r *SQLRepoImpl) Update(ctx context.Context, st string, d map[string]interface{}, f [][]string) (n int64, err error) {
+	ctx, end := r.begin(ctx, opUpdate, st)
+	defer func() { err = end(err) }()
+	return with_schema_retry(r.schema, r.db, st, func() (int64, error) {
+		return r.update(ctx, st, d, f)
+	})
+}
+
+// update runs one attempt of Update.
+func (r *SQLRepoImpl) update(ctx context.Context, st string, d map[string]interface{}, f [][]string) (int64, error) {
+	meta, err := r.schema.Table(ctx, r.db, st)
+	if err != nil {
+		return 0, translateError(opUpdate, err)
+	}
+
+	args := &statementArgs{dialect: r.dialect}
+	var assignments []string
+	for _, column := range meta.Columns {
+		val, ok := d[column]
+		if !ok {
+			continue
+		}
+		v, err := bind_value(val, r.timeStorage)
+		if err != nil {
+			return 0, translateError(opUpdate, err)
+		}
+		assignments = append(assignments, r.dialect.Quote(column)+" = "+r.dialect.Value(args.bind(v), meta.Types[column]))
+	}
+	where, err := build_where(r.dialect, meta, f, args)
+	if err != nil {
+		return 0, err
+	}
+	if where == "" {
+		return 0, errMissingFilter
+	}
+	q := fmt.Sprintf("UPDATE %s SET %s WHERE %s", quote_table(r.dialect, st), strings.Join(assignments, ", "), where)
+
+	trace_query(ctx, q)
+	stmt, err := r.statements.prepare(ctx, r.db, q)
+	if err != nil {
+		return 0, translateError(opUpdate, err)
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, args.values...)
+	if err != nil {
+		return 0, translateError(opUpdate, err)
+	}
+	return trace_affected(ctx, result), nil
+}
+
+// Remove deletes records from the specified table based on conditions.
+// Parameters:
+// - ctx: bounds the statement
+// - st: schema and table
+// - f: filter conditions, e.g., [["equal", "id", "1a"]]
+// Returns:
+// - int64: number of rows deleted
+// - error: error information
+func (r *SQLRepoImpl) Remove(ctx context.Context, st string, f [][]string) (n int64, err error) {
+	ctx, end := r.begin(ctx, opDelete, st)
+	defer func() { err = end(err) }()
+	return with_schema_retry(r.schema, r.db, st, func() (int64, error) {
+		return r.remove(ctx, st, f)
+	})
+}
+
+// remove runs one attempt of Remove.
+func (r *SQLRepoImpl) remove(ctx context.Context, st string, f [][]string) (int64, error) {
+	meta, err := r.schema.Table(ctx, r.db, st)
+	if err != nil {
+		return 0, translateError(opDelete, err)
+	}
+
+	args := &statementArgs{dialect: r.dialect}
+	where, err := build_where(r.dialect, meta, f, args)
+	if err != nil {
+		return 0, err
+	}
+	if where == "" {
+		return 0, errMissingFilter
+	}
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s", quote_table(r.dialect, st), where)
+	trace_query(ctx, q)
+	stmt, err := r.statements.prepare(ctx, r.db, q)
+	if err != nil {
+		return 0, translateError(opDelete, err)
+	}
+	defer stmt.Close()
+	result, err := stmt.ExecContext(ctx, args.values...)
+	if err != nil {
+		return 0, translateError(opDelete, err)
+	}
+	return trace_affected(ctx, result), nil
+}
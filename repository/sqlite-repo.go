@@ -2,27 +2,25 @@ package repository
 
 import (
 	"database/sql"
-	"fmt"
-	"reflect"
-	"strconv"
 	"strings"
 )
 
-// get_columns_sqlite retrieves the column names of a given SQLite table.
+// load_table_sqlite retrieves the columns, primary key and indexes of a given SQLite table.
 // Parameters:
 // - db: The database connection.
 // - sat: The name of the table.
 // Returns:
-// - A slice of column names.
+// - The table metadata, without columns when the table does not exist.
 // - An error if the query fails.
-func get_columns_sqlite(db *sql.DB, sat string) ([]string, error) {
-	rows, err := db.Query("PRAGMA table_info(" + sat + ")")
+func load_table_sqlite(db Querier, sat string) (*TableMeta, error) {
+	rows, err := db.Query("PRAGMA table_info(" + SQLiteDialect{}.Quote(sat) + ")")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var columns []string
+	meta := &TableMeta{Types: map[string]string{}}
+	keys := map[int]string{}
 	for rows.Next() {
 		var cid int
 		var name string
@@ -34,236 +32,138 @@ func get_columns_sqlite(db *sql.DB, sat string) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		columns = append(columns, name)
+		meta.Columns = append(meta.Columns, name)
+		meta.Types[name] = dtype
+		if pk > 0 {
+			keys[pk] = name
+		}
 	}
-	return columns, nil
-}
-
-type SQLiteRepoImpl struct {
-	db *sql.DB
-}
-
-// NewSQLiteRepo creates a new SQLiteRepoImpl instance.
-// Parameters:
-// - db: The database connection.
-// Returns:
-// - A pointer to the new SQLiteRepoImpl instance.
-func NewSQLiteRepo(db *sql.DB) *SQLiteRepoImpl {
-	return &SQLiteRepoImpl{db: db}
-}
-
-// Create inserts a new record into the specified table.
-// Parameters:
-// - st: The name of the table.
-// - d: A map of column names to values.
-// Returns:
-// - An error if the operation fails.
-func (r *SQLiteRepoImpl) Create(st string, d map[string]interface{}) error {
-	columns, err := get_columns_sqlite(r.db, st)
-	if err != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-
-	var columnStr string
-	var placeholders string
-	var values []interface{}
-	for _, column := range columns {
-		if val, ok := d[column]; ok {
-			columnStr += column + ","
-			placeholders += "?,"
-			values = append(values, val)
-		}
+	for i := 1; i <= len(keys); i++ {
+		meta.PrimaryKey = append(meta.PrimaryKey, keys[i])
 	}
-	columnStr = columnStr[:len(columnStr)-1]
-	placeholders = placeholders[:len(placeholders)-1]
 
-	stmt, err := r.db.Prepare("INSERT INTO " + st + " (" + columnStr + ") VALUES (" + placeholders + ")")
+	indexes, err := db.Query("PRAGMA index_list(" + SQLiteDialect{}.Quote(sat) + ")")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(values...)
-	return err
-}
-
-// Get retrieves records from the specified table.
-// Parameters:
-// - st: The name of the table.
-// - c: A slice of column names to retrieve.
-// - f: A slice of filter conditions.
-// - l: Additional SQL clauses (e.g., ORDER BY).
-// Returns:
-// - A slice of maps representing the retrieved records.
-// - An error if the operation fails.
-func (r *SQLiteRepoImpl) Get(st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
-	if len(c) == 0 {
-		var err error
-		c, err = get_columns_sqlite(r.db, st)
-		if err != nil {
+	defer indexes.Close()
+	for indexes.Next() {
+		var seq, unique, partial int
+		var name, origin string
+		if err := indexes.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
 			return nil, err
 		}
+		meta.Indexes = append(meta.Indexes, IndexMeta{Name: name, Unique: unique == 1})
 	}
-	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(c, ","), st)
-
-	var whereClauses []string
-	var params []interface{}
-
-	for _, condition := range f {
-		if len(condition) < 3 {
-			continue
+	if err := indexes.Err(); err != nil {
+		return nil, err
+	}
+	for i := range meta.Indexes {
+		columns, err := db.Query("PRAGMA index_info(" + SQLiteDialect{}.Quote(meta.Indexes[i].Name) + ")")
+		if err != nil {
+			return nil, err
 		}
-		field := condition[1]
-		operator := condition[0]
-		switch operator {
-		case "equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", field))
-			params = append(params, condition[2])
-		case "not-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s != ?", field))
-			params = append(params, condition[2])
-		case "in":
-			placeholders := strings.Repeat("?,", len(condition)-2)
-			placeholders = placeholders[:len(placeholders)-1]
-			whereClauses = append(whereClauses, fmt.Sprintf("%s IN (%s)", field, placeholders))
-			for _, v := range condition[2:] {
-				params = append(params, v)
+		for columns.Next() {
+			var seqno, cid int
+			var name sql.NullString
+			if err := columns.Scan(&seqno, &cid, &name); err != nil {
+				columns.Close()
+				return nil, err
 			}
-		case "not-in":
-			placeholders := strings.Repeat("?,", len(condition)-2)
-			placeholders = placeholders[:len(placeholders)-1]
-			whereClauses = append(whereClauses, fmt.Sprintf("%s NOT IN (%s)", field, placeholders))
-			for _, v := range condition[2:] {
-				params = append(params, v)
+			// Expression columns have no name.
+			if name.Valid {
+				meta.Indexes[i].Columns = append(meta.Indexes[i].Columns, name.String)
 			}
-		case "like":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s LIKE ?", field))
-			params = append(params, condition[2])
-		case "greater":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s > ?", field))
-			params = append(params, condition[2])
-		case "greater-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s >= ?", field))
-			params = append(params, condition[2])
-		case "less":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s < ?", field))
-			params = append(params, condition[2])
-		case "less-equal":
-			whereClauses = append(whereClauses, fmt.Sprintf("%s <= ?", field))
-			params = append(params, condition[2])
 		}
+		columns.Close()
 	}
+	return meta, nil
+}
 
-	if len(whereClauses) > 0 {
-		q += " WHERE " + strings.Join(whereClauses, " AND ")
-	}
-
-	if l != "" {
-		q += " " + l
-	}
-
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-
-	rows, err := stmt.Query(params...)
+// list_tables_sqlite lists the tables and views of the main database.
+// Parameters:
+// - db: The database connection.
+// Returns:
+// - The table names.
+// - An error if the query fails.
+func list_tables_sqlite(db Querier) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, err
-	}
-	var result []map[string]interface{}
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
+	var tables []string
 	for rows.Next() {
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
+		var name string
+		if err := rows.Scan(&name); err != nil {
 			return nil, err
 		}
-		m := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if val == nil {
-				m[col] = nil
-			} else {
-				switch v := val.(type) {
-				case []byte:
-					m[col] = string(v)
-				case int, int8, int16, int32, int64:
-					m[col] = strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
-				case uint, uint8, uint16, uint32, uint64:
-					m[col] = strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)
-				default:
-					m[col] = v
-				}
-			}
-		}
-		result = append(result, m)
+		tables = append(tables, name)
 	}
-
-	return result, nil
+	return tables, rows.Err()
 }
 
-// Update modifies existing records in the specified table.
+// SQLiteDialect is the Dialect of SQLite.
+type SQLiteDialect struct{}
+
+// NewSQLiteRepo creates a repository for SQLite.
 // Parameters:
-// - st: The name of the table.
-// - d: A map of column names to new values.
-// - w: The WHERE clause to specify which records to update.
-// - deprecated: A boolean flag for deprecated usage.
+// - db: The database connection.
+// - ts: How time.Time values are stored.
+// - statements: The number of prepared statements to cache, 0 disables caching.
 // Returns:
-// - An error if the operation fails.
-func (r *SQLiteRepoImpl) Update(st string, d map[string]interface{}, w string) error {
-	columns, err := get_columns_sqlite(r.db, st)
-	if err != nil {
-		return err
-	}
+// - A pointer to the new repository.
+func NewSQLiteRepo(db *sql.DB, ts TimeStorage, statements int) *SQLRepoImpl {
+	return NewSQLRepo(db, SQLiteDialect{}, ts, statements)
+}
 
-	q := fmt.Sprintf("UPDATE %s SET ", st)
-	var assignments []string
-	var values []interface{}
-	for _, column := range columns {
-		if val, ok := d[column]; ok {
-			assignments = append(assignments, fmt.Sprintf("%s = ?", column))
-			values = append(values, val)
-		}
-	}
-	q += strings.Join(assignments, ", ")
-	q += " WHERE " + w
+func (SQLiteDialect) Name() string { return "sqlite" }
 
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+func (SQLiteDialect) Placeholder(n int) string { return "?" }
 
-	_, err = stmt.Exec(values...)
-	return err
+func (SQLiteDialect) Quote(identifier string) string { return quote_with(`"`, identifier) }
+
+func (SQLiteDialect) LoadTable(db Querier, st string) (*TableMeta, error) {
+	return load_table_sqlite(db, st)
 }
 
-// Remove deletes records from the specified table.
-// Parameters:
-// - st: The name of the table.
-// - w: The WHERE clause to specify which records to delete.
-// Returns:
-// - An error if the operation fails.
-func (r *SQLiteRepoImpl) Remove(st string, w string) error {
-	q := fmt.Sprintf("DELETE FROM %s WHERE %s", st, w)
-	stmt, err := r.db.Prepare(q)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec()
-	if err != nil {
-		return err
+func (SQLiteDialect) ListTables(db Querier) ([]string, error) { return list_tables_sqlite(db) }
+
+// Value leaves the placeholder as is; SQLite stores JSON as text.
+func (SQLiteDialect) Value(placeholder string, columnType string) string { return placeholder }
+
+func (SQLiteDialect) Returning() bool { return false }
+
+func (SQLiteDialect) DefaultValues() string { return "DEFAULT VALUES" }
+
+// Condition compiles the JSON operators with json_each and json_extract.
+// Values are compared as text.
+func (SQLiteDialect) Condition(op string, field string, args []string, bind func(v interface{}) string) (string, bool) {
+	switch op {
+	case "json-array-contains":
+		// ["json-array-contains", column, value]
+		return "EXISTS (SELECT 1 FROM json_each(" + field + ") WHERE json_each.value = " + bind(args[0]) + ")", true
+	case "json-object-contains":
+		// ["json-object-contains", column, key, value]
+		if len(args) < 2 {
+			return "", false
+		}
+		return "json_extract(" + field + ", " + bind(json_path(args[0])) + ") = " + bind(args[1]), true
+	case "json-field-in":
+		// ["json-field-in", column, key, value...]
+		if len(args) < 2 {
+			return "", false
+		}
+		path := bind(json_path(args[0]))
+		placeholders := make([]string, len(args)-1)
+		for i, v := range args[1:] {
+			placeholders[i] = bind(v)
+		}
+		return "json_extract(" + field + ", " + path + ") IN (" + strings.Join(placeholders, ", ") + ")", true
 	}
-	return nil
+	return "", false
 }
@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// StatementStats reports how effective a statement cache is.
+type StatementStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+}
+
+// StatementCache is a bounded LRU of prepared statements keyed by SQL text.
+//
+// A *sql.Stmt prepared on *sql.DB is prepared again transparently on whichever pooled
+// connection runs it, so cached statements survive connections closed by
+// SetConnMaxLifetime. Statements are reference counted: an evicted statement is closed
+// once the last caller holding it is done.
+type StatementCache struct {
+	mu       sync.Mutex
+	db       *sql.DB
+	capacity int
+	lru      *list.List
+	entries  map[string]*list.Element
+	stats    StatementStats
+}
+
+type statementEntry struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+// NewStatementCache creates a statement cache.
+// Parameters:
+// - db: database connection the statements are prepared on
+// - capacity: maximum number of cached statements, 0 disables caching
+// Returns:
+// - *StatementCache: statement cache
+func NewStatementCache(db *sql.DB, capacity int) *StatementCache {
+	return &StatementCache{db: db, capacity: capacity, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *StatementCache) Stats() StatementStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = len(c.entries)
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// prepare returns a prepared statement for q. Close must be called when done with it,
+// which releases a cached statement instead of closing it.
+// Parameters:
+// - ctx: bounds the preparation; a cached statement stays usable after ctx is done
+// - db: connection or transaction of the repository; inside a transaction the cached
+// statement is bound to the transaction with Tx.Stmt
+// - q: SQL text
+// Returns:
+// - *preparedStmt: statement
+// - error: error information
+func (c *StatementCache) prepare(ctx context.Context, db dbtx, q string) (*preparedStmt, error) {
+	tx, inTx := db.(*sql.Tx)
+	if c == nil || c.capacity <= 0 || (!inTx && db != dbtx(c.db)) {
+		stmt, err := db.PrepareContext(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return &preparedStmt{stmt: stmt}, nil
+	}
+
+	entry, err := c.acquire(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	stmt := entry.stmt
+	if inTx {
+		stmt = tx.StmtContext(ctx, entry.stmt)
+	}
+	return &preparedStmt{stmt: stmt, cache: c, entry: entry, inTx: inTx}, nil
+}
+
+// acquire returns the cached entry for q, preparing it on a miss.
+func (c *StatementCache) acquire(ctx context.Context, q string) (*statementEntry, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[q]; ok {
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*statementEntry)
+		entry.refs++
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// Prepare without holding the lock; a concurrent miss on the same query keeps the
+	// statement that was cached first.
+	stmt, err := c.db.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[q]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*statementEntry)
+		entry.refs++
+		return entry, nil
+	}
+	entry := &statementEntry{query: q, stmt: stmt, refs: 1}
+	c.entries[q] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		c.evict(c.lru.Back().Value.(*statementEntry))
+		c.stats.Evictions++
+	}
+	return entry, nil
+}
+
+// evict removes entry from the cache, closing it now if nobody holds it. The caller
+// holds the lock.
+func (c *StatementCache) evict(entry *statementEntry) {
+	if entry.evicted {
+		return
+	}
+	entry.evicted = true
+	if el, ok := c.entries[entry.query]; ok && el.Value == entry {
+		c.lru.Remove(el)
+		delete(c.entries, entry.query)
+	}
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// release gives back a statement returned by acquire.
+func (c *StatementCache) release(entry *statementEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// Clear closes every cached statement that is not in use and drops the others once released.
+func (c *StatementCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		c.evict(el.Value.(*statementEntry))
+	}
+}
+
+// preparedStmt is a statement handed out by StatementCache.prepare.
+type preparedStmt struct {
+	stmt  *sql.Stmt
+	cache *StatementCache
+	entry *statementEntry
+	inTx  bool
+}
+
+func (s *preparedStmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	result, err := s.stmt.ExecContext(ctx, args...)
+	s.check(err)
+	return result, err
+}
+
+func (s *preparedStmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	rows, err := s.stmt.QueryContext(ctx, args...)
+	s.check(err)
+	return rows, err
+}
+
+func (s *preparedStmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
+	return s.stmt.QueryRowContext(ctx, args...)
+}
+
+// Close closes an uncached statement, or the transaction copy of a cached one, and
+// releases the cached statement.
+func (s *preparedStmt) Close() error {
+	if s.entry == nil {
+		return s.stmt.Close()
+	}
+	var err error
+	if s.inTx {
+		err = s.stmt.Close()
+	}
+	s.cache.release(s.entry)
+	return err
+}
+
+// check drops a cached statement that can no longer be run as is: PostgreSQL refuses it
+// after the table it reads was altered ("cached plan must not change result type"), and
+// a statement whose connection was closed under it has to be prepared again.
+func (s *preparedStmt) check(err error) {
+	if s.entry != nil && stale(err) {
+		s.cache.mu.Lock()
+		s.cache.evict(s.entry)
+		s.cache.mu.Unlock()
+	}
+}
+
+// stale reports whether err means a cached statement must be prepared again.
+func stale(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "0A000"
+	}
+	return errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn)
+}
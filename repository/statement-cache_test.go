@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// openStatementDB opens an in-memory SQLite database with rows records in table bench.
+func openStatementDB(tb testing.TB, rows int) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open("sqlite", "file:"+tb.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE TABLE bench (id INTEGER PRIMARY KEY, name TEXT NOT NULL, score INTEGER NOT NULL)"); err != nil {
+		tb.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 1; i <= rows; i++ {
+		if _, err := tx.Exec("INSERT INTO bench (id, name, score) VALUES (?, ?, ?)", i, "name-"+strconv.Itoa(i), i%100); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func scanScore(t *testing.T, stmt *preparedStmt, id int) int {
+	t.Helper()
+	var score int
+	if err := stmt.QueryRowContext(context.Background(), id).Scan(&score); err != nil {
+		t.Fatalf("query %d: %v", id, err)
+	}
+	return score
+}
+
+func TestStatementCacheEvictWhileCheckedOut(t *testing.T) {
+	db := openStatementDB(t, 3)
+	c := NewStatementCache(db, 1)
+	ctx := context.Background()
+
+	held, err := c.prepare(ctx, db, "SELECT score FROM bench WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := c.prepare(ctx, db, "SELECT score FROM bench WHERE id = ? + 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Size != 1 {
+		t.Fatalf("got %+v, want 1 eviction and size 1", stats)
+	}
+	// The evicted statement stays usable until the caller holding it is done.
+	if got := scanScore(t, held, 2); got != 2 {
+		t.Errorf("got score %d, want 2", got)
+	}
+	stmt := held.entry.stmt
+	if err := held.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stmt.QueryRowContext(ctx, 2).Scan(new(int)); err == nil {
+		t.Error("evicted statement is still open after its last release")
+	}
+
+	// Clear closes idle statements now and held ones on release.
+	held, err = c.prepare(ctx, db, "SELECT score FROM bench WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Clear()
+	if got := scanScore(t, held, 3); got != 3 {
+		t.Errorf("got score %d after clear, want 3", got)
+	}
+	held.Close()
+	if size := c.Stats().Size; size != 0 {
+		t.Errorf("got size %d after clear, want 0", size)
+	}
+}
+
+func TestStatementCacheConcurrent(t *testing.T) {
+	db := openStatementDB(t, 10)
+	c := NewStatementCache(db, 2)
+	ctx := context.Background()
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// Four distinct queries over a capacity of two keep evicting statements in use.
+				q := fmt.Sprintf("SELECT score FROM bench WHERE id = ? + %d", (w+i)%4)
+				stmt, err := c.prepare(ctx, db, q)
+				if err != nil {
+					errs <- err
+					return
+				}
+				var score int
+				err = stmt.QueryRowContext(ctx, 1).Scan(&score)
+				stmt.Close()
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", q, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stats := c.Stats()
+	if stats.Hits+stats.Misses != workers*rounds {
+		t.Errorf("got %d hits and %d misses, want %d lookups", stats.Hits, stats.Misses, workers*rounds)
+	}
+	if stats.Size > 2 {
+		t.Errorf("got size %d, want at most 2", stats.Size)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for q, el := range c.entries {
+		if refs := el.Value.(*statementEntry).refs; refs != 0 {
+			t.Errorf("%s: %d references left after every release", q, refs)
+		}
+	}
+}
+
+func TestStatementCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		evicted bool
+	}{
+		{"cached plan changed", &pq.Error{Code: "0A000"}, true},
+		{"connection closed", fmt.Errorf("query: %w", sql.ErrConnDone), true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"other postgres error", &pq.Error{Code: "23505"}, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"success", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openStatementDB(t, 1)
+			c := NewStatementCache(db, 4)
+			ctx := context.Background()
+			q := "SELECT score FROM bench WHERE id = ?"
+
+			stmt, err := c.prepare(ctx, db, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stmt.check(tt.err)
+			stmt.Close()
+
+			c.mu.Lock()
+			_, cached := c.entries[q]
+			c.mu.Unlock()
+			if cached == tt.evicted {
+				t.Fatalf("cached = %v, want %v", cached, !tt.evicted)
+			}
+
+			// The next caller prepares the statement again.
+			stmt, err = c.prepare(ctx, db, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stmt.Close()
+			if got := scanScore(t, stmt, 1); got != 1 {
+				t.Errorf("got score %d, want 1", got)
+			}
+			if misses, want := c.Stats().Misses, map[bool]uint64{true: 2, false: 1}[tt.evicted]; misses != want {
+				t.Errorf("got %d misses, want %d", misses, want)
+			}
+		})
+	}
+}
+
+// benchmarkGet reads one record by key through the SQLite repository. modernc.org/sqlite
+// compiles statement text on every execution, so on SQLite the cache only saves the
+// database/sql side of preparing; PostgreSQL and MySQL also save a round trip.
+func benchmarkGet(b *testing.B, statements int) {
+	const rows = 1000
+	db := openStatementDB(b, rows)
+	repo := NewSQLiteRepo(db, TimeStorageText, statements)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.Get(ctx, "bench", nil, [][]string{{"equal", "id", strconv.Itoa(i%rows + 1)}}, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if statements > 0 {
+		b.ReportMetric(float64(repo.Statements().Stats().Hits)/float64(b.N), "hits/op")
+	}
+}
+
+func BenchmarkGetCached(b *testing.B) {
+	benchmarkGet(b, 256)
+}
+
+func BenchmarkGetUncached(b *testing.B) {
+	benchmarkGet(b, 0)
+}
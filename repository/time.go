@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeStorage controls how time.Time values are bound to statements.
+type TimeStorage string
+
+const (
+	// TimeStorageNative binds time.Time in UTC for TIMESTAMPTZ / DATETIME / TIMESTAMP columns.
+	TimeStorageNative TimeStorage = "native"
+	// TimeStorageText binds time.Time as RFC 3339 UTC text for TEXT / VARCHAR columns.
+	TimeStorageText TimeStorage = "text"
+)
+
+// ParseTimeStorage parses a time storage mode.
+// Parameters:
+// - s: "native", "text" or empty
+// - fallback: mode used when s is empty
+// Returns:
+// - TimeStorage: parsed mode
+// - error: error information
+func ParseTimeStorage(s string, fallback TimeStorage) (TimeStorage, error) {
+	switch TimeStorage(s) {
+	case "":
+		return fallback, nil
+	case TimeStorageNative, TimeStorageText:
+		return TimeStorage(s), nil
+	}
+	return "", fmt.Errorf("unsupported time storage %q, expected native or text", s)
+}
+
+// textTimeLayout is the layout of times stored as text. Fractional seconds always have
+// nine digits, so stored times sort as text in time order; time.RFC3339Nano drops
+// trailing zeros and does not.
+const textTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// bindValue converts time.Time values according to the time storage mode and leaves
+// other values untouched.
+func bindValue(v interface{}, ts TimeStorage) interface{} {
+	t, ok := v.(time.Time)
+	if !ok {
+		return v
+	}
+	if ts == TimeStorageText {
+		return t.UTC().Format(textTimeLayout)
+	}
+	return t.UTC()
+}
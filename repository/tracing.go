@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"ovaphlow.com/crate/data/metrics"
+	"ovaphlow.com/crate/data/schema"
+)
+
+var tracer = otel.Tracer("ovaphlow.com/crate/data/repository")
+
+// opTransaction labels the span and metrics of Transaction.
+const opTransaction = "transaction"
+
+// affectedRowsKey is the row count of a write; semantic conventions only define
+// db.response.returned_rows for reads.
+const affectedRowsKey = attribute.Key("db.response.affected_rows")
+
+// sqlLiteralPattern matches, in order of preference, a quoted identifier (group 1), which
+// is kept, a single-quoted string with doubled quotes inside (group 2), and a numeric
+// literal with a leading minus sign, a fraction and an exponent that is not part of an
+// identifier or a $n placeholder; group 3 is the character before the number.
+var sqlLiteralPattern = regexp.MustCompile(`("(?:[^"]|"")*"|` + "`(?:[^`]|``)*`" + `)|('(?:[^']|'')*')|(^|[^\w$.])-?(?:\d+(?:\.\d*)?|\.\d+)(?:[eE][+-]?\d+)?`)
+
+// dbSystems maps dialect names to db.system.name values.
+var dbSystems = map[string]attribute.KeyValue{
+	"postgres": semconv.DBSystemNamePostgreSQL,
+	"mysql":    semconv.DBSystemNameMySQL,
+	"sqlite":   semconv.DBSystemNameSQLite,
+}
+
+// begin starts the span and the timer of one repository call. Values are always bound as
+// parameters, so the statement text only carries literals written into the l clause
+// or the columns, which sanitize_sql removes before the text is attached.
+// Parameters:
+// - ctx: context of the caller, carrying its span
+// - op: one of opInsert, opSelect, opUpdate, opDelete, opTransaction
+// - st: schema and table, empty for transactions
+// Returns:
+// - context.Context: context carrying the new span, to pass to the statements
+// - func(err error) error: ends the span and records the duration; err marks the span as
+// failed and is returned, as a client-closed error when the caller cancelled ctx
+func (r *SQLRepoImpl) begin(ctx context.Context, op string, st string) (context.Context, func(err error) error) {
+	start := time.Now()
+	name := op
+	attrs := []attribute.KeyValue{dbSystems[r.dialect.Name()], semconv.DBNamespace(r.name), semconv.DBOperationName(op)}
+	if st != "" {
+		name += " " + st
+		attrs = append(attrs, semconv.DBCollectionName(st))
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx, func(err error) error {
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			// Drivers report a cancelled statement in their own terms, e.g. 57014 on
+			// PostgreSQL; the caller went away, which is neither a timeout nor a server error.
+			err = schema.WrapError(schema.KindClientClosed, err, "")
+		}
+		metrics.QueryDuration.WithLabelValues(r.name, op).Observe(time.Since(start).Seconds())
+		end_span(span, err)
+		return err
+	}
+}
+
+// end_span ends span, marking it as failed when err is not nil. Only the error kind is
+// recorded: driver messages may quote the values of the row.
+func end_span(span trace.Span, err error) {
+	if err != nil {
+		kind := string(schema.AsError(err).Kind)
+		span.SetAttributes(semconv.ErrorTypeKey.String(kind))
+		span.SetStatus(codes.Error, kind)
+	}
+	span.End()
+}
+
+// trace_query attaches the sanitized statement text to the span of ctx.
+func trace_query(ctx context.Context, q string) {
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		span.SetAttributes(semconv.DBQueryText(sanitize_sql(q)))
+	}
+}
+
+// trace_affected attaches the number of rows a write changed to the span of ctx.
+// Returns:
+// - int64: number of rows, 0 when the driver cannot report it
+func trace_affected(ctx context.Context, result sql.Result) int64 {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	trace.SpanFromContext(ctx).SetAttributes(affectedRowsKey.Int64(n))
+	return n
+}
+
+// sanitize_sql replaces string and numeric literals in q with "?". Quoted identifiers are
+// kept, including those made of digits.
+// Parameters:
+// - q: SQL text
+// Returns:
+// - string: SQL text without literal values
+func sanitize_sql(q string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range sqlLiteralPattern.FindAllStringSubmatchIndex(q, -1) {
+		switch {
+		case m[2] >= 0:
+			continue
+		case m[4] >= 0:
+			b.WriteString(q[last:m[0]])
+		default:
+			b.WriteString(q[last:m[7]])
+		}
+		b.WriteString("?")
+		last = m[1]
+	}
+	b.WriteString(q[last:])
+	return b.String()
+}
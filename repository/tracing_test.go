@@ -0,0 +1,33 @@
+package repository
+
+import "testing"
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{"string", `SELECT * FROM "t" WHERE "a" = 'x'`, `SELECT * FROM "t" WHERE "a" = ?`},
+		{"embedded quotes", `SELECT 'it''s', 'a''''b' FROM t`, `SELECT ?, ? FROM t`},
+		{"empty string", `WHERE a = '' AND b = 'y'`, `WHERE a = ? AND b = ?`},
+		{"digits in a string", `WHERE a = 'room 101'`, `WHERE a = ?`},
+		{"integer", `LIMIT 20 OFFSET 40`, `LIMIT ? OFFSET ?`},
+		{"negative", `WHERE a > -5 AND b IN (-1,-2)`, `WHERE a > ? AND b IN (?,?)`},
+		{"decimal", `WHERE price <= 100.50 AND rate > .5 AND x < 3.`, `WHERE price <= ? AND rate > ? AND x < ?`},
+		{"exponent", `WHERE a < 1e-3 OR b > -2.5E10`, `WHERE a < ? OR b > ?`},
+		{"subtraction", `SELECT a-1 FROM t`, `SELECT a-? FROM t`},
+		{"identifiers with digits", `SELECT t1.col2, "3d" FROM t1 JOIN t_2 ON t1.id = t_2.id`, `SELECT t1.col2, "3d" FROM t1 JOIN t_2 ON t1.id = t_2.id`},
+		{"quoted identifiers", "SELECT `2024`, \"a\"\"1\" FROM t WHERE \"x'1\" = 'y\"2'", "SELECT `2024`, \"a\"\"1\" FROM t WHERE \"x'1\" = ?"},
+		{"placeholders", `WHERE a = $1 AND b = ? AND c = $12`, `WHERE a = $1 AND b = ? AND c = $12`},
+		{"function arguments", `SELECT COUNT(*), ROUND(a, 2) FROM t`, `SELECT COUNT(*), ROUND(a, ?) FROM t`},
+		{"leading number", `1 + 2`, `? + ?`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitize_sql(tt.q); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is a subscription to data changes.
+type Webhook struct {
+	ID      string `json:"id"`
+	Backend string `json:"backend"`
+	// Table is "schema.table", or "*" for every table of the backend.
+	Table string `json:"table"`
+	// Ops lists the operations to deliver, empty for all of them.
+	Ops []string `json:"ops"`
+	// Filter uses the same format as the f query parameter of the list endpoint.
+	Filter    string    `json:"filter"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Paused    bool      `json:"paused"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event queued for one subscription.
+type WebhookDelivery struct {
+	ID            string    `json:"id"`
+	WebhookID     string    `json:"webhook_id"`
+	EventID       string    `json:"event_id"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+const webhookSchema = `
+CREATE TABLE IF NOT EXISTS webhook_subscription (
+    id TEXT PRIMARY KEY,
+    backend TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    ops TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    paused INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id TEXT PRIMARY KEY,
+    webhook_id TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TEXT NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_due ON webhook_delivery (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS webhook_delivery_webhook ON webhook_delivery (webhook_id, status);
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_delivery_event ON webhook_delivery (webhook_id, event_id);
+`
+
+// WebhookRepo stores webhook subscriptions and deliveries in SQLite. Times are
+// stored as RFC 3339 UTC text with a fixed width so that they sort as text.
+type WebhookRepo struct {
+	db *sql.DB
+}
+
+const webhookTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func webhookTime(t time.Time) string {
+	return t.UTC().Format(webhookTimeLayout)
+}
+
+func parseWebhookTime(s string) time.Time {
+	t, _ := time.Parse(webhookTimeLayout, s)
+	return t
+}
+
+// NewWebhookRepo creates the webhook tables if needed.
+// Parameters:
+// - db: The webhook database connection.
+// Returns:
+// - A pointer to the new WebhookRepo instance.
+// - An error if the tables cannot be created.
+func NewWebhookRepo(db *sql.DB) (*WebhookRepo, error) {
+	if _, err := db.Exec(webhookSchema); err != nil {
+		return nil, err
+	}
+	return &WebhookRepo{db: db}, nil
+}
+
+// CreateWebhook inserts a subscription.
+func (r *WebhookRepo) CreateWebhook(w Webhook) error {
+	ops, err := json.Marshal(w.Ops)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(
+		"INSERT INTO webhook_subscription (id, backend, table_name, ops, filter, url, secret, paused, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		w.ID, w.Backend, w.Table, string(ops), w.Filter, w.URL, w.Secret, w.Paused, webhookTime(w.CreatedAt),
+	)
+	return translateError(opInsert, err)
+}
+
+// ListWebhooks returns every subscription, oldest first.
+func (r *WebhookRepo) ListWebhooks() ([]Webhook, error) {
+	return r.queryWebhooks("SELECT id, backend, table_name, ops, filter, url, secret, paused, created_at FROM webhook_subscription ORDER BY created_at")
+}
+
+// GetWebhook returns a subscription, or nil when it does not exist.
+func (r *WebhookRepo) GetWebhook(id string) (*Webhook, error) {
+	result, err := r.queryWebhooks("SELECT id, backend, table_name, ops, filter, url, secret, paused, created_at FROM webhook_subscription WHERE id = ?", id)
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+func (r *WebhookRepo) queryWebhooks(q string, args ...interface{}) ([]Webhook, error) {
+	rows, err := r.db.Query(q, args...)
+	if err != nil {
+		return nil, translateError(opSelect, err)
+	}
+	defer rows.Close()
+
+	result := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var ops, createdAt string
+		if err := rows.Scan(&w.ID, &w.Backend, &w.Table, &ops, &w.Filter, &w.URL, &w.Secret, &w.Paused, &createdAt); err != nil {
+			return nil, translateError(opSelect, err)
+		}
+		if err := json.Unmarshal([]byte(ops), &w.Ops); err != nil {
+			return nil, err
+		}
+		w.CreatedAt = parseWebhookTime(createdAt)
+		result = append(result, w)
+	}
+	return result, translateError(opSelect, rows.Err())
+}
+
+// SetWebhookPaused pauses or resumes a subscription.
+// Returns:
+// - Whether the subscription exists.
+// - An error if the operation fails.
+func (r *WebhookRepo) SetWebhookPaused(id string, paused bool) (bool, error) {
+	result, err := r.db.Exec("UPDATE webhook_subscription SET paused = ? WHERE id = ?", paused, id)
+	if err != nil {
+		return false, translateError(opUpdate, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, translateError(opUpdate, err)
+}
+
+// RemoveWebhook deletes a subscription together with its deliveries.
+// Returns:
+// - Whether the subscription existed.
+// - An error if the operation fails.
+func (r *WebhookRepo) RemoveWebhook(id string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, translateError(opDelete, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM webhook_delivery WHERE webhook_id = ?", id); err != nil {
+		return false, translateError(opDelete, err)
+	}
+	result, err := tx.Exec("DELETE FROM webhook_subscription WHERE id = ?", id)
+	if err != nil {
+		return false, translateError(opDelete, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, translateError(opDelete, err)
+	}
+	return n > 0, translateError(opDelete, tx.Commit())
+}
+
+// EnqueueDeliveries inserts pending deliveries in one transaction. A delivery of an
+// event that is already queued for the same subscription is ignored, so an event
+// handed over again by the outbox relay is delivered once.
+func (r *WebhookRepo) EnqueueDeliveries(deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return translateError(opInsert, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO webhook_delivery (id, webhook_id, event_id, payload, status, attempts, next_attempt_at, last_error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, 0, ?, '', ?, ?)")
+	if err != nil {
+		return translateError(opInsert, err)
+	}
+	defer stmt.Close()
+
+	for _, d := range deliveries {
+		now := webhookTime(d.CreatedAt)
+		if _, err := stmt.Exec(d.ID, d.WebhookID, d.EventID, d.Payload, DeliveryPending, webhookTime(d.NextAttemptAt), now, now); err != nil {
+			return translateError(opInsert, err)
+		}
+	}
+	return translateError(opInsert, tx.Commit())
+}
+
+// ClaimDeliveries returns pending deliveries that are due, oldest first, skipping
+// paused subscriptions. Claimed deliveries are pushed back by lease so that they are
+// not picked again while in flight, and become due again if the process dies.
+// Parameters:
+// - now: The current time.
+// - lease: How long a claimed delivery is hidden from other claims.
+// - limit: The maximum number of deliveries to claim.
+// Returns:
+// - The claimed deliveries.
+// - An error if the operation fails.
+func (r *WebhookRepo) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, translateError(opSelect, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT d.id, d.webhook_id, d.event_id, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_error, d.created_at, d.updated_at
+FROM webhook_delivery d JOIN webhook_subscription s ON s.id = d.webhook_id
+WHERE d.status = ? AND d.next_attempt_at <= ? AND s.paused = 0
+ORDER BY d.next_attempt_at, d.created_at LIMIT ?`, DeliveryPending, webhookTime(now), limit)
+	if err != nil {
+		return nil, translateError(opSelect, err)
+	}
+	result, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	leased := webhookTime(now.Add(lease))
+	for i := range result {
+		if _, err := tx.Exec("UPDATE webhook_delivery SET next_attempt_at = ? WHERE id = ?", leased, result[i].ID); err != nil {
+			return nil, translateError(opUpdate, err)
+		}
+	}
+	return result, translateError(opUpdate, tx.Commit())
+}
+
+// FinishDelivery records the outcome of an attempt.
+// Parameters:
+// - id: The delivery id.
+// - status: DeliveryDelivered, DeliveryPending (retry at next) or DeliveryDead.
+// - next: When to retry, ignored unless status is DeliveryPending.
+// - lastError: The failure reason, empty on success.
+// Returns:
+// - An error if the operation fails.
+func (r *WebhookRepo) FinishDelivery(id string, status string, next time.Time, lastError string) error {
+	now := webhookTime(time.Now())
+	_, err := r.db.Exec(
+		"UPDATE webhook_delivery SET status = ?, attempts = attempts + 1, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		status, webhookTime(next), lastError, now, id,
+	)
+	return translateError(opUpdate, err)
+}
+
+// ReleaseDelivery makes a claimed delivery due again without counting an attempt,
+// for attempts interrupted by shutdown.
+// Parameters:
+// - id: The delivery id.
+// - next: When to retry.
+// Returns:
+// - An error if the operation fails.
+func (r *WebhookRepo) ReleaseDelivery(id string, next time.Time) error {
+	_, err := r.db.Exec("UPDATE webhook_delivery SET next_attempt_at = ? WHERE id = ? AND status = ?", webhookTime(next), id, DeliveryPending)
+	return translateError(opUpdate, err)
+}
+
+// ListDeliveries returns the deliveries of a subscription, newest first.
+// Parameters:
+// - webhookID: The subscription id.
+// - status: Only deliveries in this state, empty for all of them.
+// - limit: The maximum number of deliveries.
+// Returns:
+// - The deliveries.
+// - An error if the operation fails.
+func (r *WebhookRepo) ListDeliveries(webhookID string, status string, limit int) ([]WebhookDelivery, error) {
+	q := "SELECT id, webhook_id, event_id, payload, status, attempts, next_attempt_at, last_error, created_at, updated_at FROM webhook_delivery WHERE webhook_id = ?"
+	args := []interface{}{webhookID}
+	if status != "" {
+		q += " AND status = ?"
+		args = append(args, status)
+	}
+	q += " ORDER BY created_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.db.Query(q, args...)
+	if err != nil {
+		return nil, translateError(opSelect, err)
+	}
+	return scanDeliveries(rows)
+}
+
+// RequeueDeliveries makes dead deliveries of a subscription pending again with a
+// fresh attempt count.
+// Parameters:
+// - webhookID: The subscription id.
+// - ids: Only these deliveries, empty for every dead delivery of the subscription.
+// Returns:
+// - The number of requeued deliveries.
+// - An error if the operation fails.
+func (r *WebhookRepo) RequeueDeliveries(webhookID string, ids []string) (int64, error) {
+	now := webhookTime(time.Now())
+	q := "UPDATE webhook_delivery SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ? WHERE webhook_id = ? AND status = ?"
+	args := []interface{}{DeliveryPending, now, now, webhookID, DeliveryDead}
+	if len(ids) > 0 {
+		q += " AND id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	result, err := r.db.Exec(q, args...)
+	if err != nil {
+		return 0, translateError(opUpdate, err)
+	}
+	n, err := result.RowsAffected()
+	return n, translateError(opUpdate, err)
+}
+
+func scanDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+
+	result := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var next, createdAt, updatedAt string
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Payload, &d.Status, &d.Attempts, &next, &d.LastError, &createdAt, &updatedAt); err != nil {
+			return nil, translateError(opSelect, err)
+		}
+		d.NextAttemptAt = parseWebhookTime(next)
+		d.CreatedAt = parseWebhookTime(createdAt)
+		d.UpdatedAt = parseWebhookTime(updatedAt)
+		result = append(result, d)
+	}
+	return result, translateError(opSelect, rows.Err())
+}
@@ -0,0 +1,265 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/middleware"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// changeHeartbeat SSE 心跳间隔，避免代理断开空闲连接。
+const changeHeartbeat = 25 * time.Second
+
+// ChangeAuthorizer 返回调用方对数据表的行、列权限，返回错误时拒绝订阅。
+//
+// 每个变更在推送前都按该权限过滤行、裁剪列。
+type ChangeAuthorizer func(r *http.Request, backend string, st string) (event.Permission, error)
+
+// AuthorizeChanges 变更流使用的权限函数，默认拒绝所有订阅（403），须注册权限函数
+// 或通过 CHANGE_STREAM_ALLOW_ALL 改为 AllowAllChanges。
+var AuthorizeChanges ChangeAuthorizer = DenyChanges
+
+// DenyChanges 拒绝订阅，未注册权限函数时使用。
+func DenyChanges(r *http.Request, backend string, st string) (event.Permission, error) {
+	return event.Permission{}, schema.NewError(schema.KindForbidden, "变更流未配置权限")
+}
+
+// AllowAllChanges 允许访问所有行和列，与列表接口相同。
+func AllowAllChanges(r *http.Request, backend string, st string) (event.Permission, error) {
+	return event.Permission{}, nil
+}
+
+// changeMessage 推送给客户端的变更。
+type changeMessage struct {
+	ID     string         `json:"id"`
+	Type   string         `json:"type"`
+	Table  string         `json:"table,omitempty"`
+	Key    string         `json:"key,omitempty"`
+	Record map[string]any `json:"record,omitempty"`
+	At     string         `json:"at,omitempty"`
+}
+
+// changeStream 一个客户端的变更订阅。
+type changeStream struct {
+	st         string
+	cfg        service.TableConfig
+	f          [][]string
+	permission event.Permission
+	loc        *time.Location
+}
+
+// message 按过滤条件和权限转换变更，不可见时返回 false。
+func (cs changeStream) message(c event.Change) (changeMessage, bool) {
+	if !cs.permission.Allows(c.Record) || !event.Match(c.Record, cs.f) {
+		return changeMessage{}, false
+	}
+	record := cs.permission.Project(c.Record)
+	localizeRecords(cs.loc, cs.cfg, record)
+	return changeMessage{
+		ID:     strconv.FormatUint(c.ID, 10),
+		Type:   c.Op,
+		Table:  c.Table,
+		Key:    c.Key,
+		Record: record,
+		At:     c.At.In(cs.loc).Format(utility.TimeLayout),
+	}, true
+}
+
+// serveChanges 推送数据表的变更。
+//
+// 请求带有 WebSocket 升级头时使用 WebSocket，否则使用 Server-Sent Events。
+// 过滤参数 f、s 与列表接口相同；断线续传使用 Last-Event-ID 头或查询参数 last_event_id，
+// 所需变更已移出缓冲区时先推送 reset，客户端应重新加载列表。
+func serveChanges(w http.ResponseWriter, r *http.Request, backend string, svc *service.ApplicationServiceImpl) {
+	bus := svc.Events()
+	if bus == nil {
+		schema.WriteProblem(w, r, "变更流未启用", schema.NewError(schema.KindNotFound, "变更流未启用"))
+		return
+	}
+
+	st := r.PathValue("st")
+	loc, err := requestLocation(r)
+	if err != nil {
+		schema.WriteProblem(w, r, "无效的时区", err)
+		return
+	}
+	f, err := utility.ConvertQueryStringToDefaultFilter(r.URL.Query().Get("f"))
+	if err != nil {
+		schema.WriteProblem(w, r, "无效的查询参数", schema.WrapError(schema.KindBadRequest, err, "无效的查询参数"))
+		return
+	}
+	if states := r.URL.Query().Get("s"); states != "" {
+		condition, err := svc.LifecycleFilter(st, strings.Split(states, ","))
+		if err != nil {
+			schema.WriteProblem(w, r, "无效的查询参数", err)
+			return
+		}
+		f = append(f, condition)
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			schema.WriteProblem(w, r, "无效的事件ID", &schema.Error{Kind: schema.KindBadRequest, Detail: "无效的事件ID", Field: "Last-Event-ID", Err: err})
+			return
+		}
+	}
+	permission, err := AuthorizeChanges(r, backend, st)
+	if err != nil {
+		// 拒绝订阅不是服务端错误
+		if schema.AsError(err).Status() >= http.StatusInternalServerError {
+			utility.ZapLogger.Error("订阅变更失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		}
+		schema.WriteProblem(w, r, "订阅变更失败", err)
+		return
+	}
+
+	cs := changeStream{st: st, cfg: svc.TableConfig(st), f: f, permission: permission, loc: loc}
+	sub, replay, complete := bus.Subscribe(st, lastID)
+	defer sub.Close()
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		streamWebSocket(w, r, cs, sub, replay, complete, lastEventID)
+		return
+	}
+	streamSSE(w, r, cs, sub, replay, complete, lastEventID)
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, cs changeStream, sub *event.Subscription, replay []event.Change, complete bool, lastEventID string) {
+	// 变更流不受服务器读写超时的限制，每次写入前按 StreamWriteTimeout 重新计时
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	extendWriteDeadline(rc)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(m changeMessage) error {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		extendWriteDeadline(rc)
+		if m.ID != "" {
+			_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", m.ID, m.Type, b)
+		} else {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, b)
+		}
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if !complete {
+		if err := write(changeMessage{Type: "reset", ID: lastEventID}); err != nil {
+			return
+		}
+	}
+	for _, c := range replay {
+		if m, ok := cs.message(c); ok {
+			if err := write(m); err != nil {
+				return
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(changeHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			extendWriteDeadline(rc)
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case c, ok := <-sub.Changes():
+			if !ok {
+				// 消费过慢被断开，客户端重连后按 Last-Event-ID 续传
+				utility.ZapLogger.Warn("变更订阅已断开", zap.String("table", cs.st), zap.Bool("dropped", sub.Dropped()), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+				return
+			}
+			if m, ok := cs.message(c); ok {
+				if err := write(m); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func streamWebSocket(w http.ResponseWriter, r *http.Request, cs changeStream, sub *event.Subscription, replay []event.Change, complete bool, lastEventID string) {
+	// 接管后的连接保留服务器设置的读写超时，清除后由每次写入的 ctx 限制
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	// 与 CORS 设置一致
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: middleware.CORSOriginPatterns()})
+	if err != nil {
+		utility.ZapLogger.Error("WebSocket 握手失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		return
+	}
+	defer conn.CloseNow()
+
+	// 客户端不发送消息，CloseRead 负责处理关闭帧并在连接断开时取消 ctx
+	ctx := conn.CloseRead(r.Context())
+	write := func(m changeMessage) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return wsjson.Write(ctx, conn, m)
+	}
+
+	if !complete {
+		if err := write(changeMessage{Type: "reset", ID: lastEventID}); err != nil {
+			return
+		}
+	}
+	for _, c := range replay {
+		if m, ok := cs.message(c); ok {
+			if err := write(m); err != nil {
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c, ok := <-sub.Changes():
+			if !ok {
+				conn.Close(websocket.StatusTryAgainLater, "订阅已断开")
+				return
+			}
+			if m, ok := cs.message(c); ok {
+				if err := write(m); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
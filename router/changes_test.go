@@ -0,0 +1,141 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+)
+
+// newChangesServer 启动只有 sqlite 数据源的数据接口，变更总线保留 size 个变更。
+func newChangesServer(t *testing.T, size int) (*httptest.Server, *event.Bus) {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dialect, _ := repository.DialectFor("sqlite")
+	bus := event.NewBus(size)
+	svc := service.NewApplicationService(repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0), nil, bus, nil, nil)
+	mux := http.NewServeMux()
+	LoadDataRouter(mux, "/api", "sqlite", svc)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, bus
+}
+
+// readEvents 读取 n 个 SSE 事件，返回 "event id" 形式的列表。
+func readEvents(t *testing.T, url string, lastEventID string, n int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+
+	var events []string
+	var id, typ string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case line == "" && typ != "":
+			events = append(events, typ+" "+id)
+			id, typ = "", ""
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("got %v before the stream ended: %v", events, scanner.Err())
+	}
+	return events
+}
+
+func TestChangesDeniedWithoutAuthorizer(t *testing.T) {
+	srv, _ := newChangesServer(t, 4)
+	resp, err := http.Get(srv.URL + "/api/sqlite/posts/_changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestChangesResume(t *testing.T) {
+	AuthorizeChanges = AllowAllChanges
+	t.Cleanup(func() { AuthorizeChanges = DenyChanges })
+
+	srv, bus := newChangesServer(t, 3)
+	var published []string
+	bus.Listen(func(c event.Change) { published = append(published, strconv.FormatUint(c.ID, 10)) })
+	for i, table := range []string{"posts", "posts", "users", "posts", "posts"} {
+		bus.Publish(event.Change{Op: event.OpCreate, Table: table, Key: strconv.Itoa(i), Record: map[string]any{"id": i}})
+	}
+	// 缓冲区保留后三个变更，其中 posts 的是 published[3]、published[4]
+	url := srv.URL + "/api/sqlite/posts/_changes"
+
+	t.Run("buffered", func(t *testing.T) {
+		got := readEvents(t, url, published[2], 2)
+		want := []string{"create " + published[3], "create " + published[4]}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("fallen out of the buffer", func(t *testing.T) {
+		got := readEvents(t, url, published[0], 3)
+		want := []string{"reset " + published[0], "create " + published[3], "create " + published[4]}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("row permission", func(t *testing.T) {
+		AuthorizeChanges = func(r *http.Request, backend string, st string) (event.Permission, error) {
+			return event.Permission{Filter: [][]string{{"equal", "id", "4"}}}, nil
+		}
+		got := readEvents(t, url, published[2], 1)
+		if want := "create " + published[4]; got[0] != want {
+			t.Errorf("got %v, want %s", got, want)
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("got status %d, want 400", resp.StatusCode)
+		}
+	})
+}
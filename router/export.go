@@ -0,0 +1,466 @@
+package router
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// CSVContentType CSV 的媒体类型。
+const CSVContentType = "text/csv"
+
+// DroppedColumnsTrailer 响应尾部字段，列出导出时因不在标题行中而未输出的展开列。
+const DroppedColumnsTrailer = "X-Dropped-Columns"
+
+const (
+	// tableLayoutRows 确定表格列时读取的记录数，JSON 列按这些记录中出现过的所有键展开。
+	tableLayoutRows = 1000
+	// maxDroppedColumns 记录的未输出展开列的数量上限。
+	maxDroppedColumns = 20
+)
+
+// tableColumn 导出表格中的一列。
+type tableColumn struct {
+	// name 列名，展开的 JSON 列为 "列名<sep>键"
+	name string
+	// source 记录中的列
+	source string
+	// path 展开的 JSON 列中的键路径，普通列为空
+	path []string
+	// first 是否为 JSON 列展开后的第一列
+	first bool
+}
+
+// tableSink 表格的输出格式。
+type tableSink interface {
+	open(w io.Writer) error
+	write(cells []any) error
+	close() error
+}
+
+// tableEncoder 以 CSV 或 Excel 表格的形式输出记录。
+//
+// 列的顺序与查询结果相同，即参数 c 的顺序，未指定 c 时为数据表的列顺序。
+// 值为 JSON 对象的列按前 tableLayoutRows 条记录中出现过的所有键展开为多列。
+// 标题行写出后才出现的键无法再加入表格，记入 dropped，由调用方在响应尾部和日志中报告。
+type tableEncoder struct {
+	sink      tableSink
+	format    string
+	st        string
+	requested []string
+	labels    map[string]string
+	flatten   bool
+	sep       string
+	listSep   string
+	columns   []tableColumn
+	// order 与 pending 为确定表格列之前读取的列名和记录
+	order   []string
+	pending []map[string]any
+	// paths 展开的 JSON 列中已输出的键路径，按 "列名\x00键\x00键" 记录
+	paths map[string]struct{}
+	// dropped 未输出的展开列，最多 maxDroppedColumns 个
+	dropped []string
+}
+
+// newTableEncoder 按请求参数创建表格编码器。
+//
+// 参数:
+//   - r: 请求，读取参数 flatten、sep、list_sep、delimiter、bom、lang 和 Accept-Language 头。
+//   - format: csv 或 xlsx。
+//   - st: schema and table，用作文件名和工作表名称。
+//   - cfg: 数据表选项，提供列标题。
+//   - c: 查询的列，结果为空时用作标题行。
+//
+// 返回值:
+//   - *tableEncoder: 编码器。
+//   - error: 参数无效时返回请求错误。
+func newTableEncoder(r *http.Request, format string, st string, cfg service.TableConfig, c []string) (*tableEncoder, error) {
+	q := r.URL.Query()
+	e := &tableEncoder{
+		format:    format,
+		st:        st,
+		requested: c,
+		labels:    cfg.HeaderLabels(requestLanguages(r)),
+		flatten:   q.Get("flatten") != "false",
+		sep:       ".",
+		listSep:   ";",
+	}
+	if q.Has("sep") {
+		e.sep = q.Get("sep")
+	}
+	if q.Has("list_sep") {
+		e.listSep = q.Get("list_sep")
+	}
+
+	switch format {
+	case "csv":
+		comma, err := csvDelimiter(r)
+		if err != nil {
+			return nil, err
+		}
+		e.sink = &csvSink{comma: comma, bom: q.Get("bom") == "true"}
+	case "xlsx":
+		e.sink = &xlsxSink{sheet: st}
+	}
+	return e, nil
+}
+
+func (e *tableEncoder) header(h http.Header) {
+	h.Set("Content-Type", responseFormats[e.format])
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": e.st + "." + e.format}))
+}
+
+func (e *tableEncoder) begin(w io.Writer) error {
+	return e.sink.open(w)
+}
+
+func (e *tableEncoder) row(w io.Writer, columns []string, m map[string]any) error {
+	if e.columns != nil {
+		return e.write(m)
+	}
+	if e.order == nil {
+		e.order = columns
+	}
+	e.pending = append(e.pending, m)
+	if len(e.pending) < tableLayoutRows {
+		return nil
+	}
+	return e.flushPending()
+}
+
+// flushPending 按已读取的记录确定表格的列，写出标题行和这些记录。
+func (e *tableEncoder) flushPending() error {
+	e.columns = e.layout(e.order, e.pending)
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	for _, m := range e.pending {
+		if err := e.write(m); err != nil {
+			return err
+		}
+	}
+	e.pending = nil
+	return nil
+}
+
+// write 按表格的列写出一条记录。
+func (e *tableEncoder) write(m map[string]any) error {
+	objects := map[string]map[string]any{}
+	cells := make([]any, len(e.columns))
+	for i, col := range e.columns {
+		v := m[col.source]
+		if col.path == nil {
+			cells[i] = tableCell(v, e.listSep)
+			continue
+		}
+		obj, ok := objects[col.source]
+		if !ok {
+			obj = jsonObject(v)
+			objects[col.source] = obj
+			e.checkPaths(col.source, obj)
+		}
+		if obj == nil {
+			// 不是 JSON 对象的值原样写入展开后的第一列
+			if col.first {
+				cells[i] = tableCell(v, e.listSep)
+			}
+			continue
+		}
+		cells[i] = tableCell(lookupPath(obj, col.path), e.listSep)
+	}
+	return e.sink.write(cells)
+}
+
+func (e *tableEncoder) end(w io.Writer) error {
+	if e.columns == nil && len(e.pending) > 0 {
+		if err := e.flushPending(); err != nil {
+			return err
+		}
+	}
+	if e.columns == nil && len(e.requested) > 0 {
+		for _, c := range e.requested {
+			e.columns = append(e.columns, tableColumn{name: columnAlias(c), source: columnAlias(c)})
+		}
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	return e.sink.close()
+}
+
+// layout 按读取的记录确定表格的列，JSON 列展开为所有记录中出现过的键路径，按键排序。
+func (e *tableEncoder) layout(columns []string, records []map[string]any) []tableColumn {
+	if len(columns) == 0 {
+		// 没有列顺序时按所有记录中的列名排序
+		seen := map[string]struct{}{}
+		for _, m := range records {
+			for k := range m {
+				if _, ok := seen[k]; !ok {
+					seen[k] = struct{}{}
+					columns = append(columns, k)
+				}
+			}
+		}
+		slices.Sort(columns)
+	}
+	e.paths = map[string]struct{}{}
+	layout := make([]tableColumn, 0, len(columns))
+	for _, col := range columns {
+		var paths [][]string
+		if e.flatten {
+			for _, m := range records {
+				for _, path := range flattenPaths(jsonObject(m[col]), nil) {
+					key := pathKey(col, path)
+					if _, ok := e.paths[key]; !ok {
+						e.paths[key] = struct{}{}
+						paths = append(paths, path)
+					}
+				}
+			}
+			slices.SortFunc(paths, slices.Compare)
+		}
+		if len(paths) == 0 {
+			layout = append(layout, tableColumn{name: col, source: col})
+			continue
+		}
+		for i, path := range paths {
+			layout = append(layout, tableColumn{name: col + e.sep + strings.Join(path, e.sep), source: col, path: path, first: i == 0})
+		}
+	}
+	return layout
+}
+
+// checkPaths 记录展开的 JSON 列中不在表格列中的键路径。
+func (e *tableEncoder) checkPaths(col string, obj map[string]any) {
+	for _, path := range flattenPaths(obj, nil) {
+		if _, ok := e.paths[pathKey(col, path)]; ok {
+			continue
+		}
+		name := col + e.sep + strings.Join(path, e.sep)
+		if len(e.dropped) < maxDroppedColumns && !slices.Contains(e.dropped, name) {
+			e.dropped = append(e.dropped, name)
+		}
+	}
+}
+
+// reportDropped 在响应尾部列出未输出的展开列并写入日志，没有时不做任何事。
+func (e *tableEncoder) reportDropped(w http.ResponseWriter, r *http.Request) {
+	if len(e.dropped) == 0 {
+		return
+	}
+	w.Header().Set(http.TrailerPrefix+DroppedColumnsTrailer, strings.Join(e.dropped, ","))
+	utility.ZapLogger.Warn("导出的记录中有不在标题行中的 JSON 键，未输出",
+		zap.String("table", e.st),
+		zap.Strings("columns", e.dropped),
+		zap.String("request_id", r.Header.Get(schema.RequestIDHeader)),
+	)
+}
+
+// pathKey 展开的 JSON 列中一个键路径的标识。
+func pathKey(col string, path []string) string {
+	return col + "\x00" + strings.Join(path, "\x00")
+}
+
+// writeHeader 写出标题行，数据表配置了当前语言的标题时使用配置的标题。
+// 展开的 JSON 列没有单独配置标题时，使用原列的标题加键路径。
+func (e *tableEncoder) writeHeader() error {
+	header := make([]any, len(e.columns))
+	for i, col := range e.columns {
+		if label, ok := e.labels[col.name]; ok {
+			header[i] = label
+		} else if label, ok := e.labels[col.source]; ok && col.path != nil {
+			header[i] = label + e.sep + strings.Join(col.path, e.sep)
+		} else {
+			header[i] = col.name
+		}
+	}
+	return e.sink.write(header)
+}
+
+// csvDelimiter 获取查询参数 delimiter 指定的 CSV 字段分隔符，默认为逗号。
+func csvDelimiter(r *http.Request) (rune, error) {
+	d := r.URL.Query().Get("delimiter")
+	if d == "" {
+		return ',', nil
+	}
+	comma, size := utf8.DecodeRuneInString(d)
+	if size != len(d) || comma == '"' || comma == '\r' || comma == '\n' || comma == utf8.RuneError {
+		return 0, &schema.Error{Kind: schema.KindBadRequest, Detail: "无效的分隔符", Field: "delimiter"}
+	}
+	return comma, nil
+}
+
+// requestLanguages 获取请求的语言偏好：查询参数 lang 优先，其次为 Accept-Language 头。
+func requestLanguages(r *http.Request) []string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return []string{lang}
+	}
+	var languages []string
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if tag = strings.TrimSpace(tag); tag != "" && tag != "*" {
+			languages = append(languages, tag)
+		}
+	}
+	return languages
+}
+
+// columnAlias 获取查询列在结果中的列名，例如 "COUNT(*) as qty" 为 "qty"。
+func columnAlias(c string) string {
+	fields := strings.Fields(c)
+	if n := len(fields); n >= 3 && strings.EqualFold(fields[n-2], "as") {
+		return strings.Trim(fields[n-1], "\"`")
+	}
+	return strings.TrimSpace(c)
+}
+
+// jsonObject 把 JSON 对象文本解析为 map，不是 JSON 对象时返回 nil。
+func jsonObject(v any) map[string]any {
+	s, ok := v.(string)
+	if !ok || !strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return nil
+	}
+	// 保留数字的原始文本，较长的整数不会丢失精度
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil
+	}
+	return obj
+}
+
+// flattenPaths 列出 JSON 对象中所有非对象值的键路径，按键排序。
+func flattenPaths(obj map[string]any, prefix []string) [][]string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var paths [][]string
+	for _, k := range keys {
+		path := append(slices.Clone(prefix), k)
+		if nested, ok := obj[k].(map[string]any); ok && len(nested) > 0 {
+			paths = append(paths, flattenPaths(nested, path)...)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// lookupPath 按键路径获取 JSON 对象中的值。
+func lookupPath(obj map[string]any, path []string) any {
+	var v any = obj
+	for _, k := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// tableCell 把记录中的值转换为单元格的值：数组的元素以 listSep 连接，对象写为 JSON 文本。
+func tableCell(v any, listSep string) any {
+	switch v := v.(type) {
+	case nil, string, bool, float64:
+		return v
+	case json.Number:
+		return v.String()
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = cellText(tableCell(item, listSep))
+		}
+		return strings.Join(items, listSep)
+	case map[string]any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return cellText(v)
+}
+
+// cellText 单元格的文本形式。
+func cellText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		return string(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(string(b), `"`)
+}
+
+// csvSink 输出 CSV，bom 为 true 时在开头写入 UTF-8 BOM，便于 Excel 识别编码。
+type csvSink struct {
+	comma rune
+	bom   bool
+	w     *csv.Writer
+	row   []string
+}
+
+func (s *csvSink) open(w io.Writer) error {
+	if s.bom {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+	s.w = csv.NewWriter(w)
+	s.w.Comma = s.comma
+	return nil
+}
+
+func (s *csvSink) write(cells []any) error {
+	s.row = s.row[:0]
+	for _, v := range cells {
+		s.row = append(s.row, cellText(v))
+	}
+	return s.w.Write(s.row)
+}
+
+func (s *csvSink) close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// xlsxSink 输出只有一个工作表的 Excel 工作簿。
+type xlsxSink struct {
+	sheet string
+	w     *utility.XLSXWriter
+}
+
+func (s *xlsxSink) open(w io.Writer) (err error) {
+	s.w, err = utility.NewXLSXWriter(w, s.sheet)
+	return err
+}
+
+func (s *xlsxSink) write(cells []any) error {
+	return s.w.WriteRow(cells)
+}
+
+func (s *xlsxSink) close() error {
+	return s.w.Close()
+}
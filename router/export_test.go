@@ -0,0 +1,179 @@
+package router
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+)
+
+// newExportServer 启动只有 sqlite 数据源的数据接口，posts 中写入 rows 中的记录。
+func newExportServer(t *testing.T, rows [][2]string) *httptest.Server {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE posts (id TEXT PRIMARY KEY, data TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if _, err := db.Exec("INSERT INTO posts (id, data) VALUES (?, ?)", row[0], row[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dialect, _ := repository.DialectFor("sqlite")
+	svc := service.NewApplicationService(repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0), nil, nil, nil, nil)
+	mux := http.NewServeMux()
+	LoadDataRouter(mux, "/api", "sqlite", svc)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// export 下载导出文件，返回响应和文件内容。
+func export(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", resp.StatusCode, body)
+	}
+	return resp, body
+}
+
+// xlsxRows 读取工作簿中第一个工作表的单元格，按行返回，空单元格为 ""，每行与标题行等长。
+func xlsxRows(t *testing.T, body []byte) [][]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.NewDecoder(f).Decode(&sheet); err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		var cells []string
+		for _, c := range row.Cells {
+			// 单列字母的列号，测试中的表格不超过 26 列
+			col := int(c.Ref[0] - 'A')
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			cells[col] = c.Value + c.Inline
+		}
+		rows = append(rows, cells)
+	}
+	for i := range rows {
+		for len(rows[i]) < len(rows[0]) {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	return rows
+}
+
+func TestExportColumnsFromAllRows(t *testing.T) {
+	srv := newExportServer(t, [][2]string{
+		{"1", `{"a": 1}`},
+		{"2", `{"a": 2, "b": {"c": "x"}}`},
+		{"3", "plain"},
+		{"4", `{"d": [1, 2]}`},
+	})
+	want := [][]string{
+		{"id", "data.a", "data.b.c", "data.d"},
+		{"1", "1", "", ""},
+		{"2", "2", "x", ""},
+		{"3", "plain", "", ""},
+		{"4", "", "", "1;2"},
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		_, body := export(t, srv.URL+"/api/sqlite/posts?format=csv&l=ORDER+BY+id")
+		got, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("xlsx", func(t *testing.T) {
+		resp, body := export(t, srv.URL+"/api/sqlite/posts?format=xlsx&l=ORDER+BY+id")
+		if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "posts.xlsx") {
+			t.Errorf("got Content-Disposition %q, want posts.xlsx", cd)
+		}
+		if got := xlsxRows(t, body); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+	t.Run("not flattened", func(t *testing.T) {
+		_, body := export(t, srv.URL+"/api/sqlite/posts?format=csv&flatten=false&delimiter=%3B&l=ORDER+BY+id")
+		r := csv.NewReader(bytes.NewReader(body))
+		r.Comma = ';'
+		got, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 5 || fmt.Sprint(got[0]) != "[id data]" || got[2][1] != `{"a": 2, "b": {"c": "x"}}` {
+			t.Errorf("got %q, want JSON kept as text", got)
+		}
+	})
+}
+
+func TestExportReportsDroppedColumns(t *testing.T) {
+	var rows [][2]string
+	for i := range tableLayoutRows {
+		rows = append(rows, [2]string{fmt.Sprintf("%05d", i), `{"a": 1}`})
+	}
+	// 标题行写出之后才出现的键
+	rows = append(rows, [2]string{"99999", `{"a": 2, "late": "x"}`})
+	srv := newExportServer(t, rows)
+
+	resp, body := export(t, srv.URL+"/api/sqlite/posts?format=csv&l=ORDER+BY+id")
+	got, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != tableLayoutRows+2 || fmt.Sprint(got[0]) != "[id data.a]" || fmt.Sprint(got[len(got)-1]) != "[99999 2]" {
+		t.Errorf("got %d rows starting with %q, want %d rows with id and data.a", len(got), got[0], tableLayoutRows+2)
+	}
+	if dropped := resp.Trailer.Get(DroppedColumnsTrailer); dropped != "data.late" {
+		t.Errorf("got trailer %q, want data.late", dropped)
+	}
+}
@@ -0,0 +1,130 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// importFormats 导入文件的媒体类型与扩展名对应的格式。
+var importFormats = map[string]string{
+	CSVContentType:        service.ImportCSV,
+	NDJSONContentType:     service.ImportNDJSON,
+	"application/jsonl":   service.ImportNDJSON,
+	"application/x-jsonl": service.ImportNDJSON,
+	".csv":                service.ImportCSV,
+	".ndjson":             service.ImportNDJSON,
+	".jsonl":              service.ImportNDJSON,
+}
+
+// serveImport 从 CSV 或 NDJSON 文件批量导入记录。
+//
+// 文件可以作为请求体直接上传，也可以作为 multipart/form-data 中的文件上传。
+// 格式取自查询参数 format，其次为 Content-Type，multipart 上传时再按文件扩展名判断。
+// 其他选项均为查询参数：mapping 为源字段到列的 JSON 对象，dry_run=true 时只校验，
+// mode=upsert 时主键已存在的记录按更新处理，batch 为每个事务写入的记录数，delimiter 为 CSV 字段分隔符。
+func serveImport(w http.ResponseWriter, r *http.Request, svc *service.ApplicationServiceImpl) {
+	w.Header().Set("Content-Type", "application/json")
+	st := r.PathValue("st")
+	q := r.URL.Query()
+
+	opts := service.ImportOptions{DryRun: q.Get("dry_run") == "true"}
+	switch q.Get("mode") {
+	case "", "insert":
+	case "upsert":
+		opts.Upsert = true
+	default:
+		schema.WriteProblem(w, r, "无效的导入模式", &schema.Error{Kind: schema.KindBadRequest, Detail: "无效的导入模式", Field: "mode"})
+		return
+	}
+	if q.Has("batch") {
+		batch, err := strconv.Atoi(q.Get("batch"))
+		if err != nil || batch < 1 || batch > service.ImportMaxBatchSize {
+			schema.WriteProblem(w, r, "无效的批量大小", &schema.Error{Kind: schema.KindBadRequest, Detail: "batch 应为 1 到 " + strconv.Itoa(service.ImportMaxBatchSize) + " 之间的整数", Field: "batch"})
+			return
+		}
+		opts.BatchSize = batch
+	}
+
+	src := service.ImportSource{Reader: r.Body}
+	if mapping := q.Get("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &src.Mapping); err != nil {
+			schema.WriteProblem(w, r, "无效的字段映射", &schema.Error{Kind: schema.KindBadRequest, Detail: "无效的字段映射", Field: "mapping", Err: err})
+			return
+		}
+	}
+	delimiter, err := csvDelimiter(r)
+	if err != nil {
+		schema.WriteProblem(w, r, "无效的查询参数", err)
+		return
+	}
+	src.Delimiter = delimiter
+
+	src.Format = q.Get("format")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, name, err := uploadedFile(r)
+		if err != nil {
+			schema.WriteProblem(w, r, "读取上传文件失败", err)
+			return
+		}
+		src.Reader = file
+		if src.Format == "" {
+			src.Format = importFormats[strings.ToLower(path.Ext(name))]
+		}
+	}
+	if src.Format == "" {
+		src.Format = importFormats[mediaType]
+	}
+	if src.Format == "" {
+		schema.WriteProblem(w, r, "无法识别的导入格式", &schema.Error{Kind: schema.KindBadRequest, Detail: "无法识别的导入格式，请指定 format=csv 或 format=ndjson", Field: "format"})
+		return
+	}
+
+	report, err := svc.Import(r.Context(), st, src, opts)
+	if !opts.DryRun {
+		svc.Wrote(readClient(r))
+	}
+	if err != nil {
+		utility.ZapLogger.Error("导入失败", zap.Error(err), zap.Int("total", report.Total), zap.Int("created", report.Created), zap.Int("updated", report.Updated), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		if report.Total == 0 {
+			schema.WriteProblem(w, r, "导入失败", err)
+			return
+		}
+		// 出错前已提交的批次不会回滚，随错误一并返回已有的结果
+		schema.WriteProblemWith(w, r, "导入失败", err, map[string]any{"report": report})
+		return
+	}
+	utility.ZapLogger.Info("导入完成", zap.String("table", st), zap.Bool("dry_run", report.DryRun), zap.Int("total", report.Total), zap.Int("created", report.Created), zap.Int("updated", report.Updated), zap.Int("failed", report.Failed), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+	json.NewEncoder(w).Encode(report)
+}
+
+// uploadedFile 获取 multipart 请求中的第一个文件，不把文件读入内存。
+func uploadedFile(r *http.Request) (io.Reader, string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", schema.WrapError(schema.KindBadRequest, err, "读取上传文件失败")
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", schema.NewError(schema.KindBadRequest, "请求中没有文件")
+		}
+		if err != nil {
+			return nil, "", schema.WrapError(schema.KindBadRequest, err, "读取上传文件失败")
+		}
+		if part.FileName() != "" {
+			return part, part.FileName(), nil
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+// UserIDHeader 操作人标识头，优先于请求体中的 by。
+const UserIDHeader = "X-User-ID"
+
+type transitionRequest struct {
+	To   string `json:"to"`
+	By   string `json:"by"`
+	Note string `json:"note"`
+}
+
+// parseTransition 解析状态迁移请求。
+func parseTransition(r *http.Request) (transitionRequest, error) {
+	var body transitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return body, schema.WrapError(schema.KindBadRequest, err, "无效的请求体")
+	}
+	if body.To == "" {
+		return body, &schema.Error{Kind: schema.KindBadRequest, Detail: "缺少目标状态", Field: "to"}
+	}
+	if by := r.Header.Get(UserIDHeader); by != "" {
+		body.By = by
+	}
+	return body, nil
+}
@@ -0,0 +1,15 @@
+package router
+
+import (
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"ovaphlow.com/crate/data/utility"
+)
+
+func TestMain(m *testing.M) {
+	utility.ZapLogger = zap.NewNop()
+	os.Exit(m.Run())
+}
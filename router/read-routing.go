@@ -0,0 +1,40 @@
+package router
+
+import (
+	"net"
+	"net/http"
+
+	"ovaphlow.com/crate/data/service"
+)
+
+const (
+	// ClientIDHeader 请求头，客户端标识，用于读到自己的写入；未设置时依次使用 X-User-ID 和客户端地址。
+	ClientIDHeader = "X-Client-ID"
+	// ReadPrimaryHeader 请求头，为 true 或 1 时读取主库。
+	ReadPrimaryHeader = "X-Read-Primary"
+	// ReadSourceHeader 响应头，处理读取的数据库：primary 或只读副本名称。
+	ReadSourceHeader = "X-Read-Source"
+)
+
+// readClient 获取请求的客户端标识。
+func readClient(r *http.Request) string {
+	if client := r.Header.Get(ClientIDHeader); client != "" {
+		return client
+	}
+	if user := r.Header.Get(UserIDHeader); user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// readService 选择处理读取请求的应用服务，并在响应头中注明读取的数据库。
+func readService(w http.ResponseWriter, r *http.Request, svc *service.ApplicationServiceImpl) *service.ApplicationServiceImpl {
+	primary := r.Header.Get(ReadPrimaryHeader)
+	reader, source := svc.ForRead(readClient(r), primary == "true" || primary == "1")
+	w.Header().Set(ReadSourceHeader, source)
+	return reader
+}
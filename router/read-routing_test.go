@@ -0,0 +1,112 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+)
+
+// newReadRoutingServer 启动有一个只读副本的数据接口。副本不复制主库，读到的记录数表明读取的数据库。
+func newReadRoutingServer(t *testing.T, window time.Duration) *httptest.Server {
+	t.Helper()
+	open := func(name string) *sql.DB {
+		db, err := sql.Open("sqlite", "file:"+t.Name()+name+"?mode=memory&cache=shared")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec("CREATE TABLE posts (id TEXT PRIMARY KEY, title TEXT, event_time TEXT, data_state TEXT, created_at TEXT, updated_at TEXT, status TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	primary, replica := open("primary"), open("replica")
+	dialect, _ := repository.DialectFor("sqlite")
+	repo := repository.NewSQLRepo(primary, dialect, repository.TimeStorageText, 0)
+	replicas := repository.NewReplicaSet(repo, 0)
+	replicas.Add("replica", replica, 0)
+	replicas.Check(context.Background())
+
+	svc := service.NewApplicationService(repo, nil, nil, nil, service.NewReadRouting(replicas, window))
+	mux := http.NewServeMux()
+	LoadDataRouter(mux, "/api", "sqlite", svc)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// readPosts 以客户端 client 读取 posts，返回读取的数据库和记录数。
+func readPosts(t *testing.T, url string, client string, primary bool) (string, int) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url+"/api/sqlite/posts", nil)
+	req.Header.Set(ClientIDHeader, client)
+	if primary {
+		req.Header.Set(ReadPrimaryHeader, "true")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rows []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Get(ReadSourceHeader), len(rows)
+}
+
+func TestReadYourWrites(t *testing.T) {
+	const window = 300 * time.Millisecond
+	srv := newReadRoutingServer(t, window)
+
+	if source, _ := readPosts(t, srv.URL, "a", false); source != "replica" {
+		t.Errorf("before writing: got source %s, want replica", source)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/sqlite/posts", strings.NewReader(`{"title": "x"}`))
+	req.Header.Set(ClientIDHeader, "a")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d, want 201", resp.StatusCode)
+	}
+
+	tests := []struct {
+		name    string
+		client  string
+		primary bool
+		source  string
+		rows    int
+	}{
+		{"writer", "a", false, "primary", 1},
+		{"other client", "b", false, "replica", 0},
+		{"forced primary", "b", true, "primary", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if source, n := readPosts(t, srv.URL, tt.client, tt.primary); source != tt.source || n != tt.rows {
+				t.Errorf("got %d rows from %s, want %d from %s", n, source, tt.rows, tt.source)
+			}
+		})
+	}
+
+	// 窗口结束后写入的客户端也读取只读副本
+	time.Sleep(window)
+	if source, n := readPosts(t, srv.URL, "a", false); source != "replica" || n != 0 {
+		t.Errorf("after the window: got %d rows from %s, want 0 from replica", n, source)
+	}
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// requestLocation 获取请求的渲染时区，时区名称无效时返回请求错误。
+func requestLocation(r *http.Request) (*time.Location, error) {
+	loc, err := utility.RequestLocation(r)
+	if err != nil {
+		return nil, schema.WrapError(schema.KindBadRequest, err, "无效的时区")
+	}
+	return loc, nil
+}
+
+// localizeRecords 按渲染时区转换记录中由服务写入的时间。
+func localizeRecords(loc *time.Location, cfg service.TableConfig, records ...map[string]any) {
+	timeColumns, jsonColumns := cfg.TimeColumns(), cfg.JSONColumns()
+	for _, m := range records {
+		utility.LocalizeRecord(m, loc, timeColumns, jsonColumns)
+	}
+}
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+// RequestTimeoutHeader 请求头，客户端要求的处理期限，例如 2s、500ms。
+//
+// 只能缩短数据表的语句超时；超时的请求返回 504。
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// withRequestTimeout 按 X-Request-Timeout 为请求设置期限，请求头无效时返回 400。
+func withRequestTimeout(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(RequestTimeoutHeader)
+		if value == "" {
+			h(w, r)
+			return
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			schema.WriteProblem(w, r, "无效的请求期限", &schema.Error{Kind: schema.KindBadRequest, Detail: "请求期限应为正的时长，例如 2s 或 500ms", Field: RequestTimeoutHeader, Err: err})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	}
+}
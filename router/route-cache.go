@@ -0,0 +1,55 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"ovaphlow.com/crate/data/service"
+)
+
+// CacheHeader 响应头，列表查询是否命中缓存：HIT、MISS 或 BYPASS。
+const CacheHeader = "X-Cache"
+
+func LoadCacheRouter(mux *http.ServeMux, prefix string, cache *service.ResponseCache) {
+	route := &RouteCache{cache: cache}
+
+	mux.HandleFunc("GET "+prefix+"/cache", func(w http.ResponseWriter, r *http.Request) {
+		route.stats(w, r)
+	})
+}
+
+type RouteCache struct {
+	cache *service.ResponseCache
+}
+
+// stats 获取缓存命中统计。
+func (route RouteCache) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(route.cache.Stats())
+}
+
+// freshRead 请求是否要求绕过缓存：Cache-Control 含 no-cache 或 no-store，或 Pragma: no-cache。
+func freshRead(r *http.Request) bool {
+	for _, v := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-cache", "no-store":
+				return true
+			}
+		}
+	}
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get("Pragma")), "no-cache")
+}
+
+// cacheStatus 生成 X-Cache 响应头的值。
+func cacheStatus(hit bool, fresh bool) string {
+	switch {
+	case hit:
+		return "HIT"
+	case fresh:
+		return "BYPASS"
+	default:
+		return "MISS"
+	}
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+// ReloadStatus 最近一次加载配置的结果。
+type ReloadStatus struct {
+	// Status 为 ok 或 failed，失败时继续使用之前的配置。
+	Status string `json:"status"`
+	// Trigger 触发方式：startup、signal 或 api。
+	Trigger string    `json:"trigger"`
+	At      time.Time `json:"at"`
+	// Version 正在使用的配置版本，每次成功加载加 1。
+	Version  int      `json:"version"`
+	Error    string   `json:"error,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+	// Added、Removed、Restarted 本次新增、移除和重新连接的数据源。
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Restarted []string `json:"restarted,omitempty"`
+	// RestartRequired 已修改但需要重启服务才能生效的配置项。
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// ConfigReloader 重新加载配置。
+type ConfigReloader interface {
+	// Reload 重新读取并应用配置，返回本次的结果。
+	Reload(trigger string) ReloadStatus
+	// Status 返回最近一次加载配置的结果。
+	Status() ReloadStatus
+}
+
+// LoadConfigRouter 加载配置重新加载接口。
+//
+// 参数:
+//   - mux: 路由。
+//   - prefix: 路由前缀。
+//   - reloader: 重新加载配置的实现。
+func LoadConfigRouter(mux *http.ServeMux, prefix string, reloader ConfigReloader) {
+	route := &RouteConfig{reloader: reloader}
+
+	mux.HandleFunc("GET "+prefix+"/config/reload", func(w http.ResponseWriter, r *http.Request) {
+		route.status(w, r)
+	})
+
+	mux.HandleFunc("POST "+prefix+"/config/reload", func(w http.ResponseWriter, r *http.Request) {
+		route.reload(w, r)
+	})
+}
+
+type RouteConfig struct {
+	reloader ConfigReloader
+}
+
+// status 获取最近一次加载配置的结果。
+func (route RouteConfig) status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(route.reloader.Status())
+}
+
+// reload 重新加载配置，失败时返回 422，之前的配置继续生效。
+func (route RouteConfig) reload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := route.reloader.Reload("api")
+	if status.Status != "ok" {
+		schema.WriteProblem(w, r, "重新加载配置失败", schema.NewError(schema.KindValidation, status.Error))
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,285 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/metrics"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// LoadDataRouter 加载一个数据源的数据接口，路径为 prefix/backend/{st}。
+//
+// 所有数据库共用同一组处理函数，数据库之间的差异由 repository.Dialect 处理。
+//
+// 参数:
+//   - mux: 路由。
+//   - prefix: 路径前缀。
+//   - backend: 数据源名称，例如 postgres。
+//   - service: 数据源的应用服务。
+func LoadDataRouter(mux *http.ServeMux, prefix string, backend string, service *service.ApplicationServiceImpl) {
+	route := &RouteData{service: service}
+	base := prefix + "/" + backend
+
+	// 未开放的数据表按不存在处理；指标只以已确认存在的数据表为标签，客户端任意的数据表名不产生新的时间序列
+	exposed := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			st := r.PathValue("st")
+			defer func() {
+				table := metrics.OtherTable
+				if service.KnownTable(st) {
+					table = st
+				}
+				metrics.Label(r, backend, table)
+			}()
+			if !service.TableConfig(st).Exposed {
+				schema.WriteProblem(w, r, "数据表不存在", schema.NewError(schema.KindNotFound, "数据表 "+st+" 不存在或未开放"))
+				return
+			}
+			h(w, r)
+		}
+	}
+	// 客户端可以通过 X-Request-Timeout 缩短请求的期限，变更流不受限制
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, exposed(withRequestTimeout(h)))
+	}
+
+	handle("DELETE "+base+"/{st}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route.delete(w, r)
+	})
+
+	handle("PUT "+base+"/{st}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route.put(w, r)
+	})
+
+	handle("POST "+base+"/{st}/{id}/transition", func(w http.ResponseWriter, r *http.Request) {
+		route.transition(w, r)
+	})
+
+	handle("POST "+base+"/{st}/_import", func(w http.ResponseWriter, r *http.Request) {
+		serveImport(w, r, service)
+	})
+
+	mux.HandleFunc("GET "+base+"/{st}/_changes", exposed(func(w http.ResponseWriter, r *http.Request) {
+		serveChanges(w, r, backend, service)
+	}))
+
+	handle("GET "+base+"/{st}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route.get(w, r)
+	})
+
+	handle("GET "+base+"/{st}", func(w http.ResponseWriter, r *http.Request) {
+		route.getMany(w, r)
+	})
+
+	handle("POST "+base+"/{st}", func(w http.ResponseWriter, r *http.Request) {
+		route.post(w, r)
+	})
+}
+
+// DataRouters 按数据源名称把请求分发给各数据源的数据接口。
+//
+// 重新加载配置时用 Set 整体替换，增删数据源不需要重启服务；请求开始时取得的数据接口处理完该请求。
+type DataRouters struct {
+	prefix   string
+	handlers atomic.Pointer[map[string]http.Handler]
+}
+
+// NewDataRouters 创建数据接口的分发器，并注册到 prefix 下未被其他接口占用的路径。
+//
+// 参数:
+//   - mux: 路由。
+//   - prefix: 路径前缀。
+//
+// 返回值:
+//   - *DataRouters: 分发器，Set 之前没有任何数据源。
+func NewDataRouters(mux *http.ServeMux, prefix string) *DataRouters {
+	d := &DataRouters{prefix: prefix}
+	mux.Handle(prefix+"/", d)
+	return d
+}
+
+// Set 替换所有数据源的数据接口。
+//
+// 参数:
+//   - handlers: 按数据源名称的数据接口，由 LoadDataRouter 加载到各自的 ServeMux。
+func (d *DataRouters) Set(handlers map[string]http.Handler) {
+	d.handlers.Store(&handlers)
+}
+
+func (d *DataRouters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, d.prefix+"/"), "/")
+	if handlers := d.handlers.Load(); handlers != nil {
+		if h, ok := (*handlers)[name]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// RouteData 数据接口的处理函数。
+type RouteData struct {
+	service *service.ApplicationServiceImpl
+}
+
+func (route *RouteData) delete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	st := r.PathValue("st")
+	id := r.PathValue("id")
+
+	err := route.service.Remove(r.Context(), st, id)
+	if err != nil {
+		utility.ZapLogger.Error("删除失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "删除失败", err)
+		return
+	}
+	route.service.Wrote(readClient(r))
+
+	w.WriteHeader(http.StatusOK)
+	response := schema.CreateHTTPResponseRFC9457("删除成功", http.StatusOK, r)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (route *RouteData) put(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	st := r.PathValue("st")
+	id := r.PathValue("id")
+	d := r.URL.Query().Get("d")
+
+	var data map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		utility.ZapLogger.Error("无效的请求体", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "无效的请求体", schema.WrapError(schema.KindBadRequest, err, "无效的请求体"))
+		return
+	}
+
+	deprecated := false
+	if d == "1" || d == "true" {
+		deprecated = true
+	}
+	err := route.service.Update(r.Context(), st, data, id, deprecated)
+	if err != nil {
+		utility.ZapLogger.Error("更新失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "更新失败", err)
+		return
+	}
+	route.service.Wrote(readClient(r))
+
+	w.WriteHeader(http.StatusOK)
+	response := schema.CreateHTTPResponseRFC9457("更新成功", http.StatusOK, r)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (route *RouteData) transition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	st := r.PathValue("st")
+	id := r.PathValue("id")
+
+	body, err := parseTransition(r)
+	if err != nil {
+		utility.ZapLogger.Error("无效的请求体", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "无效的请求体", err)
+		return
+	}
+
+	result, err := route.service.Transition(r.Context(), st, id, body.To, body.By, body.Note)
+	if err != nil {
+		utility.ZapLogger.Error("状态迁移失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "状态迁移失败", err)
+		return
+	}
+	route.service.Wrote(readClient(r))
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func (route *RouteData) get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	st := r.PathValue("st")
+	id := r.PathValue("id")
+	loc, err := requestLocation(r)
+	if err != nil {
+		schema.WriteProblem(w, r, "无效的时区", err)
+		return
+	}
+
+	result, err := readService(w, r, route.service).GetByID(r.Context(), st, id)
+	if err != nil {
+		utility.ZapLogger.Error("内部服务器错误", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "内部服务器错误", err)
+		return
+	}
+
+	localizeRecords(loc, route.service.TableConfig(st), result)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (route *RouteData) getMany(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	st := r.PathValue("st")
+	loc, err := requestLocation(r)
+	if err != nil {
+		schema.WriteProblem(w, r, "无效的时区", err)
+		return
+	}
+	last := r.URL.Query().Get("l")
+	filter := r.URL.Query().Get("f")
+	f, err := utility.ConvertQueryStringToDefaultFilter(filter)
+	if err != nil {
+		utility.ZapLogger.Error("无效的查询参数", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "无效的查询参数", schema.WrapError(schema.KindBadRequest, err, "无效的查询参数"))
+		return
+	}
+	if states := r.URL.Query().Get("s"); states != "" {
+		condition, err := route.service.LifecycleFilter(st, strings.Split(states, ","))
+		if err != nil {
+			schema.WriteProblem(w, r, "无效的查询参数", err)
+			return
+		}
+		f = append(f, condition)
+	}
+	columns := r.URL.Query().Get("c")
+	var c []string
+	if columns == "" {
+		c = []string{}
+	} else {
+		c = strings.Split(columns, ",")
+	}
+
+	serveMany(w, r, readService(w, r, route.service), st, c, f, last, loc)
+}
+
+func (route *RouteData) post(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	st := r.PathValue("st")
+
+	var data map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		utility.ZapLogger.Error("无效的请求体", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "无效的请求体", schema.WrapError(schema.KindBadRequest, err, "无效的请求体"))
+		return
+	}
+
+	id, err := route.service.Create(r.Context(), st, data)
+	if err != nil {
+		utility.ZapLogger.Error("创建失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "创建失败", err)
+		return
+	}
+	route.service.Wrote(readClient(r))
+
+	w.WriteHeader(http.StatusCreated)
+	response := schema.CreateHTTPResponseRFC9457(id, http.StatusCreated, r)
+	json.NewEncoder(w).Encode(response)
+}
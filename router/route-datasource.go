@@ -0,0 +1,92 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// dataSourcePingTimeout 检查数据源连接的超时时间。
+const dataSourcePingTimeout = 2 * time.Second
+
+// DataSourceStatus 数据源的状态，不包含连接字符串。
+type DataSourceStatus struct {
+	Name   string `json:"name"`
+	Engine string `json:"engine"`
+	// Status 为 up 或 down。
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	// OpenConnections 连接池中已建立的连接数，InUse、Idle 分别为使用中和空闲的连接数。
+	OpenConnections int `json:"open_connections"`
+	InUse           int `json:"in_use"`
+	Idle            int `json:"idle"`
+	MaxOpen         int `json:"max_open"`
+	// Replicas 只读副本最近一次检查的结果。
+	Replicas []repository.ReplicaStatus `json:"replicas,omitempty"`
+}
+
+// LoadDataSourceRouter 加载数据源列表接口。
+//
+// 参数:
+//   - mux: 路由。
+//   - prefix: 路由前缀。
+//   - sources: 返回已打开的数据源及按数据源名称的只读副本，重新加载配置后返回新的数据源。
+func LoadDataSourceRouter(mux *http.ServeMux, prefix string, sources func() ([]*utility.DataSource, map[string]*repository.ReplicaSet)) {
+	route := &RouteDataSource{sources: sources}
+
+	mux.HandleFunc("GET "+prefix+"/datasources", func(w http.ResponseWriter, r *http.Request) {
+		route.list(w, r)
+	})
+}
+
+type RouteDataSource struct {
+	sources func() ([]*utility.DataSource, map[string]*repository.ReplicaSet)
+}
+
+// list 列出数据源及其连接状态，各数据源并行检查连接。
+func (route RouteDataSource) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sources, replicaSets := route.sources()
+	result := make([]DataSourceStatus, len(sources))
+	var wg sync.WaitGroup
+	for i, ds := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result[i] = dataSourceStatus(r.Context(), ds)
+			if replicas, ok := replicaSets[ds.Name]; ok {
+				result[i].Replicas = replicas.Status()
+			}
+		}()
+	}
+	wg.Wait()
+	json.NewEncoder(w).Encode(result)
+}
+
+// dataSourceStatus 检查数据源的连接并读取连接池统计。
+func dataSourceStatus(ctx context.Context, ds *utility.DataSource) DataSourceStatus {
+	ctx, cancel := context.WithTimeout(ctx, dataSourcePingTimeout)
+	defer cancel()
+
+	status := DataSourceStatus{Name: ds.Name, Engine: ds.Engine, Status: "up"}
+	start := time.Now()
+	if err := ds.DB.PingContext(ctx); err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+	status.LatencyMS = time.Since(start).Milliseconds()
+
+	stats := ds.DB.Stats()
+	status.OpenConnections = stats.OpenConnections
+	status.InUse = stats.InUse
+	status.Idle = stats.Idle
+	status.MaxOpen = stats.MaxOpenConnections
+	return status
+}
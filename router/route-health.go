@@ -0,0 +1,293 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// 检查结果的状态。degraded 表示仍可处理请求，但连接池饱和、元数据刷新失败或只读副本不可用。
+const (
+	healthUp       = "up"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// healthConfig 当前的就绪检查设置。
+var healthConfig atomic.Pointer[utility.HealthConfig]
+
+// SetHealthConfig 设置就绪检查的超时和连接池饱和比例，重新加载配置时立即生效。
+//
+// 参数:
+//   - cfg: 已校验的就绪检查配置。
+func SetHealthConfig(cfg utility.HealthConfig) {
+	healthConfig.Store(&cfg)
+}
+
+// HealthTarget 就绪检查的一个数据源。
+type HealthTarget struct {
+	DataSource *utility.DataSource
+	// Optional 可选的数据源不可用时服务仍然就绪。
+	Optional bool
+	Schema   *repository.SchemaCache
+	Replicas *repository.ReplicaSet
+	// Tables 服务依赖、须预先创建的数据表，例如发件箱表及其租约表。
+	Tables []string
+}
+
+// HealthComponent 一个依赖的检查结果。
+type HealthComponent struct {
+	Name string `json:"name"`
+	// Kind 为 datasource 或 webhook。
+	Kind     string `json:"kind"`
+	Engine   string `json:"engine,omitempty"`
+	Optional bool   `json:"optional"`
+	// Status 为 up、degraded 或 down。
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+
+	Pool        *PoolHealth                  `json:"pool,omitempty"`
+	Migrations  *MigrationHealth             `json:"migrations,omitempty"`
+	SchemaCache *repository.SchemaCacheState `json:"schema_cache,omitempty"`
+	Replicas    []repository.ReplicaStatus   `json:"replicas,omitempty"`
+}
+
+// PoolHealth 连接池统计，WaitCount、WaitMS 为启动以来等待空闲连接的次数和总时长。
+type PoolHealth struct {
+	OpenConnections int   `json:"open_connections"`
+	InUse           int   `json:"in_use"`
+	Idle            int   `json:"idle"`
+	MaxOpen         int   `json:"max_open"`
+	WaitCount       int64 `json:"wait_count"`
+	WaitMS          int64 `json:"wait_ms"`
+	Saturated       bool  `json:"saturated"`
+}
+
+// MigrationHealth 服务依赖的数据表是否已创建。
+type MigrationHealth struct {
+	// Status 为 applied 或 pending，无法读取元数据时为 unknown。
+	Status   string   `json:"status"`
+	Required []string `json:"required"`
+	Missing  []string `json:"missing,omitempty"`
+}
+
+// HealthReport 就绪检查的结果。
+type HealthReport struct {
+	// Status 为 up、degraded 或 down，必需的依赖为 down 时整体为 down。
+	Status     string            `json:"status"`
+	CheckedAt  time.Time         `json:"checked_at"`
+	Components []HealthComponent `json:"components"`
+}
+
+// degrade 把检查结果降为 degraded（已为 down 时不变），并追加原因。
+func (c *HealthComponent) degrade(reason string) {
+	if c.Status == healthUp {
+		c.Status = healthDegraded
+	}
+	c.fail(c.Status, reason)
+}
+
+// fail 设置检查结果的状态并追加原因。
+func (c *HealthComponent) fail(status string, reason string) {
+	c.Status = status
+	if c.Error != "" {
+		reason = c.Error + "; " + reason
+	}
+	c.Error = reason
+}
+
+// LoadHealthRouter 加载存活检查与就绪检查接口。
+//
+// /health 与 /health/live 只表示进程仍在运行；/health/ready 检查每个数据源，
+// 必需的依赖不可用时返回 503，供负载均衡和编排系统摘除实例。
+//
+// 参数:
+//   - mux: 路由。
+//   - targets: 返回正在使用的数据源，重新加载配置后返回新的数据源。
+//   - webhook: Webhook 数据库，未启用时为 nil。
+func LoadHealthRouter(mux *http.ServeMux, targets func() []HealthTarget, webhook *sql.DB) {
+	route := &RouteHealth{targets: targets, webhook: webhook}
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		route.live(w, r)
+	})
+
+	mux.HandleFunc("/health/live", func(w http.ResponseWriter, r *http.Request) {
+		route.live(w, r)
+	})
+
+	mux.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		route.ready(w, r)
+	})
+}
+
+type RouteHealth struct {
+	targets func() []HealthTarget
+	webhook *sql.DB
+}
+
+// live 存活检查，不访问数据库：数据库不可用时重启服务没有帮助。
+func (route RouteHealth) live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok","service":"crate-service"}`))
+}
+
+// ready 就绪检查，各依赖并行检查，每个依赖的检查时长不超过 HEALTH_TIMEOUT。
+func (route RouteHealth) ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	cfg := healthConfig.Load()
+	targets := route.targets()
+	n := len(targets)
+	if route.webhook != nil {
+		n++
+	}
+	components := make([]HealthComponent, n)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			components[i] = checkDataSource(r.Context(), target, cfg)
+		}()
+	}
+	if route.webhook != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			components[n-1] = checkWebhook(r.Context(), route.webhook, cfg)
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: healthUp, CheckedAt: time.Now().UTC(), Components: components}
+	for _, c := range components {
+		switch {
+		case c.Status == healthDown && !c.Optional:
+			report.Status = healthDown
+		case c.Status != healthUp && report.Status == healthUp:
+			report.Status = healthDegraded
+		}
+	}
+	if report.Status == healthDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// checkDataSource 检查数据源的连接、连接池、依赖的数据表、元数据缓存和只读副本。
+func checkDataSource(ctx context.Context, target HealthTarget, cfg *utility.HealthConfig) HealthComponent {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+
+	ds := target.DataSource
+	c := HealthComponent{Name: ds.Name, Kind: "datasource", Engine: ds.Engine, Optional: target.Optional, Status: healthUp}
+	if ds.DB == nil {
+		c.fail(healthDown, "数据源未连接")
+		return c
+	}
+
+	// 连接池统计在检查连接之前读取：连接池已满时检查连接本身也要等待空闲连接
+	c.Pool = poolHealth(ds.DB.Stats(), cfg.PoolSaturation)
+	start := time.Now()
+	err := ds.DB.PingContext(ctx)
+	c.LatencyMS = time.Since(start).Milliseconds()
+	switch {
+	case err != nil && c.Pool.Saturated && errors.Is(err, context.DeadlineExceeded):
+		// 连接池已满说明数据库仍在处理请求，不视为不可用
+		c.degrade("连接池已满，等待空闲连接超时")
+	case repository.IsBusy(err):
+		// SQLite 的写锁被其他连接持有，数据库仍可访问
+		c.degrade("数据库繁忙: " + err.Error())
+		err = nil
+	case err != nil:
+		c.fail(healthDown, err.Error())
+	case c.Pool.Saturated:
+		c.degrade("连接池接近上限")
+	}
+
+	if target.Schema != nil {
+		state := target.Schema.State()
+		c.SchemaCache = &state
+		if state.Error != "" {
+			c.degrade("刷新数据表元数据失败")
+		}
+		if err == nil && len(target.Tables) > 0 {
+			c.Migrations = checkMigrations(ctx, target.Schema, target.Tables)
+			switch c.Migrations.Status {
+			case "pending":
+				c.fail(healthDown, "缺少数据表: "+strings.Join(c.Migrations.Missing, ", "))
+			case "unknown":
+				c.degrade("无法确认依赖的数据表")
+			}
+		}
+	}
+
+	if target.Replicas != nil {
+		c.Replicas = target.Replicas.Status()
+		for _, replica := range c.Replicas {
+			if replica.Status != "up" {
+				// 没有可用的副本时读取主库，因此只降级
+				c.degrade("只读副本 " + replica.Name + " " + replica.Status)
+			}
+		}
+	}
+	return c
+}
+
+// checkMigrations 确认服务依赖的数据表已创建。
+func checkMigrations(ctx context.Context, cache *repository.SchemaCache, tables []string) *MigrationHealth {
+	result := &MigrationHealth{Status: "applied", Required: tables}
+	for _, st := range tables {
+		exists, err := cache.Exists(ctx, st)
+		if err != nil {
+			result.Status = "unknown"
+			return result
+		}
+		if !exists {
+			result.Status = "pending"
+			result.Missing = append(result.Missing, st)
+		}
+	}
+	return result
+}
+
+// poolHealth 转换连接池统计，使用中的连接达到上限的 saturation 比例时视为饱和；上限为 0 表示不限制，不会饱和。
+func poolHealth(stats sql.DBStats, saturation float64) *PoolHealth {
+	return &PoolHealth{
+		OpenConnections: stats.OpenConnections,
+		InUse:           stats.InUse,
+		Idle:            stats.Idle,
+		MaxOpen:         stats.MaxOpenConnections,
+		WaitCount:       stats.WaitCount,
+		WaitMS:          stats.WaitDuration.Milliseconds(),
+		Saturated:       stats.MaxOpenConnections > 0 && float64(stats.InUse) >= saturation*float64(stats.MaxOpenConnections),
+	}
+}
+
+// checkWebhook 检查保存 Webhook 订阅与投递队列的数据库。
+func checkWebhook(ctx context.Context, db *sql.DB, cfg *utility.HealthConfig) HealthComponent {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+
+	c := HealthComponent{Name: "webhook", Kind: "webhook", Engine: "sqlite", Status: healthUp}
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		c.fail(healthDown, err.Error())
+	}
+	c.LatencyMS = time.Since(start).Milliseconds()
+	return c
+}
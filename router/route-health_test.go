@@ -0,0 +1,104 @@
+package router
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// openHealthDB 打开内存中的 SQLite 数据库并执行 stmts。
+func openHealthDB(t *testing.T, name string, stmts ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+t.Name()+name+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// ready 请求就绪检查，返回状态码和检查结果。
+func ready(t *testing.T, targets ...HealthTarget) (int, HealthReport) {
+	t.Helper()
+	mux := http.NewServeMux()
+	LoadHealthRouter(mux, func() []HealthTarget { return targets }, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	var report HealthReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	SetHealthConfig(utility.HealthConfig{Timeout: utility.Duration(time.Second), PoolSaturation: 0.9})
+
+	migrated := openHealthDB(t, "migrated", "CREATE TABLE crate_outbox (id INTEGER PRIMARY KEY)")
+	pending := openHealthDB(t, "pending")
+	closed := openHealthDB(t, "closed")
+	closed.Close()
+	target := func(name string, db *sql.DB, optional bool) HealthTarget {
+		return HealthTarget{
+			DataSource: &utility.DataSource{Name: name, Engine: "sqlite", DB: db},
+			Optional:   optional,
+			Schema:     repository.NewSQLiteRepo(db, repository.TimeStorageText, 0).Schema(),
+			Tables:     []string{"crate_outbox"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		targets []HealthTarget
+		code    int
+		status  string
+	}{
+		{"up", []HealthTarget{target("main", migrated, false)}, http.StatusOK, healthUp},
+		{"optional down", []HealthTarget{target("main", migrated, false), target("reports", closed, true)}, http.StatusOK, healthDegraded},
+		{"required down", []HealthTarget{target("main", closed, false)}, http.StatusServiceUnavailable, healthDown},
+		{"missing table", []HealthTarget{target("main", pending, false)}, http.StatusServiceUnavailable, healthDown},
+		{"not connected", []HealthTarget{{DataSource: &utility.DataSource{Name: "main", Engine: "sqlite"}}}, http.StatusServiceUnavailable, healthDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, report := ready(t, tt.targets...)
+			if code != tt.code || report.Status != tt.status {
+				t.Errorf("got %d %s (%+v), want %d %s", code, report.Status, report.Components, tt.code, tt.status)
+			}
+		})
+	}
+
+	_, report := ready(t, target("main", pending, false))
+	if m := report.Components[0].Migrations; m == nil || m.Status != "pending" || len(m.Missing) != 1 || m.Missing[0] != "crate_outbox" {
+		t.Errorf("got migrations %+v, want crate_outbox missing", m)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	// 存活检查不访问数据库，数据源不可用时仍返回 200
+	mux := http.NewServeMux()
+	LoadHealthRouter(mux, func() []HealthTarget {
+		t.Error("liveness checked the datasources")
+		return nil
+	}, nil)
+	for _, path := range []string{"/health", "/health/live"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"ovaphlow.com/crate/data/metrics"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// LoadMetricsRouter 加载 Prometheus 指标接口 /metrics。
+//
+// 连接池和缓存的指标在抓取时读取，重新加载配置后反映新的数据源。
+//
+// 参数:
+//   - mux: 路由。
+//   - sources: 返回已打开的数据源及按数据源名称的只读副本。
+//   - statements: 返回以数据库后端名称为键的预编译语句缓存。
+//   - responses: 列表查询缓存。
+func LoadMetricsRouter(mux *http.ServeMux, sources func() ([]*utility.DataSource, map[string]*repository.ReplicaSet), statements func() map[string]*repository.StatementCache, responses *service.ResponseCache) {
+	metrics.Registry.MustRegister(&poolCollector{sources: sources}, &cacheCollector{statements: statements, responses: responses})
+
+	mux.Handle("GET /metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{
+		ErrorLog: zap.NewStdLog(utility.ZapLogger),
+	}))
+}
+
+var (
+	poolOpenDesc         = prometheus.NewDesc("crate_db_pool_open_connections", "Established connections of the pool.", []string{"datasource", "pool"}, nil)
+	poolInUseDesc        = prometheus.NewDesc("crate_db_pool_in_use_connections", "Connections currently in use.", []string{"datasource", "pool"}, nil)
+	poolIdleDesc         = prometheus.NewDesc("crate_db_pool_idle_connections", "Idle connections.", []string{"datasource", "pool"}, nil)
+	poolMaxOpenDesc      = prometheus.NewDesc("crate_db_pool_max_open_connections", "Maximum number of open connections, 0 is unlimited.", []string{"datasource", "pool"}, nil)
+	poolWaitCountDesc    = prometheus.NewDesc("crate_db_pool_wait_total", "Connections waited for.", []string{"datasource", "pool"}, nil)
+	poolWaitDurationDesc = prometheus.NewDesc("crate_db_pool_wait_duration_seconds_total", "Time spent waiting for a connection.", []string{"datasource", "pool"}, nil)
+	poolClosedDesc       = prometheus.NewDesc("crate_db_pool_closed_total", "Connections closed by the pool, by reason.", []string{"datasource", "pool", "reason"}, nil)
+
+	statementLookupsDesc = prometheus.NewDesc("crate_statement_cache_lookups_total", "Prepared statement cache lookups by result.", []string{"datasource", "result"}, nil)
+	statementRatioDesc   = prometheus.NewDesc("crate_statement_cache_hit_ratio", "Share of prepared statement cache lookups that hit since start.", []string{"datasource"}, nil)
+	responseLookupsDesc  = prometheus.NewDesc("crate_response_cache_lookups_total", "List response cache lookups by result.", []string{"result"}, nil)
+	responseRatioDesc    = prometheus.NewDesc("crate_response_cache_hit_ratio", "Share of list response cache lookups that hit since start, bypasses excluded.", nil, nil)
+	responseBytesDesc    = prometheus.NewDesc("crate_response_cache_bytes", "Estimated size of the cached list responses.", nil, nil)
+	responseEntriesDesc  = prometheus.NewDesc("crate_response_cache_entries", "Cached list responses.", nil, nil)
+)
+
+// poolCollector 数据源主库和只读副本的连接池统计（sql.DBStats）。
+type poolCollector struct {
+	sources func() ([]*utility.DataSource, map[string]*repository.ReplicaSet)
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{poolOpenDesc, poolInUseDesc, poolIdleDesc, poolMaxOpenDesc, poolWaitCountDesc, poolWaitDurationDesc, poolClosedDesc} {
+		ch <- desc
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	sources, _ := c.sources()
+	for _, ds := range sources {
+		collectPool(ch, ds.Name, "primary", ds)
+		for _, replica := range ds.Replicas {
+			collectPool(ch, ds.Name, replica.Name, replica)
+		}
+	}
+}
+
+// collectPool 输出一个连接池的统计，尚未连接的连接池不输出。
+func collectPool(ch chan<- prometheus.Metric, name string, pool string, ds *utility.DataSource) {
+	if ds.DB == nil {
+		return
+	}
+	stats := ds.DB.Stats()
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, name, pool)
+	}
+	gauge(poolOpenDesc, float64(stats.OpenConnections))
+	gauge(poolInUseDesc, float64(stats.InUse))
+	gauge(poolIdleDesc, float64(stats.Idle))
+	gauge(poolMaxOpenDesc, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(poolWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount), name, pool)
+	ch <- prometheus.MustNewConstMetric(poolWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds(), name, pool)
+	ch <- prometheus.MustNewConstMetric(poolClosedDesc, prometheus.CounterValue, float64(stats.MaxIdleClosed), name, pool, "max_idle")
+	ch <- prometheus.MustNewConstMetric(poolClosedDesc, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), name, pool, "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(poolClosedDesc, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), name, pool, "max_lifetime")
+}
+
+// cacheCollector 预编译语句缓存和列表查询缓存的命中统计。
+type cacheCollector struct {
+	statements func() map[string]*repository.StatementCache
+	responses  *service.ResponseCache
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{statementLookupsDesc, statementRatioDesc, responseLookupsDesc, responseRatioDesc, responseBytesDesc, responseEntriesDesc} {
+		ch <- desc
+	}
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, cache := range c.statements() {
+		stats := cache.Stats()
+		ch <- prometheus.MustNewConstMetric(statementLookupsDesc, prometheus.CounterValue, float64(stats.Hits), name, "hit")
+		ch <- prometheus.MustNewConstMetric(statementLookupsDesc, prometheus.CounterValue, float64(stats.Misses), name, "miss")
+		ch <- prometheus.MustNewConstMetric(statementRatioDesc, prometheus.GaugeValue, hitRatio(stats.Hits, stats.Misses), name)
+	}
+
+	stats := c.responses.Stats()
+	ch <- prometheus.MustNewConstMetric(responseLookupsDesc, prometheus.CounterValue, float64(stats.Hits), "hit")
+	ch <- prometheus.MustNewConstMetric(responseLookupsDesc, prometheus.CounterValue, float64(stats.Misses), "miss")
+	ch <- prometheus.MustNewConstMetric(responseLookupsDesc, prometheus.CounterValue, float64(stats.Bypasses), "bypass")
+	ch <- prometheus.MustNewConstMetric(responseRatioDesc, prometheus.GaugeValue, hitRatio(stats.Hits, stats.Misses))
+	ch <- prometheus.MustNewConstMetric(responseBytesDesc, prometheus.GaugeValue, float64(stats.Bytes))
+	ch <- prometheus.MustNewConstMetric(responseEntriesDesc, prometheus.GaugeValue, float64(stats.Entries))
+}
+
+// hitRatio 计算命中率，没有查找时为 0。
+func hitRatio(hits uint64, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
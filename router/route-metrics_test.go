@@ -0,0 +1,70 @@
+package router
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/metrics"
+	"ovaphlow.com/crate/data/middleware"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+)
+
+// requests 读取请求数指标。
+func requests(t *testing.T, route, backend, table, status string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.HTTPRequests.WithLabelValues(route, backend, table, status).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestRequestMetricLabels(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE posts (id TEXT PRIMARY KEY, title TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	dialect, _ := repository.DialectFor("sqlite")
+	svc := service.NewApplicationService(repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0), nil, nil, nil, nil)
+	mux := http.NewServeMux()
+	LoadDataRouter(mux, "/api", "sqlite", svc)
+	handler := middleware.MetricsMiddleware(middleware.RouteMiddleware(mux))
+
+	tests := []struct {
+		name   string
+		path   string
+		route  string
+		table  string
+		status string
+	}{
+		{"known table", "/api/sqlite/posts", "GET /api/{backend}/{st}", "posts", "200"},
+		// 不存在的数据表归入同一个标签值
+		{"unknown table", "/api/sqlite/missing_1", "GET /api/{backend}/{st}", metrics.OtherTable, "400"},
+		{"another unknown table", "/api/sqlite/missing_2", "GET /api/{backend}/{st}", metrics.OtherTable, "400"},
+		{"unmatched", "/nothing/here", metrics.UnmatchedRoute, "", "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := "sqlite"
+			if tt.route == metrics.UnmatchedRoute {
+				backend = ""
+			}
+			before := requests(t, tt.route, backend, tt.table, tt.status)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := requests(t, tt.route, backend, tt.table, tt.status); got != before+1 {
+				t.Errorf("got status %d and %v requests labelled %s %s %s, want %v", w.Code, got, tt.route, tt.table, tt.status, before+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// LoadSchemaRouter 加载数据表元数据缓存的管理接口。
+//
+// 参数:
+//   - mux: 路由。
+//   - prefix: 路由前缀。
+//   - caches: 返回以数据库后端名称为键的元数据缓存，重新加载配置后返回新的数据源。
+func LoadSchemaRouter(mux *http.ServeMux, prefix string, caches func() map[string]*repository.SchemaCache) {
+	route := &RouteSchema{caches: caches}
+
+	mux.HandleFunc("GET "+prefix+"/schema/{b}", func(w http.ResponseWriter, r *http.Request) {
+		route.list(w, r)
+	})
+
+	mux.HandleFunc("GET "+prefix+"/schema/{b}/{st}", func(w http.ResponseWriter, r *http.Request) {
+		route.get(w, r)
+	})
+
+	mux.HandleFunc("DELETE "+prefix+"/schema/{b}", func(w http.ResponseWriter, r *http.Request) {
+		route.invalidate(w, r)
+	})
+
+	mux.HandleFunc("DELETE "+prefix+"/schema/{b}/{st}", func(w http.ResponseWriter, r *http.Request) {
+		route.invalidate(w, r)
+	})
+}
+
+type RouteSchema struct {
+	caches func() map[string]*repository.SchemaCache
+}
+
+// cache 获取请求的数据库后端的元数据缓存，后端未启用时返回 nil 并写入错误响应。
+func (route RouteSchema) cache(w http.ResponseWriter, r *http.Request) *repository.SchemaCache {
+	cache, ok := route.caches()[r.PathValue("b")]
+	if !ok {
+		schema.WriteProblem(w, r, "数据库后端未启用", schema.NewError(schema.KindNotFound, "数据库后端未启用"))
+		return nil
+	}
+	return cache
+}
+
+// list 获取已缓存的数据表元数据。
+func (route RouteSchema) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cache := route.cache(w, r)
+	if cache == nil {
+		return
+	}
+	json.NewEncoder(w).Encode(cache.Tables())
+}
+
+// get 获取数据表元数据，未缓存时从数据库读取。
+func (route RouteSchema) get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cache := route.cache(w, r)
+	if cache == nil {
+		return
+	}
+	meta, err := cache.Lookup(r.PathValue("st"))
+	if err != nil {
+		utility.ZapLogger.Error("读取数据表元数据失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "读取数据表元数据失败", err)
+		return
+	}
+	if len(meta.Columns) == 0 {
+		schema.WriteProblem(w, r, "数据表不存在", schema.NewError(schema.KindNotFound, "数据表不存在"))
+		return
+	}
+	json.NewEncoder(w).Encode(meta)
+}
+
+// invalidate 删除数据表的元数据缓存，路径中没有数据表时删除该后端的所有缓存，下次访问时重新读取。
+func (route RouteSchema) invalidate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cache := route.cache(w, r)
+	if cache == nil {
+		return
+	}
+	if st := r.PathValue("st"); st != "" {
+		cache.Invalidate(st)
+	} else {
+		cache.InvalidateAll()
+	}
+	utility.ZapLogger.Info("数据表元数据缓存已失效", zap.String("backend", r.PathValue("b")), zap.String("table", r.PathValue("st")))
+
+	response := schema.CreateHTTPResponseRFC9457("元数据缓存已失效", http.StatusOK, r)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ovaphlow.com/crate/data/repository"
+)
+
+// LoadStatementRouter 加载预编译语句缓存的统计接口。
+//
+// 参数:
+//   - mux: 路由。
+//   - prefix: 路由前缀。
+//   - caches: 返回以数据库后端名称为键的预编译语句缓存，重新加载配置后返回新的数据源。
+func LoadStatementRouter(mux *http.ServeMux, prefix string, caches func() map[string]*repository.StatementCache) {
+	route := &RouteStatement{caches: caches}
+
+	mux.HandleFunc("GET "+prefix+"/statements", func(w http.ResponseWriter, r *http.Request) {
+		route.stats(w, r)
+	})
+}
+
+type RouteStatement struct {
+	caches func() map[string]*repository.StatementCache
+}
+
+// stats 获取各后端预编译语句缓存的命中统计。
+func (route RouteStatement) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result := map[string]repository.StatementStats{}
+	for backend, cache := range route.caches() {
+		result[backend] = cache.Stats()
+	}
+	json.NewEncoder(w).Encode(result)
+}
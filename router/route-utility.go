@@ -0,0 +1,38 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+func LoadUtilityRouter(mux *http.ServeMux, prefix string) {
+	route := &RouteUtility{}
+
+	mux.HandleFunc("GET "+prefix+"/id/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route.decodeID(w, r)
+	})
+}
+
+type RouteUtility struct{}
+
+// decodeID 解析主键中嵌入的时间戳，查询参数 s 可指定主键生成策略。
+func (route *RouteUtility) decodeID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	strategy, t, err := utility.DecodeIDTime(id, r.URL.Query().Get("s"))
+	if err != nil {
+		schema.WriteProblem(w, r, "无法解析主键", schema.WrapError(schema.KindBadRequest, err, "无法解析主键"))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":        id,
+		"strategy":  strategy,
+		"timestamp": t.Format(utility.TimeLayout),
+		"unix_ms":   t.UnixMilli(),
+	})
+}
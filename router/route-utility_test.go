@@ -0,0 +1,57 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+func TestDecodeID(t *testing.T) {
+	mux := http.NewServeMux()
+	LoadUtilityRouter(mux, "/crate-api-data")
+
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		strategy string
+		unixMS   int64
+	}{
+		{"ulid", "/crate-api-data/id/01ARZ3NDEKTSV4RRFFQ69G5FAV", http.StatusOK, "ulid", 1469922850259},
+		{"ksuid", "/crate-api-data/id/0ujtsYcgvSTl8PAuAdqWYSMnLOv", http.StatusOK, "ksuid", 1507608047000},
+		{"uuidv7", "/crate-api-data/id/017f22e2-79b0-7cc3-98c4-dc0c0c07398f", http.StatusOK, "uuidv7", 1645557742000},
+		{"snowflake with hint", "/crate-api-data/id/4194304?s=snowflake", http.StatusOK, "snowflake", 1704067200001},
+		{"malformed", "/crate-api-data/id/not-an-id", http.StatusBadRequest, "", 0},
+		{"bad character", "/crate-api-data/id/01ARZ3NDEKTSV4RRFFQ69G5FAU", http.StatusBadRequest, "", 0},
+		{"uuidv4", "/crate-api-data/id/4f8e1b0a-3c2d-4e5f-8a9b-0c1d2e3f4a5b", http.StatusBadRequest, "", 0},
+		{"wrong hint", "/crate-api-data/id/01ARZ3NDEKTSV4RRFFQ69G5FAV?s=snowflake", http.StatusBadRequest, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != http.StatusOK {
+				if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+					t.Errorf("got content type %q", ct)
+				}
+				if body["type"] != schema.ProblemTypeBase+string(schema.KindBadRequest) {
+					t.Errorf("got type %v", body["type"])
+				}
+				return
+			}
+			if body["strategy"] != tt.strategy || int64(body["unix_ms"].(float64)) != tt.unixMS {
+				t.Errorf("got %v, want strategy %s unix_ms %d", body, tt.strategy, tt.unixMS)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+func LoadWebhookRouter(mux *http.ServeMux, prefix string, service *service.WebhookService) {
+	route := &RouteWebhook{service: service}
+
+	mux.HandleFunc("GET "+prefix+"/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		route.list(w, r)
+	})
+
+	mux.HandleFunc("POST "+prefix+"/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		route.post(w, r)
+	})
+
+	mux.HandleFunc("GET "+prefix+"/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route.get(w, r)
+	})
+
+	mux.HandleFunc("DELETE "+prefix+"/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route.delete(w, r)
+	})
+
+	mux.HandleFunc("POST "+prefix+"/webhooks/{id}/pause", func(w http.ResponseWriter, r *http.Request) {
+		route.setPaused(w, r, true)
+	})
+
+	mux.HandleFunc("POST "+prefix+"/webhooks/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		route.setPaused(w, r, false)
+	})
+
+	mux.HandleFunc("POST "+prefix+"/webhooks/{id}/test", func(w http.ResponseWriter, r *http.Request) {
+		route.test(w, r)
+	})
+
+	mux.HandleFunc("GET "+prefix+"/webhooks/{id}/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		route.deliveries(w, r)
+	})
+
+	mux.HandleFunc("POST "+prefix+"/webhooks/{id}/replay", func(w http.ResponseWriter, r *http.Request) {
+		route.replay(w, r)
+	})
+}
+
+type RouteWebhook struct {
+	service *service.WebhookService
+}
+
+func (route RouteWebhook) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(route.service.List())
+}
+
+func (route RouteWebhook) post(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var data repository.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		utility.ZapLogger.Error("无效的请求体", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "无效的请求体", schema.WrapError(schema.KindBadRequest, err, "无效的请求体"))
+		return
+	}
+
+	// 密钥只在创建时返回一次
+	result, err := route.service.Create(data)
+	if err != nil {
+		utility.ZapLogger.Error("创建失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "创建失败", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (route RouteWebhook) get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, err := route.service.Get(r.PathValue("id"))
+	if err != nil {
+		schema.WriteProblem(w, r, "获取失败", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func (route RouteWebhook) delete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := route.service.Remove(r.PathValue("id")); err != nil {
+		utility.ZapLogger.Error("删除失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "删除失败", err)
+		return
+	}
+
+	response := schema.CreateHTTPResponseRFC9457("删除成功", http.StatusOK, r)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (route RouteWebhook) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := route.service.SetPaused(r.PathValue("id"), paused); err != nil {
+		utility.ZapLogger.Error("更新失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "更新失败", err)
+		return
+	}
+
+	response := schema.CreateHTTPResponseRFC9457("更新成功", http.StatusOK, r)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (route RouteWebhook) test(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, err := route.service.Test(r.Context(), r.PathValue("id"))
+	if err != nil {
+		schema.WriteProblem(w, r, "测试失败", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// deliveries 获取投递记录，查询参数 status=dead 即死信列表，limit 默认 100。
+func (route RouteWebhook) deliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			schema.WriteProblem(w, r, "无效的查询参数", &schema.Error{Kind: schema.KindBadRequest, Detail: "无效的查询参数", Field: "limit", Err: err})
+			return
+		}
+		limit = min(n, 1000)
+	}
+
+	result, err := route.service.Deliveries(r.PathValue("id"), r.URL.Query().Get("status"), limit)
+	if err != nil {
+		schema.WriteProblem(w, r, "获取失败", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// replay 重放死信，请求体 {"delivery_ids": [...]} 可选，省略时重放所有死信。
+func (route RouteWebhook) replay(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		DeliveryIDs []string `json:"delivery_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		schema.WriteProblem(w, r, "无效的请求体", schema.WrapError(schema.KindBadRequest, err, "无效的请求体"))
+		return
+	}
+
+	n, err := route.service.Replay(r.PathValue("id"), body.DeliveryIDs)
+	if err != nil {
+		utility.ZapLogger.Error("重放失败", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		schema.WriteProblem(w, r, "重放失败", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"requeued": n})
+}
@@ -0,0 +1,275 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+const (
+	// NDJSONContentType 每行一条记录的 JSON 格式。
+	NDJSONContentType = "application/x-ndjson"
+
+	// streamFlushRows 列表响应每写入多少条记录刷新一次。
+	streamFlushRows = 100
+	// streamFlushInterval 列表响应两次刷新的最长间隔，慢查询时客户端也能尽快收到已读取的记录。
+	streamFlushInterval = 250 * time.Millisecond
+	// streamBufferSize 刷新前缓冲的字节数上限。
+	streamBufferSize = 32 << 10
+)
+
+// StreamWriteTimeout 流式响应每次写入的超时，启动时设置为服务器的 WriteTimeout。
+//
+// 列表响应、导出和变更流的总时长不受服务器 WriteTimeout 的限制，每次写入前重新计时；0 表示不限制。
+var StreamWriteTimeout time.Duration
+
+// extendWriteDeadline 按 StreamWriteTimeout 重新设置响应的写入超时，不支持设置超时的 ResponseWriter 忽略。
+func extendWriteDeadline(rc *http.ResponseController) {
+	var deadline time.Time
+	if StreamWriteTimeout > 0 {
+		deadline = time.Now().Add(StreamWriteTimeout)
+	}
+	rc.SetWriteDeadline(deadline)
+}
+
+// rowEncoder 把记录逐条写为一种响应格式。
+type rowEncoder interface {
+	// header 设置响应头，在写出第一个字节前调用。
+	header(h http.Header)
+	begin(w io.Writer) error
+	// row 写出一条记录，columns 为按查询顺序排列的列名，来自缓存的结果没有列名。
+	row(w io.Writer, columns []string, m map[string]any) error
+	end(w io.Writer) error
+}
+
+// jsonArrayEncoder 输出 JSON 数组，与原有列表响应相同。
+type jsonArrayEncoder struct {
+	started bool
+}
+
+func (e *jsonArrayEncoder) header(h http.Header) { h.Set("Content-Type", "application/json") }
+
+func (e *jsonArrayEncoder) begin(w io.Writer) error {
+	_, err := io.WriteString(w, "[")
+	return err
+}
+
+func (e *jsonArrayEncoder) row(w io.Writer, columns []string, m map[string]any) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if e.started {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	e.started = true
+	_, err = w.Write(b)
+	return err
+}
+
+func (e *jsonArrayEncoder) end(w io.Writer) error {
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// ndjsonEncoder 输出 NDJSON，每行一条记录，客户端无需读完响应即可逐条解析。
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) header(h http.Header) { h.Set("Content-Type", NDJSONContentType) }
+
+func (ndjsonEncoder) begin(w io.Writer) error { return nil }
+
+func (ndjsonEncoder) row(w io.Writer, columns []string, m map[string]any) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+func (ndjsonEncoder) end(w io.Writer) error { return nil }
+
+// responseFormats 查询参数 format 的取值与对应的媒体类型。
+var responseFormats = map[string]string{
+	"json":   "application/json",
+	"ndjson": NDJSONContentType,
+	"csv":    CSVContentType,
+	"xlsx":   utility.XLSXContentType,
+}
+
+// negotiateFormat 按查询参数 format 或 Accept 头选择列表响应的格式，默认为 json。
+//
+// Accept 头按 q 值从高到低选择第一个支持的媒体类型，都不支持时同样返回 json。
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := responseFormats[format]; !ok {
+			return "", &schema.Error{Kind: schema.KindBadRequest, Detail: "不支持的响应格式", Field: "format"}
+		}
+		return format, nil
+	}
+	best, bestQ := "json", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		media, params, _ := strings.Cut(part, ";")
+		media = strings.ToLower(strings.TrimSpace(media))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		for format, contentType := range responseFormats {
+			if media == contentType && q > bestQ {
+				best, bestQ = format, q
+			}
+		}
+	}
+	return best, nil
+}
+
+// negotiateEncoder 创建请求所选格式的编码器。
+func negotiateEncoder(r *http.Request, st string, cfg service.TableConfig, c []string) (rowEncoder, error) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "ndjson":
+		return ndjsonEncoder{}, nil
+	case "csv", "xlsx":
+		return newTableEncoder(r, format, st, cfg, c)
+	}
+	return &jsonArrayEncoder{}, nil
+}
+
+// countingWriter 记录已写入响应的字节数，用于判断出错时能否改为返回错误响应。
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// serveMany 写出列表查询的结果。
+//
+// 格式为 json、ndjson 时，配置了 cache_ttl 的数据表经由缓存读取；其他情况逐条读取、逐条写出，
+// 内存占用与结果行数无关。
+// 响应定期刷新，客户端断开时查询随请求的 context 取消。尚未写出任何数据时出错返回错误响应，
+// 已写出部分数据后出错则中断连接，客户端不会把截断的结果当作完整结果。
+//
+// 参数:
+//   - w: 响应。
+//   - r: 请求。
+//   - svc: 应用服务。
+//   - st: schema and table。
+//   - c: 查询的列。
+//   - f: 查询过滤条件。
+//   - l: 限制条件。
+//   - loc: 渲染时区。
+func serveMany(w http.ResponseWriter, r *http.Request, svc *service.ApplicationServiceImpl, st string, c []string, f [][]string, l string, loc *time.Location) {
+	cfg := svc.TableConfig(st)
+	enc, err := negotiateEncoder(r, st, cfg, c)
+	if err != nil {
+		schema.WriteProblem(w, r, "无效的查询参数", err)
+		return
+	}
+
+	cw := &countingWriter{w: w}
+	buf := bufio.NewWriterSize(cw, streamBufferSize)
+	rc := http.NewResponseController(w)
+	extendWriteDeadline(rc)
+	rows := 0
+	lastFlush := time.Now()
+	started := false
+
+	write := func(columns []string, m map[string]any) error {
+		if !started {
+			enc.header(w.Header())
+			if err := enc.begin(buf); err != nil {
+				return err
+			}
+			started = true
+		}
+		localizeRecords(loc, cfg, m)
+		if err := enc.row(buf, columns, m); err != nil {
+			return err
+		}
+		rows++
+		if rows%streamFlushRows == 0 || time.Since(lastFlush) >= streamFlushInterval {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			lastFlush = time.Now()
+			extendWriteDeadline(rc)
+		}
+		return nil
+	}
+
+	if _, tabular := enc.(*tableEncoder); !tabular && svc.Cached(st) {
+		fresh := freshRead(r)
+		var result []map[string]interface{}
+		var hit bool
+		result, hit, err = svc.GetManyCached(r.Context(), st, c, f, l, fresh)
+		if err == nil {
+			w.Header().Set(CacheHeader, cacheStatus(hit, fresh))
+			for _, m := range result {
+				if err = write(nil, m); err != nil {
+					break
+				}
+			}
+		}
+	} else {
+		err = svc.StreamMany(r.Context(), st, c, f, l, write)
+	}
+	if err == nil && !started {
+		enc.header(w.Header())
+		err = enc.begin(buf)
+		started = true
+	}
+	if err == nil {
+		if err = enc.end(buf); err == nil {
+			err = buf.Flush()
+		}
+	}
+	if err == nil {
+		if te, ok := enc.(*tableEncoder); ok {
+			te.reportDropped(w, r)
+		}
+		return
+	}
+
+	if cw.n == 0 {
+		utility.ZapLogger.Error("内部服务器错误", zap.Error(err), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+		w.Header().Del(CacheHeader)
+		w.Header().Del("Content-Disposition")
+		schema.WriteProblem(w, r, "内部服务器错误", err)
+		return
+	}
+	if r.Context().Err() == nil {
+		utility.ZapLogger.Error("列表响应中断", zap.Error(err), zap.Int("rows", rows), zap.String("request_id", r.Header.Get(schema.RequestIDHeader)))
+	}
+	// 响应头已发出，中断连接而不是正常结束响应
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+)
+
+// slowStreamRepo 逐条读取时每条记录之前等待 delay，模拟较慢的查询或较大的结果。
+type slowStreamRepo struct {
+	repository.RDBRepo
+	delay time.Duration
+}
+
+func (r slowStreamRepo) Stream(ctx context.Context, st string, c []string, f [][]string, l string, fn func(columns []string, row map[string]interface{}) error) error {
+	return r.RDBRepo.Stream(ctx, st, c, f, l, func(columns []string, row map[string]interface{}) error {
+		time.Sleep(r.delay)
+		return fn(columns, row)
+	})
+}
+
+func TestStreamOutlastsWriteTimeout(t *testing.T) {
+	const (
+		writeTimeout = 400 * time.Millisecond
+		rows         = 8
+		delay        = 100 * time.Millisecond
+	)
+	StreamWriteTimeout = writeTimeout
+	t.Cleanup(func() { StreamWriteTimeout = 0 })
+
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range rows {
+		if _, err := db.Exec("INSERT INTO posts (id, title) VALUES (?, 'x')", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dialect, _ := repository.DialectFor("sqlite")
+	repo := slowStreamRepo{RDBRepo: repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0), delay: delay}
+	mux := http.NewServeMux()
+	LoadDataRouter(mux, "/api", "sqlite", service.NewApplicationService(repo, nil, nil, nil, nil))
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	// 响应的总时长是服务器 WriteTimeout 的两倍，每次刷新前重新计时，响应完整结束
+	resp, err := http.Get(srv.URL + "/api/sqlite/posts?format=ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	n := 0
+	for ; dec.More(); n++ {
+		var m map[string]any
+		if err := dec.Decode(&m); err != nil {
+			t.Fatalf("after %d rows: %v", n, err)
+		}
+	}
+	if n != rows {
+		t.Errorf("got %d rows, want %d", n, rows)
+	}
+}
@@ -0,0 +1,173 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/middleware"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/service"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// collector 接收 OTLP/HTTP 导出的 span。
+type collector struct {
+	mu      sync.Mutex
+	service string
+	spans   []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	var req collectortrace.ExportTraceServiceRequest
+	if err == nil && r.URL.Path == "/v1/traces" {
+		err = proto.Unmarshal(body, &req)
+	}
+	if err != nil || r.URL.Path != "/v1/traces" {
+		http.Error(w, "bad export", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, attr := range rs.GetResource().GetAttributes() {
+			if attr.Key == "service.name" {
+				c.service = attr.GetValue().GetStringValue()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	c.mu.Unlock()
+	b, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(b)
+}
+
+// attribute 返回 span 的字符串属性。
+func attribute(span *tracepb.Span, key string) string {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.GetValue().GetStringValue()
+		}
+	}
+	return ""
+}
+
+func TestTraceparentContinuedToRepository(t *testing.T) {
+	received := &collector{}
+	otlp := httptest.NewServer(received)
+	defer otlp.Close()
+	// 采样比例为 0：只有上游已决定采样的请求导出 span
+	err := utility.InitTracing(utility.TracingConfig{Exporter: "otlp", Endpoint: otlp.URL, SampleRatio: 0, ServiceName: "crate-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, q := range []string{
+		"CREATE TABLE items (id TEXT PRIMARY KEY, title TEXT)",
+		"INSERT INTO items (id, title) VALUES ('1', 'secret title')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dialect, _ := repository.DialectFor("sqlite")
+	svc := service.NewApplicationService(repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0), nil, nil, nil, nil)
+	mux := http.NewServeMux()
+	LoadDataRouter(mux, "/api", "sqlite", svc)
+	// 与服务相同的中间件顺序
+	handler := middleware.MetricsMiddleware(middleware.TracingMiddleware(middleware.RequestIDMiddleware(middleware.RouteMiddleware(mux))))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	get := func(traceparent string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/sqlite/items?f=eq,2,title,secret%20title&l=LIMIT%205", nil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d", resp.StatusCode)
+		}
+	}
+	get("00-" + traceID + "-" + parentID + "-01")
+	get("")
+	// 停止时导出批处理中的 span
+	utility.CloseTracing(context.Background())
+
+	received.mu.Lock()
+	defer received.mu.Unlock()
+	if received.service != "crate-test" {
+		t.Errorf("got service.name %q, want crate-test", received.service)
+	}
+	byID := map[string]*tracepb.Span{}
+	var serverSpan, serviceSpan, repoSpan *tracepb.Span
+	for _, span := range received.spans {
+		if got := hex.EncodeToString(span.TraceId); got != traceID {
+			t.Errorf("span %q: got trace %s, want only the sampled upstream trace", span.Name, got)
+			continue
+		}
+		byID[hex.EncodeToString(span.SpanId)] = span
+		switch {
+		case span.Kind == tracepb.Span_SPAN_KIND_SERVER:
+			serverSpan = span
+		case strings.HasPrefix(span.Name, "ApplicationService."):
+			serviceSpan = span
+		case span.Name == "select items":
+			repoSpan = span
+		}
+	}
+	if serverSpan == nil || serviceSpan == nil || repoSpan == nil {
+		t.Fatalf("got spans %v, want server, service and repository spans", byID)
+	}
+
+	if got := hex.EncodeToString(serverSpan.ParentSpanId); got != parentID {
+		t.Errorf("server span: got parent %s, want the upstream span %s", got, parentID)
+	}
+	if serverSpan.Name != "GET /api/{backend}/{st}" || attribute(serverSpan, "crate.datasource") != "sqlite" {
+		t.Errorf("server span: got name %q on %q", serverSpan.Name, attribute(serverSpan, "crate.datasource"))
+	}
+	// 沿父 span 向上，仓储的 span 应经过应用服务的 span 到达服务端 span
+	ancestors := func(span *tracepb.Span) []string {
+		var names []string
+		for span != nil {
+			names = append(names, span.Name)
+			span = byID[hex.EncodeToString(span.ParentSpanId)]
+		}
+		return names
+	}
+	if got, want := ancestors(repoSpan), []string{repoSpan.Name, serviceSpan.Name, serverSpan.Name}; strings.Join(got, " < ") != strings.Join(want, " < ") {
+		t.Errorf("got span chain %q, want %q", got, want)
+	}
+
+	if q := attribute(repoSpan, "db.query.text"); !strings.Contains(q, "LIMIT ?") || strings.Contains(q, "secret") {
+		t.Errorf("got db.query.text %q, want a statement without literals", q)
+	}
+	if got := attribute(repoSpan, "db.system.name"); got != "sqlite" {
+		t.Errorf("got db.system.name %q, want sqlite", got)
+	}
+}
@@ -0,0 +1,239 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"ovaphlow.com/crate/data/metrics"
+)
+
+// ProblemTypeBase 问题类型 URI 的前缀，类型 URI 一经发布不再变更。
+const ProblemTypeBase = "https://ovaphlow.com/crate/problems/"
+
+// RequestIDHeader 请求标识头。
+const RequestIDHeader = "X-Request-ID"
+
+// StatusClientClosedRequest 客户端在响应之前断开连接，沿用 nginx 的 499。
+const StatusClientClosedRequest = 499
+
+// ErrorKind 错误分类，决定 HTTP 状态码与问题类型。
+type ErrorKind string
+
+const (
+	KindBadRequest       ErrorKind = "bad-request"
+	KindValidation       ErrorKind = "validation"
+	KindForbidden        ErrorKind = "forbidden"
+	KindNotFound         ErrorKind = "not-found"
+	KindConflict         ErrorKind = "conflict"
+	KindReferenced       ErrorKind = "referenced"
+	KindInvalidReference ErrorKind = "invalid-reference"
+	KindTimeout          ErrorKind = "timeout"
+	KindClientClosed     ErrorKind = "client-closed"
+	KindTooManyRequests  ErrorKind = "too-many-requests"
+	KindUnavailable      ErrorKind = "unavailable"
+	KindInternal         ErrorKind = "internal"
+)
+
+type problemSpec struct {
+	status int
+	title  string
+	code   string
+}
+
+var problemSpecs = map[ErrorKind]problemSpec{
+	KindBadRequest:       {http.StatusBadRequest, "请求无效", "CRATE-400"},
+	KindValidation:       {http.StatusUnprocessableEntity, "数据校验失败", "CRATE-422"},
+	KindForbidden:        {http.StatusForbidden, "没有访问权限", "CRATE-403"},
+	KindNotFound:         {http.StatusNotFound, "资源不存在", "CRATE-404"},
+	KindConflict:         {http.StatusConflict, "记录冲突", "CRATE-409"},
+	KindReferenced:       {http.StatusConflict, "记录仍被引用", "CRATE-409-REF"},
+	KindInvalidReference: {http.StatusUnprocessableEntity, "引用的记录不存在", "CRATE-422-REF"},
+	KindTimeout:          {http.StatusGatewayTimeout, "数据库响应超时", "CRATE-504"},
+	KindClientClosed:     {StatusClientClosedRequest, "客户端已断开", "CRATE-499"},
+	KindTooManyRequests:  {http.StatusTooManyRequests, "请求过于频繁", "CRATE-429"},
+	KindUnavailable:      {http.StatusServiceUnavailable, "数据库不可用", "CRATE-503"},
+	KindInternal:         {http.StatusInternalServerError, "内部服务器错误", "CRATE-500"},
+}
+
+// Error 带分类的应用错误。
+//
+// Detail、Field、Constraint 会返回给调用方，Err 只用于日志，
+// 因此驱动原始错误（可能包含 SQL）不得写入 Detail。
+type Error struct {
+	Kind       ErrorKind
+	Code       string
+	Detail     string
+	Field      string
+	Constraint string
+	Err        error
+}
+
+// NewError 创建一个带分类的错误。
+//
+// 参数:
+//   - kind (ErrorKind): 错误分类。
+//   - detail (string): 可返回给调用方的说明。
+//
+// 返回:
+//   - *Error: 应用错误。
+func NewError(kind ErrorKind, detail string) *Error {
+	return &Error{Kind: kind, Detail: detail}
+}
+
+// WrapError 为底层错误附加分类。
+//
+// 参数:
+//   - kind (ErrorKind): 错误分类。
+//   - err (error): 底层错误，只记录日志。
+//   - detail (string): 可返回给调用方的说明。
+//
+// 返回:
+//   - *Error: 应用错误。
+func WrapError(kind ErrorKind, err error, detail string) *Error {
+	return &Error{Kind: kind, Detail: detail, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Kind)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Title 返回错误分类的标题。
+func (e *Error) Title() string {
+	return specOf(e.Kind).title
+}
+
+// ProblemCode 返回错误的问题代码，未指定 Code 时为分类的代码。
+func (e *Error) ProblemCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return specOf(e.Kind).code
+}
+
+// Status 返回错误对应的 HTTP 状态码。
+func (e *Error) Status() int {
+	return specOf(e.Kind).status
+}
+
+func specOf(kind ErrorKind) problemSpec {
+	if spec, ok := problemSpecs[kind]; ok {
+		return spec
+	}
+	return problemSpecs[KindInternal]
+}
+
+// AsError 将任意错误归类为应用错误，无法识别的错误视为内部错误。
+//
+// 参数:
+//   - err (error): 任意错误。
+//
+// 返回:
+//   - *Error: 应用错误。
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return WrapError(KindTimeout, err, "")
+	}
+	if errors.Is(err, context.Canceled) {
+		return WrapError(KindClientClosed, err, "")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return WrapError(KindTimeout, err, "")
+		}
+		return WrapError(KindUnavailable, err, "")
+	}
+	return WrapError(KindInternal, err, "")
+}
+
+// problemKind 返回错误的问题类型，未登记的分类按内部错误计，使指标的取值有限。
+func problemKind(err error) ErrorKind {
+	kind := AsError(err).Kind
+	if _, ok := problemSpecs[kind]; !ok {
+		return KindInternal
+	}
+	return kind
+}
+
+// CreateProblemRFC9457 根据错误创建符合RFC9457格式的问题详情。
+//
+// 参数:
+//   - detail (string): 未携带说明的错误所使用的默认说明，例如 "创建失败"。
+//   - err (error): 错误。
+//   - r (*http.Request): 与响应关联的HTTP请求。
+//
+// 返回:
+//   - map[string]any: 问题详情，包含 code、field、constraint、request_id 扩展成员。
+func CreateProblemRFC9457(detail string, err error, r *http.Request) map[string]any {
+	e := AsError(err)
+	spec := specOf(e.Kind)
+	if e.Detail != "" {
+		detail = e.Detail
+	}
+	problem := map[string]any{
+		"type":     ProblemTypeBase + string(e.Kind),
+		"title":    spec.title,
+		"status":   spec.status,
+		"detail":   detail,
+		"instance": r.Method + " " + r.URL.Path,
+		"code":     e.ProblemCode(),
+	}
+	if e.Field != "" {
+		problem["field"] = e.Field
+	}
+	if e.Constraint != "" {
+		problem["constraint"] = e.Constraint
+	}
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		problem["request_id"] = id
+	}
+	return problem
+}
+
+// WriteProblem 以 application/problem+json 写出错误响应。
+//
+// 参数:
+//   - w (http.ResponseWriter): 响应写入器。
+//   - r (*http.Request): 与响应关联的HTTP请求。
+//   - detail (string): 默认说明。
+//   - err (error): 错误。
+func WriteProblem(w http.ResponseWriter, r *http.Request, detail string, err error) {
+	WriteProblemWith(w, r, detail, err, nil)
+}
+
+// WriteProblemWith 与 WriteProblem 相同，并在问题详情中附加扩展成员。
+//
+// 参数:
+//   - w (http.ResponseWriter): 响应写入器。
+//   - r (*http.Request): 与响应关联的HTTP请求。
+//   - detail (string): 默认说明。
+//   - err (error): 错误。
+//   - members (map[string]any): 扩展成员，例如导入的部分结果。
+func WriteProblemWith(w http.ResponseWriter, r *http.Request, detail string, err error, members map[string]any) {
+	problem := CreateProblemRFC9457(detail, err, r)
+	for k, v := range members {
+		problem[k] = v
+	}
+	metrics.Problems.WithLabelValues(string(problemKind(err))).Inc()
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem["status"].(int))
+	json.NewEncoder(w).Encode(problem)
+}
@@ -1,75 +1,219 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"ovaphlow.com/crate/data/event"
 	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
 	"ovaphlow.com/crate/data/utility"
 )
 
 // ApplicationService 定义了应用服务操作的接口。
+//
+// 每个方法的查询受 ctx 和数据表的语句超时限制，超时或取消时返回 schema.KindTimeout 错误。
 type ApplicationService interface {
-	Create(st string, d map[string]interface{}) (string, error)
-	Get(st string, f [][]string, l string) (map[string]interface{}, error)
-	Update(st string, d map[string]interface{}, w string, deprecated bool) error
-	Remove(st string, w string) error
+	Create(ctx context.Context, st string, d map[string]interface{}) (string, error)
+	Get(ctx context.Context, st string, f [][]string, l string) (map[string]interface{}, error)
+	GetByID(ctx context.Context, st string, id string) (map[string]interface{}, error)
+	Update(ctx context.Context, st string, d map[string]interface{}, id string, deprecated bool) error
+	Remove(ctx context.Context, st string, id string) error
+	Transition(ctx context.Context, st string, id string, to string, by string, note string) (map[string]interface{}, error)
 }
 
 // ApplicationServiceImpl 实现了 ApplicationService 接口。
 type ApplicationServiceImpl struct {
-	repo repository.RDBRepo
+	repo   repository.RDBRepo
+	tables *TableRegistry
+	events *event.Bus
+	cache  *BackendCache
+	reads  *ReadRouting
+	// reader 由 ForRead 绑定的只读副本仓储，为 nil 时读取主库。
+	reader repository.RDBRepo
 }
 
 // NewApplicationService 创建一个新的 ApplicationServiceImpl 实例。
-func NewApplicationService(repo repository.RDBRepo) *ApplicationServiceImpl {
-	return &ApplicationServiceImpl{repo: repo}
+//
+// 参数:
+//   - repo: 数据库仓储。
+//   - tables: 数据表选项，为 nil 时所有数据表使用默认选项。
+//   - events: 变更总线，为 nil 时不发布变更。
+//   - cache: 列表查询缓存，为 nil 时不缓存。
+//   - reads: 只读副本路由，为 nil 时所有读取使用主库。
+func NewApplicationService(repo repository.RDBRepo, tables *TableRegistry, events *event.Bus, cache *BackendCache, reads *ReadRouting) *ApplicationServiceImpl {
+	return &ApplicationServiceImpl{repo: repo, tables: tables, events: events, cache: cache, reads: reads}
+}
+
+// withTimeout 按数据表的语句超时限制 ctx，ctx 已有更早的期限（例如客户端请求的期限）时保持不变。
+func (s *ApplicationServiceImpl) withTimeout(ctx context.Context, st string) (context.Context, context.CancelFunc) {
+	timeout := s.tables.Lookup(st).statementTimeout()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 // Create 创建一个新的应用服务记录。
 //
 // 参数:
+//   - ctx: 限制写入的时长。
 //   - st: 服务类型。
 //   - d: 应用服务数据。
 //
 // 返回值:
 //   - string: 创建的记录ID。
 //   - error: 如果创建失败，返回相应的错误。
-func (s *ApplicationServiceImpl) Create(st string, d map[string]any) (string, error) {
+func (s *ApplicationServiceImpl) Create(ctx context.Context, st string, d map[string]any) (id string, err error) {
+	ctx, span := startSpan(ctx, "Create", st)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+
+	cfg := s.tables.Lookup(st)
+	if !cfg.Managed {
+		return s.createPlain(ctx, st, cfg, d)
+	}
+
+	if err := prepareCreate(cfg, d, time.Now()); err != nil {
+		return "", err
+	}
+
 	// id
-	id, err := utility.GenerateKsuid()
+	if cfg.IDStrategy == utility.IDStrategyDatabase {
+		delete(d, cfg.PrimaryKey)
+		id, err := s.mutate(ctx, st, event.OpCreate, func(repo repository.RDBRepo) (string, map[string]any, error) {
+			id, err := repo.CreateReturning(ctx, st, d, cfg.PrimaryKey)
+			return id, nil, err
+		})
+		if err != nil {
+			return "", err
+		}
+		s.publish(ctx, event.OpCreate, st, id, d)
+		return id, nil
+	}
+	id, err = utility.GenerateID(cfg.IDStrategy)
 	if err != nil {
 		return "", err
 	}
-	d["id"] = id
+	d[cfg.PrimaryKey] = id
 
-	time_string := time.Now().Format("2006-01-02 15:04:05")
+	_, err = s.mutate(ctx, st, event.OpCreate, func(repo repository.RDBRepo) (string, map[string]any, error) {
+		return id, nil, repo.Create(ctx, st, d)
+	})
+	if err != nil {
+		return "", err
+	}
+	s.publish(ctx, event.OpCreate, st, id, d)
+	return id, nil
+}
 
+// prepareCreate 写入新记录的事件时间和状态元数据，不生成主键。
+//
+// 参数:
+//   - cfg: 数据表选项，须为托管元数据的数据表。
+//   - d: 记录。
+//   - now: 当前时间。
+//
+// 返回值:
+//   - error: 事件时间无效时返回校验错误。
+func prepareCreate(cfg TableConfig, d map[string]any, now time.Time) error {
 	// time
-	d["event_time"] = time_string
+	if cfg.EventTime != "" {
+		eventTime, err := parseEventTime(cfg.EventTime, d[cfg.EventTime], now)
+		if err != nil {
+			return err
+		}
+		d[cfg.EventTime] = eventTime
+	}
 
 	// state
-	state := map[string]any{
-		"created_at": time_string,
-		"status":     "active",
+	switch cfg.State {
+	case StateJSON:
+		state := map[string]any{
+			"created_at": utility.FormatTime(now),
+			"status":     cfg.lifecycle().Initial,
+		}
+		stateJson, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		d[cfg.StateColumn] = string(stateJson)
+	case StateColumns:
+		if cfg.CreatedAtColumn != "" {
+			d[cfg.CreatedAtColumn] = now.UTC()
+		}
+		if cfg.StatusColumn != "" {
+			d[cfg.StatusColumn] = cfg.lifecycle().Initial
+		}
 	}
-	stateJson, err := json.Marshal(state)
+	return nil
+}
+
+// createPlain 按原样写入未托管元数据的数据表。
+//
+// 请求体未提供主键且主键由数据库生成时，返回数据库生成的主键。
+func (s *ApplicationServiceImpl) createPlain(ctx context.Context, st string, cfg TableConfig, d map[string]any) (string, error) {
+	id, ok := d[cfg.PrimaryKey]
+	key, err := s.mutate(ctx, st, event.OpCreate, func(repo repository.RDBRepo) (string, map[string]any, error) {
+		if !ok && cfg.IDStrategy == utility.IDStrategyDatabase {
+			key, err := repo.CreateReturning(ctx, st, d, cfg.PrimaryKey)
+			return key, nil, err
+		}
+		if !ok || id == nil {
+			// 没有主键时无法读回记录，使用写入的数据
+			return "", d, repo.Create(ctx, st, d)
+		}
+		return fmt.Sprintf("%v", id), nil, repo.Create(ctx, st, d)
+	})
 	if err != nil {
 		return "", err
 	}
-	d["data_state"] = string(stateJson)
+	s.publish(ctx, event.OpCreate, st, key, d)
+	return key, nil
+}
 
-	err = s.repo.Create(st, d)
+// eventTimeSkew 允许客户端提交的 event_time 超前于服务器时间的幅度。
+const eventTimeSkew = time.Minute
+
+// parseEventTime 解析客户端提交的事件时间。
+//
+// 参数:
+//   - column: 事件时间列。
+//   - v: 客户端提交的值，为空时使用 now。
+//   - now: 当前时间。
+//
+// 返回值:
+//   - time.Time: UTC 时间。
+//   - error: 无法解析或晚于当前时间时返回校验错误。
+func parseEventTime(column string, v any, now time.Time) (time.Time, error) {
+	str, ok := v.(string)
+	if v == nil || (ok && str == "") {
+		return now.UTC(), nil
+	}
+	if !ok {
+		return time.Time{}, &schema.Error{Kind: schema.KindValidation, Detail: column + " 应为 RFC 3339 格式的时间", Field: column}
+	}
+	t, err := utility.ParseTime(str)
 	if err != nil {
-		return "", err
+		return time.Time{}, &schema.Error{Kind: schema.KindValidation, Detail: column + " 应为 RFC 3339 格式的时间", Field: column, Err: err}
 	}
-	return id, nil
+	if t.After(now.Add(eventTimeSkew)) {
+		return time.Time{}, &schema.Error{Kind: schema.KindValidation, Detail: column + " 不能晚于当前时间", Field: column}
+	}
+	return t, nil
 }
 
 // GetMany 获取多个应用服务记录。
 //
 // 参数:
+//   - ctx: 限制查询的时长。
 //   - st: 服务类型。
 //   - f: 查询过滤条件。
 //   - l: 限制条件。
@@ -77,8 +221,17 @@ func (s *ApplicationServiceImpl) Create(st string, d map[string]any) (string, er
 // 返回值:
 //   - []map[string]interface{}: 应用服务数据列表。
 //   - error: 如果获取失败，返回相应的错误。
-func (s *ApplicationServiceImpl) GetMany(st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
-	result, err := s.repo.Get(st, c, f, l)
+func (s *ApplicationServiceImpl) GetMany(ctx context.Context, st string, c []string, f [][]string, l string) (result []map[string]interface{}, err error) {
+	ctx, span := startSpan(ctx, "GetMany", st)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+	return getMany(ctx, s.read(), st, c, f, l)
+}
+
+// getMany 从指定的仓储获取多个记录，没有记录时返回空切片。
+func getMany(ctx context.Context, repo repository.RDBRepo, st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
+	result, err := repo.Get(ctx, st, c, f, l)
 	if err != nil {
 		return nil, err
 	}
@@ -88,9 +241,91 @@ func (s *ApplicationServiceImpl) GetMany(st string, c []string, f [][]string, l
 	return result, nil
 }
 
+// GetManyCached 获取多个应用服务记录，数据表配置了 cache_ttl 时使用缓存。
+//
+// 参数:
+//   - ctx: 限制查询的时长。
+//   - st: schema and table。
+//   - c: 查询的列。
+//   - f: 查询过滤条件。
+//   - l: 限制条件。
+//   - fresh: 为 true 时绕过缓存直接查询，结果仍写入缓存。
+//
+// 返回值:
+//   - []map[string]interface{}: 应用服务数据列表，可以修改记录的顶层字段。
+//   - bool: 是否命中缓存。
+//   - error: 如果获取失败，返回相应的错误。
+func (s *ApplicationServiceImpl) GetManyCached(ctx context.Context, st string, c []string, f [][]string, l string, fresh bool) (result []map[string]interface{}, hit bool, err error) {
+	ctx, span := startSpan(ctx, "GetManyCached", st)
+	defer func() {
+		span.SetAttributes(attribute.Bool("crate.cache.hit", hit))
+		endSpan(span, err)
+	}()
+	ttl := s.tables.Lookup(st).cacheTTL()
+	if s.cache == nil || ttl <= 0 {
+		result, err := s.GetMany(ctx, st, c, f, l)
+		return result, false, err
+	}
+
+	key := s.cache.key(st, c, f, l)
+	if fresh {
+		s.cache.bypass()
+	} else if result, ok := s.cache.get(key); ok {
+		return result, true, nil
+	}
+	// 写入缓存的结果读取主库：只读副本的延迟数据会在写入使缓存失效后重新写入缓存
+	generation := s.cache.begin(st)
+	defer s.cache.end(st)
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+	result, err = getMany(ctx, s.repo, st, c, f, l)
+	if err != nil {
+		return nil, false, err
+	}
+	s.cache.put(st, key, generation, result, ttl)
+	return result, false, nil
+}
+
+// StreamMany 逐条读取多个应用服务记录，不在内存中汇总结果。
+//
+// 数据表的语句超时只限制到读取第一条记录为止：之后的时长取决于客户端读取的速度，
+// 导出大量数据或客户端较慢时不应中断响应。
+//
+// 参数:
+//   - ctx: 取消时停止查询，例如客户端断开连接。
+//   - st: schema and table。
+//   - c: 查询的列。
+//   - f: 查询过滤条件。
+//   - l: 限制条件。
+//   - fn: 每条记录调用一次，同时传入按查询顺序排列的列名，返回错误时停止查询。
+//
+// 返回值:
+//   - error: 如果获取失败，返回相应的错误；fn 的错误原样返回。
+func (s *ApplicationServiceImpl) StreamMany(ctx context.Context, st string, c []string, f [][]string, l string, fn func(columns []string, row map[string]interface{}) error) (err error) {
+	ctx, span := startSpan(ctx, "StreamMany", st)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if timeout := s.tables.Lookup(st).statementTimeout(); timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+		defer timer.Stop()
+		next := fn
+		fn = func(columns []string, row map[string]interface{}) error {
+			timer.Stop()
+			return next(columns, row)
+		}
+	}
+	err = s.read().Stream(ctx, st, c, f, l, fn)
+	if err != nil && errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		return schema.WrapError(schema.KindTimeout, context.DeadlineExceeded, "")
+	}
+	return err
+}
+
 // Get 获取单个应用服务记录。
 //
 // 参数:
+//   - ctx: 限制查询的时长。
 //   - st: 服务类型。
 //   - f: 查询过滤条件。
 //   - l: 限制条件。
@@ -98,68 +333,225 @@ func (s *ApplicationServiceImpl) GetMany(st string, c []string, f [][]string, l
 // 返回值:
 //   - map[string]interface{}: 应用服务数据。
 //   - error: 如果获取失败，返回相应的错误。
-func (s *ApplicationServiceImpl) Get(st string, f [][]string, l string) (map[string]any, error) {
-	data, err := s.repo.Get(st, nil, f, l+" limit 1")
+func (s *ApplicationServiceImpl) Get(ctx context.Context, st string, f [][]string, l string) (record map[string]any, err error) {
+	ctx, span := startSpan(ctx, "Get", st)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+	data, err := s.read().Get(ctx, st, nil, f, l+" limit 1")
 	if err != nil {
 		return nil, err
 	}
 	if len(data) == 0 {
-		return map[string]any{}, fmt.Errorf("记录不存在")
+		return map[string]any{}, schema.NewError(schema.KindNotFound, "记录不存在")
 	}
 	return data[0], nil
 }
 
+// GetByID 按主键获取单个应用服务记录。
+//
+// 参数:
+//   - ctx: 限制查询的时长。
+//   - st: schema and table。
+//   - id: 主键。
+//
+// 返回值:
+//   - map[string]interface{}: 应用服务数据。
+//   - error: 如果获取失败，返回相应的错误。
+func (s *ApplicationServiceImpl) GetByID(ctx context.Context, st string, id string) (map[string]any, error) {
+	return s.Get(ctx, st, [][]string{{"equal", s.tables.Lookup(st).PrimaryKey, id}}, "")
+}
+
 // Update 更新应用服务记录。
 //
+// 状态只能通过状态迁移修改：deprecated 为 true 时将记录迁移到 deprecated 状态，
+// 生命周期未声明该状态或不允许迁移时返回错误，不写入。
+//
 // 参数:
+//   - ctx: 限制读取和写入的时长。
 //   - st: schema and table。
 //   - d: 更新的数据。
-//   - w: 更新条件字符串
+//   - id: 主键。
 //   - deprecated: 是否标记数据弃用。
 //
 // 返回值:
-//   - error: 如果更新失败，返回相应的错误。
-func (s *ApplicationServiceImpl) Update(st string, d map[string]any, w string, deprecated bool) error {
-	id, ok := d["id"].(string)
-	if !ok {
-		return fmt.Errorf("缺少ID")
+//   - error: 如果更新失败，返回相应的错误；记录不存在时为 not-found 错误。
+func (s *ApplicationServiceImpl) Update(ctx context.Context, st string, d map[string]any, id string, deprecated bool) (err error) {
+	ctx, span := startSpan(ctx, "Update", st)
+	defer func() { endSpan(span, err) }()
+	if id == "" {
+		return schema.NewError(schema.KindBadRequest, "缺少ID")
+	}
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+	cfg := s.tables.Lookup(st)
+	f := [][]string{{"equal", cfg.PrimaryKey, id}}
+	delete(d, cfg.PrimaryKey)
+	if !cfg.Managed {
+		if err := s.update(ctx, st, id, d, f); err != nil {
+			return err
+		}
+		s.publish(ctx, event.OpUpdate, st, id, d)
+		return nil
 	}
 
-	existingData, err := s.repo.Get(st, []string{"data_state"}, [][]string{{"equal", "id", id}}, "")
+	if cfg.State == StateColumns {
+		if _, ok := d[cfg.StatusColumn]; ok && cfg.StatusColumn != "" {
+			return statusWrite(cfg.StatusColumn)
+		}
+	}
+	if deprecated && cfg.lifecycleEnabled() {
+		if !slices.Contains(cfg.lifecycle().States, "deprecated") {
+			return &schema.Error{Kind: schema.KindValidation, Code: "CRATE-422-STATE", Detail: "生命周期未声明 deprecated 状态", Field: "d"}
+		}
+		// 先迁移状态，再读取现有状态，避免下面的写入覆盖迁移记录
+		if _, err := s.transition(ctx, st, id, "deprecated", "", "", true); err != nil {
+			return err
+		}
+	}
+
+	existingData, err := s.repo.Get(ctx, st, []string{cfg.existingColumn()}, f, "")
 	if err != nil {
 		return err
 	}
 	if len(existingData) == 0 {
-		return fmt.Errorf("记录不存在")
+		return schema.NewError(schema.KindNotFound, "记录不存在")
+	}
+	if err := prepareUpdate(cfg, d, existingData[0], time.Now()); err != nil {
+		return err
 	}
 
-	var state map[string]any
-	err = json.Unmarshal([]byte(existingData[0]["data_state"].(string)), &state)
-	if err != nil {
+	if err := s.update(ctx, st, id, d, f); err != nil {
 		return err
 	}
+	s.publish(ctx, event.OpUpdate, st, id, d)
+	return nil
+}
 
-	state["updated_at"] = time.Now().Format("2006-01-02 15:04:05")
-	if deprecated {
-		state["deprecated"] = true
+// prepareUpdate 写入更新记录时的事件时间和状态元数据。
+//
+// 参数:
+//   - cfg: 数据表选项，须为托管元数据的数据表。
+//   - d: 更新的字段。
+//   - existing: 现有记录，须包含 cfg.existingColumn() 列。
+//   - now: 当前时间。
+//
+// 返回值:
+//   - error: 事件时间无效、直接写入状态列或现有状态无法解析时返回错误。
+func prepareUpdate(cfg TableConfig, d map[string]any, existing map[string]any, now time.Time) error {
+	if cfg.EventTime != "" {
+		if v, ok := d[cfg.EventTime]; ok {
+			eventTime, err := parseEventTime(cfg.EventTime, v, now)
+			if err != nil {
+				return err
+			}
+			d[cfg.EventTime] = eventTime
+		}
 	}
-	stateJson, err := json.Marshal(state)
-	if err != nil {
-		return err
+
+	switch cfg.State {
+	case StateJSON:
+		// 状态列由服务维护，忽略请求中的值
+		state := map[string]any{}
+		if raw, ok := existing[cfg.StateColumn].(string); ok && raw != "" {
+			if err := json.Unmarshal([]byte(raw), &state); err != nil {
+				return err
+			}
+		}
+		state["updated_at"] = utility.FormatTime(now)
+		stateJson, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		d[cfg.StateColumn] = string(stateJson)
+	case StateColumns:
+		if _, ok := d[cfg.StatusColumn]; ok && cfg.StatusColumn != "" {
+			return statusWrite(cfg.StatusColumn)
+		}
+		if cfg.UpdatedAtColumn != "" {
+			d[cfg.UpdatedAtColumn] = now.UTC()
+		}
 	}
-	d["data_state"] = string(stateJson)
+	return nil
+}
 
-	return s.repo.Update(st, d, w)
+// statusWrite 请求直接写入状态列时的错误。
+func statusWrite(column string) error {
+	return &schema.Error{Kind: schema.KindValidation, Code: "CRATE-422-STATE", Detail: "状态只能通过状态迁移修改", Field: column}
+}
+
+// update 按过滤条件更新记录，启用发件箱时在同一事务中写入发件箱记录，没有匹配的记录时返回 not-found 错误。
+func (s *ApplicationServiceImpl) update(ctx context.Context, st string, id string, d map[string]any, f [][]string) error {
+	_, err := s.mutate(ctx, st, event.OpUpdate, func(repo repository.RDBRepo) (string, map[string]any, error) {
+		n, err := repo.Update(ctx, st, d, f)
+		if err == nil && n == 0 {
+			// 启用发件箱时随之回滚，不写入发件箱
+			err = schema.NewError(schema.KindNotFound, "记录不存在")
+		}
+		return id, nil, err
+	})
+	return err
 }
 
 // Remove 移除应用服务记录。
 //
 // 参数:
-//   - st: 服务类型。
-//   - w: 移除条件。
+//   - ctx: 限制读取和删除的时长。
+//   - st: schema and table。
+//   - id: 主键。
 //
 // 返回值:
-//   - error: 如果移除失败，返回相应的错误。
-func (s *ApplicationServiceImpl) Remove(st string, w string) error {
-	return s.repo.Remove(st, w)
+//   - error: 如果移除失败，返回相应的错误；记录不存在时为 not-found 错误。
+func (s *ApplicationServiceImpl) Remove(ctx context.Context, st string, id string) (err error) {
+	ctx, span := startSpan(ctx, "Remove", st)
+	defer func() { endSpan(span, err) }()
+	if id == "" {
+		return schema.NewError(schema.KindBadRequest, "缺少ID")
+	}
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+	cfg := s.tables.Lookup(st)
+	f := [][]string{{"equal", cfg.PrimaryKey, id}}
+
+	// 删除前读取记录，订阅者据此判断行权限和过滤条件
+	var existing map[string]any
+	_, err = s.mutate(ctx, st, event.OpDelete, func(repo repository.RDBRepo) (string, map[string]any, error) {
+		if s.events != nil || cfg.Outbox {
+			existingData, err := repo.Get(ctx, st, nil, f, "")
+			if err != nil {
+				return "", nil, err
+			}
+			if len(existingData) == 0 {
+				return "", nil, schema.NewError(schema.KindNotFound, "记录不存在")
+			}
+			existing = existingData[0]
+		}
+		n, err := repo.Remove(ctx, st, f)
+		if err == nil && n == 0 {
+			err = schema.NewError(schema.KindNotFound, "记录不存在")
+		}
+		return id, existing, err
+	})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		s.events.Publish(event.Change{Op: event.OpDelete, Table: st, Key: id, Record: existing})
+	}
+	return nil
+}
+
+// Cached 数据表的列表查询是否使用缓存。
+func (s *ApplicationServiceImpl) Cached(st string) bool {
+	return s.cache != nil && s.tables.Lookup(st).cacheTTL() > 0
+}
+
+// KnownTable 数据表是否已确认存在，即元数据已在缓存中，不查询数据库。
+func (s *ApplicationServiceImpl) KnownTable(st string) bool {
+	return s.repo.Schema().Has(st)
+}
+
+// TableConfig 获取数据表的选项。
+func (s *ApplicationServiceImpl) TableConfig(st string) TableConfig {
+	return s.tables.Lookup(st)
 }
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+func TestMain(m *testing.M) {
+	utility.ZapLogger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// newTestService 在内存 SQLite 上创建服务：plain 为未托管的数据表，managed 为托管的数据表，
+// items 启用发件箱，但不创建发件箱表。
+func newTestService(t *testing.T) (*ApplicationServiceImpl, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	for _, q := range []string{
+		"CREATE TABLE plain (id TEXT PRIMARY KEY, title TEXT)",
+		"CREATE TABLE managed (id TEXT PRIMARY KEY, title TEXT, event_time TEXT, data_state TEXT, created_at TEXT, updated_at TEXT, status TEXT)",
+		"CREATE TABLE items (id TEXT PRIMARY KEY, title TEXT)",
+		"INSERT INTO plain (id, title) VALUES ('1', 'a')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dialect, _ := repository.DialectFor("sqlite")
+	repo := repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0)
+	plain := defaultTableConfig()
+	plain.Managed = false
+	withOutbox := plain
+	withOutbox.Outbox = true
+	withOutbox.OutboxTable = "crate_outbox"
+	tables := NewTableRegistry(defaultTableConfig(), map[string]TableConfig{
+		"plain": plain,
+		"items": withOutbox,
+	})
+	return NewApplicationService(repo, tables, nil, nil, nil), db
+}
+
+func TestMissingRowIsNotFound(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"update plain", func() error { return s.Update(ctx, "plain", map[string]any{"title": "b"}, "9", false) }},
+		{"update managed", func() error { return s.Update(ctx, "managed", map[string]any{"title": "b"}, "9", false) }},
+		// 发件箱表不存在：未匹配到记录时应在写入发件箱之前返回
+		{"update with outbox", func() error { return s.Update(ctx, "items", map[string]any{"title": "b"}, "9", false) }},
+		{"remove plain", func() error { return s.Remove(ctx, "plain", "9") }},
+		{"remove with outbox", func() error { return s.Remove(ctx, "items", "9") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn()
+			if kind := schema.AsError(err).Kind; kind != schema.KindNotFound {
+				t.Errorf("got %v (%s), want %s", err, kind, schema.KindNotFound)
+			}
+		})
+	}
+}
+
+func TestUpdateUnchangedRowIsFound(t *testing.T) {
+	s, db := newTestService(t)
+	ctx := context.Background()
+
+	// 值未变化的行仍算作匹配
+	if err := s.Update(ctx, "plain", map[string]any{"title": "a"}, "1", false); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := s.Remove(ctx, "plain", "1"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM plain").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d rows after remove, want 0", n)
+	}
+}
+
+// slowRepo 在开始查询之前等待 delay，模拟执行缓慢的查询。
+type slowRepo struct {
+	repository.RDBRepo
+	delay time.Duration
+}
+
+func (r slowRepo) Stream(ctx context.Context, st string, c []string, f [][]string, l string, fn func(columns []string, row map[string]interface{}) error) error {
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r.RDBRepo.Stream(ctx, st, c, f, l, fn)
+}
+
+func TestStreamManyTimeout(t *testing.T) {
+	s, db := newTestService(t)
+	if _, err := db.Exec("INSERT INTO plain (id, title) VALUES ('2', 'b'), ('3', 'c')"); err != nil {
+		t.Fatal(err)
+	}
+	const timeout = 50 * time.Millisecond
+	plain := defaultTableConfig()
+	plain.Managed = false
+	plain.StatementTimeout = timeout.String()
+	tables := NewTableRegistry(defaultTableConfig(), map[string]TableConfig{"plain": plain})
+	ctx := context.Background()
+	repo := s.repo
+
+	// 超时只限制到第一条记录，之后较慢的客户端不会中断读取
+	s = NewApplicationService(slowRepo{RDBRepo: repo}, tables, nil, nil, nil)
+	rows := 0
+	err := s.StreamMany(ctx, "plain", nil, nil, "", func(columns []string, row map[string]interface{}) error {
+		rows++
+		time.Sleep(timeout)
+		return nil
+	})
+	if err != nil || rows != 3 {
+		t.Errorf("slow client: got %d rows, %v, want 3 rows", rows, err)
+	}
+
+	s = NewApplicationService(slowRepo{RDBRepo: repo, delay: 10 * timeout}, tables, nil, nil, nil)
+	err = s.StreamMany(ctx, "plain", nil, nil, "", func(columns []string, row map[string]interface{}) error {
+		t.Error("got a row from a query that timed out")
+		return nil
+	})
+	if kind := schema.AsError(err).Kind; kind != schema.KindTimeout {
+		t.Errorf("slow query: got %v (%s), want %s", err, kind, schema.KindTimeout)
+	}
+}
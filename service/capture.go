@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/utility"
+)
+
+const (
+	// captureBatch 每次从变更日志读取的数量。
+	captureBatch = 500
+	// capturePoll 没有通知时检查连接并读取变更日志的间隔。
+	capturePoll = 30 * time.Second
+	// captureGapWait 变更日志序号出现空洞时等待的时长：
+	// 序号较小的事务可能晚于序号较大的事务提交，超过该时长仍未出现的序号视为已回滚。
+	captureGapWait = time.Minute
+	// captureMaxGaps 同时等待的空洞数量上限。
+	captureMaxGaps = 10000
+)
+
+// PostgresCapture 将触发器捕获的写入转换为变更总线上的变更。
+//
+// 触发器把每次写入记入变更日志并通过 pg_notify 发出通知，PostgresCapture 使用独立连接
+// LISTEN，收到通知后按序号从变更日志读取；连接断开后自动重连，并从上次读取的序号继续，
+// 因此断线期间的写入不会丢失。本实例经由 API 的写入已由应用服务发布，按 application_name 跳过。
+type PostgresCapture struct {
+	repo        *repository.PostgresCaptureRepo
+	dsn         string
+	application string
+	bus         *event.Bus
+	retention   time.Duration
+	last        int64
+	gaps        map[int64]time.Time
+}
+
+// NewPostgresCapture 创建变更捕获。
+//
+// 参数:
+//   - repo: 变更日志仓储。
+//   - dsn: LISTEN 使用的连接字符串。
+//   - application: 本实例连接的 application_name。
+//   - bus: 变更总线。
+//   - retention: 变更日志的保留时长，须长于预期的最长断线时间。
+//
+// 返回值:
+//   - *PostgresCapture: 变更捕获。
+func NewPostgresCapture(repo *repository.PostgresCaptureRepo, dsn string, application string, bus *event.Bus, retention time.Duration) *PostgresCapture {
+	return &PostgresCapture{
+		repo:        repo,
+		dsn:         dsn,
+		application: application,
+		bus:         bus,
+		retention:   retention,
+		gaps:        map[int64]time.Time{},
+	}
+}
+
+// Run 监听通知，直到 ctx 取消。
+//
+// 启动时从变更日志当前的最大序号开始，此前的写入不会发布。
+func (c *PostgresCapture) Run(ctx context.Context) {
+	for {
+		last, err := c.repo.LastID()
+		if err == nil {
+			c.last = last
+			break
+		}
+		utility.ZapLogger.Warn("读取变更日志失败", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(capturePoll):
+		}
+	}
+
+	listener := pq.NewListener(c.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			utility.ZapLogger.Warn("变更捕获连接断开", zap.Error(err))
+		case pq.ListenerEventReconnected:
+			utility.ZapLogger.Info("变更捕获连接已恢复")
+		case pq.ListenerEventConnectionAttemptFailed:
+			utility.ZapLogger.Warn("变更捕获连接失败", zap.Error(err))
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(c.repo.Channel); err != nil {
+		utility.ZapLogger.Error("监听变更通知失败", zap.String("channel", c.repo.Channel), zap.Error(err))
+		return
+	}
+	utility.ZapLogger.Info("变更捕获已启动", zap.String("channel", c.repo.Channel), zap.Int64("last_id", c.last))
+
+	ticker := time.NewTicker(capturePoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// 重连后收到 nil，同样从变更日志追赶断线期间的写入
+		case <-ticker.C:
+			go listener.Ping()
+			c.purge()
+		}
+		c.catchUp()
+	}
+}
+
+// catchUp 读取并发布上次序号之后的变更，以及仍在等待的空洞。
+func (c *PostgresCapture) catchUp() {
+	if len(c.gaps) > 0 {
+		ids := make([]int64, 0, len(c.gaps))
+		now := time.Now()
+		for id, since := range c.gaps {
+			if now.Sub(since) > captureGapWait {
+				delete(c.gaps, id)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) > 0 {
+			entries, err := c.repo.ByIDs(ids)
+			if err != nil {
+				utility.ZapLogger.Warn("读取变更日志失败", zap.Error(err))
+				return
+			}
+			for _, e := range entries {
+				delete(c.gaps, e.ID)
+				c.publish(e)
+			}
+		}
+	}
+
+	for {
+		entries, err := c.repo.After(c.last, captureBatch)
+		if err != nil {
+			utility.ZapLogger.Warn("读取变更日志失败", zap.Error(err))
+			return
+		}
+		for _, e := range entries {
+			for id := c.last + 1; id < e.ID && len(c.gaps) < captureMaxGaps; id++ {
+				c.gaps[id] = time.Now()
+			}
+			c.last = e.ID
+			c.publish(e)
+		}
+		if len(entries) < captureBatch {
+			return
+		}
+	}
+}
+
+func (c *PostgresCapture) publish(e repository.ChangeLogEntry) {
+	if e.Application == c.application {
+		return
+	}
+	c.bus.Publish(event.Change{Op: e.Op, Table: e.Table, Key: e.Key, Record: e.Record, At: e.CreatedAt, Captured: true})
+}
+
+// purge 删除超过保留时长的变更日志。多个实例同时删除是安全的。
+func (c *PostgresCapture) purge() {
+	if c.retention <= 0 {
+		return
+	}
+	if _, err := c.repo.Purge(time.Now().Add(-c.retention)); err != nil {
+		utility.ZapLogger.Warn("清理变更日志失败", zap.Error(err))
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// Events 获取变更总线，未启用变更流时为 nil。
+func (s *ApplicationServiceImpl) Events() *event.Bus {
+	return s.events
+}
+
+// publish 发布写入成功的变更。
+//
+// 订阅者按完整记录判断过滤条件和行权限，因此重新读取记录；
+// 无法读取（例如未返回主键的普通数据表）时使用写入的数据。
+//
+// 参数:
+//   - ctx: 限制重新读取的时长，超时时使用写入的数据。
+//   - op: 变更类型。
+//   - st: schema and table。
+//   - id: 主键。
+//   - d: 写入的数据。
+func (s *ApplicationServiceImpl) publish(ctx context.Context, op string, st string, id string, d map[string]any) {
+	if s.events == nil {
+		return
+	}
+	record := d
+	if id != "" {
+		if existing, err := s.GetByID(ctx, st, id); err == nil {
+			record = existing
+		} else {
+			utility.ZapLogger.Warn("读取变更记录失败", zap.String("table", st), zap.String("id", id), zap.Error(err))
+		}
+	}
+	s.events.Publish(event.Change{Op: op, Table: st, Key: id, Record: record})
+}
@@ -0,0 +1,613 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// 导入文件的格式
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+)
+
+const (
+	// ImportBatchSize 默认每个事务写入的记录数。
+	ImportBatchSize = 500
+	// ImportMaxBatchSize 每个事务写入的记录数上限。
+	ImportMaxBatchSize = 5000
+	// importMaxFailures 报告中列出的失败记录数上限，超过时只计数。
+	importMaxFailures = 1000
+	// importMaxLine NDJSON 单行长度上限。
+	importMaxLine = 16 << 20
+)
+
+// ImportSource 导入的文件。
+type ImportSource struct {
+	// Format 文件格式：csv 或 ndjson。
+	Format string
+	Reader io.Reader
+	// Mapping 源字段到列的映射。为空时源字段即列名；不为空时只导入映射中的字段。
+	Mapping map[string]string
+	// Delimiter CSV 字段分隔符，为 0 时使用逗号。
+	Delimiter rune
+}
+
+// ImportOptions 导入选项。
+type ImportOptions struct {
+	// DryRun 为 true 时只校验，不写入。
+	DryRun bool
+	// Upsert 为 true 时，主键已存在的记录按更新处理，否则一律新增。
+	Upsert bool
+	// BatchSize 每个事务写入的记录数，为 0 时使用 ImportBatchSize。
+	BatchSize int
+}
+
+// ImportFailure 一条导入失败的记录。
+type ImportFailure struct {
+	// Line 记录在文件中的起始行号，从 1 开始，CSV 的标题行为第 1 行。
+	Line  int    `json:"line"`
+	Code  string `json:"code"`
+	Field string `json:"field,omitempty"`
+	// Reason 错误的说明，不包含驱动的原始错误。
+	Reason string `json:"reason"`
+}
+
+// ImportReport 导入结果。
+type ImportReport struct {
+	DryRun bool `json:"dry_run"`
+	// Total 读取的记录数。
+	Total int `json:"total"`
+	// Valid 通过校验的记录数。
+	Valid   int `json:"valid"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+	// Failures 失败的记录，最多列出 1000 条，FailuresTruncated 表示还有未列出的失败。
+	Failures          []ImportFailure `json:"failures"`
+	FailuresTruncated bool            `json:"failures_truncated,omitempty"`
+}
+
+// fail 记录一条失败的记录。
+//
+// 报告只包含错误的代码、字段和说明，驱动的原始错误（可能包含 SQL）只写入日志。
+func (r *ImportReport) fail(line int, err error) {
+	r.Failed++
+	if len(r.Failures) >= importMaxFailures {
+		r.FailuresTruncated = true
+		return
+	}
+	appErr := schema.AsError(err)
+	reason := appErr.Detail
+	if reason == "" {
+		reason = appErr.Title()
+	}
+	if appErr.Err != nil {
+		utility.ZapLogger.Warn("导入记录失败", zap.Int("line", line), zap.String("code", appErr.ProblemCode()), zap.Error(appErr.Err))
+	}
+	r.Failures = append(r.Failures, ImportFailure{Line: line, Code: appErr.ProblemCode(), Field: appErr.Field, Reason: reason})
+}
+
+// importRow 一条待写入的记录。
+type importRow struct {
+	line int
+	data map[string]any
+}
+
+// Import 从 CSV 或 NDJSON 文件批量导入记录。
+//
+// 字段按数据表元数据中的列类型转换；托管元数据的数据表与 Create 相同，生成主键、事件时间和状态元数据。
+// 记录按批写入，每批一个事务，启用发件箱时发件箱记录写入同一事务；一批中有记录写入失败时整批回滚，
+// 再逐条重新写入，以便按行报告失败原因。单条记录的错误不会中止导入。
+//
+// 参数:
+//   - ctx: 取消或超时时停止导入，正在写入的批次回滚；每个批次的事务另受数据表的语句超时限制。
+//   - st: schema and table。
+//   - src: 导入的文件。
+//   - opts: 导入选项。
+//
+// 返回值:
+//   - *ImportReport: 导入结果，出错时为出错前的结果。
+//   - error: 数据表不存在、标题行或映射无效、读取文件失败时返回错误。
+func (s *ApplicationServiceImpl) Import(ctx context.Context, st string, src ImportSource, opts ImportOptions) (report *ImportReport, err error) {
+	ctx, span := startSpan(ctx, "Import", st)
+	defer func() { endSpan(span, err) }()
+	report = &ImportReport{DryRun: opts.DryRun, Failures: []ImportFailure{}}
+	meta, err := s.repo.Schema().Lookup(st)
+	if err != nil {
+		return report, err
+	}
+	if len(meta.Columns) == 0 {
+		return report, schema.NewError(schema.KindNotFound, "数据表不存在")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = ImportBatchSize
+	}
+	cfg := s.tables.Lookup(st)
+	imp := &importer{ctx: ctx, svc: s, st: st, cfg: cfg, meta: meta, opts: opts, report: report}
+
+	var readErr error
+	switch src.Format {
+	case ImportCSV:
+		readErr = imp.readCSV(src, imp.add)
+	case ImportNDJSON:
+		readErr = imp.readNDJSON(src, imp.add)
+	default:
+		return report, &schema.Error{Kind: schema.KindBadRequest, Detail: "不支持的导入格式", Field: "format"}
+	}
+	if readErr != nil {
+		return report, readErr
+	}
+	return report, imp.flush()
+}
+
+// importer 一次导入的状态。
+type importer struct {
+	ctx    context.Context
+	svc    *ApplicationServiceImpl
+	st     string
+	cfg    TableConfig
+	meta   *repository.TableMeta
+	opts   ImportOptions
+	report *ImportReport
+	batch  []importRow
+}
+
+// add 接收读取到的一条记录，err 不为空时记为失败。
+func (imp *importer) add(line int, d map[string]any, err error) error {
+	imp.report.Total++
+	if err == nil {
+		err = imp.coerce(d)
+	}
+	if err == nil && imp.opts.DryRun && imp.cfg.Managed {
+		// 与写入时相同地校验事件时间，在副本上执行
+		err = prepareCreate(imp.cfg, maps.Clone(d), time.Now())
+	}
+	if err != nil {
+		imp.report.fail(line, err)
+		return nil
+	}
+	imp.report.Valid++
+	if imp.opts.DryRun {
+		return nil
+	}
+	imp.batch = append(imp.batch, importRow{line: line, data: d})
+	if len(imp.batch) >= imp.opts.BatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+// flush 写入当前批次。
+func (imp *importer) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	if err := imp.ctx.Err(); err != nil {
+		return err
+	}
+	batch := imp.batch
+	imp.batch = nil
+	s := imp.svc
+	defer s.cache.Invalidate(imp.st)
+
+	type written struct {
+		op, key string
+		data    map[string]any
+	}
+	var results []written
+	now := time.Now()
+	ctx, cancel := s.withTimeout(imp.ctx, imp.st)
+	err := s.repo.Transaction(ctx, func(tx repository.RDBRepo) error {
+		results = results[:0]
+		for _, row := range batch {
+			// 失败后逐条重试，写入前保留原始数据
+			d := maps.Clone(row.data)
+			op, key, err := s.importOne(ctx, tx, imp.st, imp.cfg, d, imp.opts.Upsert, now)
+			if err != nil {
+				return err
+			}
+			results = append(results, written{op, key, d})
+		}
+		return nil
+	})
+	cancel()
+	if err != nil {
+		if err := imp.ctx.Err(); err != nil {
+			return schema.WrapError(schema.KindTimeout, err, "")
+		}
+		// 整批回滚，逐条写入以找出失败的记录
+		results = results[:0]
+		for _, row := range batch {
+			var op, key string
+			d := row.data
+			ctx, cancel := s.withTimeout(imp.ctx, imp.st)
+			err := s.repo.Transaction(ctx, func(tx repository.RDBRepo) error {
+				var err error
+				op, key, err = s.importOne(ctx, tx, imp.st, imp.cfg, d, imp.opts.Upsert, now)
+				return err
+			})
+			cancel()
+			if err != nil {
+				imp.report.fail(row.line, err)
+				continue
+			}
+			results = append(results, written{op, key, d})
+		}
+	}
+
+	for _, w := range results {
+		if w.op == event.OpUpdate {
+			imp.report.Updated++
+		} else {
+			imp.report.Created++
+		}
+		s.publish(imp.ctx, w.op, imp.st, w.key, w.data)
+	}
+	return nil
+}
+
+// importOne 在事务中写入一条记录。
+//
+// 返回值:
+//   - string: 变更类型，新增或更新。
+//   - string: 主键，未知时为空。
+//   - error: 写入失败时返回错误。
+func (s *ApplicationServiceImpl) importOne(ctx context.Context, tx repository.RDBRepo, st string, cfg TableConfig, d map[string]any, upsert bool, now time.Time) (string, string, error) {
+	if upsert {
+		if id, ok := d[cfg.PrimaryKey]; ok && id != nil && fmt.Sprint(id) != "" {
+			key := fmt.Sprint(id)
+			f := [][]string{{"equal", cfg.PrimaryKey, key}}
+			existing, err := tx.Get(ctx, st, []string{cfg.existingColumn()}, f, "")
+			if err != nil {
+				return "", "", err
+			}
+			if len(existing) > 0 {
+				delete(d, cfg.PrimaryKey)
+				if cfg.Managed {
+					if err := prepareUpdate(cfg, d, existing[0], now); err != nil {
+						return "", "", err
+					}
+				}
+				if _, err := tx.Update(ctx, st, d, f); err != nil {
+					return "", "", err
+				}
+				return event.OpUpdate, key, s.importOutbox(ctx, tx, cfg, st, event.OpUpdate, key, nil)
+			}
+			// 主键不存在时按文件中的主键新增
+			if cfg.Managed {
+				if err := prepareCreate(cfg, d, now); err != nil {
+					return "", "", err
+				}
+			}
+			if err := tx.Create(ctx, st, d); err != nil {
+				return "", "", err
+			}
+			return event.OpCreate, key, s.importOutbox(ctx, tx, cfg, st, event.OpCreate, key, nil)
+		}
+	}
+
+	if !cfg.Managed {
+		id, ok := d[cfg.PrimaryKey]
+		if !ok && cfg.IDStrategy == utility.IDStrategyDatabase {
+			key, err := tx.CreateReturning(ctx, st, d, cfg.PrimaryKey)
+			if err != nil {
+				return "", "", err
+			}
+			return event.OpCreate, key, s.importOutbox(ctx, tx, cfg, st, event.OpCreate, key, nil)
+		}
+		if err := tx.Create(ctx, st, d); err != nil {
+			return "", "", err
+		}
+		if !ok || id == nil {
+			return event.OpCreate, "", s.importOutbox(ctx, tx, cfg, st, event.OpCreate, "", d)
+		}
+		key := fmt.Sprintf("%v", id)
+		return event.OpCreate, key, s.importOutbox(ctx, tx, cfg, st, event.OpCreate, key, nil)
+	}
+
+	if err := prepareCreate(cfg, d, now); err != nil {
+		return "", "", err
+	}
+	if cfg.IDStrategy == utility.IDStrategyDatabase {
+		delete(d, cfg.PrimaryKey)
+		key, err := tx.CreateReturning(ctx, st, d, cfg.PrimaryKey)
+		if err != nil {
+			return "", "", err
+		}
+		return event.OpCreate, key, s.importOutbox(ctx, tx, cfg, st, event.OpCreate, key, nil)
+	}
+	key, err := utility.GenerateID(cfg.IDStrategy)
+	if err != nil {
+		return "", "", err
+	}
+	d[cfg.PrimaryKey] = key
+	if err := tx.Create(ctx, st, d); err != nil {
+		return "", "", err
+	}
+	return event.OpCreate, key, s.importOutbox(ctx, tx, cfg, st, event.OpCreate, key, nil)
+}
+
+// importOutbox 数据表启用发件箱时写入发件箱记录，规则与 mutate 相同。
+func (s *ApplicationServiceImpl) importOutbox(ctx context.Context, tx repository.RDBRepo, cfg TableConfig, st string, op string, key string, record map[string]any) error {
+	if !cfg.Outbox || (key == "" && record == nil) {
+		return nil
+	}
+	return appendOutbox(ctx, tx, cfg, st, op, key, record)
+}
+
+// readCSV 逐行读取 CSV，第一行为标题行。
+func (imp *importer) readCSV(src ImportSource, fn func(line int, d map[string]any, err error) error) error {
+	cr := csv.NewReader(src.Reader)
+	if src.Delimiter != 0 {
+		cr.Comma = src.Delimiter
+	}
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return schema.WrapError(schema.KindBadRequest, err, "无法读取标题行")
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	targets := make([]string, len(header))
+	seen := map[string]bool{}
+	sources := map[string]bool{}
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		sources[h] = true
+		column, ok := imp.target(src.Mapping, h)
+		if !ok {
+			continue
+		}
+		if err := imp.checkColumn(column); err != nil {
+			return err
+		}
+		if seen[column] {
+			return &schema.Error{Kind: schema.KindBadRequest, Detail: "重复的列 " + column, Field: column}
+		}
+		seen[column] = true
+		targets[i] = column
+	}
+	for source := range src.Mapping {
+		if !sources[source] {
+			return &schema.Error{Kind: schema.KindBadRequest, Detail: "映射的字段不在标题行中: " + source, Field: "mapping"}
+		}
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if ferr := fn(parseErr.StartLine, nil, schema.WrapError(schema.KindValidation, parseErr.Err, "CSV 格式错误: "+parseErr.Err.Error())); ferr != nil {
+				return ferr
+			}
+			continue
+		}
+		if err != nil {
+			return schema.WrapError(schema.KindBadRequest, err, "读取导入文件失败")
+		}
+		line, _ := cr.FieldPos(0)
+		d := make(map[string]any, len(targets))
+		for i, column := range targets {
+			if column != "" {
+				d[column] = record[i]
+			}
+		}
+		if err := fn(line, d, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// readNDJSON 逐行读取 NDJSON，忽略空行。
+func (imp *importer) readNDJSON(src ImportSource, fn func(line int, d map[string]any, err error) error) error {
+	scanner := bufio.NewScanner(src.Reader)
+	scanner.Buffer(make([]byte, 64<<10), importMaxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			if ferr := fn(line, nil, schema.WrapError(schema.KindValidation, err, "JSON 格式错误: "+err.Error())); ferr != nil {
+				return ferr
+			}
+			continue
+		}
+		d, err := imp.mapObject(src.Mapping, obj)
+		if ferr := fn(line, d, err); ferr != nil {
+			return ferr
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return schema.WrapError(schema.KindBadRequest, err, fmt.Sprintf("第 %d 行超过长度上限", line+1))
+		}
+		return schema.WrapError(schema.KindBadRequest, err, "读取导入文件失败")
+	}
+	return nil
+}
+
+// mapObject 按映射把 NDJSON 记录的字段转换为列。
+func (imp *importer) mapObject(mapping map[string]string, obj map[string]any) (map[string]any, error) {
+	d := make(map[string]any, len(obj))
+	for k, v := range obj {
+		column, ok := imp.target(mapping, k)
+		if !ok {
+			continue
+		}
+		if err := imp.checkColumn(column); err != nil {
+			return nil, err
+		}
+		d[column] = v
+	}
+	return d, nil
+}
+
+// target 获取源字段对应的列，映射不为空且不包含该字段时返回 false。
+func (imp *importer) target(mapping map[string]string, source string) (string, bool) {
+	if len(mapping) == 0 {
+		return source, source != ""
+	}
+	column, ok := mapping[source]
+	return column, ok && column != ""
+}
+
+// checkColumn 检查列是否属于数据表。没有读取到列的数据表（例如未指定 schema 的 PostgreSQL 数据表）不检查。
+func (imp *importer) checkColumn(column string) error {
+	if slices.Equal(imp.meta.Columns, []string{"*"}) || slices.Contains(imp.meta.Columns, column) {
+		return nil
+	}
+	return &schema.Error{Kind: schema.KindBadRequest, Detail: "字段不存在: " + column, Field: column}
+}
+
+// coerce 按列类型转换记录中的值。
+func (imp *importer) coerce(d map[string]any) error {
+	for column, v := range d {
+		typ := imp.meta.Types[column]
+		value, err := coerceValue(typ, v)
+		if err != nil {
+			return &schema.Error{Kind: schema.KindValidation, Detail: fmt.Sprintf("%s 无法转换为 %s: %v", column, typ, err), Field: column, Err: err}
+		}
+		d[column] = value
+	}
+	return nil
+}
+
+// 列类型的分类
+const (
+	kindText = iota
+	kindInteger
+	kindNumber
+	kindBool
+	kindTime
+	kindJSON
+)
+
+// columnKind 按数据库报告的列类型分类。SQLite 按类型亲和性规则，类型名包含 INT 的为整数。
+func columnKind(typ string) int {
+	t := strings.ToLower(strings.TrimSpace(typ))
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = strings.TrimSpace(t[:i])
+	}
+	t = strings.TrimSuffix(t, " unsigned")
+	switch t {
+	case "bool", "boolean":
+		return kindBool
+	case "real", "double", "double precision", "float", "float4", "float8", "numeric", "decimal", "number":
+		return kindNumber
+	case "date", "datetime", "timestamp", "timestamptz", "timestamp with time zone", "timestamp without time zone":
+		return kindTime
+	case "json", "jsonb":
+		return kindJSON
+	case "serial", "bigserial", "smallserial":
+		return kindInteger
+	}
+	if strings.Contains(t, "int") && t != "point" && !strings.Contains(t, "interval") {
+		return kindInteger
+	}
+	return kindText
+}
+
+// coerceValue 把导入的值转换为列类型对应的值。
+//
+// CSV 的值都是文本：非文本列的空字符串视为 NULL，文本列保留空字符串。
+// NDJSON 中的对象和数组写为 JSON 文本。
+func coerceValue(typ string, v any) (any, error) {
+	kind := columnKind(typ)
+	if s, ok := v.(string); ok && s == "" && kind != kindText {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any, []any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if kind != kindJSON && kind != kindText {
+			return nil, errors.New("不能是 JSON 对象或数组")
+		}
+		return string(b), nil
+	case bool:
+		switch kind {
+		case kindBool, kindJSON:
+			return v, nil
+		case kindInteger:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case kindText:
+			return strconv.FormatBool(v), nil
+		}
+		return nil, errors.New("不能是布尔值")
+	}
+
+	text := fmt.Sprint(v)
+	switch kind {
+	case kindInteger:
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case kindNumber:
+		text = strings.TrimSpace(text)
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, err
+		}
+		// 保留原始文本，避免 decimal 列丢失精度
+		return text, nil
+	case kindBool:
+		switch strings.ToLower(strings.TrimSpace(text)) {
+		case "1", "t", "true", "y", "yes":
+			return true, nil
+		case "0", "f", "false", "n", "no":
+			return false, nil
+		}
+		return nil, fmt.Errorf("无法解析布尔值 %q", text)
+	case kindTime:
+		return utility.ParseTime(strings.TrimSpace(text))
+	case kindJSON:
+		if !json.Valid([]byte(text)) {
+			return nil, errors.New("不是有效的 JSON")
+		}
+		return text, nil
+	}
+	return text, nil
+}
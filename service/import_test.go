@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// newImportService 在 newTestService 的数据库上创建服务，stock 为未托管的数据表。
+func newImportService(t *testing.T) (*ApplicationServiceImpl, func() int) {
+	t.Helper()
+	s, db := newTestService(t)
+	if _, err := db.Exec("CREATE TABLE stock (id TEXT PRIMARY KEY, qty INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	plain := defaultTableConfig()
+	plain.Managed = false
+	s = NewApplicationService(s.repo, NewTableRegistry(defaultTableConfig(), map[string]TableConfig{"stock": plain}), nil, nil, nil)
+	count := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM stock").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	return s, count
+}
+
+func TestImportPartialFailure(t *testing.T) {
+	s, count := newImportService(t)
+	// 第 4 行与第 2 行主键重复，与之同批的第 2 行在逐条重试时写入
+	csv := "id,qty\n1,5\n2,x\n1,7\n3,9\n"
+	report, err := s.Import(context.Background(), "stock", ImportSource{Format: ImportCSV, Reader: strings.NewReader(csv)}, ImportOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 4 || report.Valid != 3 || report.Created != 2 || report.Failed != 2 {
+		t.Errorf("got %+v, want 4 read, 3 valid, 2 created and 2 failed", report)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("got %d rows, want 2", n)
+	}
+
+	if len(report.Failures) != 2 {
+		t.Fatalf("got failures %+v, want 2", report.Failures)
+	}
+	coerce, conflict := report.Failures[0], report.Failures[1]
+	if coerce.Line != 3 || coerce.Field != "qty" || coerce.Code != "CRATE-422" || !strings.HasPrefix(coerce.Reason, "qty 无法转换为 INTEGER: ") {
+		t.Errorf("got %+v, want line 3 failing to convert qty", coerce)
+	}
+	if conflict.Line != 4 || conflict.Code != "CRATE-409-UNIQUE" {
+		t.Errorf("got %+v, want line 4 as a unique conflict", conflict)
+	}
+	// 驱动的原始错误只写入日志
+	for _, f := range report.Failures {
+		if reason := strings.ToLower(f.Reason); strings.Contains(reason, "constraint") || strings.Contains(reason, "insert") || strings.Contains(reason, "sqlite") {
+			t.Errorf("line %d: got reason %q, want no driver text", f.Line, f.Reason)
+		}
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	s, count := newImportService(t)
+	ndjson := `{"id": "1", "qty": 5}
+{"id": "2", "qty": "many"}
+{"id": "3",
+{"id": "4", "qty": 1}
+`
+	report, err := s.Import(context.Background(), "stock", ImportSource{Format: ImportNDJSON, Reader: strings.NewReader(ndjson)}, ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Total != 4 || report.Valid != 2 || report.Created != 0 || report.Failed != 2 {
+		t.Errorf("got %+v, want 4 read, 2 valid and nothing written", report)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("got %d rows after a dry run, want 0", n)
+	}
+	if len(report.Failures) != 2 || report.Failures[0].Line != 2 || report.Failures[1].Line != 3 || !strings.HasPrefix(report.Failures[1].Reason, "JSON 格式错误: ") {
+		t.Errorf("got failures %+v, want lines 2 and 3", report.Failures)
+	}
+}
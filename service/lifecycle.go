@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// Lifecycle 记录生命周期状态机。
+type Lifecycle struct {
+	// Initial 新建记录的状态。
+	Initial string `json:"initial"`
+	// States 允许的状态。
+	States []string `json:"states"`
+	// Transitions 每个状态允许迁移到的状态。
+	Transitions map[string][]string `json:"transitions"`
+}
+
+// defaultLifecycle 未配置生命周期的数据表所使用的状态机，与 PUT 的 d 参数保持一致。
+func defaultLifecycle() *Lifecycle {
+	return &Lifecycle{
+		Initial: "active",
+		States:  []string{"active", "deprecated"},
+		Transitions: map[string][]string{
+			"active":     {"deprecated"},
+			"deprecated": {"active"},
+		},
+	}
+}
+
+func (l *Lifecycle) validate() error {
+	if len(l.States) == 0 {
+		return fmt.Errorf("生命周期未声明状态")
+	}
+	if !slices.Contains(l.States, l.Initial) {
+		return fmt.Errorf("初始状态 %q 不在声明的状态中", l.Initial)
+	}
+	for from, targets := range l.Transitions {
+		if !slices.Contains(l.States, from) {
+			return fmt.Errorf("状态 %q 不在声明的状态中", from)
+		}
+		for _, to := range targets {
+			if !slices.Contains(l.States, to) {
+				return fmt.Errorf("状态 %q 不在声明的状态中", to)
+			}
+		}
+	}
+	return nil
+}
+
+// CanTransition 判断状态迁移是否合法。
+func (l *Lifecycle) CanTransition(from string, to string) bool {
+	return slices.Contains(l.Transitions[from], to)
+}
+
+// Transition 迁移记录的生命周期状态。
+//
+// json 方式下将状态、操作人和时间写入状态列，并追加到 transitions 历史；
+// columns 方式下只更新状态列和更新时间列。
+// 写入时比较读取到的状态，期间状态已被其他请求修改时返回冲突错误，不覆盖对方的迁移。
+//
+// 参数:
+//   - ctx: 限制读取和写入的时长。
+//   - st: schema and table。
+//   - id: 主键。
+//   - to: 目标状态。
+//   - by: 操作人。
+//   - note: 备注。
+//
+// 返回值:
+//   - map[string]any: 迁移记录，包含 from、to、by、at。
+//   - error: 目标状态未声明时返回校验错误，迁移不被允许或状态已被并发修改时返回冲突错误。
+func (s *ApplicationServiceImpl) Transition(ctx context.Context, st string, id string, to string, by string, note string) (record map[string]any, err error) {
+	ctx, span := startSpan(ctx, "Transition", st)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := s.withTimeout(ctx, st)
+	defer cancel()
+	return s.transition(ctx, st, id, to, by, note, false)
+}
+
+// transition 迁移记录的生命周期状态，stay 为 true 时记录已处于目标状态视为成功，不写入。
+func (s *ApplicationServiceImpl) transition(ctx context.Context, st string, id string, to string, by string, note string, stay bool) (map[string]any, error) {
+	cfg := s.tables.Lookup(st)
+	if !cfg.lifecycleEnabled() {
+		return nil, schema.NewError(schema.KindBadRequest, "数据表未启用生命周期状态")
+	}
+	lifecycle := cfg.lifecycle()
+	if !slices.Contains(lifecycle.States, to) {
+		return nil, &schema.Error{Kind: schema.KindValidation, Code: "CRATE-422-STATE", Detail: fmt.Sprintf("未声明的状态 %s", to), Field: "to"}
+	}
+
+	f := [][]string{{"equal", cfg.PrimaryKey, id}}
+	column := cfg.StatusColumn
+	if cfg.State == StateJSON {
+		column = cfg.StateColumn
+	}
+	existingData, err := s.repo.Get(ctx, st, []string{column}, f, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(existingData) == 0 {
+		return nil, schema.NewError(schema.KindNotFound, "记录不存在")
+	}
+
+	now := time.Now()
+	d := map[string]any{}
+	// guard 写入时比较的条件：读取后状态被修改则不匹配任何记录
+	guard := slices.Clone(f)
+	var from string
+	switch cfg.State {
+	case StateJSON:
+		state := map[string]any{}
+		if raw, ok := existingData[0][column].(string); ok && raw != "" {
+			if err := json.Unmarshal([]byte(raw), &state); err != nil {
+				return nil, err
+			}
+		}
+		from, _ = state["status"].(string)
+		if from != "" {
+			guard = append(guard, []string{"json-field-in", column, "status", from})
+		} else {
+			from = lifecycle.Initial
+		}
+		// 每次迁移都会更新 status_at，状态经过其他迁移回到 from 时同样不匹配
+		if at, ok := state["status_at"].(string); ok {
+			guard = append(guard, []string{"json-field-in", column, "status_at", at})
+		}
+		if stay && from == to {
+			return map[string]any{"from": from, "to": to}, nil
+		}
+		if !lifecycle.CanTransition(from, to) {
+			return nil, illegalTransition(from, to)
+		}
+
+		record := map[string]any{"from": from, "to": to, "by": by, "at": utility.FormatTime(now)}
+		if note != "" {
+			record["note"] = note
+		}
+		history, _ := state["transitions"].([]any)
+		state["transitions"] = append(history, record)
+		state["status"] = to
+		state["status_by"] = by
+		state["status_at"] = utility.FormatTime(now)
+		state["updated_at"] = utility.FormatTime(now)
+		stateJson, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+		d[column] = string(stateJson)
+	case StateColumns:
+		from, _ = existingData[0][column].(string)
+		if from != "" {
+			guard = append(guard, []string{"equal", column, from})
+		} else {
+			from = lifecycle.Initial
+		}
+		if stay && from == to {
+			return map[string]any{"from": from, "to": to}, nil
+		}
+		if !lifecycle.CanTransition(from, to) {
+			return nil, illegalTransition(from, to)
+		}
+		d[column] = to
+		if cfg.UpdatedAtColumn != "" {
+			d[cfg.UpdatedAtColumn] = now.UTC()
+		}
+	}
+
+	_, err = s.mutate(ctx, st, event.OpUpdate, func(repo repository.RDBRepo) (string, map[string]any, error) {
+		n, err := repo.Update(ctx, st, d, guard)
+		if err == nil && n == 0 {
+			// 记录在读取后被删除，或状态已被其他请求修改
+			err = &schema.Error{Kind: schema.KindConflict, Code: "CRATE-409-TRANSITION", Detail: fmt.Sprintf("记录的状态已不是 %s", from), Field: "to"}
+		}
+		return id, nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publish(ctx, event.OpUpdate, st, id, d)
+	return map[string]any{"from": from, "to": to, "by": by, "at": utility.FormatTime(now)}, nil
+}
+
+func illegalTransition(from string, to string) error {
+	return &schema.Error{Kind: schema.KindConflict, Code: "CRATE-409-TRANSITION", Detail: fmt.Sprintf("不允许从 %s 迁移到 %s", from, to), Field: "to"}
+}
+
+// LifecycleFilter 生成按生命周期状态过滤的条件。
+//
+// 参数:
+//   - st: schema and table。
+//   - states: 状态，满足其一即可。
+//
+// 返回值:
+//   - []string: 过滤条件。
+//   - error: 数据表未启用生命周期状态或状态未声明时返回错误。
+func (s *ApplicationServiceImpl) LifecycleFilter(st string, states []string) ([]string, error) {
+	cfg := s.tables.Lookup(st)
+	lifecycle := cfg.lifecycle()
+	for _, state := range states {
+		if !slices.Contains(lifecycle.States, state) {
+			return nil, &schema.Error{Kind: schema.KindBadRequest, Detail: fmt.Sprintf("未声明的状态 %s", state), Field: "s"}
+		}
+	}
+	switch {
+	case !cfg.lifecycleEnabled():
+		return nil, schema.NewError(schema.KindBadRequest, "数据表未启用生命周期状态")
+	case cfg.State == StateJSON:
+		return append([]string{"json-field-in", cfg.StateColumn, "status"}, states...), nil
+	}
+	return append([]string{"in", cfg.StatusColumn}, states...), nil
+}
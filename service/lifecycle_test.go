@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+)
+
+// racingRepo 在第一次读取之后执行 hook，模拟在读取与写入之间完成的另一个请求。
+type racingRepo struct {
+	repository.RDBRepo
+	hook func()
+}
+
+func (r *racingRepo) Get(ctx context.Context, st string, c []string, f [][]string, l string) ([]map[string]interface{}, error) {
+	rows, err := r.RDBRepo.Get(ctx, st, c, f, l)
+	if hook := r.hook; hook != nil {
+		r.hook = nil
+		hook()
+	}
+	return rows, err
+}
+
+// newLifecycleService 在 newTestService 的数据库上创建服务：managed 使用默认的 json 方式，
+// tasks 使用 columns 方式和自定义的状态机。
+func newLifecycleService(t *testing.T) (*ApplicationServiceImpl, *TableRegistry) {
+	t.Helper()
+	s, db := newTestService(t)
+	if _, err := db.Exec("CREATE TABLE tasks (id TEXT PRIMARY KEY, title TEXT, event_time TEXT, created_at TEXT, updated_at TEXT, status TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	tasks := defaultTableConfig()
+	tasks.State = StateColumns
+	tasks.Lifecycle = &Lifecycle{
+		Initial: "draft",
+		States:  []string{"draft", "review", "published"},
+		Transitions: map[string][]string{
+			"draft":  {"review"},
+			"review": {"draft", "published"},
+		},
+	}
+	tables := NewTableRegistry(defaultTableConfig(), map[string]TableConfig{"tasks": tasks})
+	return NewApplicationService(s.repo, tables, nil, nil, nil), tables
+}
+
+// status 读取记录的状态和迁移历史的长度。
+func status(t *testing.T, s *ApplicationServiceImpl, st string, id string) (string, int) {
+	t.Helper()
+	record, err := s.GetByID(context.Background(), st, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == "tasks" {
+		return record["status"].(string), 0
+	}
+	var state struct {
+		Status      string `json:"status"`
+		Transitions []any  `json:"transitions"`
+	}
+	if err := json.Unmarshal([]byte(record["data_state"].(string)), &state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Status, len(state.Transitions)
+}
+
+func TestTransition(t *testing.T) {
+	s, _ := newLifecycleService(t)
+	ctx := context.Background()
+	id, err := s.Create(ctx, "managed", map[string]any{"title": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := s.Transition(ctx, "managed", id, "deprecated", "u1", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["from"] != "active" || record["to"] != "deprecated" || record["by"] != "u1" {
+		t.Errorf("got %v, want active to deprecated by u1", record)
+	}
+	if got, n := status(t, s, "managed", id); got != "deprecated" || n != 1 {
+		t.Errorf("got %s with %d transitions, want deprecated with 1", got, n)
+	}
+
+	tests := []struct {
+		name string
+		st   string
+		id   string
+		to   string
+		kind schema.ErrorKind
+		code string
+	}{
+		{"not allowed", "managed", id, "deprecated", schema.KindConflict, "CRATE-409-TRANSITION"},
+		{"undeclared state", "managed", id, "archived", schema.KindValidation, "CRATE-422-STATE"},
+		{"missing record", "managed", "missing", "active", schema.KindNotFound, ""},
+		{"no lifecycle", "plain", "1", "active", schema.KindBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Transition(ctx, tt.st, tt.id, tt.to, "u2", "")
+			if e := schema.AsError(err); e.Kind != tt.kind || (tt.code != "" && e.Code != tt.code) {
+				t.Errorf("got %v (%s %s), want %s %s", err, e.Kind, e.Code, tt.kind, tt.code)
+			}
+		})
+	}
+	if got, n := status(t, s, "managed", id); got != "deprecated" || n != 1 {
+		t.Errorf("got %s with %d transitions after rejected transitions, want deprecated with 1", got, n)
+	}
+}
+
+func TestTransitionConcurrent(t *testing.T) {
+	for _, st := range []string{"managed", "tasks"} {
+		t.Run(st, func(t *testing.T) {
+			s, tables := newLifecycleService(t)
+			ctx := context.Background()
+			id, err := s.Create(ctx, st, map[string]any{"title": "a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			to := "deprecated"
+			if st == "tasks" {
+				to = "review"
+			}
+
+			// 另一个请求在读取之后、写入之前完成了同样的迁移
+			racing := &racingRepo{RDBRepo: s.repo}
+			racing.hook = func() {
+				if _, err := s.Transition(ctx, st, id, to, "other", ""); err != nil {
+					t.Errorf("concurrent transition: %v", err)
+				}
+			}
+			_, err = NewApplicationService(racing, tables, nil, nil, nil).Transition(ctx, st, id, to, "u1", "")
+			if e := schema.AsError(err); e.Kind != schema.KindConflict || e.Code != "CRATE-409-TRANSITION" {
+				t.Errorf("got %v, want a transition conflict", err)
+			}
+			if got, n := status(t, s, st, id); got != to || (st == "managed" && n != 1) {
+				t.Errorf("got %s with %d transitions, want only the concurrent transition to %s", got, n, to)
+			}
+		})
+	}
+}
+
+func TestStatusOnlyChangesThroughTransitions(t *testing.T) {
+	s, _ := newLifecycleService(t)
+	ctx := context.Background()
+	task, err := s.Create(ctx, "tasks", map[string]any{"title": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := s.Create(ctx, "managed", map[string]any{"title": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Update(ctx, "tasks", map[string]any{"title": "b", "status": "published"}, task, false)
+	if e := schema.AsError(err); e.Kind != schema.KindValidation || e.Field != "status" {
+		t.Errorf("status write: got %v, want a validation error on status", err)
+	}
+	// tasks 的状态机没有 deprecated
+	err = s.Update(ctx, "tasks", map[string]any{"title": "b"}, task, true)
+	if e := schema.AsError(err); e.Kind != schema.KindValidation || e.Field != "d" {
+		t.Errorf("deprecate tasks: got %v, want a validation error on d", err)
+	}
+	if got, _ := status(t, s, "tasks", task); got != "draft" {
+		t.Errorf("tasks: got %s after rejected updates, want draft", got)
+	}
+
+	// d=1 是到 deprecated 的迁移，已经弃用的记录再次弃用不报错
+	for i := 0; i < 2; i++ {
+		if err := s.Update(ctx, "managed", map[string]any{"title": "b"}, record, true); err != nil {
+			t.Fatalf("deprecate %d: %v", i, err)
+		}
+	}
+	if got, n := status(t, s, "managed", record); got != "deprecated" || n != 1 {
+		t.Errorf("managed: got %s with %d transitions, want deprecated with 1", got, n)
+	}
+	if r, _ := s.GetByID(ctx, "managed", record); r["title"] != "b" {
+		t.Errorf("managed: got title %v, want the update written with the transition", r["title"])
+	}
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// mutate 执行写入。数据表启用发件箱时，写入与发件箱记录在同一事务中提交。
+//
+// 参数:
+//   - ctx: 限制事务的时长。
+//   - st: schema and table。
+//   - op: 变更类型。
+//   - fn: 写入，返回主键和记录；记录为 nil 时在事务中按主键读取，二者都为空时不写入发件箱。
+//
+// 返回值:
+//   - string: fn 返回的主键。
+//   - error: 写入或发件箱记录失败时返回错误，二者一同回滚。
+func (s *ApplicationServiceImpl) mutate(ctx context.Context, st string, op string, fn func(repo repository.RDBRepo) (string, map[string]any, error)) (string, error) {
+	// 写入失败时同样使缓存失效：无法确定数据库是否已经执行
+	defer s.cache.Invalidate(st)
+
+	cfg := s.tables.Lookup(st)
+	if !cfg.Outbox {
+		key, _, err := fn(s.repo)
+		return key, err
+	}
+
+	var key string
+	err := s.repo.Transaction(ctx, func(tx repository.RDBRepo) error {
+		var record map[string]any
+		var err error
+		key, record, err = fn(tx)
+		if err != nil || (key == "" && record == nil) {
+			return err
+		}
+		return appendOutbox(ctx, tx, cfg, st, op, key, record)
+	})
+	return key, err
+}
+
+// appendOutbox 写入发件箱记录。
+func appendOutbox(ctx context.Context, tx repository.RDBRepo, cfg TableConfig, st string, op string, key string, record map[string]any) error {
+	if record == nil && key != "" && op != event.OpDelete {
+		rows, err := tx.Get(ctx, st, nil, [][]string{{"equal", cfg.PrimaryKey, key}}, "")
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			record = rows[0]
+		}
+	}
+	if record == nil {
+		record = map[string]any{cfg.PrimaryKey: key}
+	}
+	snapshot := make(map[string]any, len(record))
+	for k, v := range record {
+		snapshot[k] = v
+	}
+	utility.LocalizeRecord(snapshot, time.UTC, nil, nil)
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	id, err := utility.GenerateKsuid()
+	if err != nil {
+		return err
+	}
+	return tx.Create(ctx, cfg.OutboxTable, map[string]any{
+		"event_id":   id,
+		"table_name": st,
+		"op":         op,
+		"record_key": key,
+		"payload":    string(payload),
+		"created_at": time.Now().UTC(),
+		"dispatched": 0,
+	})
+}
+
+const (
+	// outboxLease 中继租约时长，持有者每次轮询时续约。
+	outboxLease = 30 * time.Second
+	// outboxLeaseMargin 租约剩余时间少于该值时停止投递，避免租约过期后与新持有者重复投递。
+	outboxLeaseMargin = 5 * time.Second
+	// outboxBatch 每次读取的事件数量。
+	outboxBatch = 100
+	// outboxMaxBackoff Sink 连续失败时的最大重试间隔。
+	outboxMaxBackoff = time.Minute
+)
+
+// OutboxRelay 按写入顺序将发件箱事件交给 Sink，并标记为已投递。
+//
+// 多个服务实例通过租约表选出一个中继持有者，只有持有者读取发件箱，
+// 因此事件不会被多个实例同时投递；持有者退出后租约过期，由其他实例接管。
+type OutboxRelay struct {
+	repo       repository.RDBRepo
+	backend    string
+	table      string
+	leaseTable string
+	sinks      []event.Sink
+	owner      string
+	leaseUntil time.Time
+}
+
+// NewOutboxRelay 创建发件箱中继。
+//
+// 参数:
+//   - repo: 发件箱所在数据库的仓储。
+//   - backend: 数据库后端名称。
+//   - table: 发件箱表，租约表为 table + "_lease"。
+//   - sinks: 事件接收方，按顺序投递。
+//
+// 返回值:
+//   - *OutboxRelay: 中继。
+func NewOutboxRelay(repo repository.RDBRepo, backend string, table string, sinks []event.Sink) *OutboxRelay {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &OutboxRelay{
+		repo:       repo,
+		backend:    backend,
+		table:      table,
+		leaseTable: table + "_lease",
+		sinks:      sinks,
+		owner:      fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b)),
+	}
+}
+
+// Run 轮询发件箱，直到 ctx 取消。
+func (r *OutboxRelay) Run(ctx context.Context) {
+	delay := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		n, err := r.relay(ctx)
+		switch {
+		case err != nil:
+			utility.ZapLogger.Warn("发件箱中继失败", zap.String("backend", r.backend), zap.String("table", r.table), zap.Error(err))
+			delay = min(delay*2, outboxMaxBackoff)
+		case n == outboxBatch:
+			// 还有未投递的事件，立即继续
+			delay = 0
+		default:
+			delay = time.Second
+		}
+	}
+}
+
+// Flush 投递发件箱中剩余的事件，直到没有未投递的事件、投递失败或 ctx 结束。
+//
+// 用于停止服务前清空发件箱，调用前须先停止 Run。未持有租约时不投递，由持有者继续投递；
+// 持有租约时结束后释放租约，其他实例无需等待租约过期即可接管。
+//
+// 参数:
+//   - ctx: 限制投递的时长。
+//
+// 返回值:
+//   - int: 投递的事件数量。
+//   - error: 续约、读取或投递失败时返回错误。
+func (r *OutboxRelay) Flush(ctx context.Context) (int, error) {
+	defer r.release()
+	total := 0
+	for ctx.Err() == nil {
+		n, err := r.relay(ctx)
+		total += n
+		if err != nil || n < outboxBatch {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
+
+// relay 持有租约时投递一批事件。
+//
+// 返回值:
+//   - int: 读取的事件数量。
+//   - error: 续约、读取或投递失败时返回错误。
+func (r *OutboxRelay) relay(ctx context.Context) (int, error) {
+	held, err := r.acquire(ctx)
+	if err != nil || !held {
+		return 0, err
+	}
+
+	rows, err := r.repo.Get(ctx, r.table, []string{"id", "event_id", "table_name", "op", "record_key", "payload", "created_at"},
+		[][]string{{"equal", "dispatched", "0"}}, "ORDER BY id LIMIT "+strconv.Itoa(outboxBatch))
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		if time.Until(r.leaseUntil) < outboxLeaseMargin {
+			return 0, nil
+		}
+		e := outboxEvent(r.backend, row)
+		for _, sink := range r.sinks {
+			if err := sink.Send(ctx, e); err != nil {
+				// 保持顺序：本批次其余事件等待下一次重试
+				return 0, fmt.Errorf("投递事件 %s 到 %s 失败: %w", e.ID, sink.Name(), err)
+			}
+		}
+		_, err := r.repo.Update(ctx, r.table, map[string]any{"dispatched": 1, "dispatched_at": time.Now().UTC()},
+			[][]string{{"equal", "id", e.Sequence}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), nil
+}
+
+func outboxEvent(backend string, row map[string]any) event.OutboxEvent {
+	str := func(k string) string {
+		switch v := row[k].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		case []byte:
+			return string(v)
+		case time.Time:
+			return utility.FormatTime(v)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return event.OutboxEvent{
+		ID:       str("event_id"),
+		Sequence: str("id"),
+		Backend:  backend,
+		Table:    str("table_name"),
+		Type:     str("op"),
+		Key:      str("record_key"),
+		Record:   json.RawMessage(str("payload")),
+		At:       str("created_at"),
+	}
+}
+
+// release 释放当前实例持有的租约，停止服务时的 ctx 可能已经结束，因此使用独立的超时。
+func (r *OutboxRelay) release() {
+	if r.leaseUntil.IsZero() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), outboxLeaseMargin)
+	defer cancel()
+	_, err := r.repo.Update(ctx, r.leaseTable, map[string]any{"expires_ms": 0},
+		[][]string{{"equal", "name", r.table}, {"equal", "owner", r.owner}})
+	if err != nil {
+		utility.ZapLogger.Warn("释放发件箱租约失败", zap.String("backend", r.backend), zap.String("table", r.table), zap.Error(err))
+	}
+	r.leaseUntil = time.Time{}
+}
+
+// acquire 获取或续约租约。
+//
+// 租约以比较并交换的方式更新：只有 owner 与 expires_ms 仍为读取时的值才会更新，
+// 更新后重新读取确认持有者，因此多个实例同时抢占时只有一个成功。
+//
+// 返回值:
+//   - bool: 当前实例是否持有租约。
+//   - error: 读写租约表失败时返回错误。
+func (r *OutboxRelay) acquire(ctx context.Context) (bool, error) {
+	f := [][]string{{"equal", "name", r.table}}
+	now := time.Now()
+	expires := now.Add(outboxLease)
+	expiresMs := strconv.FormatInt(expires.UnixMilli(), 10)
+
+	rows, err := r.repo.Get(ctx, r.leaseTable, []string{"owner", "expires_ms"}, f, "")
+	if err != nil {
+		return false, err
+	}
+	if len(rows) == 0 {
+		err := r.repo.Create(ctx, r.leaseTable, map[string]any{"name": r.table, "owner": r.owner, "expires_ms": expires.UnixMilli()})
+		if err != nil {
+			if schema.AsError(err).Kind == schema.KindConflict {
+				// 其他实例先创建了租约
+				return false, nil
+			}
+			return false, err
+		}
+		r.leaseUntil = expires
+		return true, nil
+	}
+
+	owner := fmt.Sprint(rows[0]["owner"])
+	current, err := strconv.ParseInt(fmt.Sprint(rows[0]["expires_ms"]), 10, 64)
+	if err != nil {
+		return false, err
+	}
+	if owner != r.owner && now.UnixMilli() < current {
+		return false, nil
+	}
+
+	_, err = r.repo.Update(ctx, r.leaseTable, map[string]any{"owner": r.owner, "expires_ms": expires.UnixMilli()},
+		append(f, []string{"equal", "owner", owner}, []string{"equal", "expires_ms", strconv.FormatInt(current, 10)}))
+	if err != nil {
+		return false, err
+	}
+	rows, err = r.repo.Get(ctx, r.leaseTable, []string{"owner", "expires_ms"}, f, "")
+	if err != nil || len(rows) == 0 {
+		return false, err
+	}
+	if fmt.Sprint(rows[0]["owner"]) != r.owner || fmt.Sprint(rows[0]["expires_ms"]) != expiresMs {
+		return false, nil
+	}
+	r.leaseUntil = expires
+	return true, nil
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"ovaphlow.com/crate/data/event"
+)
+
+// recordingSink 记录收到的事件的主键，err 不为 nil 时投递失败。
+type recordingSink struct {
+	keys []string
+	err  error
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Send(ctx context.Context, e event.OutboxEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.keys = append(s.keys, e.Key)
+	return nil
+}
+
+// newOutboxService 在 newTestService 的数据库上创建发件箱表和租约表，items 启用发件箱。
+func newOutboxService(t *testing.T) (*ApplicationServiceImpl, *sql.DB) {
+	t.Helper()
+	s, db := newTestService(t)
+	for _, q := range []string{
+		`CREATE TABLE crate_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id TEXT NOT NULL UNIQUE,
+			table_name TEXT NOT NULL,
+			op TEXT NOT NULL,
+			record_key TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			dispatched INTEGER NOT NULL DEFAULT 0,
+			dispatched_at TEXT
+		)`,
+		"CREATE TABLE crate_outbox_lease (name TEXT PRIMARY KEY, owner TEXT NOT NULL, expires_ms INTEGER NOT NULL)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, db
+}
+
+// createItems 在 items 中创建记录。
+func createItems(t *testing.T, s *ApplicationServiceImpl, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if _, err := s.Create(context.Background(), "items", map[string]any{"id": id, "title": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOutboxWrittenWithData(t *testing.T) {
+	s, db := newOutboxService(t)
+	createItems(t, s, "1")
+	// 主键重复，写入与发件箱记录一同回滚
+	if _, err := s.Create(context.Background(), "items", map[string]any{"id": "1", "title": "again"}); err == nil {
+		t.Fatal("got no error for a duplicate key")
+	}
+
+	var n int
+	var key, op, payload string
+	if err := db.QueryRow("SELECT COUNT(*), MAX(record_key), MAX(op), MAX(payload) FROM crate_outbox").Scan(&n, &key, &op, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || key != "1" || op != event.OpCreate || payload != `{"id":"1","title":"1"}` {
+		t.Errorf("got %d events, last %s %s %s, want the create of 1 only", n, op, key, payload)
+	}
+}
+
+func TestOutboxRelayLease(t *testing.T) {
+	s, db := newOutboxService(t)
+	ctx := context.Background()
+	a, b := &recordingSink{}, &recordingSink{}
+	relayA := NewOutboxRelay(s.repo, "sqlite", "crate_outbox", []event.Sink{a})
+	relayB := NewOutboxRelay(s.repo, "sqlite", "crate_outbox", []event.Sink{b})
+	relay := func(r *OutboxRelay) int {
+		t.Helper()
+		n, err := r.relay(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	createItems(t, s, "1", "2")
+	if n := relay(relayA); n != 2 {
+		t.Errorf("holder: got %d events, want 2", n)
+	}
+	createItems(t, s, "3")
+	if n := relay(relayB); n != 0 {
+		t.Errorf("other instance: got %d events while the lease is held, want 0", n)
+	}
+	if n := relay(relayA); n != 1 {
+		t.Errorf("holder renewing: got %d events, want 1", n)
+	}
+
+	// 持有者释放租约后其他实例立即接管
+	relayA.release()
+	createItems(t, s, "4")
+	if n := relay(relayB); n != 1 {
+		t.Errorf("after release: got %d events, want 1", n)
+	}
+
+	// 持有者退出而未释放，租约过期后由其他实例接管
+	createItems(t, s, "5")
+	if _, err := db.Exec("UPDATE crate_outbox_lease SET expires_ms = ?", time.Now().Add(-time.Second).UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if n := relay(relayA); n != 1 {
+		t.Errorf("after expiry: got %d events, want 1", n)
+	}
+	if n := relay(relayB); n != 0 {
+		t.Errorf("previous holder: got %d events after the lease was taken over, want 0", n)
+	}
+
+	// 每个事件只投递一次，按写入顺序
+	if fmt.Sprint(a.keys) != "[1 2 3 5]" || fmt.Sprint(b.keys) != "[4]" {
+		t.Errorf("got %v and %v, want [1 2 3 5] and [4]", a.keys, b.keys)
+	}
+	var pending int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crate_outbox WHERE dispatched = 0").Scan(&pending); err != nil || pending != 0 {
+		t.Errorf("got %d pending events (%v), want 0", pending, err)
+	}
+}
+
+func TestOutboxRelaySinkFailure(t *testing.T) {
+	s, _ := newOutboxService(t)
+	ctx := context.Background()
+	sink := &recordingSink{err: errors.New("unavailable")}
+	relay := NewOutboxRelay(s.repo, "sqlite", "crate_outbox", []event.Sink{sink})
+
+	createItems(t, s, "1", "2")
+	if _, err := relay.relay(ctx); err == nil {
+		t.Fatal("got no error from a failing sink")
+	}
+	// 失败的事件保留在发件箱中，恢复后按顺序重新投递
+	sink.err = nil
+	n, err := relay.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || fmt.Sprint(sink.keys) != "[1 2]" {
+		t.Errorf("got %d events %v, want [1 2]", n, sink.keys)
+	}
+	if !relay.leaseUntil.IsZero() {
+		t.Error("got the lease held after Flush, want it released")
+	}
+}
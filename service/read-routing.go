@@ -0,0 +1,114 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"ovaphlow.com/crate/data/repository"
+)
+
+// ReadRouting 把读取分发到只读副本，写入仍然使用主库。
+//
+// 客户端写入后的 window 时间内，该客户端的读取使用主库，保证能读到自己的写入。
+type ReadRouting struct {
+	replicas *repository.ReplicaSet
+	window   time.Duration
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+	swept  time.Time
+}
+
+// NewReadRouting 创建只读副本路由。
+//
+// 参数:
+//   - replicas: 只读副本。
+//   - window: 客户端写入后读取主库的时长，0 表示不保证读到自己的写入。
+//
+// 返回值:
+//   - *ReadRouting: 只读副本路由。
+func NewReadRouting(replicas *repository.ReplicaSet, window time.Duration) *ReadRouting {
+	return &ReadRouting{replicas: replicas, window: window, writes: map[string]time.Time{}}
+}
+
+// wrote 记录客户端的写入时间，并清理已过期的记录。
+func (rr *ReadRouting) wrote(client string) {
+	if rr.window <= 0 || client == "" {
+		return
+	}
+	now := time.Now()
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.writes[client] = now
+	if now.Sub(rr.swept) < rr.window {
+		return
+	}
+	for c, t := range rr.writes {
+		if now.Sub(t) >= rr.window {
+			delete(rr.writes, c)
+		}
+	}
+	rr.swept = now
+}
+
+// reader 选择客户端读取使用的只读副本。
+//
+// 返回值:
+//   - repository.RDBRepo: 只读副本的仓储。
+//   - string: 只读副本名称。
+//   - bool: 客户端刚写入过或没有可用的只读副本时返回 false，应读取主库。
+func (rr *ReadRouting) reader(client string) (repository.RDBRepo, string, bool) {
+	if rr.window > 0 && client != "" {
+		rr.mu.Lock()
+		t, ok := rr.writes[client]
+		rr.mu.Unlock()
+		if ok && time.Since(t) < rr.window {
+			return nil, "", false
+		}
+	}
+	return rr.replicas.Reader()
+}
+
+// ForRead 返回处理客户端读取的应用服务。
+//
+// 配置了只读副本时，返回的应用服务从一个可用的只读副本读取；以下情况返回主库的应用服务：
+// 没有只读副本或都不可用、primary 为 true、客户端在读写一致窗口内写入过。
+// 写入和写入前的读取始终使用主库。
+//
+// 参数:
+//   - client: 客户端标识，用于读到自己的写入。
+//   - primary: 是否强制读取主库。
+//
+// 返回值:
+//   - *ApplicationServiceImpl: 处理读取的应用服务。
+//   - string: 只读副本名称，读取主库时为 "primary"。
+func (s *ApplicationServiceImpl) ForRead(client string, primary bool) (*ApplicationServiceImpl, string) {
+	if s.reads == nil || primary {
+		return s, "primary"
+	}
+	repo, name, ok := s.reads.reader(client)
+	if !ok {
+		return s, "primary"
+	}
+	replica := *s
+	replica.reader = repo
+	return &replica, name
+}
+
+// Wrote 记录客户端的写入，读写一致窗口内该客户端的读取使用主库。
+//
+// 参数:
+//   - client: 客户端标识。
+func (s *ApplicationServiceImpl) Wrote(client string) {
+	if s.reads != nil {
+		s.reads.wrote(client)
+	}
+}
+
+// read 返回读取使用的仓储，未绑定只读副本时为主库。
+func (s *ApplicationServiceImpl) read() repository.RDBRepo {
+	if s.reader != nil {
+		return s.reader
+	}
+	return s.repo
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"container/list"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache 进程内的列表查询缓存，所有数据库后端共用一个内存上限。
+//
+// 缓存按数据表失效：服务写入数据表或变更总线收到该表的变更时，该表的所有缓存被删除。
+// 每个数据表有一个代数，查询前记录代数，写回时代数已变化说明期间发生过写入，结果不写入缓存，
+// 因此写入完成后不会再读到写入前的结果。其他实例的写入只有经变更捕获才能使缓存失效，否则由 TTL 限定过期时间。
+//
+// 只为有缓存结果或有进行中查询的数据表保留状态，写入不存在的数据表或没有缓存的数据表不占用内存。
+type ResponseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	tables   map[string]*tableCache
+	stats    CacheStats
+}
+
+// tableCache 数据表的缓存键、代数和进行中的查询数。
+type tableCache struct {
+	keys       map[string]struct{}
+	generation uint64
+	pending    int
+}
+
+// CacheStats 缓存统计。
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Bypasses      uint64 `json:"bypasses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Bytes         int64  `json:"bytes"`
+	MaxBytes      int64  `json:"max_bytes"`
+}
+
+type cacheEntry struct {
+	key     string
+	table   string
+	records []map[string]any
+	size    int64
+	expires time.Time
+}
+
+// NewResponseCache 创建缓存。
+//
+// 参数:
+//   - maxBytes: 缓存结果的总大小上限，按结果的 JSON 长度估算，超过时淘汰最久未使用的结果。
+//
+// 返回值:
+//   - *ResponseCache: 缓存。
+func NewResponseCache(maxBytes int64) *ResponseCache {
+	return &ResponseCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		tables:   map[string]*tableCache{},
+	}
+}
+
+// For 获取数据库后端的缓存。
+func (c *ResponseCache) For(backend string) *BackendCache {
+	if c == nil {
+		return nil
+	}
+	return &BackendCache{cache: c, backend: backend}
+}
+
+// Stats 获取缓存统计。
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+// BackendCache 一个数据库后端的缓存，缓存键和失效都限定在该后端内。
+type BackendCache struct {
+	cache   *ResponseCache
+	backend string
+}
+
+// Invalidate 删除数据表的所有缓存。数据表没有缓存结果也没有进行中的查询时不做任何事。
+func (b *BackendCache) Invalidate(st string) {
+	if b == nil {
+		return
+	}
+	c := b.cache
+	table := b.backend + "\x00" + st
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tables[table]; ok {
+		c.invalidate(table, t)
+	}
+}
+
+// InvalidateAll 删除数据库后端所有数据表的缓存，用于数据源被移除或重新连接。
+func (b *BackendCache) InvalidateAll() {
+	if b == nil {
+		return
+	}
+	c := b.cache
+	prefix := b.backend + "\x00"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for table, t := range c.tables {
+		if strings.HasPrefix(table, prefix) {
+			c.invalidate(table, t)
+		}
+	}
+}
+
+// invalidate 增加数据表的代数并删除其缓存，调用方持有锁。
+func (c *ResponseCache) invalidate(table string, t *tableCache) {
+	t.generation++
+	c.stats.Invalidations++
+	for key := range t.keys {
+		c.remove(c.entries[key])
+	}
+	c.prune(table)
+}
+
+// begin 开始一次查询，返回数据表当前的代数。查询结束后必须调用 end。
+func (b *BackendCache) begin(st string) uint64 {
+	c := b.cache
+	table := b.backend + "\x00" + st
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tables[table]
+	if !ok {
+		t = &tableCache{keys: map[string]struct{}{}}
+		c.tables[table] = t
+	}
+	t.pending++
+	return t.generation
+}
+
+// end 结束 begin 开始的查询。
+func (b *BackendCache) end(st string) {
+	c := b.cache
+	table := b.backend + "\x00" + st
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tables[table]; ok {
+		t.pending--
+		c.prune(table)
+	}
+}
+
+// bypass 记录一次绕过缓存的查询。
+func (b *BackendCache) bypass() {
+	b.cache.mu.Lock()
+	b.cache.stats.Bypasses++
+	b.cache.mu.Unlock()
+}
+
+// get 获取未过期的结果，返回记录的浅拷贝，调用方可以修改顶层字段。
+func (b *BackendCache) get(key string) ([]map[string]any, bool) {
+	c := b.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok || time.Now().After(el.Value.(*cacheEntry).expires) {
+		if ok {
+			c.remove(el)
+		}
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return copyRecords(el.Value.(*cacheEntry).records), true
+}
+
+// put 写入结果，在 begin 与 end 之间调用。查询期间数据表的代数发生变化，或结果超过内存上限时不写入。
+func (b *BackendCache) put(st string, key string, generation uint64, records []map[string]any, ttl time.Duration) {
+	c := b.cache
+	table := b.backend + "\x00" + st
+	encoded, err := json.Marshal(records)
+	if err != nil {
+		return
+	}
+	size := int64(len(encoded) + len(key))
+	if size > c.maxBytes {
+		return
+	}
+	records = copyRecords(records)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tables[table]
+	if !ok || t.generation != generation {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	entry := &cacheEntry{key: key, table: table, records: records, size: size, expires: time.Now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	t.keys[key] = struct{}{}
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove 删除缓存项，调用方持有锁。
+func (c *ResponseCache) remove(el *list.Element) {
+	if el == nil {
+		return
+	}
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, entry.key)
+	if t, ok := c.tables[entry.table]; ok {
+		delete(t.keys, entry.key)
+	}
+	c.prune(entry.table)
+	c.bytes -= entry.size
+}
+
+// prune 数据表没有缓存结果也没有进行中的查询时删除其状态，调用方持有锁。
+// 此时没有查询持有旧的代数，之后重新从零开始计数不会把写入前的结果写入缓存。
+func (c *ResponseCache) prune(table string) {
+	if t, ok := c.tables[table]; ok && len(t.keys) == 0 && t.pending == 0 {
+		delete(c.tables, table)
+	}
+}
+
+// key 生成缓存键。过滤条件之间是 AND 关系，排序后相同条件的不同写法共用缓存；
+// 返回的记录是 map，列的顺序同样不影响结果。
+func (b *BackendCache) key(st string, c []string, f [][]string, l string) string {
+	conditions := make([]string, 0, len(f))
+	for _, condition := range f {
+		conditions = append(conditions, strings.Join(condition, "\x1f"))
+	}
+	slices.Sort(conditions)
+	columns := slices.Clone(c)
+	slices.Sort(columns)
+	return strings.Join([]string{
+		b.backend,
+		st,
+		strings.Join(conditions, "\x1e"),
+		strings.Join(columns, "\x1f"),
+		strings.Join(strings.Fields(l), " "),
+	}, "\x00")
+}
+
+func copyRecords(records []map[string]any) []map[string]any {
+	result := make([]map[string]any, len(records))
+	for i, m := range records {
+		cp := make(map[string]any, len(m))
+		for k, v := range m {
+			cp[k] = v
+		}
+		result[i] = cp
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newCachedService 在 newTestService 的数据库上创建服务，plain 的列表查询使用缓存。
+func newCachedService(t *testing.T, cache *ResponseCache) (*ApplicationServiceImpl, *TableRegistry) {
+	t.Helper()
+	s, _ := newTestService(t)
+	plain := defaultTableConfig()
+	plain.Managed = false
+	plain.CacheTTL = "1m"
+	tables := NewTableRegistry(defaultTableConfig(), map[string]TableConfig{"plain": plain})
+	return NewApplicationService(s.repo, tables, nil, cache.For("sqlite"), nil), tables
+}
+
+// title 通过缓存读取 plain 的唯一记录的标题。
+func title(t *testing.T, s *ApplicationServiceImpl) (string, bool) {
+	t.Helper()
+	rows, hit, err := s.GetManyCached(context.Background(), "plain", nil, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+	return rows[0]["title"].(string), hit
+}
+
+func TestResponseCacheInvalidate(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	s, _ := newCachedService(t, cache)
+	ctx := context.Background()
+
+	if got, hit := title(t, s); got != "a" || hit {
+		t.Errorf("got %s (hit %v), want a from the database", got, hit)
+	}
+	if got, hit := title(t, s); got != "a" || !hit {
+		t.Errorf("got %s (hit %v), want a from the cache", got, hit)
+	}
+	if err := s.Update(ctx, "plain", map[string]any{"title": "b"}, "1", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, hit := title(t, s); got != "b" || hit {
+		t.Errorf("got %s (hit %v) after a write, want b from the database", got, hit)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Invalidations != 1 || stats.Entries != 1 {
+		t.Errorf("got %+v, want 1 hit, 2 misses, 1 invalidation and 1 entry", stats)
+	}
+}
+
+func TestResponseCacheWriteDuringQuery(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	s, tables := newCachedService(t, cache)
+	ctx := context.Background()
+
+	// 另一个请求在查询返回之后、结果写入缓存之前完成了写入
+	racing := &racingRepo{RDBRepo: s.repo}
+	racing.hook = func() {
+		if err := s.Update(ctx, "plain", map[string]any{"title": "b"}, "1", false); err != nil {
+			t.Errorf("concurrent update: %v", err)
+		}
+	}
+	if got, _ := title(t, NewApplicationService(racing, tables, nil, s.cache, nil)); got != "a" {
+		t.Errorf("got %s, want a read before the write", got)
+	}
+	if got, hit := title(t, s); got != "b" || hit {
+		t.Errorf("got %s (hit %v), want b: the result read before the write is not cached", got, hit)
+	}
+}
+
+func TestResponseCacheTables(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	s, _ := newCachedService(t, cache)
+	ctx := context.Background()
+
+	// 写入失败或数据表不存在时不为数据表保留状态
+	for i := 0; i < 100; i++ {
+		s.Update(ctx, fmt.Sprintf("missing_%d", i), map[string]any{"title": "b"}, "1", false)
+		s.cache.Invalidate(fmt.Sprintf("other_%d", i))
+	}
+	if n := len(cache.tables); n != 0 {
+		t.Errorf("got %d tables, want 0", n)
+	}
+
+	title(t, s)
+	if n := len(cache.tables); n != 1 {
+		t.Errorf("got %d tables with a cached result, want 1", n)
+	}
+	s.cache.Invalidate("plain")
+	if n := len(cache.tables); n != 0 {
+		t.Errorf("got %d tables after invalidating, want 0", n)
+	}
+}
+
+func TestResponseCacheMaxBytes(t *testing.T) {
+	cache := NewResponseCache(200)
+	b := cache.For("sqlite")
+	records := []map[string]any{{"title": "0123456789012345678901234567890123456789"}}
+
+	for i := 0; i < 10; i++ {
+		key := b.key("plain", nil, [][]string{{"equal", "id", fmt.Sprint(i)}}, "")
+		generation := b.begin("plain")
+		b.put("plain", key, generation, records, time.Minute)
+		b.end("plain")
+	}
+	stats := cache.Stats()
+	if stats.Bytes > stats.MaxBytes || stats.Entries == 0 || stats.Evictions == 0 {
+		t.Errorf("got %+v, want entries evicted to stay within 200 bytes", stats)
+	}
+	// 最近写入的结果保留，最早的被淘汰
+	if _, ok := b.get(b.key("plain", nil, [][]string{{"equal", "id", "9"}}, "")); !ok {
+		t.Error("got a miss for the latest result, want a hit")
+	}
+	if _, ok := b.get(b.key("plain", nil, [][]string{{"equal", "id", "0"}}, "")); ok {
+		t.Error("got a hit for the oldest result, want it evicted")
+	}
+
+	large := []map[string]any{{"title": string(make([]byte, 300))}}
+	generation := b.begin("plain")
+	b.put("plain", "large", generation, large, time.Minute)
+	b.end("plain")
+	if _, ok := b.get("large"); ok {
+		t.Error("got a hit for a result larger than the cache, want it skipped")
+	}
+}
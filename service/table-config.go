@@ -0,0 +1,397 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ovaphlow.com/crate/data/utility"
+)
+
+// 状态元数据的保存方式
+const (
+	StateJSON    = "json"    // 保存在一个 JSON 列中，默认 data_state
+	StateColumns = "columns" // 保存在独立的 created_at、updated_at、status 列中
+	StateNone    = "none"    // 不保存状态元数据
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// TableConfig 单个数据表的选项。
+type TableConfig struct {
+	// IDStrategy 主键生成策略，参见 utility.IDStrategy*。
+	IDStrategy string `json:"id_strategy"`
+	// PrimaryKey 主键列。
+	PrimaryKey string `json:"primary_key"`
+	// Managed 为 false 时视为普通 CRUD：数据按原样写入，不生成主键、事件时间和状态元数据。
+	Managed bool `json:"managed"`
+	// EventTime 事件时间列，为空时不管理事件时间。
+	EventTime string `json:"event_time"`
+	// State 状态元数据的保存方式：json、columns 或 none。
+	State string `json:"state"`
+	// StateColumn json 方式下保存状态的列。
+	StateColumn string `json:"state_column"`
+	// CreatedAtColumn、UpdatedAtColumn、StatusColumn columns 方式下的各列，为空时不写入该列。
+	CreatedAtColumn string `json:"created_at_column"`
+	UpdatedAtColumn string `json:"updated_at_column"`
+	StatusColumn    string `json:"status_column"`
+	// Lifecycle 生命周期状态机，为空时使用 active / deprecated 两个状态。
+	Lifecycle *Lifecycle `json:"lifecycle"`
+	// Outbox 为 true 时写入与发件箱记录在同一事务中提交。
+	Outbox bool `json:"outbox"`
+	// OutboxTable 发件箱表，与业务数据表位于同一数据库，格式与数据表相同，例如 "public.crate_outbox"。
+	OutboxTable string `json:"outbox_table"`
+	// Capture 为 true 时在数据表上安装触发器，捕获绕过服务的写入，仅 PostgreSQL 支持。
+	Capture bool `json:"capture"`
+	// CacheTTL 列表查询结果的缓存时长，例如 "30s"，为空时不缓存。
+	CacheTTL string `json:"cache_ttl"`
+	// StatementTimeout 每次请求查询数据表的最长时间，例如 "5s"，为空时使用默认的语句超时，"0s" 表示不限制。
+	StatementTimeout string `json:"statement_timeout"`
+	// Labels 导出 CSV、Excel 时的列标题，按语言分组，例如 {"zh-CN": {"name": "名称"}}。
+	Labels map[string]map[string]string `json:"labels"`
+	// Exposed 为 false 时数据表不对外提供数据接口，请求返回 404。
+	Exposed bool `json:"exposed"`
+}
+
+// cacheTTL 获取列表查询结果的缓存时长，未配置时为 0。
+func (c TableConfig) cacheTTL() time.Duration {
+	ttl, _ := time.ParseDuration(c.CacheTTL)
+	return ttl
+}
+
+// defaultStatementTimeout 未配置 statement_timeout 的数据表所使用的语句超时。
+var defaultStatementTimeout atomic.Int64
+
+// SetStatementTimeout 设置未配置 statement_timeout 的数据表所使用的语句超时，重新加载配置时立即生效。
+//
+// 参数:
+//   - d: 语句超时，0 表示不限制。
+func SetStatementTimeout(d time.Duration) {
+	defaultStatementTimeout.Store(int64(d))
+}
+
+// statementTimeout 获取数据表的语句超时，0 表示不限制。
+func (c TableConfig) statementTimeout() time.Duration {
+	if c.StatementTimeout == "" {
+		return time.Duration(defaultStatementTimeout.Load())
+	}
+	timeout, _ := time.ParseDuration(c.StatementTimeout)
+	return timeout
+}
+
+// lifecycle 获取数据表的生命周期状态机。
+func (c TableConfig) lifecycle() *Lifecycle {
+	if c.Lifecycle == nil {
+		return defaultLifecycle()
+	}
+	return c.Lifecycle
+}
+
+// lifecycleEnabled 判断数据表是否保存生命周期状态。
+func (c TableConfig) lifecycleEnabled() bool {
+	return c.Managed && (c.State == StateJSON || (c.State == StateColumns && c.StatusColumn != ""))
+}
+
+// defaultTableConfig 未配置的数据表所使用的选项。
+func defaultTableConfig() TableConfig {
+	return TableConfig{
+		IDStrategy:      utility.IDStrategyKsuid,
+		PrimaryKey:      "id",
+		Managed:         true,
+		EventTime:       "event_time",
+		State:           StateJSON,
+		StateColumn:     "data_state",
+		CreatedAtColumn: "created_at",
+		UpdatedAtColumn: "updated_at",
+		StatusColumn:    "status",
+		Exposed:         true,
+	}
+}
+
+func (c TableConfig) validate() error {
+	if !utility.ValidIDStrategy(c.IDStrategy) {
+		return fmt.Errorf("不支持的主键生成策略 %s", c.IDStrategy)
+	}
+	if !identifierPattern.MatchString(c.PrimaryKey) {
+		return fmt.Errorf("无效的主键列 %q", c.PrimaryKey)
+	}
+	switch c.State {
+	case StateJSON:
+		if !identifierPattern.MatchString(c.StateColumn) {
+			return fmt.Errorf("无效的状态列 %q", c.StateColumn)
+		}
+	case StateColumns, StateNone:
+	default:
+		return fmt.Errorf("不支持的状态保存方式 %s", c.State)
+	}
+	for _, col := range []string{c.EventTime, c.CreatedAtColumn, c.UpdatedAtColumn, c.StatusColumn} {
+		if col != "" && !identifierPattern.MatchString(col) {
+			return fmt.Errorf("无效的列名 %q", col)
+		}
+	}
+	if c.Outbox && !tableNamePattern.MatchString(c.OutboxTable) {
+		return fmt.Errorf("启用发件箱时须指定有效的 outbox_table，当前为 %q", c.OutboxTable)
+	}
+	if c.CacheTTL != "" {
+		if ttl, err := time.ParseDuration(c.CacheTTL); err != nil || ttl < 0 {
+			return fmt.Errorf("无效的缓存时长 %q", c.CacheTTL)
+		}
+	}
+	if c.StatementTimeout != "" {
+		if timeout, err := time.ParseDuration(c.StatementTimeout); err != nil || timeout < 0 {
+			return fmt.Errorf("无效的语句超时 %q", c.StatementTimeout)
+		}
+	}
+	if c.Lifecycle != nil {
+		if err := c.Lifecycle.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TimeColumns 返回由服务写入时间的列，用于按请求时区渲染响应。
+func (c TableConfig) TimeColumns() []string {
+	if !c.Managed {
+		return nil
+	}
+	var columns []string
+	if c.EventTime != "" {
+		columns = append(columns, c.EventTime)
+	}
+	if c.State == StateColumns {
+		for _, col := range []string{c.CreatedAtColumn, c.UpdatedAtColumn} {
+			if col != "" {
+				columns = append(columns, col)
+			}
+		}
+	}
+	return columns
+}
+
+// JSONColumns 返回由服务写入、包含时间字段的 JSON 列。
+func (c TableConfig) JSONColumns() []string {
+	if c.Managed && c.State == StateJSON {
+		return []string{c.StateColumn}
+	}
+	return nil
+}
+
+// existingColumn 更新记录前需要读取的列：state=json 时为状态列，否则为主键。
+func (c TableConfig) existingColumn() string {
+	if c.Managed && c.State == StateJSON {
+		return c.StateColumn
+	}
+	return c.PrimaryKey
+}
+
+// HeaderLabels 按语言偏好选择导出时的列标题。
+//
+// 依次尝试每个语言：先按完整标签匹配，再按主语言匹配，例如 "zh-TW" 可以使用 "zh" 的标题。
+//
+// 参数:
+//   - languages: 按偏好排列的语言标签，例如 ["zh-CN", "en"]。
+//
+// 返回值:
+//   - map[string]string: 列名到标题的映射，没有匹配的语言时为 nil。
+func (c TableConfig) HeaderLabels(languages []string) map[string]string {
+	for _, lang := range languages {
+		for tag, labels := range c.Labels {
+			if strings.EqualFold(tag, lang) {
+				return labels
+			}
+		}
+		primary, _, _ := strings.Cut(lang, "-")
+		for tag, labels := range c.Labels {
+			if strings.EqualFold(tag, primary) {
+				return labels
+			}
+		}
+	}
+	return nil
+}
+
+// TableRegistry 一个数据库后端下各数据表的选项。
+//
+// 重新加载配置时用 Replace 整体替换内容，持有注册表的应用服务随之使用新的选项。
+type TableRegistry struct {
+	mu       sync.RWMutex
+	defaults TableConfig
+	tables   map[string]TableConfig
+}
+
+// NewTableRegistry 创建数据表选项注册表。
+//
+// 参数:
+//   - defaults: 未单独配置的数据表所使用的选项。
+//   - tables: 以 "schema.table" 为键的数据表选项。
+//
+// 返回值:
+//   - *TableRegistry: 注册表。
+func NewTableRegistry(defaults TableConfig, tables map[string]TableConfig) *TableRegistry {
+	if tables == nil {
+		tables = map[string]TableConfig{}
+	}
+	return &TableRegistry{defaults: defaults, tables: tables}
+}
+
+// Lookup 获取数据表的选项，未配置时返回默认选项。
+func (r *TableRegistry) Lookup(st string) TableConfig {
+	if r == nil {
+		return defaultTableConfig()
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.tables[st]; ok {
+		return c
+	}
+	return r.defaults
+}
+
+// OutboxTables 返回启用发件箱的数据表所使用的发件箱表，每个发件箱表需要一个中继。
+func (r *TableRegistry) OutboxTables() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var result []string
+	for _, c := range append([]TableConfig{r.defaults}, slices.Collect(maps.Values(r.tables))...) {
+		if c.Outbox && !slices.Contains(result, c.OutboxTable) {
+			result = append(result, c.OutboxTable)
+		}
+	}
+	return result
+}
+
+// CaptureTables 返回启用变更捕获的数据表及其主键列。
+//
+// 只包含单独配置的数据表：默认选项中的 capture 无法确定需要安装触发器的数据表，因此被忽略。
+func (r *TableRegistry) CaptureTables() map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := map[string]string{}
+	for st, c := range r.tables {
+		if c.Capture {
+			result[st] = c.PrimaryKey
+		}
+	}
+	return result
+}
+
+// Replace 以 next 的选项替换注册表的内容，之后的查询立即使用新的选项。
+func (r *TableRegistry) Replace(next *TableRegistry) {
+	next.mu.RLock()
+	defaults, tables := next.defaults, next.tables
+	next.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults, r.tables = defaults, tables
+}
+
+// TableRegistries 按数据源名称划分的数据表选项。
+type TableRegistries map[string]*TableRegistry
+
+// For 获取数据源的注册表，未配置时返回只含默认选项的注册表。
+func (r TableRegistries) For(backend string) *TableRegistry {
+	if reg, ok := r[backend]; ok {
+		return reg
+	}
+	return NewTableRegistry(defaultTableConfig(), nil)
+}
+
+// LoadTableRegistries 从 JSON 文件加载数据表选项。
+//
+// 文件按数据源名称分组，"*" 为该数据源的默认选项，其余键为 "schema.table"；
+// 数据表选项在默认选项的基础上覆盖:
+//
+//	{"postgres": {"*": {"id_strategy": "uuidv7"}, "public.orders": {"id_strategy": "database"}}}
+//
+// 参数:
+//   - path: 文件路径，为空时所有数据表使用默认选项。
+//   - engines: 数据源名称到数据库引擎的映射，用于校验只有 PostgreSQL 支持的选项。
+//
+// 返回值:
+//   - TableRegistries: 各数据源的注册表。
+//   - error: 读取、解析或校验失败时返回错误。
+func LoadTableRegistries(path string, engines map[string]string) (TableRegistries, error) {
+	if path == "" {
+		return TableRegistries{}, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := ParseTableRegistries(b, engines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return result, nil
+}
+
+// ParseTableRegistries 解析 JSON 格式的数据表选项，格式与 LoadTableRegistries 读取的文件相同。
+//
+// 参数:
+//   - b: JSON 文本，例如配置文件中 tables 转换成的 JSON。
+//   - engines: 数据源名称到数据库引擎的映射，用于校验只有 PostgreSQL 支持的选项。
+//
+// 返回值:
+//   - TableRegistries: 各数据源的注册表。
+//   - error: 解析或校验失败时返回错误。
+func ParseTableRegistries(b []byte, engines map[string]string) (TableRegistries, error) {
+	result := TableRegistries{}
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("解析数据表选项失败: %w", err)
+	}
+
+	for backend, entries := range raw {
+		defaults := defaultTableConfig()
+		if d, ok := entries["*"]; ok {
+			if err := json.Unmarshal(d, &defaults); err != nil {
+				return nil, fmt.Errorf("解析数据表选项失败 %s.*: %w", backend, err)
+			}
+		}
+		if err := defaults.validate(); err != nil {
+			return nil, fmt.Errorf("%s.*: %w", backend, err)
+		}
+		tables := map[string]TableConfig{}
+		for st, entry := range entries {
+			if st == "*" {
+				continue
+			}
+			c := defaults
+			// 数据表的生命周期整体替换默认生命周期，而不是与其合并
+			c.Lifecycle = nil
+			if err := json.Unmarshal(entry, &c); err != nil {
+				return nil, fmt.Errorf("解析数据表选项失败 %s.%s: %w", backend, st, err)
+			}
+			if c.Lifecycle == nil {
+				c.Lifecycle = defaults.Lifecycle
+			}
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", backend, st, err)
+			}
+			// 未启用的数据源不知道引擎，只校验表名
+			if engine, ok := engines[backend]; c.Capture && ((ok && engine != "postgres") || !tableNamePattern.MatchString(st)) {
+				return nil, fmt.Errorf("%s.%s: 变更捕获仅支持 PostgreSQL 中以 schema.table 配置的数据表", backend, st)
+			}
+			tables[st] = c
+		}
+		result[backend] = NewTableRegistry(defaults, tables)
+	}
+	return result, nil
+}
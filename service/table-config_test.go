@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReplaceTableRegistry(t *testing.T) {
+	s, _ := newTestService(t)
+	parse := func(config string) *TableRegistry {
+		t.Helper()
+		registries, err := ParseTableRegistries([]byte(config), map[string]string{"sqlite": "sqlite"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registries.For("sqlite")
+	}
+	tables := parse(`{"sqlite": {"*": {"managed": false}}}`)
+	s = NewApplicationService(s.repo, tables, nil, NewResponseCache(1<<20).For("sqlite"), nil)
+	if s.Cached("plain") {
+		t.Fatal("got plain cached before the reload, want not cached")
+	}
+
+	// 重新加载后正在运行的服务立即使用新的选项
+	tables.Replace(parse(`{"sqlite": {"*": {"managed": false}, "plain": {"cache_ttl": "30s"}}}`))
+	if !s.Cached("plain") || s.Cached("items") {
+		t.Error("got cache options unchanged after the reload, want plain cached")
+	}
+	if _, hit, err := s.GetManyCached(context.Background(), "plain", nil, nil, "", false); err != nil || hit {
+		t.Errorf("got hit %v, %v, want a miss read from the database", hit, err)
+	}
+	if _, hit, _ := s.GetManyCached(context.Background(), "plain", nil, nil, "", false); !hit {
+		t.Error("got a miss on the second read, want a hit")
+	}
+}
+
+func TestParseTableRegistriesRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"malformed", `{"sqlite": [`, "解析数据表选项失败"},
+		{"cache ttl", `{"sqlite": {"plain": {"cache_ttl": "soon"}}}`, "sqlite.plain"},
+		{"default state", `{"sqlite": {"*": {"state": "table"}}}`, "sqlite.*"},
+		{"capture outside postgres", `{"sqlite": {"main.plain": {"capture": true}}}`, "仅支持 PostgreSQL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTableRegistries([]byte(tt.config), map[string]string{"sqlite": "sqlite"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"ovaphlow.com/crate/data/schema"
+)
+
+var tracer = otel.Tracer("ovaphlow.com/crate/data/service")
+
+// startSpan 开始应用服务方法的 span，数据库调用的 span 是它的子 span。
+//
+// 参数:
+//   - ctx: 调用方的上下文。
+//   - name: 方法名称，例如 Create。
+//   - st: schema and table。
+//
+// 返回值:
+//   - context.Context: 带有 span 的上下文。
+//   - trace.Span: span，由 endSpan 结束。
+func startSpan(ctx context.Context, name string, st string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "ApplicationService."+name, trace.WithAttributes(semconv.DBCollectionName(st)))
+}
+
+// endSpan 结束 span，err 不为 nil 时标记为失败。只记录错误分类，驱动的错误信息可能包含记录的值。
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		kind := string(schema.AsError(err).Kind)
+		span.SetAttributes(semconv.ErrorTypeKey.String(kind))
+		span.SetStatus(codes.Error, kind)
+	}
+	span.End()
+}
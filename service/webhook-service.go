@@ -0,0 +1,606 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/utility"
+)
+
+// Webhook 请求头
+const (
+	WebhookSignatureHeader = "X-Crate-Signature"
+	WebhookTimestampHeader = "X-Crate-Timestamp"
+	WebhookEventHeader     = "X-Crate-Event"
+	WebhookDeliveryHeader  = "X-Crate-Delivery"
+)
+
+const (
+	// webhookLease 投递中的记录对其他领取隐藏的时长，须大于请求超时。
+	webhookLease = time.Minute
+	// webhookTimeout 单次投递的请求超时。
+	webhookTimeout = 10 * time.Second
+	// webhookBatch 每次领取的投递数量，同时也是并发投递数量。
+	webhookBatch = 8
+	// webhookBackoff、webhookMaxBackoff 重试的初始间隔与最大间隔，每次失败间隔加倍。
+	webhookBackoff    = 10 * time.Second
+	webhookMaxBackoff = time.Hour
+)
+
+// WebhookEvent 投递给订阅方的事件。
+type WebhookEvent struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Backend string         `json:"backend"`
+	Table   string         `json:"table"`
+	Key     string         `json:"key,omitempty"`
+	Record  map[string]any `json:"record,omitempty"`
+	At      string         `json:"at"`
+}
+
+// WebhookService 管理 Webhook 订阅，并将数据变更可靠地投递给订阅方。
+//
+// 启用发件箱的数据表作为发件箱中继的 Sink 接收变更：变更与发件箱记录在同一事务中提交，
+// 中继把它写入投递队列后才标记为已投递。其他数据表的写入和变更捕获到的外部写入在发布到
+// 变更总线时写入投递队列，写入成功后、进入队列前服务退出时会丢失。
+// 队列保存在数据库中，服务重启后继续投递；失败的投递按指数退避重试，
+// 超过最大次数后进入死信列表，可以通过管理接口重放。
+type WebhookService struct {
+	repo        *repository.WebhookRepo
+	client      *http.Client
+	maxAttempts int
+	allowLocal  bool
+	// outboxed 判断数据源的数据表是否启用发件箱，为 nil 时视为未启用。
+	outboxed func(backend string, table string) bool
+
+	mu       sync.RWMutex
+	webhooks []repository.Webhook
+	wake     chan struct{}
+}
+
+// NewWebhookService 创建 Webhook 服务。
+//
+// 参数:
+//   - repo: Webhook 仓储。
+//   - maxAttempts: 进入死信列表前的最大投递次数。
+//   - allowLocal: 是否允许投递到本机、链路本地和内网地址，为 false 时创建订阅和建立连接时都会拒绝。
+//   - outboxed: 判断数据源的数据表是否启用发件箱，这些数据表经由 API 的写入由发件箱中继投递，为 nil 时视为未启用。
+//
+// 返回值:
+//   - *WebhookService: Webhook 服务。
+//   - error: 读取订阅失败时返回错误。
+func NewWebhookService(repo *repository.WebhookRepo, maxAttempts int, allowLocal bool, outboxed func(backend string, table string) bool) (*WebhookService, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowLocal {
+		// 在连接时按解析后的地址检查，域名解析到本机的订阅同样被拒绝
+		dialer := &net.Dialer{Timeout: webhookTimeout, Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && internalIP(ip) {
+				return fmt.Errorf("不允许投递到本机、链路本地或内网地址 %s", host)
+			}
+			return nil
+		}}
+		transport.DialContext = dialer.DialContext
+	}
+	s := &WebhookService{
+		repo:        repo,
+		client:      &http.Client{Timeout: webhookTimeout, Transport: transport},
+		maxAttempts: maxAttempts,
+		allowLocal:  allowLocal,
+		outboxed:    outboxed,
+		wake:        make(chan struct{}, 1),
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload 重新读取订阅，发布变更时使用内存中的订阅匹配。
+func (s *WebhookService) reload() error {
+	webhooks, err := s.repo.ListWebhooks()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.webhooks = webhooks
+	s.mu.Unlock()
+	return nil
+}
+
+// Name 作为发件箱 Sink 的名称。
+func (s *WebhookService) Name() string {
+	return "webhook"
+}
+
+// Send 为匹配事件的订阅写入投递记录，实现 event.Sink。
+//
+// 中继在 Send 成功后才把事件标记为已投递，失败时重试；同一事件重复写入时忽略，不会重复投递。
+//
+// 参数:
+//   - ctx: 未使用，写入本地数据库。
+//   - e: 发件箱事件。
+//
+// 返回值:
+//   - error: 写入投递队列失败时返回错误。
+func (s *WebhookService) Send(ctx context.Context, e event.OutboxEvent) error {
+	var record map[string]any
+	if len(e.Record) > 0 {
+		if err := json.Unmarshal(e.Record, &record); err != nil {
+			return err
+		}
+	}
+	// 记录已由发件箱转换为 UTC
+	return s.enqueue(WebhookEvent{
+		ID:      e.ID,
+		Type:    e.Type,
+		Backend: e.Backend,
+		Table:   e.Table,
+		Key:     e.Key,
+		Record:  record,
+		At:      e.At,
+	})
+}
+
+// Watch 接收数据源变更总线上未启用发件箱的数据表的写入，以及变更捕获到的外部写入。
+//
+// 启用发件箱的数据表经由 API 的写入由中继交给 Send，这里跳过，不会重复投递。
+//
+// 参数:
+//   - backend: 数据源名称。
+//   - bus: 该数据源的变更总线，为 nil 时不接收。
+func (s *WebhookService) Watch(backend string, bus *event.Bus) {
+	if bus == nil {
+		return
+	}
+	bus.Listen(func(c event.Change) {
+		if !c.Captured && s.outboxed != nil && s.outboxed(backend, c.Table) {
+			return
+		}
+		record := make(map[string]any, len(c.Record))
+		for k, v := range c.Record {
+			record[k] = v
+		}
+		utility.LocalizeRecord(record, time.UTC, nil, nil)
+		err := s.enqueue(WebhookEvent{
+			ID:      backend + "-" + strconv.FormatUint(c.ID, 10),
+			Type:    c.Op,
+			Backend: backend,
+			Table:   c.Table,
+			Key:     c.Key,
+			Record:  record,
+			At:      utility.FormatTime(c.At),
+		})
+		if err != nil {
+			utility.ZapLogger.Error("写入 Webhook 投递队列失败", zap.String("backend", backend), zap.String("table", c.Table), zap.String("key", c.Key), zap.Error(err))
+		}
+	})
+}
+
+// enqueue 为匹配事件的订阅写入投递记录。
+func (s *WebhookService) enqueue(e WebhookEvent) error {
+	c := event.Change{Op: e.Type, Table: e.Table, Key: e.Key, Record: e.Record}
+	s.mu.RLock()
+	var matched []repository.Webhook
+	for _, w := range s.webhooks {
+		if webhookMatches(w, e.Backend, c) {
+			matched = append(matched, w)
+		}
+	}
+	s.mu.RUnlock()
+	if len(matched) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]repository.WebhookDelivery, 0, len(matched))
+	for _, w := range matched {
+		id, err := utility.GenerateKsuid()
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, repository.WebhookDelivery{
+			ID:            id,
+			WebhookID:     w.ID,
+			EventID:       e.ID,
+			Payload:       string(payload),
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if err := s.repo.EnqueueDeliveries(deliveries); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// webhookMatches 判断订阅是否接收变更，订阅暂停时仍写入队列，恢复后继续投递。
+func webhookMatches(w repository.Webhook, backend string, c event.Change) bool {
+	if w.Backend != backend || (w.Table != "*" && w.Table != c.Table) {
+		return false
+	}
+	if len(w.Ops) > 0 && !slices.Contains(w.Ops, c.Op) {
+		return false
+	}
+	if w.Filter == "" {
+		return true
+	}
+	f, err := utility.ConvertQueryStringToDefaultFilter(w.Filter)
+	return err == nil && event.Match(c.Record, f)
+}
+
+func (s *WebhookService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run 投递到期的记录，直到 ctx 取消。
+func (s *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		s.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// Flush 投递所有到期的记录，直到没有到期的记录或 ctx 结束，失败的记录按重试间隔留在队列中。
+//
+// 用于停止服务前清空投递队列，调用前须先停止 Run。
+func (s *WebhookService) Flush(ctx context.Context) {
+	s.deliverDue(ctx)
+}
+
+func (s *WebhookService) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := s.repo.ClaimDeliveries(time.Now(), webhookLease, webhookBatch)
+		if err != nil {
+			utility.ZapLogger.Error("领取 Webhook 投递失败", zap.Error(err))
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.attempt(ctx, d)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// attempt 投递一次并记录结果。
+func (s *WebhookService) attempt(ctx context.Context, d repository.WebhookDelivery) {
+	w, err := s.repo.GetWebhook(d.WebhookID)
+	if err != nil || w == nil {
+		return
+	}
+	var eventType string
+	var e WebhookEvent
+	if json.Unmarshal([]byte(d.Payload), &e) == nil {
+		eventType = e.Type
+	}
+
+	status, err := s.send(ctx, *w, d.ID, eventType, []byte(d.Payload))
+	if err == nil {
+		if err := s.repo.FinishDelivery(d.ID, repository.DeliveryDelivered, time.Now(), ""); err != nil {
+			utility.ZapLogger.Error("记录 Webhook 投递结果失败", zap.String("delivery", d.ID), zap.Error(err))
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// 停止服务时中断的投递不计入次数，下次启动后立即重试
+		if err := s.repo.ReleaseDelivery(d.ID, time.Now()); err != nil {
+			utility.ZapLogger.Error("记录 Webhook 投递结果失败", zap.String("delivery", d.ID), zap.Error(err))
+		}
+		return
+	}
+
+	attempts := d.Attempts + 1
+	next := time.Now().Add(webhookRetryDelay(attempts))
+	state := repository.DeliveryPending
+	if attempts >= s.maxAttempts {
+		state = repository.DeliveryDead
+	}
+	utility.ZapLogger.Warn("Webhook 投递失败", zap.String("webhook", w.ID), zap.String("delivery", d.ID), zap.Int("attempts", attempts), zap.Int("status", status), zap.String("state", state), zap.Error(err))
+	if err := s.repo.FinishDelivery(d.ID, state, next, err.Error()); err != nil {
+		utility.ZapLogger.Error("记录 Webhook 投递结果失败", zap.String("delivery", d.ID), zap.Error(err))
+	}
+}
+
+// webhookRetryDelay 第 attempts 次失败后的重试间隔，加入最多 10% 的抖动以错开重试。
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, webhookMaxBackoff)
+	return delay + rand.N(delay/10+1)
+}
+
+// send 发送签名后的事件。
+//
+// 签名为 HMAC-SHA256(secret, timestamp + "." + body) 的十六进制，
+// 以 "sha256=" 前缀写入 X-Crate-Signature，时间戳写入 X-Crate-Timestamp，
+// 订阅方应校验签名并拒绝时间戳过旧的请求。
+//
+// 返回值:
+//   - int: 响应状态码，请求未完成时为 0。
+//   - error: 请求失败或响应状态码不是 2xx 时返回错误。
+func (s *WebhookService) send(ctx context.Context, w repository.Webhook, deliveryID string, eventType string, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "crate-webhook")
+	req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookEventHeader, eventType)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("响应状态码 %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// validateURL 检查订阅地址：只允许 http 和 https，未允许时拒绝本机、链路本地和内网地址，防止借订阅访问内部服务。
+//
+// 域名在连接时按解析后的地址再次检查。
+func (s *WebhookService) validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return &schema.Error{Kind: schema.KindValidation, Detail: "url 应为 http 或 https 地址", Field: "url"}
+	}
+	if s.allowLocal {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &schema.Error{Kind: schema.KindValidation, Detail: "url 不能指向本机", Field: "url"}
+	}
+	if ip := net.ParseIP(host); ip != nil && internalIP(ip) {
+		return &schema.Error{Kind: schema.KindValidation, Detail: "url 不能指向本机、链路本地或内网地址", Field: "url"}
+	}
+	return nil
+}
+
+// sharedAddressSpace 运营商级 NAT 的共享地址段 100.64.0.0/10（RFC 6598）。
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// internalIP 判断地址是否为回环、链路本地、未指定、私有（10/8、172.16/12、192.168/16、fc00::/7）
+// 或运营商级 NAT 地址。
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() ||
+		ip.IsPrivate() || sharedAddressSpace.Contains(ip)
+}
+
+// Create 创建订阅。
+//
+// 参数:
+//   - w: 订阅，ID 与创建时间由服务生成，Secret 为空时生成随机密钥。
+//
+// 返回值:
+//   - repository.Webhook: 创建的订阅，包含密钥。
+//   - error: 参数无效或写入失败时返回错误。
+func (s *WebhookService) Create(w repository.Webhook) (repository.Webhook, error) {
+	if w.Backend == "" {
+		return w, &schema.Error{Kind: schema.KindValidation, Detail: "缺少数据库后端", Field: "backend"}
+	}
+	if w.Table == "" {
+		return w, &schema.Error{Kind: schema.KindValidation, Detail: "缺少数据表", Field: "table"}
+	}
+	if err := s.validateURL(w.URL); err != nil {
+		return w, err
+	}
+	for _, op := range w.Ops {
+		if op != event.OpCreate && op != event.OpUpdate && op != event.OpDelete {
+			return w, &schema.Error{Kind: schema.KindValidation, Detail: fmt.Sprintf("不支持的操作 %s", op), Field: "ops"}
+		}
+	}
+	if _, err := utility.ConvertQueryStringToDefaultFilter(w.Filter); err != nil {
+		return w, &schema.Error{Kind: schema.KindValidation, Detail: "无效的过滤条件", Field: "filter", Err: err}
+	}
+	if w.Ops == nil {
+		w.Ops = []string{}
+	}
+
+	id, err := utility.GenerateKsuid()
+	if err != nil {
+		return w, err
+	}
+	w.ID = id
+	w.CreatedAt = time.Now()
+	w.Paused = false
+	if w.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := crand.Read(b); err != nil {
+			return w, err
+		}
+		w.Secret = hex.EncodeToString(b)
+	}
+	if err := s.repo.CreateWebhook(w); err != nil {
+		return w, err
+	}
+	return w, s.reload()
+}
+
+// List 获取所有订阅，不包含密钥。
+func (s *WebhookService) List() []repository.Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]repository.Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		w.Secret = ""
+		result = append(result, w)
+	}
+	return result
+}
+
+// Get 获取订阅，不包含密钥。
+func (s *WebhookService) Get(id string) (repository.Webhook, error) {
+	w, err := s.repo.GetWebhook(id)
+	if err != nil {
+		return repository.Webhook{}, err
+	}
+	if w == nil {
+		return repository.Webhook{}, schema.NewError(schema.KindNotFound, "订阅不存在")
+	}
+	w.Secret = ""
+	return *w, nil
+}
+
+// Remove 删除订阅及其投递记录。
+func (s *WebhookService) Remove(id string) error {
+	ok, err := s.repo.RemoveWebhook(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return schema.NewError(schema.KindNotFound, "订阅不存在")
+	}
+	return s.reload()
+}
+
+// SetPaused 暂停或恢复订阅。暂停期间的变更仍写入队列，恢复后继续投递。
+func (s *WebhookService) SetPaused(id string, paused bool) error {
+	ok, err := s.repo.SetWebhookPaused(id, paused)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return schema.NewError(schema.KindNotFound, "订阅不存在")
+	}
+	if !paused {
+		s.notify()
+	}
+	return s.reload()
+}
+
+// Deliveries 获取订阅的投递记录。
+//
+// 参数:
+//   - id: 订阅ID。
+//   - status: 投递状态，为 dead 时即死信列表，为空时返回所有状态。
+//   - limit: 最大数量。
+func (s *WebhookService) Deliveries(id string, status string, limit int) ([]repository.WebhookDelivery, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	switch status {
+	case "", repository.DeliveryPending, repository.DeliveryDelivered, repository.DeliveryDead:
+	default:
+		return nil, &schema.Error{Kind: schema.KindBadRequest, Detail: fmt.Sprintf("不支持的投递状态 %s", status), Field: "status"}
+	}
+	return s.repo.ListDeliveries(id, status, limit)
+}
+
+// Replay 将死信列表中的投递重新加入队列。
+//
+// 参数:
+//   - id: 订阅ID。
+//   - deliveryIDs: 需要重放的投递，为空时重放该订阅的所有死信。
+//
+// 返回值:
+//   - int64: 重新加入队列的数量。
+//   - error: 订阅不存在或写入失败时返回错误。
+func (s *WebhookService) Replay(id string, deliveryIDs []string) (int64, error) {
+	if _, err := s.Get(id); err != nil {
+		return 0, err
+	}
+	n, err := s.repo.RequeueDeliveries(id, deliveryIDs)
+	if err != nil {
+		return 0, err
+	}
+	s.notify()
+	return n, nil
+}
+
+// Test 向订阅方同步发送一个 ping 事件，不写入投递记录。
+//
+// 返回值:
+//   - map[string]any: 响应状态码、耗时与错误信息。
+//   - error: 订阅不存在时返回错误。
+func (s *WebhookService) Test(ctx context.Context, id string) (map[string]any, error) {
+	w, err := s.repo.GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, schema.NewError(schema.KindNotFound, "订阅不存在")
+	}
+	deliveryID, err := utility.GenerateKsuid()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(WebhookEvent{ID: deliveryID, Type: "ping", Backend: w.Backend, Table: w.Table, At: utility.FormatTime(time.Now())})
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	status, err := s.send(ctx, *w, deliveryID, "ping", body)
+	result := map[string]any{
+		"delivered":   err == nil,
+		"status":      status,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	return result, nil
+}
@@ -0,0 +1,535 @@
+package service_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"ovaphlow.com/crate/data/event"
+	"ovaphlow.com/crate/data/repository"
+	"ovaphlow.com/crate/data/router"
+	"ovaphlow.com/crate/data/schema"
+	"ovaphlow.com/crate/data/service"
+)
+
+// receiver 记录收到的 Webhook 请求，按 status 响应。
+type receiver struct {
+	*httptest.Server
+	status atomic.Int32
+	mu     sync.Mutex
+	got    []*http.Request
+	bodies [][]byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rc := &receiver{}
+	rc.status.Store(http.StatusOK)
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		rc.got = append(rc.got, r)
+		rc.bodies = append(rc.bodies, body)
+		rc.mu.Unlock()
+		w.WriteHeader(int(rc.status.Load()))
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.got)
+}
+
+// newWebhookService 在内存 SQLite 上创建 Webhook 服务，允许投递到本机的测试服务器。
+func newWebhookService(t *testing.T, maxAttempts int) (*service.WebhookService, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+strings.ReplaceAll(t.Name(), "/", "_")+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	repo, err := repository.NewWebhookRepo(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := service.NewWebhookService(repo, maxAttempts, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+func orderEvent(id string, op string, status string) event.OutboxEvent {
+	return event.OutboxEvent{
+		ID:       id,
+		Sequence: "1",
+		Backend:  "main",
+		Table:    "orders",
+		Type:     op,
+		Key:      "7",
+		Record:   json.RawMessage(`{"id":"7","status":"` + status + `"}`),
+		At:       "2025-01-02T03:04:05Z",
+	}
+}
+
+// makeDue 使所有待投递的记录立即到期。
+func makeDue(t *testing.T, db *sql.DB) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE webhook_delivery SET next_attempt_at = '2000-01-01T00:00:00.000000000Z' WHERE status = 'pending'"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func onlyDelivery(t *testing.T, s *service.WebhookService, webhookID string) repository.WebhookDelivery {
+	t.Helper()
+	deliveries, err := s.Deliveries(webhookID, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rc := newReceiver(t)
+	s, _ := newWebhookService(t, 3)
+	w, err := s.Create(repository.Webhook{Backend: "main", Table: "orders", URL: rc.URL + "/hook", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), orderEvent("evt-1", event.OpCreate, "paid")); err != nil {
+		t.Fatal(err)
+	}
+	s.Flush(context.Background())
+
+	if rc.count() != 1 {
+		t.Fatalf("got %d requests, want 1", rc.count())
+	}
+	r, body := rc.got[0], rc.bodies[0]
+	timestamp := r.Header.Get(service.WebhookTimestampHeader)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(service.WebhookSignatureHeader) != want {
+		t.Errorf("got signature %q, want %q", r.Header.Get(service.WebhookSignatureHeader), want)
+	}
+	if ts, err := time.Parse(time.RFC3339, time.Unix(atoi(t, timestamp), 0).Format(time.RFC3339)); err != nil || time.Since(ts) > time.Minute {
+		t.Errorf("got timestamp %q", timestamp)
+	}
+	if r.Header.Get(service.WebhookEventHeader) != event.OpCreate {
+		t.Errorf("got event %q", r.Header.Get(service.WebhookEventHeader))
+	}
+	d := onlyDelivery(t, s, w.ID)
+	if r.Header.Get(service.WebhookDeliveryHeader) != d.ID || d.Status != repository.DeliveryDelivered || d.Attempts != 1 {
+		t.Errorf("got delivery %+v, header %q", d, r.Header.Get(service.WebhookDeliveryHeader))
+	}
+	var e service.WebhookEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "evt-1" || e.Backend != "main" || e.Table != "orders" || e.Key != "7" || e.Record["status"] != "paid" {
+		t.Errorf("got event %+v", e)
+	}
+}
+
+func atoi(t *testing.T, s string) int64 {
+	t.Helper()
+	var n int64
+	if err := json.Unmarshal([]byte(s), &n); err != nil {
+		t.Fatalf("%q: %v", s, err)
+	}
+	return n
+}
+
+func TestWebhookMatching(t *testing.T) {
+	rc := newReceiver(t)
+	s, _ := newWebhookService(t, 3)
+	w, err := s.Create(repository.Webhook{Backend: "main", Table: "orders", Ops: []string{event.OpUpdate}, Filter: "eq,2,status,paid", URL: rc.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, e := range []event.OutboxEvent{
+		orderEvent("create", event.OpCreate, "paid"),
+		orderEvent("unpaid", event.OpUpdate, "open"),
+		{ID: "other-table", Backend: "main", Table: "users", Type: event.OpUpdate, Record: json.RawMessage(`{"status":"paid"}`)},
+		{ID: "other-backend", Backend: "audit", Table: "orders", Type: event.OpUpdate, Record: json.RawMessage(`{"status":"paid"}`)},
+		orderEvent("match", event.OpUpdate, "paid"),
+		// 中继重试时同一事件再次交给 Sink
+		orderEvent("match", event.OpUpdate, "paid"),
+	} {
+		if err := s.Send(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := onlyDelivery(t, s, w.ID); d.EventID != "match" {
+		t.Errorf("got event %s, want match", d.EventID)
+	}
+}
+
+func TestWebhookBackoffAndDeadLetter(t *testing.T) {
+	rc := newReceiver(t)
+	rc.status.Store(http.StatusInternalServerError)
+	s, db := newWebhookService(t, 3)
+	w, err := s.Create(repository.Webhook{Backend: "main", Table: "*", URL: rc.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Send(ctx, orderEvent("evt-1", event.OpCreate, "paid")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每次失败间隔加倍，另有最多 10% 的抖动
+	for attempt, base := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		before := time.Now()
+		s.Flush(ctx)
+		d := onlyDelivery(t, s, w.ID)
+		if d.Status != repository.DeliveryPending || d.Attempts != attempt+1 {
+			t.Fatalf("attempt %d: got %s after %d attempts", attempt+1, d.Status, d.Attempts)
+		}
+		delay := d.NextAttemptAt.Sub(before)
+		if delay < base || delay > base+base/10+time.Second {
+			t.Errorf("attempt %d: got retry after %s, want %s plus jitter", attempt+1, delay, base)
+		}
+		if !strings.Contains(d.LastError, "500") {
+			t.Errorf("got last error %q", d.LastError)
+		}
+		// 未到期时不重试
+		s.Flush(ctx)
+		if rc.count() != attempt+1 {
+			t.Fatalf("got %d requests, want %d", rc.count(), attempt+1)
+		}
+		makeDue(t, db)
+	}
+
+	s.Flush(ctx)
+	dead, err := s.Deliveries(w.ID, repository.DeliveryDead, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Fatalf("got dead letters %+v, want one after 3 attempts", dead)
+	}
+	makeDue(t, db)
+	s.Flush(ctx)
+	if rc.count() != 3 {
+		t.Errorf("dead letter was retried: %d requests", rc.count())
+	}
+}
+
+func TestWebhookShutdownDoesNotCountAttempt(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	rc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	}))
+	defer rc.Close()
+	defer close(release)
+	s, _ := newWebhookService(t, 3)
+	w, err := s.Create(repository.Webhook{Backend: "main", Table: "orders", URL: rc.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), orderEvent("evt-1", event.OpCreate, "paid")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+	s.Flush(ctx)
+
+	d := onlyDelivery(t, s, w.ID)
+	if d.Status != repository.DeliveryPending || d.Attempts != 0 || d.LastError != "" {
+		t.Errorf("got %s after %d attempts (%q), want pending and uncounted", d.Status, d.Attempts, d.LastError)
+	}
+	if d.NextAttemptAt.After(time.Now()) {
+		t.Errorf("got next attempt at %s, want due now", d.NextAttemptAt)
+	}
+}
+
+func TestWebhookURLValidation(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:webhook-url?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	repo, err := repository.NewWebhookRepo(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := service.NewWebhookService(repo, 3, false, func(backend string, table string) bool { return table == "orders" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		table string
+		url   string
+		field string
+	}{
+		{"public https", "orders", "https://example.com/hook", ""},
+		{"any table", "*", "http://203.0.113.10:8080/hook", ""},
+		{"table without outbox", "users", "https://example.com/hook", ""},
+		{"public next to private", "orders", "http://172.32.0.1/hook", ""},
+		{"ftp", "orders", "ftp://example.com/hook", "url"},
+		{"no host", "orders", "https:///hook", "url"},
+		{"localhost", "orders", "http://localhost:8080/hook", "url"},
+		{"localhost subdomain", "orders", "http://api.localhost./hook", "url"},
+		{"loopback", "orders", "http://127.0.0.2/hook", "url"},
+		{"ipv6 loopback", "orders", "http://[::1]:8080/hook", "url"},
+		{"metadata service", "orders", "http://169.254.169.254/latest/meta-data", "url"},
+		{"ipv6 link-local", "orders", "http://[fe80::1]/hook", "url"},
+		{"unspecified", "orders", "http://0.0.0.0/hook", "url"},
+		{"private 10/8", "orders", "http://10.1.2.3/hook", "url"},
+		{"private 172.16/12", "orders", "http://172.31.255.1/hook", "url"},
+		{"private 192.168/16", "orders", "https://192.168.1.1/hook", "url"},
+		{"ipv6 unique local", "orders", "http://[fd12::1]/hook", "url"},
+		{"ipv4-mapped private", "orders", "http://[::ffff:10.0.0.1]/hook", "url"},
+		{"shared address space", "orders", "http://100.64.0.1/hook", "url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Create(repository.Webhook{Backend: "main", Table: tt.table, URL: tt.url})
+			if tt.field == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if e := schema.AsError(err); e.Kind != schema.KindValidation || e.Field != tt.field {
+				t.Errorf("got %v, want a validation error on %s", err, tt.field)
+			}
+		})
+	}
+
+	// 已保存的订阅在连接时同样被拒绝
+	rc := newReceiver(t)
+	w := repository.Webhook{ID: "stored", Backend: "main", Table: "orders", Ops: []string{}, URL: rc.URL, Secret: "x", CreatedAt: time.Now()}
+	if err := repo.CreateWebhook(w); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.Test(context.Background(), w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["delivered"] != false || rc.count() != 0 {
+		t.Errorf("got %v with %d requests, want the connection refused", result, rc.count())
+	}
+}
+
+func TestWebhookAdminRoutes(t *testing.T) {
+	rc := newReceiver(t)
+	s, db := newWebhookService(t, 1)
+	mux := http.NewServeMux()
+	router.LoadWebhookRouter(mux, "/api", s)
+	api := httptest.NewServer(mux)
+	defer api.Close()
+	ctx := context.Background()
+
+	post := func(path string, body string, want int) map[string]any {
+		t.Helper()
+		resp, err := http.Post(api.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result map[string]any
+		json.NewDecoder(resp.Body).Decode(&result)
+		if resp.StatusCode != want {
+			t.Fatalf("POST %s: got %d %v, want %d", path, resp.StatusCode, result, want)
+		}
+		return result
+	}
+
+	created := post("/api/webhooks", `{"backend": "main", "table": "orders", "url": "`+rc.URL+`"}`, http.StatusCreated)
+	id, _ := created["id"].(string)
+	if id == "" || created["secret"] == "" {
+		t.Fatalf("got %v", created)
+	}
+
+	t.Run("test", func(t *testing.T) {
+		result := post("/api/webhooks/"+id+"/test", "", http.StatusOK)
+		if result["delivered"] != true || result["status"] != float64(http.StatusOK) {
+			t.Errorf("got %v", result)
+		}
+		if rc.got[rc.count()-1].Header.Get(service.WebhookEventHeader) != "ping" {
+			t.Error("ping event header is missing")
+		}
+		post("/api/webhooks/missing/test", "", http.StatusNotFound)
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		n := rc.count()
+		post("/api/webhooks/"+id+"/pause", "", http.StatusOK)
+		if err := s.Send(ctx, orderEvent("while-paused", event.OpCreate, "paid")); err != nil {
+			t.Fatal(err)
+		}
+		s.Flush(ctx)
+		if rc.count() != n {
+			t.Fatal("delivered while paused")
+		}
+		post("/api/webhooks/"+id+"/resume", "", http.StatusOK)
+		s.Flush(ctx)
+		if rc.count() != n+1 {
+			t.Fatalf("got %d requests after resume, want %d", rc.count(), n+1)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		rc.status.Store(http.StatusServiceUnavailable)
+		if err := s.Send(ctx, orderEvent("failing", event.OpCreate, "paid")); err != nil {
+			t.Fatal(err)
+		}
+		s.Flush(ctx)
+
+		resp, err := http.Get(api.URL + "/api/webhooks/" + id + "/deliveries?status=dead")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dead []repository.WebhookDelivery
+		json.NewDecoder(resp.Body).Decode(&dead)
+		resp.Body.Close()
+		if len(dead) != 1 || dead[0].EventID != "failing" {
+			t.Fatalf("got dead letters %+v", dead)
+		}
+
+		rc.status.Store(http.StatusOK)
+		result := post("/api/webhooks/"+id+"/replay", `{"delivery_ids": ["`+dead[0].ID+`"]}`, http.StatusOK)
+		if result["requeued"] != float64(1) {
+			t.Fatalf("got %v", result)
+		}
+		makeDue(t, db)
+		s.Flush(ctx)
+		deliveries, err := s.Deliveries(id, repository.DeliveryDelivered, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 2 {
+			t.Errorf("got %d delivered, want 2", len(deliveries))
+		}
+		if result := post("/api/webhooks/"+id+"/replay", "", http.StatusOK); result["requeued"] != float64(0) {
+			t.Errorf("nothing left to replay, got %v", result)
+		}
+	})
+}
+
+func TestWebhookDeliverySources(t *testing.T) {
+	rc := newReceiver(t)
+	webhookDB, err := sql.Open("sqlite", "file:webhook-sources-queue?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webhookDB.Close()
+	webhookDB.SetMaxOpenConns(1)
+	webhookRepo, err := repository.NewWebhookRepo(webhookDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outboxed := func(backend string, table string) bool { return table == "items" }
+	webhooks, err := service.NewWebhookService(webhookRepo, 3, true, outboxed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := webhooks.Create(repository.Webhook{Backend: "main", Table: "*", URL: rc.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", "file:webhook-sources?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	for _, q := range []string{
+		"CREATE TABLE items (id TEXT PRIMARY KEY, title TEXT NOT NULL)",
+		"CREATE TABLE notes (id TEXT PRIMARY KEY, title TEXT NOT NULL)",
+		"CREATE TABLE crate_outbox (id INTEGER PRIMARY KEY AUTOINCREMENT, event_id TEXT NOT NULL UNIQUE, table_name TEXT NOT NULL, op TEXT NOT NULL, record_key TEXT NOT NULL, payload TEXT NOT NULL, created_at TEXT NOT NULL, dispatched INTEGER NOT NULL DEFAULT 0, dispatched_at TEXT)",
+		"CREATE TABLE crate_outbox_lease (name TEXT PRIMARY KEY, owner TEXT NOT NULL, expires_ms INTEGER NOT NULL)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dialect, _ := repository.DialectFor("sqlite")
+	repo := repository.NewSQLRepo(db, dialect, repository.TimeStorageText, 0)
+	tables := service.NewTableRegistry(service.TableConfig{}, map[string]service.TableConfig{
+		"items": {IDStrategy: "ksuid", PrimaryKey: "id", Outbox: true, OutboxTable: "crate_outbox", Exposed: true},
+		"notes": {IDStrategy: "ksuid", PrimaryKey: "id", Exposed: true},
+	})
+	bus := event.NewBus(16)
+	webhooks.Watch("main", bus)
+	svc := service.NewApplicationService(repo, tables, bus, nil, nil)
+	ctx := context.Background()
+
+	if _, err := svc.Create(ctx, "items", map[string]any{"id": "1", "title": "first"}); err != nil {
+		t.Fatal(err)
+	}
+	// 失败的写入与发件箱记录一同回滚，不会投递
+	if _, err := svc.Create(ctx, "items", map[string]any{"id": "2"}); err == nil {
+		t.Fatal("expected a NOT NULL violation")
+	}
+	// 未启用发件箱的数据表在发布变更时入队，失败的写入不发布
+	if _, err := svc.Create(ctx, "notes", map[string]any{"id": "3", "title": "note"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Create(ctx, "notes", map[string]any{"id": "4"}); err == nil {
+		t.Fatal("expected a NOT NULL violation")
+	}
+	// 变更捕获到的外部写入不经过发件箱，启用发件箱的数据表同样投递
+	bus.Publish(event.Change{Op: event.OpUpdate, Table: "items", Key: "5", Record: map[string]any{"id": "5", "title": "external"}, Captured: true})
+
+	relay := service.NewOutboxRelay(repo, "main", "crate_outbox", []event.Sink{webhooks})
+	if n, err := relay.Flush(ctx); err != nil || n != 1 {
+		t.Fatalf("relayed %d events: %v", n, err)
+	}
+	webhooks.Flush(ctx)
+
+	got := map[string]service.WebhookEvent{}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, body := range rc.bodies {
+		var e service.WebhookEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got[e.Key]; ok {
+			t.Errorf("record %s delivered twice", e.Key)
+		}
+		got[e.Key] = e
+	}
+	if len(got) != 3 {
+		t.Fatalf("got deliveries for %d records, want 1, 3 and 5", len(got))
+	}
+	for key, want := range map[string]struct{ op, table, title string }{
+		"1": {event.OpCreate, "items", "first"},
+		"3": {event.OpCreate, "notes", "note"},
+		"5": {event.OpUpdate, "items", "external"},
+	} {
+		if e := got[key]; e.Type != want.op || e.Table != want.table || e.Record["title"] != want.title {
+			t.Errorf("record %s: got %+v", key, e)
+		}
+	}
+}
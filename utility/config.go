@@ -0,0 +1,746 @@
+package utility
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv 配置文件路径的环境变量，命令行参数 --config 优先。
+const ConfigFileEnv = "CONFIG_FILE"
+
+// redacted 输出配置时代替密码的文本。
+const redacted = "******"
+
+// Duration 配置中的时长，写作 "30s"、"5m"、"24h" 等。
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return fmt.Errorf("应为时长，例如 30s: %q", string(b))
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config 服务配置。
+//
+// 依次由默认值、配置文件（YAML）和环境变量组成，环境变量优先。
+// 每个字段的环境变量名见 env 标签，数据源的字段以数据源的前缀开头，见 DataSourceConfig。
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Log       LogConfig       `yaml:"log"`
+	CORS      CORSConfig      `yaml:"cors"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// DataSources 按名称的数据源。
+	DataSources map[string]*DataSourceConfig `yaml:"datasources"`
+	// TableConfig 数据表选项 JSON 文件，与 Tables 二选一。
+	TableConfig string `yaml:"table_config,omitempty" env:"TABLE_CONFIG"`
+	// Tables 数据表选项，按数据源名称分组，格式与 TableConfig 文件相同。
+	Tables map[string]map[string]any `yaml:"tables,omitempty"`
+	// StatementTimeout 每次请求查询数据库的最长时间，数据表可以用 statement_timeout 单独设置，0 表示不限制。
+	StatementTimeout Duration `yaml:"statement_timeout" env:"STATEMENT_TIMEOUT"`
+	// SnowflakeNode 使用 snowflake 主键时的实例编号，0-1023。
+	SnowflakeNode  int                  `yaml:"snowflake_node" env:"SNOWFLAKE_NODE"`
+	ChangeStream   ChangeStreamConfig   `yaml:"change_stream"`
+	Webhook        WebhookConfig        `yaml:"webhook"`
+	StatementCache StatementCacheConfig `yaml:"statement_cache"`
+	SchemaCache    SchemaCacheConfig    `yaml:"schema_cache"`
+	ResponseCache  ResponseCacheConfig  `yaml:"response_cache"`
+	Outbox         OutboxConfig         `yaml:"outbox"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	Health         HealthConfig         `yaml:"health"`
+}
+
+// ServerConfig HTTP 监听与服务时区。
+type ServerConfig struct {
+	Address string `yaml:"address" env:"SERVER_ADDRESS"`
+	Port    int    `yaml:"port" env:"PORT"`
+	// Timezone IANA 时区名称，用于解释不带时区的输入并渲染响应，为空时使用 UTC。
+	Timezone string `yaml:"timezone,omitempty" env:"SERVICE_TIMEZONE"`
+
+	// 连接超时，0 表示不限制。流式响应和变更流不受 WriteTimeout 限制，每次写入前按 WriteTimeout 重新计时。
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout      Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownTimeout 收到 SIGTERM 后等待处理中的请求完成并投递队列的最长时间。
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+// LogConfig 日志。终端输出 Level 及以上级别，Dir 下的日志文件记录 FileLevel 及以上级别。
+type LogConfig struct {
+	Level     string `yaml:"level" env:"LOG_LEVEL"`
+	FileLevel string `yaml:"file_level" env:"LOG_FILE_LEVEL"`
+	Dir       string `yaml:"dir" env:"LOG_DIR"`
+}
+
+// CORSConfig 跨域访问。
+type CORSConfig struct {
+	// AllowedOrigins 允许跨域访问的来源，例如 https://app.example.com，"*" 表示任意来源。
+	AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+}
+
+// RateLimitConfig 按客户端地址限制请求速率。
+type RateLimitConfig struct {
+	// RequestsPerSecond 每个客户端每秒的请求数，0 表示不限制。
+	RequestsPerSecond float64 `yaml:"requests_per_second" env:"RATE_LIMIT_REQUESTS_PER_SECOND"`
+	// Burst 允许的突发请求数，0 表示与每秒请求数相同。
+	Burst int `yaml:"burst" env:"RATE_LIMIT_BURST"`
+}
+
+// DataSourceConfig 一个数据源的配置。
+//
+// 环境变量以 DATASOURCE_<名称>_ 为前缀，名称转为大写，"-" 转为 "_"，例如 DATASOURCE_MAIN_HOST；
+// 兼容配置 POSTGRES_ENABLED 等定义的数据源以 POSTGRES_、MYSQL_、SQLITE_ 为前缀。
+type DataSourceConfig struct {
+	// Engine 数据库引擎：postgres、mysql 或 sqlite。
+	Engine string `yaml:"engine" env:"ENGINE"`
+	// DSN 连接字符串，设置后忽略 Host、Port、User、Password、Database。
+	DSN      string `yaml:"dsn,omitempty" env:"DSN"`
+	Host     string `yaml:"host,omitempty" env:"HOST"`
+	Port     int    `yaml:"port,omitempty" env:"PORT"`
+	User     string `yaml:"user,omitempty" env:"USER"`
+	Password string `yaml:"password,omitempty" env:"PASSWORD"`
+	// Database 数据库名称，SQLite 为数据库文件路径。
+	Database string `yaml:"database,omitempty" env:"DATABASE"`
+
+	// 连接池，未设置时使用引擎的默认值
+	MaxOpenConns    *int      `yaml:"max_open_conns" env:"MAX_OPEN_CONNS"`
+	MaxIdleConns    *int      `yaml:"max_idle_conns" env:"MAX_IDLE_CONNS"`
+	ConnMaxLifetime *Duration `yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime *Duration `yaml:"conn_max_idle_time" env:"CONN_MAX_IDLE_TIME"`
+
+	// TimeStorage 时间存储方式：native 或 text，SQLite 默认为 text。
+	TimeStorage string `yaml:"time_storage" env:"TIME_STORAGE"`
+
+	// Replicas 只读副本地址，host 或 host:port。
+	Replicas             []string `yaml:"replicas,omitempty" env:"REPLICAS"`
+	ReplicaMaxLag        Duration `yaml:"replica_max_lag" env:"REPLICA_MAX_LAG"`
+	ReplicaCheckInterval Duration `yaml:"replica_check_interval" env:"REPLICA_CHECK_INTERVAL"`
+	// ReadYourWrites 客户端写入后读取主库的时长，0 表示不保证读到自己的写入。
+	ReadYourWrites Duration `yaml:"read_your_writes" env:"READ_YOUR_WRITES"`
+
+	// Optional 可选的数据源：不可用时就绪检查仍然通过，只报告它的状态。
+	Optional bool `yaml:"optional" env:"OPTIONAL"`
+
+	Capture CaptureConfig `yaml:"capture" env:"CAPTURE_"`
+
+	// envPrefix 数据源的环境变量前缀。
+	envPrefix string
+}
+
+// CaptureConfig PostgreSQL 变更捕获。
+type CaptureConfig struct {
+	Enabled bool `yaml:"enabled" env:"ENABLED"`
+	// Log 变更日志表，表名同时是通知频道。
+	Log       string   `yaml:"log" env:"LOG"`
+	Retention Duration `yaml:"retention" env:"RETENTION"`
+}
+
+// ChangeStreamConfig 变更流。
+type ChangeStreamConfig struct {
+	Enabled bool `yaml:"enabled" env:"CHANGE_STREAM_ENABLED"`
+	// Buffer 每个数据源保留的变更数量，用于断线续传。
+	Buffer int `yaml:"buffer" env:"CHANGE_STREAM_BUFFER"`
+	// AllowAll 为 true 时订阅者可以访问所有行和列；为 false 且未注册权限函数时拒绝订阅。
+	AllowAll bool `yaml:"allow_all" env:"CHANGE_STREAM_ALLOW_ALL"`
+}
+
+// WebhookConfig Webhook 订阅与投递。
+type WebhookConfig struct {
+	Enabled bool `yaml:"enabled" env:"WEBHOOK_ENABLED"`
+	// Database 保存订阅与投递队列的 SQLite 文件。
+	Database    string `yaml:"database" env:"WEBHOOK_DATABASE"`
+	MaxAttempts int    `yaml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	// AllowLocal 允许订阅投递到本机、链路本地和内网地址，仅用于开发环境。
+	AllowLocal bool `yaml:"allow_local" env:"WEBHOOK_ALLOW_LOCAL"`
+}
+
+// StatementCacheConfig 预编译语句缓存。
+type StatementCacheConfig struct {
+	// Size 每个数据源缓存的语句数量，0 表示不缓存。
+	Size int `yaml:"size" env:"STATEMENT_CACHE_SIZE"`
+}
+
+// SchemaCacheConfig 数据表元数据缓存。
+type SchemaCacheConfig struct {
+	Preload bool `yaml:"preload" env:"SCHEMA_CACHE_PRELOAD"`
+	// Refresh 定时刷新间隔，0 表示不刷新。
+	Refresh Duration `yaml:"refresh" env:"SCHEMA_CACHE_REFRESH"`
+}
+
+// ResponseCacheConfig 列表查询缓存。
+type ResponseCacheConfig struct {
+	// MaxBytes 所有数据源共用的内存上限。
+	MaxBytes int64 `yaml:"max_bytes" env:"RESPONSE_CACHE_MAX_BYTES"`
+}
+
+// OutboxConfig 事务发件箱。
+type OutboxConfig struct {
+	// Sinks 发件箱的投递目标：log、file:<路径> 或 http(s) URL，为空时为 log。
+	Sinks []string `yaml:"sinks,omitempty" env:"OUTBOX_SINKS"`
+}
+
+// TracingConfig OpenTelemetry 链路追踪。
+type TracingConfig struct {
+	// Exporter 导出方式：none、otlp（OTLP/HTTP）或 stdout。
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// Endpoint OTLP/HTTP 接收地址，例如 http://localhost:4318，不带路径时发送到 /v1/traces；
+	// 为空时按 OTEL_EXPORTER_OTLP_ENDPOINT 等环境变量，都未设置时为 http://localhost:4318。
+	Endpoint string `yaml:"endpoint,omitempty" env:"TRACING_ENDPOINT"`
+	// SampleRatio 没有上游采样决定的请求的采样比例，0-1；上游 traceparent 已决定采样时沿用上游的决定。
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	// ServiceName 上报的服务名称。
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+}
+
+// HealthConfig 就绪检查。
+type HealthConfig struct {
+	// Timeout 检查每个数据源的最长时间。
+	Timeout Duration `yaml:"timeout" env:"HEALTH_TIMEOUT"`
+	// PoolSaturation 使用中的连接占连接池上限的比例达到该值时，连接池视为饱和，0-1。
+	PoolSaturation float64 `yaml:"pool_saturation" env:"HEALTH_POOL_SATURATION"`
+}
+
+// ConfigError 配置校验失败，列出所有问题。
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "配置无效:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// defaultConfig 返回默认配置。
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Address:           "0.0.0.0",
+			Port:              8421,
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ReadTimeout:       Duration(60 * time.Second),
+			WriteTimeout:      Duration(60 * time.Second),
+			IdleTimeout:       Duration(120 * time.Second),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		Log:              LogConfig{Level: "info", FileLevel: "warn", Dir: "logs"},
+		CORS:             CORSConfig{AllowedOrigins: []string{"*"}},
+		DataSources:      map[string]*DataSourceConfig{},
+		StatementTimeout: Duration(30 * time.Second),
+		ChangeStream:     ChangeStreamConfig{Buffer: 1024},
+		Webhook:          WebhookConfig{Database: "./webhook.db", MaxAttempts: 8},
+		StatementCache:   StatementCacheConfig{Size: 256},
+		SchemaCache:      SchemaCacheConfig{Refresh: Duration(5 * time.Minute)},
+		ResponseCache:    ResponseCacheConfig{MaxBytes: 64 << 20},
+		Tracing:          TracingConfig{Exporter: "none", SampleRatio: 1, ServiceName: "crate-data"},
+		Health:           HealthConfig{Timeout: Duration(2 * time.Second), PoolSaturation: 0.9},
+	}
+}
+
+// defaultDataSourceConfig 返回与引擎无关的数据源默认配置。
+func defaultDataSourceConfig() DataSourceConfig {
+	return DataSourceConfig{
+		ReplicaMaxLag:        Duration(10 * time.Second),
+		ReplicaCheckInterval: Duration(5 * time.Second),
+		ReadYourWrites:       Duration(5 * time.Second),
+		Capture:              CaptureConfig{Log: "public.crate_change_log", Retention: Duration(24 * time.Hour)},
+	}
+}
+
+// UnmarshalYAML 在默认配置的基础上读取数据源。
+func (c *DataSourceConfig) UnmarshalYAML(node *yaml.Node) error {
+	// node.Decode 不继承 KnownFields，未知的键在这里检查
+	if err := checkKnownFields(node, reflect.TypeOf(*c)); err != nil {
+		return err
+	}
+	*c = defaultDataSourceConfig()
+	type plain DataSourceConfig
+	return node.Decode((*plain)(c))
+}
+
+// checkKnownFields 检查映射节点中的键都是 t 的字段，嵌套的结构体同样检查。
+func checkKnownFields(node *yaml.Node, t reflect.Type) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		ft, ok := fields[key.Value]
+		if !ok {
+			return fmt.Errorf("line %d: 未知的配置项 %q", key.Line, key.Value)
+		}
+		if ft.Kind() == reflect.Struct {
+			if err := checkKnownFields(node.Content[i+1], ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadConfig 读取配置。
+//
+// 配置文件为 YAML，未知的键视为错误。环境变量覆盖配置文件中的同名设置，
+// 未设置或为空的环境变量不覆盖。读取后补全引擎相关的默认值并校验全部设置。
+//
+// 参数:
+//   - path (string): 配置文件路径，为空时使用环境变量 CONFIG_FILE，都为空时只使用环境变量。
+//
+// 返回:
+//   - *Config: 配置，校验失败时仍然返回，便于输出。
+//   - error: 无法读取配置文件时返回错误，校验失败时返回 *ConfigError。
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("解析配置文件失败 %s: %w", path, err)
+		}
+		if cfg.DataSources == nil {
+			cfg.DataSources = map[string]*DataSourceConfig{}
+		}
+	}
+
+	var problems []string
+	applyEnv(reflect.ValueOf(cfg).Elem(), "", &problems)
+	cfg.applyDataSourceEnv(&problems)
+	for _, ds := range cfg.DataSources {
+		ds.applyEngineDefaults()
+	}
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return cfg, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// dataSourceEnvPrefix 返回数据源的环境变量前缀。
+func dataSourceEnvPrefix(name string) string {
+	return "DATASOURCE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+// applyDataSourceEnv 用环境变量覆盖数据源配置。
+//
+// POSTGRES_ENABLED、MYSQL_ENABLED、SQLITE_ENABLED 定义以引擎命名的数据源，DATASOURCES 中的名称
+// 定义新的数据源；配置文件中的数据源同样可以用 DATASOURCE_<名称>_ 前缀的环境变量覆盖。
+func (c *Config) applyDataSourceEnv(problems *[]string) {
+	for _, engine := range DataSourceEngines {
+		prefix := strings.ToUpper(engine) + "_"
+		if enabled := os.Getenv(prefix + "ENABLED"); enabled == "true" || enabled == "1" {
+			ds, ok := c.DataSources[engine]
+			if !ok {
+				d := defaultDataSourceConfig()
+				d.Engine = engine
+				ds = &d
+				c.DataSources[engine] = ds
+			}
+			ds.envPrefix = prefix
+		}
+	}
+	for _, name := range strings.Split(os.Getenv("DATASOURCES"), ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := c.DataSources[name]; name != "" && !ok {
+			d := defaultDataSourceConfig()
+			c.DataSources[name] = &d
+		}
+	}
+	for name, ds := range c.DataSources {
+		if ds == nil {
+			d := defaultDataSourceConfig()
+			ds = &d
+			c.DataSources[name] = ds
+		}
+		if ds.envPrefix == "" {
+			ds.envPrefix = dataSourceEnvPrefix(name)
+		}
+		applyEnv(reflect.ValueOf(ds).Elem(), ds.envPrefix, problems)
+	}
+}
+
+// applyEngineDefaults 补全与引擎相关的默认值：连接池和时间存储方式。
+func (c *DataSourceConfig) applyEngineDefaults() {
+	n := func(v int) *int { return &v }
+	d := func(v time.Duration) *Duration { x := Duration(v); return &x }
+	open := 2*runtime.NumCPU() + 1
+	var idle int
+	var lifetime, idleTime time.Duration
+	switch c.Engine {
+	case "postgres":
+		idle, lifetime = open, 30*time.Second
+	case "mysql":
+		idle, lifetime = 0, 30*time.Second
+	case "sqlite":
+		idle, idleTime = 1, 15*time.Minute
+	}
+	if c.MaxOpenConns == nil {
+		c.MaxOpenConns = n(open)
+	}
+	if c.MaxIdleConns == nil {
+		c.MaxIdleConns = n(idle)
+	}
+	if c.ConnMaxLifetime == nil {
+		c.ConnMaxLifetime = d(lifetime)
+	}
+	if c.ConnMaxIdleTime == nil {
+		c.ConnMaxIdleTime = d(idleTime)
+	}
+	if c.TimeStorage == "" {
+		c.TimeStorage = "native"
+		if c.Engine == "sqlite" {
+			c.TimeStorage = "text"
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// applyEnv 用环境变量覆盖 v 中带 env 标签的字段。
+//
+// 结构体字段的 env 标签是其字段环境变量名的前缀；map 字段不处理。
+func applyEnv(v reflect.Value, prefix string, problems *[]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, ok := field.Tag.Lookup("env")
+		if field.Type.Kind() == reflect.Struct {
+			applyEnv(v.Field(i), prefix+name, problems)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		raw := os.Getenv(prefix + name)
+		if raw == "" {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), raw); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s%s: %v", prefix, name, err))
+		}
+	}
+}
+
+// setFromEnv 把环境变量的值写入字段。
+func setFromEnv(v reflect.Value, raw string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setFromEnv(p.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("应为整数: %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("应为数字: %q", raw)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		switch raw {
+		case "true", "1":
+			v.SetBool(true)
+		case "false", "0":
+			v.SetBool(false)
+		default:
+			return fmt.Errorf("应为 true 或 false: %q", raw)
+		}
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// validate 校验配置，返回全部问题。
+func (c *Config) validate() []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port (PORT): 应为 1-65535 之间的端口: %d", c.Server.Port)
+	}
+	if c.Server.Timezone != "" {
+		if _, err := time.LoadLocation(c.Server.Timezone); err != nil {
+			add("server.timezone (SERVICE_TIMEZONE): 无效的时区 %q", c.Server.Timezone)
+		}
+	}
+	for key, d := range map[string]Duration{
+		"server.read_header_timeout (SERVER_READ_HEADER_TIMEOUT)": c.Server.ReadHeaderTimeout,
+		"server.read_timeout (SERVER_READ_TIMEOUT)":               c.Server.ReadTimeout,
+		"server.write_timeout (SERVER_WRITE_TIMEOUT)":             c.Server.WriteTimeout,
+		"server.idle_timeout (SERVER_IDLE_TIMEOUT)":               c.Server.IdleTimeout,
+	} {
+		if d < 0 {
+			add("%s: 不能为负数", key)
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout (SERVER_SHUTDOWN_TIMEOUT): 应大于 0")
+	}
+	for key, level := range map[string]string{"log.level (LOG_LEVEL)": c.Log.Level, "log.file_level (LOG_FILE_LEVEL)": c.Log.FileLevel} {
+		if _, err := zapcore.ParseLevel(level); err != nil {
+			add("%s: 应为 debug、info、warn 或 error: %q", key, level)
+		}
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "") {
+			add("cors.allowed_origins (CORS_ALLOWED_ORIGINS): 应为 \"*\" 或 scheme://host[:port]: %q", origin)
+		}
+	}
+	if c.RateLimit.RequestsPerSecond < 0 {
+		add("rate_limit.requests_per_second (RATE_LIMIT_REQUESTS_PER_SECOND): 不能为负数")
+	}
+	if c.RateLimit.Burst < 0 {
+		add("rate_limit.burst (RATE_LIMIT_BURST): 不能为负数")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.DataSources)) {
+		problems = append(problems, c.DataSources[name].validate(name)...)
+	}
+
+	if c.TableConfig != "" && len(c.Tables) > 0 {
+		add("tables 与 table_config (TABLE_CONFIG) 只能设置一个")
+	}
+	if c.StatementTimeout < 0 {
+		add("statement_timeout (STATEMENT_TIMEOUT): 不能为负数")
+	}
+	if c.SnowflakeNode < 0 || c.SnowflakeNode >= 1<<snowflakeNodeBits {
+		add("snowflake_node (SNOWFLAKE_NODE): 超出范围 0-%d: %d", 1<<snowflakeNodeBits-1, c.SnowflakeNode)
+	}
+	if c.ChangeStream.Buffer <= 0 {
+		add("change_stream.buffer (CHANGE_STREAM_BUFFER): 应为正整数: %d", c.ChangeStream.Buffer)
+	}
+	if c.Webhook.MaxAttempts <= 0 {
+		add("webhook.max_attempts (WEBHOOK_MAX_ATTEMPTS): 应为正整数: %d", c.Webhook.MaxAttempts)
+	}
+	if c.StatementCache.Size < 0 {
+		add("statement_cache.size (STATEMENT_CACHE_SIZE): 应为非负整数: %d", c.StatementCache.Size)
+	}
+	if c.SchemaCache.Refresh < 0 {
+		add("schema_cache.refresh (SCHEMA_CACHE_REFRESH): 不能为负数")
+	}
+	if c.ResponseCache.MaxBytes <= 0 {
+		add("response_cache.max_bytes (RESPONSE_CACHE_MAX_BYTES): 应为正整数: %d", c.ResponseCache.MaxBytes)
+	}
+	for _, sink := range c.Outbox.Sinks {
+		if sink != "log" && !strings.HasPrefix(sink, "file:") && !strings.HasPrefix(sink, "http://") && !strings.HasPrefix(sink, "https://") {
+			add("outbox.sinks (OUTBOX_SINKS): 无法识别的发件箱 Sink %q，可选 log、file:<路径>、http(s) URL", sink)
+		}
+	}
+	if !slices.Contains(TracingExporters, c.Tracing.Exporter) {
+		add("tracing.exporter (TRACING_EXPORTER): 不支持的导出方式 %q，可选 %s", c.Tracing.Exporter, strings.Join(TracingExporters, "、"))
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("tracing.endpoint (TRACING_ENDPOINT): 应为 http(s)://host[:port][/path]: %q", c.Tracing.Endpoint)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio (TRACING_SAMPLE_RATIO): 应为 0-1 之间的数: %v", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.ServiceName == "" {
+		add("tracing.service_name (TRACING_SERVICE_NAME): 不能为空")
+	}
+	if c.Health.Timeout <= 0 {
+		add("health.timeout (HEALTH_TIMEOUT): 应大于 0")
+	}
+	if c.Health.PoolSaturation <= 0 || c.Health.PoolSaturation > 1 {
+		add("health.pool_saturation (HEALTH_POOL_SATURATION): 应为大于 0、不超过 1 的数: %v", c.Health.PoolSaturation)
+	}
+	return problems
+}
+
+// validate 校验数据源配置。
+func (c *DataSourceConfig) validate(name string) []string {
+	var problems []string
+	// key 为数据源内的配置路径，例如 "capture.retention"，对应环境变量 <前缀>CAPTURE_RETENTION
+	add := func(key string, format string, args ...any) {
+		env := c.envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		problems = append(problems, fmt.Sprintf("datasources.%s.%s (%s): %s", name, key, env, fmt.Sprintf(format, args...)))
+	}
+
+	if !dataSourceNamePattern.MatchString(name) || slices.Contains(reservedDataSourceNames, name) {
+		problems = append(problems, fmt.Sprintf("datasources.%s: 无效的数据源名称，应为小写字母开头的字母、数字、_ 或 -，且不能是 %s", name, strings.Join(reservedDataSourceNames, "、")))
+	}
+	switch c.Engine {
+	case "postgres", "mysql":
+		if c.DSN == "" && c.Host == "" {
+			add("host", "未设置 dsn 或 host")
+		}
+	case "sqlite":
+		if c.DSN == "" && c.Database == "" {
+			add("database", "未设置 dsn 或 database")
+		}
+		if len(c.Replicas) > 0 {
+			add("replicas", "SQLite 不支持只读副本")
+		}
+	case "":
+		add("engine", "未设置，可选 %s", strings.Join(DataSourceEngines, "、"))
+	default:
+		add("engine", "不支持的数据库引擎 %q，可选 %s", c.Engine, strings.Join(DataSourceEngines, "、"))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		add("port", "应为 1-65535 之间的端口: %d", c.Port)
+	}
+	if *c.MaxOpenConns < 0 {
+		add("max_open_conns", "应为非负整数: %d", *c.MaxOpenConns)
+	}
+	if *c.MaxIdleConns < 0 {
+		add("max_idle_conns", "应为非负整数: %d", *c.MaxIdleConns)
+	}
+	for key, d := range map[string]Duration{"conn_max_lifetime": *c.ConnMaxLifetime, "conn_max_idle_time": *c.ConnMaxIdleTime, "replica_max_lag": c.ReplicaMaxLag, "read_your_writes": c.ReadYourWrites} {
+		if d < 0 {
+			add(key, "不能为负数")
+		}
+	}
+	if c.ReplicaCheckInterval <= 0 {
+		add("replica_check_interval", "应大于 0")
+	}
+	if c.TimeStorage != "native" && c.TimeStorage != "text" {
+		add("time_storage", "应为 native 或 text: %q", c.TimeStorage)
+	}
+	if c.Capture.Enabled && c.Engine != "postgres" {
+		add("capture.enabled", "变更捕获仅支持 PostgreSQL")
+	}
+	if c.Capture.Retention <= 0 {
+		add("capture.retention", "应大于 0")
+	}
+	if len(problems) == 0 {
+		if _, err := newDataSource(name, c); err != nil {
+			add("dsn", "%v", err)
+		}
+	}
+	return problems
+}
+
+// Redacted 返回隐去密码的配置副本，用于输出。
+//
+// 返回:
+//   - *Config: 数据源的密码、连接字符串和发件箱 URL 中的密码替换为 ******。
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.DataSources = make(map[string]*DataSourceConfig, len(c.DataSources))
+	for name, ds := range c.DataSources {
+		copied := *ds
+		if copied.Password != "" {
+			copied.Password = redacted
+		}
+		copied.DSN = redactDSN(ds.Engine, ds.DSN)
+		out.DataSources[name] = &copied
+	}
+	out.Outbox.Sinks = make([]string, len(c.Outbox.Sinks))
+	for i, sink := range c.Outbox.Sinks {
+		out.Outbox.Sinks[i] = redactURL(sink)
+	}
+	return &out
+}
+
+// redactDSN 隐去连接字符串中的密码，无法解析时整体隐去。
+func redactDSN(engine string, dsn string) string {
+	if dsn == "" || engine == "sqlite" {
+		return dsn
+	}
+	if strings.Contains(dsn, "://") {
+		return redactURL(dsn)
+	}
+	if engine == "mysql" {
+		if cfg, err := mysql.ParseDSN(dsn); err == nil {
+			if cfg.Passwd != "" {
+				cfg.Passwd = redacted
+			}
+			return cfg.FormatDSN()
+		}
+		return redacted
+	}
+	fields := strings.Fields(dsn)
+	for i, field := range fields {
+		if k, _, _ := strings.Cut(field, "="); k == "password" {
+			fields[i] = "password=" + redacted
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// redactURL 隐去 URL 中的密码，无法解析时整体隐去。
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	// url.UserPassword 会转义 *，在 Redacted 的输出中替换它的占位符
+	return strings.Replace(u.Redacted(), ":xxxxx@", ":"+redacted+"@", 1)
+}
+
+// WriteYAML 以 YAML 输出配置。
+//
+// 参数:
+//   - w (io.Writer): 输出位置。
+//
+// 返回:
+//   - error: 写入失败时返回错误。
+func (c *Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
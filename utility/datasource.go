@@ -0,0 +1,156 @@
+package utility
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// DataSourceEngines 支持的数据库引擎。
+var DataSourceEngines = []string{"postgres", "mysql", "sqlite"}
+
+// reservedDataSourceNames 与其他接口路径冲突、不能用作数据源名称的名称。
+var reservedDataSourceNames = []string{"cache", "config", "datasources", "id", "schema", "statements", "webhooks"}
+
+var dataSourceNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// DataSource 一个命名的数据源，接口路径为 /crate-api-data/{Name}/{st}。
+type DataSource struct {
+	// Name 数据源名称，同时用作接口路径、数据表选项和变更流的分组。
+	Name string
+	// Engine 数据库引擎：postgres、mysql 或 sqlite。
+	Engine string
+	// DSN 连接字符串。
+	DSN string
+	// ApplicationName PostgreSQL 连接的 application_name，变更捕获据此区分本实例的写入。
+	ApplicationName string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// Replicas 只读副本，连接选项与主库相同，只有地址不同。Name 为副本地址。
+	Replicas []*DataSource
+	// ReplicaMaxLag 复制延迟超过该值的只读副本不再处理读取。
+	ReplicaMaxLag time.Duration
+	// ReplicaCheckInterval 检查只读副本连接和复制延迟的间隔。
+	ReplicaCheckInterval time.Duration
+	// ReadYourWrites 客户端写入后读取主库的时长。
+	ReadYourWrites time.Duration
+
+	// Config 数据源的配置，包括时间存储方式和变更捕获。
+	Config *DataSourceConfig
+
+	// DB 连接池，Open 之后可用。
+	DB *sql.DB
+}
+
+// LoadDataSources 按配置创建数据源，按名称排序。
+//
+// 参数:
+//   - cfg (*Config): 已校验的配置。
+//
+// 返回:
+//   - []*DataSource: 数据源，尚未连接。
+//   - error: 连接字符串无效时返回错误。
+func LoadDataSources(cfg *Config) ([]*DataSource, error) {
+	var result []*DataSource
+	for _, name := range slices.Sorted(maps.Keys(cfg.DataSources)) {
+		ds, err := newDataSource(name, cfg.DataSources[name])
+		if err != nil {
+			return nil, fmt.Errorf("数据源 %s: %w", name, err)
+		}
+		result = append(result, ds)
+	}
+	return result, nil
+}
+
+// newDataSource 按数据源配置生成连接字符串和连接池选项。
+func newDataSource(name string, c *DataSourceConfig) (*DataSource, error) {
+	ds := &DataSource{
+		Name:                 name,
+		Engine:               c.Engine,
+		MaxOpenConns:         *c.MaxOpenConns,
+		MaxIdleConns:         *c.MaxIdleConns,
+		ConnMaxLifetime:      time.Duration(*c.ConnMaxLifetime),
+		ConnMaxIdleTime:      time.Duration(*c.ConnMaxIdleTime),
+		ReplicaMaxLag:        time.Duration(c.ReplicaMaxLag),
+		ReplicaCheckInterval: time.Duration(c.ReplicaCheckInterval),
+		ReadYourWrites:       time.Duration(c.ReadYourWrites),
+		Config:               c,
+	}
+	var err error
+	switch c.Engine {
+	case "postgres":
+		err = ds.postgresDSN(c)
+	case "mysql":
+		err = ds.mysqlDSN(c)
+	case "sqlite":
+		err = ds.sqliteDSN(c)
+	default:
+		err = fmt.Errorf("不支持的数据库引擎 %q", c.Engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ds, ds.configureReplicas()
+}
+
+// configureReplicas 按只读副本地址（host 或 host:port）生成只读副本的连接字符串，
+// 未指定端口时使用主库的端口。
+func (ds *DataSource) configureReplicas() error {
+	for _, addr := range ds.Config.Replicas {
+		replica := *ds
+		replica.Name, replica.Replicas = addr, nil
+		var err error
+		switch ds.Engine {
+		case "postgres":
+			replica.DSN, err = postgresReplicaDSN(ds.DSN, addr)
+		case "mysql":
+			replica.DSN, err = mysqlReplicaDSN(ds.DSN, addr)
+		default:
+			err = fmt.Errorf("%s 不支持只读副本", ds.Engine)
+		}
+		if err != nil {
+			return fmt.Errorf("无效的只读副本地址 %q: %w", addr, err)
+		}
+		ds.Replicas = append(ds.Replicas, &replica)
+	}
+	return nil
+}
+
+// replicaAddr 补全只读副本地址中的端口。
+func replicaAddr(addr string, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil || port == "" {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// Open 打开数据源的连接池并检查连接，不打开只读副本。
+//
+// 连接失败时连接池仍然可用，数据库恢复后自动重新连接，调用方可以只记录错误。
+//
+// 返回:
+//   - error: 连接字符串无效或检查连接失败时返回错误。
+func (ds *DataSource) Open() error {
+	var err error
+	ds.DB, err = sql.Open(ds.Engine, ds.DSN)
+	if err != nil {
+		return err
+	}
+	ds.DB.SetMaxOpenConns(ds.MaxOpenConns)
+	ds.DB.SetMaxIdleConns(ds.MaxIdleConns)
+	ds.DB.SetConnMaxLifetime(ds.ConnMaxLifetime)
+	ds.DB.SetConnMaxIdleTime(ds.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return ds.DB.PingContext(ctx)
+}
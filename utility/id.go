@@ -0,0 +1,266 @@
+package utility
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 主键生成策略
+const (
+	IDStrategyKsuid     = "ksuid"
+	IDStrategyUUIDv4    = "uuidv4"
+	IDStrategyUUIDv7    = "uuidv7"
+	IDStrategyULID      = "ulid"
+	IDStrategySnowflake = "snowflake"
+	IDStrategyDatabase  = "database" // SERIAL / AUTO_INCREMENT / ROWID，由数据库生成
+)
+
+// ValidIDStrategy 判断主键生成策略是否受支持。
+func ValidIDStrategy(strategy string) bool {
+	switch strategy {
+	case IDStrategyKsuid, IDStrategyUUIDv4, IDStrategyUUIDv7, IDStrategyULID, IDStrategySnowflake, IDStrategyDatabase:
+		return true
+	}
+	return false
+}
+
+// GenerateID 按策略生成主键。
+//
+// 参数:
+//   - strategy (string): 主键生成策略，空字符串等同于 ksuid。
+//
+// 返回:
+//   - (string, error): 生成的主键。database 策略由数据库生成，调用此函数会返回错误。
+func GenerateID(strategy string) (string, error) {
+	switch strategy {
+	case "", IDStrategyKsuid:
+		return GenerateKsuid()
+	case IDStrategyUUIDv4:
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	case IDStrategyUUIDv7:
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	case IDStrategyULID:
+		return GenerateULID()
+	case IDStrategySnowflake:
+		id, err := snowflake.next()
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(id, 10), nil
+	}
+	return "", fmt.Errorf("不支持的主键生成策略 %s", strategy)
+}
+
+// ULID: 48 位毫秒时间戳 + 80 位随机数，Crockford Base32 编码为 26 个字符
+const crockfordChars = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type ulidGenerator struct {
+	mu      sync.Mutex
+	lastMs  int64
+	entropy [10]byte
+}
+
+var ulid = &ulidGenerator{}
+
+// GenerateULID 生成 ULID，同一毫秒内生成的 ULID 单调递增。
+func GenerateULID() (string, error) {
+	return ulid.next(time.Now().UnixMilli())
+}
+
+// next 生成时间戳为 ms 的 ULID。时间戳未前进时沿用上次的时间戳并把随机部分加一，
+// 按规范在随机部分溢出时返回错误。
+func (g *ulidGenerator) next(ms int64) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		entropy := g.entropy
+		i := len(entropy) - 1
+		for ; i >= 0; i-- {
+			entropy[i]++
+			if entropy[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			return "", fmt.Errorf("同一毫秒内的 ULID 已用尽")
+		}
+		g.entropy = entropy
+	} else {
+		if _, err := rand.Read(g.entropy[:]); err != nil {
+			return "", err
+		}
+		g.lastMs = ms
+	}
+
+	var b [16]byte
+	u := uint64(ms)
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(u)
+		u >>= 8
+	}
+	copy(b[6:], g.entropy[:])
+
+	// 128 位按 5 位一组编码，首字符只占 3 位
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordChars[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+// ParseULIDTime 解析 ULID 中的时间戳。
+func ParseULIDTime(id string) (time.Time, error) {
+	if len(id) != 26 {
+		return time.Time{}, fmt.Errorf("ULID 长度应为 26")
+	}
+	var ms uint64
+	for n, c := range []byte(strings.ToUpper(id)) {
+		i := strings.IndexByte(crockfordChars, c)
+		if i < 0 {
+			return time.Time{}, fmt.Errorf("非法字符 %q", c)
+		}
+		// 前 10 个字符包含 48 位时间戳（首字符只用 3 位）
+		if n < 10 {
+			ms = ms<<5 | uint64(i)
+		}
+	}
+	if id[0] > '7' {
+		return time.Time{}, fmt.Errorf("ULID 超出范围")
+	}
+	return time.UnixMilli(int64(ms)).UTC(), nil
+}
+
+// Snowflake: 1 位符号 + 41 位毫秒时间戳 + 10 位节点 + 12 位序列
+const (
+	snowflakeEpoch    = 1704067200000 // 2024-01-01T00:00:00Z
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+type snowflakeGenerator struct {
+	mu     sync.Mutex
+	node   int64
+	lastMs int64
+	seq    int64
+}
+
+var snowflake = &snowflakeGenerator{}
+
+// SetSnowflakeNode 设置 snowflake 主键的实例编号，多实例部署时每个实例需配置不同的编号。
+//
+// 参数:
+//   - node (int): 实例编号，0-1023，由配置校验范围。
+func SetSnowflakeNode(node int) {
+	snowflake.mu.Lock()
+	defer snowflake.mu.Unlock()
+	snowflake.node = int64(node)
+}
+
+func (g *snowflakeGenerator) next() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms := time.Now().UnixMilli()
+	if ms < g.lastMs {
+		// 时钟回拨时沿用上次的时间戳，保证单调递增
+		ms = g.lastMs
+	}
+	if ms == g.lastMs {
+		g.seq = (g.seq + 1) & snowflakeMaxSeq
+		if g.seq == 0 {
+			for ms <= g.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Now().UnixMilli()
+			}
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastMs = ms
+	return (ms-snowflakeEpoch)<<(snowflakeNodeBits+snowflakeSeqBits) | g.node<<snowflakeSeqBits | g.seq, nil
+}
+
+// ParseSnowflakeTime 解析 Snowflake 主键中的时间戳。
+func ParseSnowflakeTime(id string) (time.Time, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("Snowflake 主键应为非负整数")
+	}
+	return time.UnixMilli(n>>(snowflakeNodeBits+snowflakeSeqBits) + snowflakeEpoch).UTC(), nil
+}
+
+var (
+	ksuidPattern     = regexp.MustCompile(`^[0-9A-Za-z]{27}$`)
+	ulidPattern      = regexp.MustCompile(`^[0-9A-HJKMNP-TV-Za-hjkmnp-tv-z]{26}$`)
+	snowflakePattern = regexp.MustCompile(`^[0-9]{1,19}$`)
+)
+
+// DecodeIDTime 解析主键中嵌入的时间戳。
+//
+// 参数:
+//   - id (string): 主键。
+//   - strategy (string): 主键生成策略，为空时根据格式自动识别。
+//
+// 返回:
+//   - (string, time.Time, error): 识别出的策略、UTC 时间，或无法解析时的错误。
+func DecodeIDTime(id string, strategy string) (string, time.Time, error) {
+	if strategy == "" {
+		switch {
+		case ksuidPattern.MatchString(id):
+			strategy = IDStrategyKsuid
+		case ulidPattern.MatchString(id):
+			strategy = IDStrategyULID
+		case snowflakePattern.MatchString(id):
+			strategy = IDStrategySnowflake
+		default:
+			if u, err := uuid.Parse(id); err == nil {
+				strategy = "uuidv" + strconv.Itoa(int(u.Version()))
+			}
+		}
+	}
+
+	var t time.Time
+	var err error
+	switch strategy {
+	case IDStrategyKsuid:
+		t, err = ParseKsuidTime(id)
+	case IDStrategyULID:
+		t, err = ParseULIDTime(id)
+	case IDStrategySnowflake:
+		t, err = ParseSnowflakeTime(id)
+	case IDStrategyUUIDv7:
+		var u uuid.UUID
+		u, err = uuid.Parse(id)
+		if err == nil && u.Version() != 7 {
+			err = fmt.Errorf("不是 UUIDv7")
+		}
+		if err == nil {
+			sec, nsec := u.Time().UnixTime()
+			t = time.Unix(sec, nsec).UTC()
+		}
+	default:
+		return strategy, time.Time{}, fmt.Errorf("无法从该主键解析时间戳")
+	}
+	return strategy, t, err
+}
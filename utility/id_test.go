@@ -0,0 +1,187 @@
+package utility
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestULIDMonotonicWithinMillisecond(t *testing.T) {
+	g := &ulidGenerator{}
+	ms := time.Date(2025, 1, 2, 3, 4, 5, 6e6, time.UTC).UnixMilli()
+	prev, err := g.next(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 1000 {
+		id, err := g.next(ms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= prev {
+			t.Fatalf("%s is not after %s", id, prev)
+		}
+		if id[:10] != prev[:10] {
+			t.Fatalf("timestamp changed: %s after %s", id, prev)
+		}
+		prev = id
+	}
+
+	// 时钟回拨时沿用上次的时间戳
+	id, err := g.next(ms - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= prev {
+		t.Errorf("%s is not after %s", id, prev)
+	}
+	got, err := ParseULIDTime(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UnixMilli() != ms {
+		t.Errorf("got %d, want %d", got.UnixMilli(), ms)
+	}
+}
+
+func TestULIDOverflow(t *testing.T) {
+	g := &ulidGenerator{lastMs: 1}
+	for i := range g.entropy {
+		g.entropy[i] = 0xff
+	}
+	if _, err := g.next(1); err == nil {
+		t.Fatal("expected an error when the random part overflows")
+	}
+	// 溢出后不改变状态，下一毫秒恢复
+	if _, err := g.next(1); err == nil {
+		t.Fatal("expected an error on the same millisecond")
+	}
+	if _, err := g.next(2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnowflakeSequence(t *testing.T) {
+	now := time.Now().UnixMilli()
+	tests := []struct {
+		name    string
+		g       *snowflakeGenerator
+		seq     int64
+		advance bool
+	}{
+		{"same millisecond", &snowflakeGenerator{node: 5, lastMs: now + 2, seq: 7}, 8, false},
+		// 序列用尽时等待下一毫秒
+		{"sequence overflow", &snowflakeGenerator{node: 5, lastMs: now, seq: snowflakeMaxSeq}, 0, true},
+		{"max node", &snowflakeGenerator{node: 1<<snowflakeNodeBits - 1, lastMs: now, seq: snowflakeMaxSeq}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lastMs, node := tt.g.lastMs, tt.g.node
+			id, err := tt.g.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seq := id & snowflakeMaxSeq; seq != tt.seq {
+				t.Errorf("got sequence %d, want %d", seq, tt.seq)
+			}
+			if got := id >> snowflakeSeqBits & (1<<snowflakeNodeBits - 1); got != node {
+				t.Errorf("got node %d, want %d", got, node)
+			}
+			ms := id>>(snowflakeNodeBits+snowflakeSeqBits) + snowflakeEpoch
+			if tt.advance && ms <= lastMs || !tt.advance && ms != lastMs {
+				t.Errorf("got timestamp %d after %d", ms, lastMs)
+			}
+			parsed, err := ParseSnowflakeTime(strconv.FormatInt(id, 10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.UnixMilli() != ms {
+				t.Errorf("parsed %d, want %d", parsed.UnixMilli(), ms)
+			}
+		})
+	}
+}
+
+func TestUUIDVersionAndVariant(t *testing.T) {
+	for _, tt := range []struct {
+		strategy string
+		version  byte
+	}{
+		{IDStrategyUUIDv4, 4},
+		{IDStrategyUUIDv7, 7},
+	} {
+		t.Run(tt.strategy, func(t *testing.T) {
+			id, err := GenerateID(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := uuid.Parse(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := u[6] >> 4; v != tt.version {
+				t.Errorf("got version %d, want %d", v, tt.version)
+			}
+			// RFC 9562 变体：最高两位为 10
+			if u[8]&0xc0 != 0x80 {
+				t.Errorf("got variant bits %02b, want 10", u[8]>>6)
+			}
+		})
+	}
+}
+
+func TestDecodeIDTime(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	for _, strategy := range []string{IDStrategyKsuid, IDStrategyULID, IDStrategySnowflake, IDStrategyUUIDv7} {
+		t.Run(strategy, func(t *testing.T) {
+			id, err := GenerateID(strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, hint := range []string{"", strategy} {
+				got, ts, err := DecodeIDTime(id, hint)
+				if err != nil {
+					t.Fatalf("%s (hint %q): %v", id, hint, err)
+				}
+				if got != strategy {
+					t.Errorf("%s: detected %s, want %s", id, got, strategy)
+				}
+				if ts.Before(before) || ts.After(time.Now()) {
+					t.Errorf("%s: got %s", id, ts)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeIDTimeMalformed(t *testing.T) {
+	v4, _ := GenerateID(IDStrategyUUIDv4)
+	tests := []struct {
+		name     string
+		id       string
+		strategy string
+	}{
+		{"empty", "", ""},
+		{"unknown format", "not-an-id", ""},
+		{"uuidv4 has no time", v4, ""},
+		{"ksuid with bad character", strings.Repeat("0", 26) + "-", IDStrategyKsuid},
+		{"ksuid out of range", strings.Repeat("z", 27), ""},
+		{"ulid too short", "01ARZ3NDEKTSV4RRFFQ69G5FA", IDStrategyULID},
+		{"ulid out of range", "81ARZ3NDEKTSV4RRFFQ69G5FAV", ""},
+		{"ulid with bad character", "01ARZ3NDEKTSV4RRFFQ69G5FAU", IDStrategyULID},
+		{"negative snowflake", "-1", IDStrategySnowflake},
+		{"snowflake overflow", "99999999999999999999", IDStrategySnowflake},
+		{"uuidv4 as uuidv7", v4, IDStrategyUUIDv7},
+		{"unsupported strategy", "1", "uuidv1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ts, err := DecodeIDTime(tt.id, tt.strategy); err == nil {
+				t.Errorf("got %s, want an error", ts)
+			}
+		})
+	}
+}
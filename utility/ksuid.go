@@ -1,16 +1,26 @@
 package utility
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"fmt"
 	"math/big"
+	"strings"
 	"time"
 )
 
+const (
+	base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// KSUID 时间戳从 2014-05-13T16:53:20Z 开始计算，编码后固定为 27 个字符
+	ksuidEpoch        = 1400000000
+	ksuidEncodedSize  = 27
+	ksuidTimestampLen = 4
+	ksuidPayloadLen   = 16
+)
+
 func encodeBase62(b []byte) string {
-	const base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	var result bytes.Buffer
+	var result strings.Builder
 	var base = big.NewInt(62)
 	var zero = big.NewInt(0)
 	n := big.NewInt(0).SetBytes(b)
@@ -18,11 +28,11 @@ func encodeBase62(b []byte) string {
 	for n.Cmp(zero) > 0 {
 		mod := big.NewInt(0)
 		n.DivMod(n, base, mod) // 计算n/base和n%base
-		result.WriteString(string(base62Chars[mod.Int64()]))
+		result.WriteByte(base62Chars[mod.Int64()])
 	}
 
 	// 由于上面的循环产生的字符串是反向的，所以需要将其反转回来
-	encoded := result.Bytes()
+	encoded := []byte(result.String())
 	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
 		encoded[i], encoded[j] = encoded[j], encoded[i]
 	}
@@ -30,19 +40,60 @@ func encodeBase62(b []byte) string {
 	return string(encoded)
 }
 
+func decodeBase62(s string) (*big.Int, error) {
+	n := big.NewInt(0)
+	base := big.NewInt(62)
+	for _, c := range []byte(s) {
+		i := strings.IndexByte(base62Chars, c)
+		if i < 0 {
+			return nil, fmt.Errorf("非法字符 %q", c)
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	return n, nil
+}
+
+// GenerateKsuid 生成符合规范的 KSUID。
+//
+// 返回:
+//   - (string, error): 27 个字符的 KSUID，按字典序即按时间排序。
 func GenerateKsuid() (string, error) {
-	timestamp := time.Now().Unix()
-	timestampBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(timestampBytes, uint32(timestamp))
+	ksuidBytes := make([]byte, ksuidTimestampLen+ksuidPayloadLen)
+	binary.BigEndian.PutUint32(ksuidBytes, uint32(time.Now().Unix()-ksuidEpoch))
 
 	// 随机部分，这里我们生成16字节的随机数据
-	randomBytes := make([]byte, 16)
-	if _, err := rand.Read(randomBytes); err != nil {
+	if _, err := rand.Read(ksuidBytes[ksuidTimestampLen:]); err != nil {
 		return "", err
 	}
 
-	// 将时间戳和随机数据拼接
-	ksuidBytes := append(timestampBytes, randomBytes...)
+	return formatKsuid(ksuidBytes), nil
+}
+
+// formatKsuid 把 20 字节的 KSUID 编码为 base62，左侧补 0 至 27 个字符。
+func formatKsuid(b []byte) string {
+	encoded := encodeBase62(b)
+	return strings.Repeat("0", ksuidEncodedSize-len(encoded)) + encoded
+}
 
-	return encodeBase62(ksuidBytes), nil
+// ParseKsuidTime 解析 KSUID 中的时间戳。
+//
+// 参数:
+//   - id (string): KSUID。
+//
+// 返回:
+//   - (time.Time, error): UTC 时间或格式错误。
+func ParseKsuidTime(id string) (time.Time, error) {
+	if len(id) != ksuidEncodedSize {
+		return time.Time{}, fmt.Errorf("KSUID 长度应为 %d", ksuidEncodedSize)
+	}
+	n, err := decodeBase62(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n.BitLen() > (ksuidTimestampLen+ksuidPayloadLen)*8 {
+		return time.Time{}, fmt.Errorf("KSUID 超出范围")
+	}
+	b := n.FillBytes(make([]byte, ksuidTimestampLen+ksuidPayloadLen))
+	return time.Unix(int64(binary.BigEndian.Uint32(b))+ksuidEpoch, 0).UTC(), nil
 }
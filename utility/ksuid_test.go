@@ -0,0 +1,93 @@
+package utility
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestKsuidRoundTrip(t *testing.T) {
+	max := bytes.Repeat([]byte{0xff}, ksuidTimestampLen+ksuidPayloadLen)
+	leading := make([]byte, ksuidTimestampLen+ksuidPayloadLen)
+	leading[len(leading)-1] = 1
+	tests := []struct {
+		name    string
+		b       []byte
+		encoded string
+		time    time.Time
+	}{
+		{"zero is the epoch", make([]byte, ksuidTimestampLen+ksuidPayloadLen), "000000000000000000000000000", time.Unix(ksuidEpoch, 0)},
+		{"leading zeros are padded", leading, "000000000000000000000000001", time.Unix(ksuidEpoch, 0)},
+		{"max", max, "aWgEPTl1tmebfsQzFP4bxwgy80V", time.Unix(ksuidEpoch+1<<32-1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := formatKsuid(tt.b)
+			if encoded != tt.encoded {
+				t.Errorf("got %q, want %q", encoded, tt.encoded)
+			}
+			got, err := ParseKsuidTime(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.time) {
+				t.Errorf("got %s, want %s", got, tt.time)
+			}
+		})
+	}
+}
+
+func TestKsuidRandomRoundTrip(t *testing.T) {
+	b := make([]byte, ksuidTimestampLen+ksuidPayloadLen)
+	for range 1000 {
+		rand.Read(b)
+		// 时间戳高位为 0 时编码较短，须补齐
+		b[0] &= 0x0f
+		encoded := formatKsuid(b)
+		if len(encoded) != ksuidEncodedSize {
+			t.Fatalf("%x: got %d characters, want %d", b, len(encoded), ksuidEncodedSize)
+		}
+		n, err := decodeBase62(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n.FillBytes(make([]byte, len(b))); !bytes.Equal(got, b) {
+			t.Fatalf("got %x, want %x", got, b)
+		}
+		got, _ := ParseKsuidTime(encoded)
+		if want := int64(binary.BigEndian.Uint32(b)) + ksuidEpoch; got.Unix() != want {
+			t.Fatalf("got %d, want %d", got.Unix(), want)
+		}
+	}
+}
+
+func TestGenerateKsuid(t *testing.T) {
+	before := time.Now().Truncate(time.Second)
+	id, err := GenerateKsuid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseKsuidTime(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Before(before) || got.After(time.Now()) {
+		t.Errorf("got %s, want around %s", got, before)
+	}
+}
+
+func TestParseKsuidTimeMalformed(t *testing.T) {
+	for _, id := range []string{
+		"",
+		"00000000000000000000000000",   // 26 个字符
+		"0000000000000000000000000000", // 28 个字符
+		"00000000000000000000000000-",
+		"zzzzzzzzzzzzzzzzzzzzzzzzzzz", // 超过 160 位
+	} {
+		if _, err := ParseKsuidTime(id); err == nil {
+			t.Errorf("%q: expected an error", id)
+		}
+	}
+}
@@ -1,50 +1,71 @@
 package utility
 
 import (
-	"database/sql"
 	"fmt"
-	"runtime"
+	"net"
+	"strconv"
 	"time"
 
-	_ "time/tzdata"
-
-	_ "github.com/go-sql-driver/mysql"
-	"go.uber.org/zap"
+	"github.com/go-sql-driver/mysql"
 )
 
-var MySQL *sql.DB
-
-func init() {
-	loc, err := time.LoadLocation("Asia/Shanghai")
-	if err != nil {
-		ZapLogger.Warn("Failed to load Asia/Shanghai timezone", zap.Error(err))
+// mysqlDSN 设置 MySQL 数据源的连接字符串。
+//
+// 连接字符串取自 dsn，未设置时由 user、password、host、port、database 组成，端口默认为 3306。
+// 两种方式都使用 UTC 会话时区并解析时间列，DATETIME / TIMESTAMP 列按 UTC 读写。
+func (ds *DataSource) mysqlDSN(c *DataSourceConfig) error {
+	var cfg *mysql.Config
+	if c.DSN != "" {
+		var err error
+		cfg, err = mysql.ParseDSN(c.DSN)
+		if err != nil {
+			return fmt.Errorf("无效的连接字符串: %w", err)
+		}
 	} else {
-		time.Local = loc
+		port := c.Port
+		if port == 0 {
+			port = 3306
+		}
+		cfg = mysql.NewConfig()
+		cfg.User = c.User
+		cfg.Passwd = c.Password
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(c.Host, strconv.Itoa(port))
+		cfg.DBName = c.Database
+		cfg.Timeout = 5 * time.Second
+		cfg.ReadTimeout = 5 * time.Second
+		cfg.WriteTimeout = 5 * time.Second
 	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	// 更新的行数按匹配的行计算，与 PostgreSQL、SQLite 一致，取值未变的行不会被当作不存在
+	cfg.ClientFoundRows = true
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	if _, ok := cfg.Params["charset"]; !ok {
+		cfg.Params["charset"] = "utf8mb4"
+	}
+	ds.DSN = cfg.FormatDSN()
+	return nil
 }
 
-func InitMySQL(user, password, host, port, database string) {
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=Local&charset=utf8mb4&timeout=5s&readTimeout=5s&writeTimeout=5s",
-		user,
-		password,
-		host,
-		port,
-		database,
-	)
-	var err error
-	MySQL, err = sql.Open("mysql", dsn)
+// mysqlReplicaDSN 把连接字符串中的地址替换为只读副本的地址。
+//
+// 参数:
+//   - dsn (string): 主库的连接字符串。
+//   - addr (string): 只读副本地址，host 或 host:port。
+//
+// 返回:
+//   - string: 只读副本的连接字符串。
+//   - error: 连接字符串无效时返回错误。
+func mysqlReplicaDSN(dsn string, addr string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
 	if err != nil {
-		ZapLogger.Fatal(err.Error())
-	}
-	MySQL.SetConnMaxLifetime(time.Minute * 3)
-	cpuCount := runtime.NumCPU()
-	MySQL.SetMaxOpenConns(cpuCount*2 + 1)
-	// MySQL.SetMaxIdleConns(cpuCount*2 + 1)
-	MySQL.SetMaxIdleConns(0)
-	MySQL.SetConnMaxLifetime(time.Second * 30)
-	if err = MySQL.Ping(); err != nil {
-		ZapLogger.Fatal("连接数据库失败 MySQL", zap.Error(err))
+		return "", err
 	}
-	ZapLogger.Info("连接数据库成功 MySQL")
+	_, port, _ := net.SplitHostPort(cfg.Addr)
+	cfg.Addr = replicaAddr(addr, port)
+	return cfg.FormatDSN(), nil
 }
@@ -1,37 +1,110 @@
 package utility
 
 import (
-	"database/sql"
 	"fmt"
-	"runtime"
-	"time"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
 	_ "github.com/lib/pq"
-	"go.uber.org/zap"
 )
 
-var Postgres *sql.DB
+// postgresDSN 设置 PostgreSQL 数据源的连接字符串和 application_name。
+//
+// 连接字符串取自 dsn，未设置时由 user、password、host、port、database 组成，端口默认为 5432。
+// 连接字符串中没有 application_name 时加上本实例的名称。
+func (ds *DataSource) postgresDSN(c *DataSourceConfig) error {
+	hostname, _ := os.Hostname()
+	ds.ApplicationName = fmt.Sprintf("crate-%s-%d", hostname, os.Getpid())
+	if len(ds.ApplicationName) > 63 {
+		ds.ApplicationName = ds.ApplicationName[:63]
+	}
+
+	dsn := c.DSN
+	if dsn == "" {
+		port := c.Port
+		if port == 0 {
+			port = 5432
+		}
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(c.User, c.Password),
+			Host:     net.JoinHostPort(c.Host, strconv.Itoa(port)),
+			Path:     "/" + c.Database,
+			RawQuery: "sslmode=disable",
+		}
+		dsn = u.String()
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return fmt.Errorf("无效的连接字符串: %w", err)
+		}
+		q := u.Query()
+		if q.Get("application_name") == "" {
+			q.Set("application_name", ds.ApplicationName)
+			u.RawQuery = q.Encode()
+		} else {
+			ds.ApplicationName = q.Get("application_name")
+		}
+		ds.DSN = u.String()
+		return nil
+	}
+	// key=value 形式的连接字符串
+	if name, ok := keywordValue(dsn, "application_name"); ok {
+		ds.ApplicationName = name
+	} else {
+		dsn += " application_name=" + ds.ApplicationName
+	}
+	ds.DSN = dsn
+	return nil
+}
+
+// keywordValue 获取 key=value 形式连接字符串中的值，不处理带引号的值。
+func keywordValue(dsn string, key string) (string, bool) {
+	for _, field := range strings.Fields(dsn) {
+		if k, v, ok := strings.Cut(field, "="); ok && k == key {
+			return strings.Trim(v, "'"), true
+		}
+	}
+	return "", false
+}
 
-func InitPostgres(user, password, host, port, database string) {
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		user,
-		password,
-		host,
-		port,
-		database,
-	)
-	var err error
-	Postgres, err = sql.Open("postgres", dsn)
+// postgresReplicaDSN 把连接字符串中的地址替换为只读副本的地址。
+//
+// 参数:
+//   - dsn (string): 主库的连接字符串，URL 或 key=value 形式。
+//   - addr (string): 只读副本地址，host 或 host:port。
+//
+// 返回:
+//   - string: 只读副本的连接字符串。
+//   - error: 连接字符串或地址无效时返回错误。
+func postgresReplicaDSN(dsn string, addr string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		u.Host = replicaAddr(addr, u.Port())
+		return u.String(), nil
+	}
+	port, _ := keywordValue(dsn, "port")
+	host, port, err := net.SplitHostPort(replicaAddr(addr, port))
 	if err != nil {
-		ZapLogger.Fatal(err.Error())
+		host, port = addr, ""
+	}
+	var fields []string
+	for _, field := range strings.Fields(dsn) {
+		if k, _, _ := strings.Cut(field, "="); k != "host" && k != "port" {
+			fields = append(fields, field)
+		}
 	}
-	Postgres.SetConnMaxLifetime(time.Second * 30)
-	cpuCount := runtime.NumCPU()
-	Postgres.SetMaxOpenConns(cpuCount*2 + 1)
-	Postgres.SetMaxIdleConns(cpuCount*2 + 1)
-	if err = Postgres.Ping(); err != nil {
-		ZapLogger.Fatal("连接数据库失败 Postgres", zap.Error(err))
+	fields = append(fields, "host="+host)
+	if port != "" {
+		fields = append(fields, "port="+port)
 	}
-	ZapLogger.Info("连接数据库成功 Postgres")
+	return strings.Join(fields, " "), nil
 }
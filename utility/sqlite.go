@@ -1,44 +1,22 @@
 package utility
 
 import (
-	"database/sql"
-	"errors"
-	"os"
-	"runtime"
-	"time"
+	"fmt"
 
-	"github.com/joho/godotenv"
-	"go.uber.org/zap"
 	_ "modernc.org/sqlite"
 )
 
-var SQLite *sql.DB
-
-func InitSQLite() {
-	err := godotenv.Load()
-	if err != nil {
-		ZapLogger.Warn("环境变量未设置 SQLite", zap.Error(err))
-	}
-
-	dsn := os.Getenv("SQLITE_DATABASE")
-	if dsn == "" {
-		ZapLogger.Fatal("环境变量未设置 SQLite", zap.Error(errors.New("环境变量未设置 SQLite")))
-	}
-
-	SQLite, err = sql.Open("sqlite", dsn+"?_journal_mode=WAL&_cache=shared&_synchronous=NORMAL&_temp_store=MEMORY&_auto_vacuum=INCREMENTAL")
-	if err != nil {
-		ZapLogger.Fatal("Failed to open SQLite connection", zap.Error(err))
+// sqliteDSN 设置 SQLite 数据源的连接字符串。
+//
+// 连接字符串取自 dsn，未设置时为 database 指定的数据库文件加上默认的连接参数。
+func (ds *DataSource) sqliteDSN(c *DataSourceConfig) error {
+	if c.DSN != "" {
+		ds.DSN = c.DSN
+		return nil
 	}
-
-	if err := SQLite.Ping(); err != nil {
-		ZapLogger.Fatal("连接数据库失败 SQLite", zap.Error(err))
+	if c.Database == "" {
+		return fmt.Errorf("未设置 dsn 或 database")
 	}
-
-	// 设置连接池
-	numCPU := runtime.NumCPU()
-	SQLite.SetMaxIdleConns(1)                   // 设置最大空闲连接数
-	SQLite.SetMaxOpenConns(numCPU*2 + 1)        // 设置最大打开连接数
-	SQLite.SetConnMaxIdleTime(15 * time.Minute) // 设置最大空闲时间
-
-	ZapLogger.Info("连接数据库成功 SQLite")
+	ds.DSN = c.Database + "?_journal_mode=WAL&_cache=shared&_synchronous=NORMAL&_temp_store=MEMORY&_auto_vacuum=INCREMENTAL"
+	return nil
 }
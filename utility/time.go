@@ -0,0 +1,137 @@
+package utility
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "time/tzdata"
+)
+
+// ServiceLocation 服务时区，用于解释不带时区的时间输入，以及未指定请求时区时渲染响应。
+var ServiceLocation = time.UTC
+
+// TimezoneHeader 请求时区头，也可以使用查询参数 tz。
+const TimezoneHeader = "X-Timezone"
+
+// TimeLayout 渲染和以文本存储时间的格式。与 time.RFC3339Nano 不同，小数秒固定为 9 位，
+// 同一时区的时间文本宽度相同，按文本排序与按时间排序一致。
+const TimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// 不带时区的输入格式，按服务时区解释
+var localTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// InitTimezone 设置服务时区。
+//
+// 参数:
+//   - name (string): IANA 时区名称，例如 "Asia/Shanghai"，为空时使用 UTC。
+//
+// 返回:
+//   - error: 时区名称无效时返回错误。
+func InitTimezone(name string) error {
+	if name == "" {
+		ServiceLocation = time.UTC
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	ServiceLocation = loc
+	return nil
+}
+
+// ParseTime 解析客户端提交的时间。
+//
+// 参数:
+//   - s (string): RFC 3339 时间，或按服务时区解释的 "2006-01-02 15:04:05" 格式时间。
+//
+// 返回:
+//   - (time.Time, error): UTC 时间或解析失败时的错误。
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, ServiceLocation); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间 %q，应使用 RFC 3339 格式", s)
+}
+
+// FormatTime 将时间格式化为 TimeLayout 格式的 UTC 文本，用于 data_state 等文本存储。
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
+
+// RequestLocation 获取请求指定的渲染时区。
+//
+// 参数:
+//   - r (*http.Request): 请求，时区取自 X-Timezone 头或查询参数 tz。
+//
+// 返回:
+//   - (*time.Location, error): 请求时区，未指定时为服务时区。
+func RequestLocation(r *http.Request) (*time.Location, error) {
+	name := r.Header.Get(TimezoneHeader)
+	if name == "" {
+		name = r.URL.Query().Get("tz")
+	}
+	if name == "" {
+		return ServiceLocation, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// LocalizeRecord 将记录中的时间渲染为指定时区的 TimeLayout 格式文本。
+//
+// time.Time 类型的值、timeColumns 中可解析为 RFC 3339 的文本，
+// 以及 JSON 列 jsonColumns 中以 "_at" 结尾的时间字段都会被转换。
+//
+// 参数:
+//   - m (map[string]any): 记录，原地修改。
+//   - loc (*time.Location): 渲染时区。
+//   - timeColumns ([]string): 以文本存储时间的列。
+//   - jsonColumns ([]string): 以 JSON 文本存储、包含时间字段的列。
+func LocalizeRecord(m map[string]any, loc *time.Location, timeColumns []string, jsonColumns []string) {
+	for k, v := range m {
+		if t, ok := v.(time.Time); ok {
+			m[k] = t.In(loc).Format(TimeLayout)
+		}
+	}
+	for _, col := range timeColumns {
+		if s, ok := m[col].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				m[col] = t.In(loc).Format(TimeLayout)
+			}
+		}
+	}
+	for _, col := range jsonColumns {
+		s, ok := m[col].(string)
+		if !ok || !strings.Contains(s, "_at") {
+			continue
+		}
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(s), &obj); err != nil {
+			continue
+		}
+		for k, v := range obj {
+			if str, ok := v.(string); ok && strings.HasSuffix(k, "_at") {
+				if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+					obj[k] = t.In(loc).Format(TimeLayout)
+				}
+			}
+		}
+		if b, err := json.Marshal(obj); err == nil {
+			m[col] = string(b)
+		}
+	}
+}
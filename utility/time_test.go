@@ -0,0 +1,42 @@
+package utility
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFormatTimeSortsAsText(t *testing.T) {
+	base := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	times := []time.Time{
+		base,
+		base.Add(100 * time.Millisecond),
+		base.Add(120 * time.Millisecond),
+		base.Add(123456789),
+		base.Add(time.Second),
+	}
+	var texts []string
+	for _, tm := range times {
+		texts = append(texts, FormatTime(tm))
+	}
+	if !slices.IsSorted(texts) {
+		t.Errorf("got %q, want texts in time order", texts)
+	}
+	if texts[0] != "2025-01-02T03:04:05.000000000Z" {
+		t.Errorf("got %s, want nine fractional digits", texts[0])
+	}
+	for _, s := range texts {
+		if len(s) != len(texts[0]) {
+			t.Errorf("got %s, want %d characters", s, len(texts[0]))
+		}
+		if got, err := ParseTime(s); err != nil || !slices.Contains(times, got) {
+			t.Errorf("%s: got %v, %v, want it parsed back", s, got, err)
+		}
+	}
+
+	m := map[string]any{"event_time": base.Add(time.Second), "data_state": `{"status_at": "2025-01-02T03:04:05.1Z"}`}
+	LocalizeRecord(m, time.FixedZone("", 8*3600), nil, []string{"data_state"})
+	if m["event_time"] != "2025-01-02T11:04:06.000000000+08:00" || m["data_state"] != `{"status_at":"2025-01-02T11:04:05.100000000+08:00"}` {
+		t.Errorf("got %v, want fixed-width times in +08:00", m)
+	}
+}
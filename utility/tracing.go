@@ -0,0 +1,89 @@
+package utility
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.uber.org/zap"
+)
+
+// TracingExporters 支持的链路追踪导出方式。
+var TracingExporters = []string{"none", "otlp", "stdout"}
+
+// tracerProvider 导出 span 的 TracerProvider，未启用链路追踪时为 nil。
+var tracerProvider *sdktrace.TracerProvider
+
+// InitTracing 初始化链路追踪。
+//
+// 无论是否导出，都按 W3C Trace Context 读取请求头中的 traceparent，日志因此可以关联上游的追踪。
+// 导出方式为 none 时不记录 span。
+//
+// 参数:
+//   - cfg (TracingConfig): 导出方式、接收地址、采样比例和服务名称，已由配置校验。
+//
+// 返回:
+//   - error: 无法创建导出器时返回错误。
+func InitTracing(cfg TracingConfig) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		ZapLogger.Warn("导出链路追踪失败", zap.Error(err))
+	}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			// 与 OTEL_EXPORTER_OTLP_ENDPOINT 相同，只有地址时发送到 /v1/traces
+			endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+			if u, err := url.Parse(endpoint); err == nil && u.Path == "" {
+				endpoint += "/v1/traces"
+			}
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("创建链路追踪导出器失败: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return fmt.Errorf("创建链路追踪资源失败: %w", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// CloseTracing 导出尚未导出的 span 并停止导出。
+//
+// 参数:
+//   - ctx (context.Context): 限制导出的时长。
+func CloseTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		ZapLogger.Warn("停止链路追踪失败", zap.Error(err))
+	}
+}
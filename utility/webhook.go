@@ -0,0 +1,33 @@
+package utility
+
+import (
+	"database/sql"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// Webhook 保存 Webhook 订阅与投递记录的 SQLite 数据库，与业务数据库分开，
+// 不论启用了哪些数据库后端都可以使用。
+var Webhook *sql.DB
+
+// InitWebhook 打开 Webhook 数据库。
+//
+// 参数:
+//   - dsn (string): SQLite 数据库文件路径。
+func InitWebhook(dsn string) {
+	var err error
+	Webhook, err = sql.Open("sqlite", dsn+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		ZapLogger.Fatal("Failed to open Webhook connection", zap.Error(err))
+	}
+
+	if err := Webhook.Ping(); err != nil {
+		ZapLogger.Fatal("连接数据库失败 Webhook", zap.Error(err))
+	}
+
+	// 投递任务与管理接口共用，单个连接避免 SQLite 写锁竞争
+	Webhook.SetMaxOpenConns(1)
+
+	ZapLogger.Info("连接数据库成功 Webhook", zap.String("database", dsn))
+}
@@ -0,0 +1,204 @@
+package utility
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// XLSXContentType Excel 工作簿的媒体类型。
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// XLSXMaxRows 一个工作表的行数上限，包括标题行。
+const XLSXMaxRows = 1048576
+
+// ErrXLSXTooManyRows 写入的行数超过工作表上限。
+var ErrXLSXTooManyRows = errors.New("超过 Excel 工作表的行数上限")
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+// XLSXWriter 逐行写出只有一个工作表的 Excel 工作簿。
+//
+// 工作表以 zip 流的形式直接写入底层 io.Writer，不在内存中保留已写出的行；
+// 字符串使用内联字符串，不需要共享字符串表，因此也无需预先知道全部内容。
+type XLSXWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rows  int
+	buf   []byte
+}
+
+// NewXLSXWriter 创建 Excel 工作簿并开始写入工作表。
+//
+// 参数:
+//   - w (io.Writer): 输出。
+//   - sheet (string): 工作表名称，超过 31 个字符时截断。
+//
+// 返回:
+//   - *XLSXWriter: 工作簿。
+//   - error: 写入失败时返回错误。
+func NewXLSXWriter(w io.Writer, sheet string) (*XLSXWriter, error) {
+	zw := zip.NewWriter(w)
+	var name strings.Builder
+	xml.EscapeText(&name, []byte(xlsxSheetName(sheet)))
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", strings.Replace(xlsxWorkbook, "%s", name.String(), 1)},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return nil, err
+		}
+	}
+	sheetWriter, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheetWriter, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+	return &XLSXWriter{zw: zw, sheet: sheetWriter}, nil
+}
+
+// WriteRow 写入一行。
+//
+// 数值和布尔值写为对应类型的单元格；不超过 15 位、没有前导零的整数字符串写为数值，
+// 更长的整数保留为文本，避免 Excel 丢失精度；其他值写为文本。
+//
+// 参数:
+//   - cells ([]any): 单元格的值，nil 写为空单元格。
+//
+// 返回:
+//   - error: 超过行数上限或写入失败时返回错误。
+func (x *XLSXWriter) WriteRow(cells []any) error {
+	if x.rows >= XLSXMaxRows {
+		return ErrXLSXTooManyRows
+	}
+	x.rows++
+	row := strconv.Itoa(x.rows)
+	b := x.buf[:0]
+	b = append(b, `<row r="`...)
+	b = append(b, row...)
+	b = append(b, `">`...)
+	for i, v := range cells {
+		if v == nil {
+			continue
+		}
+		ref := xlsxColumn(i) + row
+		switch v := v.(type) {
+		case bool:
+			b = append(b, `<c r="`+ref+`" t="b"><v>`...)
+			if v {
+				b = append(b, '1')
+			} else {
+				b = append(b, '0')
+			}
+			b = append(b, `</v></c>`...)
+		case int:
+			b = append(b, `<c r="`+ref+`"><v>`...)
+			b = strconv.AppendInt(b, int64(v), 10)
+			b = append(b, `</v></c>`...)
+		case int64:
+			b = append(b, `<c r="`+ref+`"><v>`...)
+			b = strconv.AppendInt(b, v, 10)
+			b = append(b, `</v></c>`...)
+		case float64:
+			b = append(b, `<c r="`+ref+`"><v>`...)
+			b = strconv.AppendFloat(b, v, 'g', -1, 64)
+			b = append(b, `</v></c>`...)
+		case string:
+			if xlsxNumeric(v) {
+				b = append(b, `<c r="`+ref+`"><v>`+v+`</v></c>`...)
+				continue
+			}
+			b = xlsxInlineString(b, ref, v)
+		default:
+			b = xlsxInlineString(b, ref, fmt.Sprint(v))
+		}
+	}
+	b = append(b, `</row>`...)
+	x.buf = b
+	_, err := x.sheet.Write(b)
+	return err
+}
+
+// Close 结束工作表并写出 zip 目录，不关闭底层 io.Writer。
+func (x *XLSXWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxInlineString 追加一个内联字符串单元格，单元格文本的长度上限为 32767 个字符。
+func xlsxInlineString(b []byte, ref string, s string) []byte {
+	if r := []rune(s); len(r) > 32767 {
+		s = string(r[:32767])
+	}
+	b = append(b, `<c r="`+ref+`" t="inlineStr"><is><t xml:space="preserve">`...)
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	b = append(b, sb.String()...)
+	return append(b, `</t></is></c>`...)
+}
+
+// xlsxNumeric 判断字符串是否为可以无损写为数值的整数。
+func xlsxNumeric(s string) bool {
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || len(digits) > 15 || (len(digits) > 1 && digits[0] == '0') {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// xlsxColumn 将从 0 开始的列序号转换为列名，例如 0 为 A，26 为 AA。
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSheetName 去掉工作表名称中不允许的字符并截断到 31 个字符。
+func xlsxSheetName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	if r := []rune(s); len(r) > 31 {
+		s = string(r[:31])
+	}
+	if s == "" {
+		s = "Sheet1"
+	}
+	return s
+}
@@ -3,6 +3,7 @@ package utility
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"go.uber.org/zap"
@@ -12,9 +13,21 @@ import (
 
 var ZapLogger *zap.Logger
 
-func InitZapLogger() {
+// consoleLevel、fileLevel 终端与日志文件的级别，可在运行时修改。
+var (
+	consoleLevel = zap.NewAtomicLevel()
+	fileLevel    = zap.NewAtomicLevel()
+)
+
+// InitZapLogger 初始化日志器。
+//
+// 参数:
+//   - cfg (LogConfig): 终端与日志文件的级别、日志目录，级别已由配置校验。
+func InitZapLogger(cfg LogConfig) {
+	SetLogLevel(cfg)
+
 	// 创建日志目录
-	if err := os.MkdirAll("logs", 0755); err != nil {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
 		panic(fmt.Sprintf("无法创建日志目录: %v", err))
 	}
 
@@ -23,16 +36,16 @@ func InitZapLogger() {
 	encoderConfig.TimeKey = "timestamp"
 	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("2006-01-02 15:04:05")
 
-	// 1. 控制台输出配置 (info级别，普通格式)
+	// 1. 控制台输出配置 (默认 info 级别，普通格式)
 	consoleEncoder := zapcore.NewConsoleEncoder(encoderConfig)
 	consoleCore := zapcore.NewCore(
 		consoleEncoder,
 		zapcore.AddSync(os.Stdout),
-		zapcore.InfoLevel, // 终端只显示 info 及以上级别
+		consoleLevel, // 终端只显示该级别及以上
 	)
 
-	// 2. 文件输出配置 (warn级别，JSON格式)
-	logFileName := fmt.Sprintf("logs/crate.%s.log", time.Now().Format("2006-01-02"))
+	// 2. 文件输出配置 (默认 warn 级别，JSON格式)
+	logFileName := filepath.Join(cfg.Dir, fmt.Sprintf("crate.%s.log", time.Now().Format("2006-01-02")))
 	fileWriter := &lumberjack.Logger{
 		Filename:   logFileName,
 		MaxSize:    100,  // MB
@@ -50,7 +63,7 @@ func InitZapLogger() {
 	fileCore := zapcore.NewCore(
 		fileEncoder,
 		zapcore.AddSync(fileWriter),
-		zapcore.WarnLevel, // 文件只记录 warn 及以上级别
+		fileLevel, // 文件只记录该级别及以上
 	)
 
 	// 合并两个输出核心
@@ -63,6 +76,19 @@ func InitZapLogger() {
 	zap.ReplaceGlobals(ZapLogger)
 }
 
+// SetLogLevel 修改终端与日志文件的级别，立即生效。
+//
+// 参数:
+//   - cfg (LogConfig): 日志配置，只使用其中的级别，级别已由配置校验。
+func SetLogLevel(cfg LogConfig) {
+	if level, err := zapcore.ParseLevel(cfg.Level); err == nil {
+		consoleLevel.SetLevel(level)
+	}
+	if level, err := zapcore.ParseLevel(cfg.FileLevel); err == nil {
+		fileLevel.SetLevel(level)
+	}
+}
+
 // 优雅关闭日志器
 func CloseZapLogger() {
 	if ZapLogger != nil {